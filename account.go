@@ -0,0 +1,164 @@
+package localcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/wildone/localcert/internal/acmeutil"
+)
+
+// Account returns the ACME account at accountURL.
+func (c *Client) Account(ctx context.Context, accountURL string) (*acme.Account, error) {
+	c.accountURL = accountURL
+	account, err := c.acmeClient.GetReg(ctx, accountURL)
+	if err != nil {
+		return nil, fmt.Errorf("account: %w", err)
+	}
+	return account, nil
+}
+
+// UpdateContact replaces the contact addresses of the account, such as
+// "mailto:admin@example.com".
+func (c *Client) UpdateContact(ctx context.Context, accountURL string, contact []string) (*acme.Account, error) {
+	account, err := c.acmeClient.UpdateReg(ctx, &acme.Account{URI: accountURL, Contact: contact})
+	if err != nil {
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	return account, nil
+}
+
+// DeactivateAccount permanently deactivates the account of the client's
+// key. The key can't be used to register again.
+func (c *Client) DeactivateAccount(ctx context.Context) error {
+	// DeactivateReg needs the directory but doesn't fetch it
+	if _, err := c.acmeClient.Discover(ctx); err != nil {
+		return fmt.Errorf("discover: %w", err)
+	}
+	if err := c.acmeClient.DeactivateReg(ctx); err != nil {
+		return fmt.Errorf("deactivate account: %w", err)
+	}
+	return nil
+}
+
+// RotateKey replaces the account's key with newKey using the CA's
+// key-change endpoint (RFC 8555, section 7.3.5). The Client keeps the old
+// key; use a new Client with newKey afterwards.
+func (c *Client) RotateKey(ctx context.Context, accountURL string, newKey crypto.Signer) error {
+	dir, err := c.acmeClient.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discover: %w", err)
+	}
+	if dir.KeyChangeURL == "" {
+		return errors.New("ACME server doesn't support account key changes")
+	}
+
+	// The inner JWS, signed by the new key, names the account and the old
+	// key, and becomes the payload of a request signed by the old key
+	oldJWK := jose.JSONWebKey{Key: c.acmeClient.Key.Public()}
+	keyChange, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{accountURL, oldJWK})
+	if err != nil {
+		return err
+	}
+	inner, err := signJWS(newKey, keyChange, (&jose.SignerOptions{EmbedJWK: true}).WithHeader("url", dir.KeyChangeURL))
+	if err != nil {
+		return fmt.Errorf("sign key change: %w", err)
+	}
+
+	c.accountURL = accountURL
+	if _, err := c.post(ctx, dir, dir.KeyChangeURL, []byte(inner)); err != nil {
+		return fmt.Errorf("key change: %w", err)
+	}
+	return nil
+}
+
+// post sends payload to url signed by the account key, for requests the
+// acme package has no method for, and returns the response headers. An
+// empty payload makes it a POST-as-GET.
+func (c *Client) post(ctx context.Context, dir acme.Directory, url string, payload []byte) (http.Header, error) {
+	if c.accountURL == "" {
+		return nil, errors.New("no ACME account")
+	}
+	// Retry once with a fresh nonce if the CA rejects the first
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx, dir.NonceURL)
+		if err != nil {
+			return nil, fmt.Errorf("nonce: %w", err)
+		}
+		opts := (&jose.SignerOptions{}).WithHeader("kid", c.accountURL).WithHeader("url", url).WithHeader("nonce", nonce)
+		jws, err := signJWS(c.acmeClient.Key, payload, opts)
+		if err != nil {
+			return nil, err
+		}
+		header, err := c.postJWS(ctx, url, jws)
+		var statusErr *acmeutil.StatusError
+		if attempt == 0 && errors.As(err, &statusErr) && statusErr.ShortType() == "badnonce" {
+			continue
+		}
+		return header, err
+	}
+}
+
+// signJWS signs payload with key, returning the flattened JSON
+// serialization ACME expects.
+func signJWS(key crypto.Signer, payload []byte, opts *jose.SignerOptions) (string, error) {
+	signingKey, err := acmeutil.SigningKey(key)
+	if err != nil || signingKey.Algorithm == jose.EdDSA {
+		return "", fmt.Errorf("unsupported account key type %T", key.Public())
+	}
+	signer, err := jose.NewSigner(signingKey, opts)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.FullSerialize(), nil
+}
+
+func (c *Client) nonce(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", c.acmeClient.UserAgent)
+	resp, err := c.acmeClient.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("no Replay-Nonce in response")
+	}
+	return nonce, nil
+}
+
+func (c *Client) postJWS(ctx context.Context, url, jws string) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader([]byte(jws)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", acmeutil.RequestContentType)
+	req.Header.Set("User-Agent", c.acmeClient.UserAgent)
+	resp, err := c.acmeClient.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if statusErr := acmeutil.ErrorFromResponse(resp); statusErr != nil {
+		return nil, statusErr
+	}
+	return resp.Header, nil
+}
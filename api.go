@@ -1,6 +1,8 @@
 package localcert
 
 import (
+	"time"
+
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -8,8 +10,13 @@ type DomainRequest struct {
 	AccountRequest []byte `json:"signedAccountRequest"`
 }
 
+// DomainResult is the domain the localcert server has assigned the
+// account. Status and Expires describe its registration, for servers that
+// reclaim domains whose registrations aren't renewed.
 type DomainResult struct {
-	Domain string `json:"localcertDomain"`
+	Domain  string     `json:"localcertDomain"`
+	Status  string     `json:"status,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
 }
 
 type ProvisionRequest struct {
@@ -21,3 +28,25 @@ type ProvisionResult struct {
 	AuthorizationURL        string `json:"authorizationURL"`
 	ProvisionedChallengeURL string `json:"provisionedChallengeURL"`
 }
+
+// DNSRecord is a record the localcert server serves under the assigned
+// domain. Name is relative to the domain, and empty for the domain itself.
+type DNSRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// DNSRecordsRequest replaces the records of each type and name in Set with
+// the given values and removes those matching Delete (any value, if empty),
+// in that order. An empty request lists the records.
+type DNSRecordsRequest struct {
+	AccountRequest []byte      `json:"signedAccountRequest"`
+	Set            []DNSRecord `json:"set,omitempty"`
+	Delete         []DNSRecord `json:"delete,omitempty"`
+}
+
+type DNSRecordsResult struct {
+	Domain  string      `json:"localcertDomain"`
+	Records []DNSRecord `json:"records"`
+}
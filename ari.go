@@ -0,0 +1,109 @@
+package localcert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/wildone/localcert/internal/acmeutil"
+	"golang.org/x/crypto/acme"
+)
+
+// RenewalInfo is a CA's ACME Renewal Information (ARI) for a certificate.
+type RenewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL,omitempty"`
+
+	// RetryAfter is how long the CA asks clients to wait before fetching
+	// the renewal information again, from its Retry-After header.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// DefaultARIPollInterval is how long to wait before fetching renewal
+// information again when the CA doesn't say.
+const DefaultARIPollInterval = 6 * time.Hour
+
+// RenewalTime returns a time in the suggested window. It is chosen from the
+// certificate serial so that repeated checks agree.
+func (ri *RenewalInfo) RenewalTime(cert *x509.Certificate) time.Time {
+	start, end := ri.SuggestedWindow.Start, ri.SuggestedWindow.End
+	if !end.After(start) {
+		return start
+	}
+	h := fnv.New64a()
+	h.Write(cert.SerialNumber.Bytes())
+	return start.Add(time.Duration(h.Sum64() % uint64(end.Sub(start))))
+}
+
+// RenewalInfo fetches the CA's renewal information for cert, returning nil
+// if the CA doesn't support ARI.
+func (c *Client) RenewalInfo(ctx context.Context, cert *x509.Certificate) (*RenewalInfo, error) {
+	dirURL := c.acmeClient.DirectoryURL
+	if dirURL == "" {
+		dirURL = acme.LetsEncryptURL
+	}
+	var dir struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}
+	if _, err := c.getJSON(ctx, dirURL, &dir); err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	if dir.RenewalInfo == "" {
+		return nil, nil
+	}
+
+	certID, err := ariCertID(cert)
+	if err != nil {
+		return nil, err
+	}
+	var info RenewalInfo
+	header, err := c.getJSON(ctx, dir.RenewalInfo+"/"+certID, &info)
+	if err != nil {
+		return nil, fmt.Errorf("renewal info: %w", err)
+	}
+	info.RetryAfter, _ = parseRetryAfter(header.Get("Retry-After"), time.Now())
+	return &info, nil
+}
+
+// ariCertID identifies cert by its authority key identifier and serial.
+func ariCertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", errors.New("certificate has no authority key identifier")
+	}
+	serialDER, err := asn1.Marshal(cert.SerialNumber)
+	if err != nil {
+		return "", err
+	}
+	var serial asn1.RawValue
+	if _, err := asn1.Unmarshal(serialDER, &serial); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial.Bytes), nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.acmeClient.UserAgent)
+	resp, err := c.acmeClient.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if statusErr := acmeutil.ErrorFromResponse(resp); statusErr != nil {
+		return nil, statusErr
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,29 @@
+package localcert
+
+// AuditEvent is an action taken with the CA or on the stored certificate,
+// passed to Config.Audit.
+type AuditEvent struct {
+	// Action is "registration", "order", "challenge", "issuance",
+	// "revocation" or "write".
+	Action string
+	// Details identify what the action was on, such as its "names",
+	// "serial" or "path".
+	Details map[string]string
+	// Err is why the action failed, or nil if it succeeded.
+	Err error
+}
+
+// audit passes an event for action to fn, if set, with details as
+// alternating keys and values. Empty values are left out.
+func audit(fn func(AuditEvent), action string, err error, details ...string) {
+	if fn == nil {
+		return
+	}
+	event := AuditEvent{Action: action, Details: map[string]string{}, Err: err}
+	for i := 0; i+1 < len(details); i += 2 {
+		if details[i+1] != "" {
+			event.Details[details[i]] = details[i+1]
+		}
+	}
+	fn(event)
+}
@@ -0,0 +1,235 @@
+package localcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckInterval = time.Second
+	ocspRetryInterval    = 5 * time.Minute
+	ocspTimeout          = 30 * time.Second
+)
+
+// CertSource serves a certificate to tls.Config.GetCertificate, picking up
+// renewals without a restart. Certificates are either loaded from files,
+// which are re-read when they change, including when another process
+// replaces them, or set directly with SetCertificate.
+type CertSource struct {
+	CertificateFile string
+	KeyFile         string
+
+	// Store, if set, holds the files instead of the local filesystem. They
+	// are loaded once and then only by Reload, since a Store can't report
+	// changes.
+	Store Store
+
+	// KeyPassphrase decrypts KeyFile if it is encrypted.
+	KeyPassphrase []byte
+
+	// Signer, if set, is the key instead of KeyFile.
+	Signer crypto.Signer
+
+	// CheckInterval limits how often the files are checked for changes;
+	// one second if zero.
+	CheckInterval time.Duration
+
+	// OCSPFile, if set, caches the OCSP response for the certificate. The
+	// response is stapled to handshakes and refreshed in the background
+	// before it goes stale.
+	OCSPFile string
+
+	// HTTPClient fetches OCSP responses; http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// HoldUntilValid keeps serving the previous certificate while a new one
+	// isn't valid yet by the local clock, as happens when it is behind the
+	// CA's.
+	HoldUntilValid bool
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	lastCheck time.Time
+	files     [2]os.FileInfo
+	holding   bool
+
+	staple       *OCSPStaple
+	ocspNext     time.Time
+	ocspUpdating bool
+}
+
+func NewCertSource(certFile, keyFile string) *CertSource {
+	return &CertSource{CertificateFile: certFile, KeyFile: keyFile}
+}
+
+// CertSource returns a CertSource serving the Manager's certificate files.
+func (m *Manager) CertSource() *CertSource {
+	s := NewCertSource(m.CertificateFile, m.KeyFile)
+	s.Store = m.Store
+	s.KeyPassphrase = m.KeyPassphrase
+	if m.Signer != nil {
+		s.KeyFile, s.Signer = "", m.Signer
+	}
+	return s
+}
+
+func (s *CertSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkInterval := s.CheckInterval
+	if checkInterval == 0 {
+		checkInterval = defaultCheckInterval
+	}
+	if s.CertificateFile != "" && (s.cert == nil || time.Since(s.lastCheck) >= checkInterval) {
+		s.lastCheck = time.Now()
+		if err := s.reload(false); err != nil && s.cert == nil {
+			return nil, err
+		}
+	}
+	if s.cert == nil {
+		return nil, errors.New("localcert: no certificate available")
+	}
+	if s.OCSPFile != "" {
+		s.maintainOCSP()
+	}
+	return s.cert, nil
+}
+
+// SetCertificate replaces the served certificate.
+func (s *CertSource) SetCertificate(cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setCert(cert)
+}
+
+// Staple returns the OCSP response stapled to the served certificate, or
+// nil if there is none.
+func (s *CertSource) Staple() *OCSPStaple {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.staple
+}
+
+// Reload re-reads the certificate files.
+func (s *CertSource) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload(true)
+}
+
+// reload loads the certificate if it changed since it was last loaded, or
+// if force is set.
+func (s *CertSource) reload(force bool) error {
+	var files [2]os.FileInfo
+	if _, ok := storeOrFiles(s.Store).(FileStore); ok {
+		for i, name := range []string{s.CertificateFile, s.KeyFile} {
+			if name == "" && s.Signer != nil {
+				continue
+			}
+			fi, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			files[i] = fi
+		}
+	}
+	if !force && s.cert != nil && !s.holding && !FileChanged(files[0], s.files[0]) && !FileChanged(files[1], s.files[1]) {
+		return nil
+	}
+
+	// The files may be mid-rewrite; keep serving the old certificate until
+	// the pair loads cleanly.
+	key := s.Signer
+	if key == nil {
+		var err error
+		if key, _, err = readKeyFile(storeOrFiles(s.Store), s.KeyFile, s.KeyPassphrase); err != nil {
+			return err
+		}
+	}
+	cert, err := loadCertificate(storeOrFiles(s.Store), s.CertificateFile, key)
+	if err != nil {
+		return err
+	}
+	s.holding = s.HoldUntilValid && s.cert != nil && time.Now().Before(cert.Leaf.NotBefore)
+	if s.holding {
+		return nil
+	}
+	s.setCert(cert)
+	s.files = files
+	return nil
+}
+
+// FileChanged reports whether a file has changed between two stats of it:
+// whether it was rewritten, or replaced by another file, as a restore from
+// a backup does even when it keeps the modification time. A nil FileInfo
+// stands for a missing file.
+func FileChanged(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return !os.SameFile(a, b) || !a.ModTime().Equal(b.ModTime()) || a.Size() != b.Size()
+}
+
+// setCert replaces the served certificate, stapling the cached OCSP
+// response if it is for this certificate.
+func (s *CertSource) setCert(cert *tls.Certificate) {
+	s.cert, s.staple, s.ocspNext = cert, nil, time.Time{}
+	if s.OCSPFile == "" || cert == nil {
+		return
+	}
+	if raw, err := storeOrFiles(s.Store).ReadFile(s.OCSPFile); err == nil {
+		if staple, err := ParseOCSP(raw, cert.Certificate); err == nil && staple.Valid() {
+			s.setStaple(staple)
+		}
+	}
+}
+
+func (s *CertSource) setStaple(staple *OCSPStaple) {
+	cert := *s.cert
+	cert.OCSPStaple = nil
+	if staple != nil {
+		cert.OCSPStaple = staple.Raw
+		s.ocspNext = staple.RefreshTime()
+	}
+	s.cert, s.staple = &cert, staple
+}
+
+// maintainOCSP drops a stale OCSP response and starts refreshing it when
+// it is due.
+func (s *CertSource) maintainOCSP() {
+	if s.staple != nil && !s.staple.Valid() {
+		s.setStaple(nil)
+	}
+	if s.ocspUpdating || time.Now().Before(s.ocspNext) {
+		return
+	}
+	s.ocspUpdating = true
+	go s.updateOCSP(s.cert.Certificate)
+}
+
+func (s *CertSource) updateOCSP(chain [][]byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), ocspTimeout)
+	defer cancel()
+	staple, _, err := UpdateOCSPFile(ctx, s.HTTPClient, s.Store, s.OCSPFile, chain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ocspUpdating = false
+	// The certificate may have been renewed meanwhile
+	if s.cert == nil || !bytes.Equal(s.cert.Certificate[0], chain[0]) {
+		return
+	}
+	if err != nil {
+		s.ocspNext = time.Now().Add(ocspRetryInterval)
+		return
+	}
+	s.setStaple(staple)
+}
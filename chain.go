@@ -0,0 +1,81 @@
+package localcert
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+)
+
+// selectChain returns chain if its topmost certificate is issued by the
+// preferred chain's name, and otherwise the first alternate chain offered
+// at certURL that is. If none is, chain is used after all.
+func (c *Client) selectChain(ctx context.Context, certURL string, chain [][]byte) [][]byte {
+	if chainIssuedBy(chain, c.chain) {
+		return chain
+	}
+	ctx, done := c.phase(ctx, "alternate chains", c.timeouts.Download)
+	defer done(nil)
+
+	dir, err := c.acmeClient.Discover(ctx)
+	if err != nil {
+		c.warnChain("discover: %v", err)
+		return chain
+	}
+	header, err := c.post(ctx, dir, certURL, nil)
+	if err != nil {
+		c.warnChain("fetch alternate chains: %v", err)
+		return chain
+	}
+	for _, altURL := range alternateLinks(header) {
+		alt, err := c.acmeClient.FetchCert(ctx, altURL, true)
+		if err != nil {
+			c.warnChain("fetch alternate chain %s: %v", altURL, err)
+			continue
+		}
+		if chainIssuedBy(alt, c.chain) {
+			return alt
+		}
+	}
+	c.warnChain("no chain offered is issued by %q", c.chain)
+	return chain
+}
+
+func (c *Client) warnChain(format string, args ...interface{}) {
+	if c.logf != nil {
+		c.logf("Preferred chain: "+format+"; using the default chain\n", args...)
+	}
+}
+
+// chainIssuedBy reports whether the topmost certificate of chain is
+// issued by, or is itself, the certificate with common name name.
+func chainIssuedBy(chain [][]byte, name string) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	top, err := x509.ParseCertificate(chain[len(chain)-1])
+	if err != nil {
+		return false
+	}
+	return top.Issuer.CommonName == name || top.Subject.CommonName == name
+}
+
+// alternateLinks returns the URLs of Link: <url>;rel="alternate" headers.
+func alternateLinks(header http.Header) []string {
+	var urls []string
+	for _, v := range header.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			url := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(url, "<") || !strings.HasSuffix(url, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				if p := strings.ReplaceAll(strings.TrimSpace(param), " ", ""); p == `rel="alternate"` || p == "rel=alternate" {
+					urls = append(urls, url[1:len(url)-1])
+				}
+			}
+		}
+	}
+	return urls
+}
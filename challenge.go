@@ -0,0 +1,307 @@
+package localcert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Challenger completes one type of ACME challenge, for CAs validating
+// hosts directly rather than through the localcert server.
+type Challenger interface {
+	// Type is the challenge type, such as "http-01".
+	Type() string
+	// Present makes the response to chal for identifier available to the
+	// CA, and returns a func that withdraws it.
+	Present(ctx context.Context, client *acme.Client, identifier string, chal *acme.Challenge) (cleanup func(), err error)
+}
+
+// solve completes a challenge of the authorization with the first of the
+// configured challengers it offers, returning the challenge accepted and a
+// func to withdraw the response once the order is done.
+func (c *Client) solve(ctx context.Context, authzURI string) (acceptedChallenge, func(), error) {
+	var accepted acceptedChallenge
+	authz, err := c.acmeClient.GetAuthorization(ctx, authzURI)
+	if err != nil {
+		return accepted, nil, fmt.Errorf("authorization: %w", err)
+	}
+	accepted.identifier = authz.Identifier.Value
+	if authz.Status == acme.StatusValid {
+		return accepted, nil, nil
+	}
+	var types []string
+	for _, challenger := range c.challengers {
+		types = append(types, challenger.Type())
+		var chal *acme.Challenge
+		for _, ch := range authz.Challenges {
+			if ch.Type == challenger.Type() {
+				chal = ch
+			}
+		}
+		if chal == nil {
+			continue
+		}
+		accepted.typ, accepted.url = chal.Type, chal.URI
+		cleanup, err := challenger.Present(ctx, c.acmeClient, authz.Identifier.Value, chal)
+		if err != nil {
+			return accepted, nil, fmt.Errorf("%s challenge for %q: %w", chal.Type, authz.Identifier.Value, err)
+		}
+		if _, err := c.acmeClient.Accept(ctx, chal); err != nil {
+			cleanup()
+			return accepted, nil, fmt.Errorf("challenge accept: %w", err)
+		}
+		return accepted, cleanup, nil
+	}
+	if authz.Wildcard {
+		return accepted, nil, fmt.Errorf("authorization for %q offers no %s challenge; wildcards need dns-01", authz.Identifier.Value, strings.Join(types, " or "))
+	}
+	return accepted, nil, fmt.Errorf("authorization for %q offers no %s challenge", authz.Identifier.Value, strings.Join(types, " or "))
+}
+
+// HTTP01Server completes HTTP-01 challenges by serving the responses
+// itself, listening on Addr only while a challenge is pending.
+type HTTP01Server struct {
+	// Addr is the address to listen on; ":80" if empty.
+	Addr string
+
+	mu        sync.Mutex
+	responses map[string]string
+	server    *http.Server
+}
+
+func (*HTTP01Server) Type() string { return "http-01" }
+
+func (s *HTTP01Server) Present(ctx context.Context, client *acme.Client, identifier string, chal *acme.Challenge) (func(), error) {
+	response, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+	path := client.HTTP01ChallengePath(chal.Token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		addr := s.Addr
+		if addr == "" {
+			addr = ":80"
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP), ReadHeaderTimeout: 10 * time.Second}
+		s.responses = map[string]string{}
+		go s.server.Serve(l)
+	}
+	s.responses[path] = response
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.responses, path)
+		if len(s.responses) == 0 && s.server != nil {
+			s.server.Close()
+			s.server = nil
+		}
+	}, nil
+}
+
+func (s *HTTP01Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response, ok := s.responses[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// HTTP01Webroot completes HTTP-01 challenges by writing the responses under
+// the document root of a web server already serving the host.
+type HTTP01Webroot struct {
+	Dir string
+}
+
+func (HTTP01Webroot) Type() string { return "http-01" }
+
+func (w HTTP01Webroot) Present(ctx context.Context, client *acme.Client, identifier string, chal *acme.Challenge) (func(), error) {
+	response, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(w.Dir, filepath.FromSlash(client.HTTP01ChallengePath(chal.Token)))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	// The web server must be able to read it
+	if err := os.WriteFile(name, []byte(response), 0644); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := os.Remove(name); err != nil {
+			log.Printf("Error cleaning up %s: %v", name, err)
+		}
+	}, nil
+}
+
+// TLSALPN01Server completes TLS-ALPN-01 challenges by answering the CA's
+// acme-tls/1 handshakes itself, listening on Addr only while a challenge is
+// pending.
+type TLSALPN01Server struct {
+	// Addr is the address to listen on; ":443" if empty.
+	Addr string
+
+	mu       sync.Mutex
+	certs    map[string]*tls.Certificate
+	listener net.Listener
+}
+
+func (*TLSALPN01Server) Type() string { return "tls-alpn-01" }
+
+func (s *TLSALPN01Server) Present(ctx context.Context, client *acme.Client, identifier string, chal *acme.Challenge) (func(), error) {
+	var cert tls.Certificate
+	var err error
+	name := strings.ToLower(identifier)
+	if ip := net.ParseIP(identifier); ip != nil {
+		// The CA sends the reverse DNS name of an IP address (RFC 8738)
+		cert, err = tlsALPN01IPCert(client, chal.Token, ip)
+		name = reverseName(ip)
+	} else {
+		cert, err = client.TLSALPN01ChallengeCert(chal.Token, identifier)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		addr := s.Addr
+		if addr == "" {
+			addr = ":443"
+		}
+		config := &tls.Config{
+			NextProtos:     []string{acme.ALPNProto},
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: s.getCertificate,
+		}
+		l, err := tls.Listen("tcp", addr, config)
+		if err != nil {
+			return nil, err
+		}
+		s.listener = l
+		s.certs = map[string]*tls.Certificate{}
+		go serveTLSALPN(l)
+	}
+	s.certs[name] = &cert
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.certs, name)
+		if len(s.certs) == 0 && s.listener != nil {
+			s.listener.Close()
+			s.listener = nil
+		}
+	}, nil
+}
+
+func (s *TLSALPN01Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert, ok := s.certs[strings.ToLower(hello.ServerName)]
+	if !ok {
+		return nil, fmt.Errorf("no tls-alpn-01 challenge for %q", hello.ServerName)
+	}
+	return cert, nil
+}
+
+// tlsALPN01IPCert returns the TLS-ALPN-01 challenge certificate for an IP
+// address, which names it as an IP address rather than a DNS name.
+func tlsALPN01IPCert(client *acme.Client, token string, ip net.IP) (tls.Certificate, error) {
+	thumbprint, err := acme.JWKThumbprint(client.Key.Public())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	sum := sha256.Sum256([]byte(token + "." + thumbprint))
+	value, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ACME TLS-ALPN-01 challenge"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{ip},
+		ExtraExtensions: []pkix.Extension{{
+			Id:       oidACMEIdentifier,
+			Critical: true,
+			Value:    value,
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// id-pe-acmeIdentifier (RFC 8737)
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of ip.
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	ip = ip.To16()
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// serveTLSALPN completes the handshake of each connection to l, which is
+// all the CA checks, until l is closed.
+func serveTLSALPN(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			conn.(*tls.Conn).Handshake()
+		}()
+	}
+}
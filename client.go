@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"context"
 	"crypto"
-	"crypto/rand"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/acme"
 	"gopkg.in/square/go-jose.v2"
@@ -23,16 +23,61 @@ import (
 
 const defaultUserAgent = "localcert/1.0"
 
-var flagDebug = flag.Bool("debug", false, "show debug statments.")
-
-
 type Config struct {
+	// ACMEPrivateKey is the account key. Like Manager.Signer, it can be any
+	// crypto.Signer with an RSA or ECDSA public key.
 	ACMEPrivateKey   crypto.Signer
 	ACMEDirectoryURL string
 
+	// ChallengeSolver, if set, completes DNS-01 challenges instead of the
+	// localcert server.
+	ChallengeSolver ChallengeSolver
+
+	// Challengers, if set, complete challenges instead of the localcert
+	// server, each authorization with the first whose type it offers,
+	// after ChallengeSolver.
+	Challengers []Challenger
+
+	// ExternalAccountBinding binds new accounts to an account with the CA,
+	// for CAs that require it.
+	ExternalAccountBinding *acme.ExternalAccountBinding
+
 	LocalCertServerURL string
 	HTTPClient         *http.Client
 	UserAgentPrefix    string
+
+	// Retry controls retries of failed requests.
+	Retry RetryPolicy
+
+	// Timeouts bound each phase of issuance.
+	Timeouts Timeouts
+
+	// PreferredChain, if set, picks among the chains the CA offers the one
+	// whose topmost certificate is issued by this common name, such as
+	// "ISRG Root X1". The default chain is used if none is.
+	PreferredChain string
+
+	// Profile, if set, is the certificate profile to order, such as
+	// "tlsserver" or "shortlived", from those the CA advertises in its
+	// directory (draft-aaron-acme-profiles). The CA's default profile is
+	// used if empty.
+	Profile string
+
+	// Logf, if set, receives a message for each retried request, and about
+	// chain selection.
+	Logf func(format string, args ...interface{})
+
+	// StartSpan, if set, is called at the start of each phase of issuance,
+	// named "registration", "domain", "dns records", "order", "challenges",
+	// "finalize", "download" or "alternate chains", such as to trace it. It
+	// returns the context for the phase and a func that ends it with the
+	// phase's error.
+	StartSpan func(ctx context.Context, name string) (context.Context, func(error))
+
+	// Audit, if set, receives an event for each registration, order,
+	// challenge, issuance and revocation, and each write of the certificate
+	// or key, such as to keep an audit log.
+	Audit func(AuditEvent)
 }
 
 func (config Config) Client() *Client {
@@ -40,29 +85,68 @@ func (config Config) Client() *Client {
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
+	if config.Retry.maxRetries() > 0 {
+		retryClient := *httpClient
+		retryClient.Transport = retryTransport{next: httpClient.Transport, policy: config.Retry, logf: config.Logf}
+		httpClient = &retryClient
+	}
 
 	userAgent := config.UserAgentPrefix
 	if userAgent == "" {
 		userAgent = defaultUserAgent
 	}
 
+	challengers := config.Challengers
+	if config.ChallengeSolver != nil {
+		challengers = append([]Challenger{DNS01(config.ChallengeSolver)}, challengers...)
+	}
+
 	return &Client{
-		serverURL: config.LocalCertServerURL,
+		serverURL:   config.LocalCertServerURL,
+		eab:         config.ExternalAccountBinding,
+		challengers: challengers,
+		timeouts:    config.Timeouts,
+		chain:       config.PreferredChain,
+		profile:     config.Profile,
+		logf:        config.Logf,
+		startSpan:   config.StartSpan,
+		audit:       config.Audit,
 		acmeClient: &acme.Client{
 			Key:          config.ACMEPrivateKey,
 			DirectoryURL: config.ACMEDirectoryURL,
 			HTTPClient:   httpClient,
 			UserAgent:    userAgent,
+			RetryBackoff: config.Retry.acmeBackoff(config.Logf),
 		},
 	}
 }
 
 type Client struct {
-	serverURL  string
-	acmeClient *acme.Client
+	serverURL   string
+	eab         *acme.ExternalAccountBinding
+	challengers []Challenger
+	timeouts    Timeouts
+	chain       string
+	profile     string
+	logf        func(format string, args ...interface{})
+	startSpan   func(ctx context.Context, name string) (context.Context, func(error))
+	audit       func(AuditEvent)
+	acmeClient  *acme.Client
+
+	// accountURL is the account's key ID, once known.
+	accountURL string
 }
 
 func (c *Client) EnsureRegistration(ctx context.Context, acceptedTermsURI string, accountURL string) (*acme.Account, error) {
+	ctx, done := c.phase(ctx, "registration", c.timeouts.Registration)
+	account, err := c.ensureRegistration(ctx, acceptedTermsURI, accountURL)
+	if err == nil {
+		c.accountURL = account.URI
+	}
+	return account, done(err)
+}
+
+func (c *Client) ensureRegistration(ctx context.Context, acceptedTermsURI string, accountURL string) (*acme.Account, error) {
 	dir, err := c.acmeClient.Discover(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("discover: %w", err)
@@ -72,10 +156,19 @@ func (c *Client) EnsureRegistration(ctx context.Context, acceptedTermsURI string
 	}
 
 	if accountURL == "" {
-		account, err := c.acmeClient.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+		if dir.ExternalAccountRequired && c.eab == nil {
+			return nil, errors.New("register: ACME server requires external account binding")
+		}
+		account, err := c.acmeClient.Register(ctx, &acme.Account{ExternalAccountBinding: c.eab}, acme.AcceptTOS)
+		if errors.Is(err, acme.ErrAccountAlreadyExists) {
+			// Another host sharing the key registered it first
+			account, err = c.acmeClient.GetReg(ctx, "")
+		}
 		if err != nil {
+			audit(c.audit, "registration", err, "ca", c.acmeClient.DirectoryURL)
 			return nil, fmt.Errorf("register: %w", err)
 		}
+		audit(c.audit, "registration", nil, "ca", c.acmeClient.DirectoryURL, "account", account.URI)
 		return account, nil
 	} else {
 		account, err := c.acmeClient.GetReg(ctx, accountURL)
@@ -89,117 +182,446 @@ func (c *Client) EnsureRegistration(ctx context.Context, acceptedTermsURI string
 	}
 }
 
-func (c *Client) GetDomain() (string, error) {
-	acctReq, err := acmeutil.CaptureAccountRequest(c.acmeClient)
+func (c *Client) GetDomain(ctx context.Context) (string, error) {
+	res, err := c.DomainInfo(ctx)
 	if err != nil {
 		return "", err
 	}
+	return res.Domain, nil
+}
+
+// DomainInfo returns the domain the localcert server has assigned the
+// account, assigning one if it has none, and its registration.
+func (c *Client) DomainInfo(ctx context.Context) (*DomainResult, error) {
+	return c.domainPost(ctx, "/domain")
+}
+
+// ReleaseDomain gives the assigned domain back to the localcert server,
+// which may assign it to another account. The account is assigned a new
+// domain the next time it asks for one.
+func (c *Client) ReleaseDomain(ctx context.Context) error {
+	_, err := c.domainPost(ctx, "/domain/release")
+	return err
+}
+
+// RenewDomainRegistration extends the account's registration of its
+// assigned domain, returning when it now expires.
+func (c *Client) RenewDomainRegistration(ctx context.Context) (*DomainResult, error) {
+	return c.domainPost(ctx, "/domain/renew")
+}
+
+func (c *Client) domainPost(ctx context.Context, urlSuffix string) (*DomainResult, error) {
+	acctReq, err := acmeutil.CaptureAccountRequest(c.acmeClient)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx, done := c.phase(ctx, "domain", c.timeouts.Registration)
 	var domainRes DomainResult
-	err = c.localcertPost("/domain", DomainRequest{AccountRequest: acctReq}, &domainRes)
+	err = done(c.localcertPost(ctx, urlSuffix, DomainRequest{AccountRequest: acctReq}, &domainRes))
 	if err != nil {
-		return "", fmt.Errorf("domain: %w", err)
+		return nil, fmt.Errorf("domain: %w", err)
 	}
-	return domainRes.Domain, nil
+	return &domainRes, nil
+}
+
+// DNSRecords lists the records the localcert server serves for the
+// account's domain.
+func (c *Client) DNSRecords(ctx context.Context) (*DNSRecordsResult, error) {
+	return c.updateDNSRecords(ctx, DNSRecordsRequest{})
+}
+
+// SetDNSRecords replaces the records of each type and name in records,
+// returning the domain's records afterwards.
+func (c *Client) SetDNSRecords(ctx context.Context, records []DNSRecord) (*DNSRecordsResult, error) {
+	return c.updateDNSRecords(ctx, DNSRecordsRequest{Set: records})
+}
+
+// DeleteDNSRecords removes the records matching records, returning the
+// domain's records afterwards.
+func (c *Client) DeleteDNSRecords(ctx context.Context, records []DNSRecord) (*DNSRecordsResult, error) {
+	return c.updateDNSRecords(ctx, DNSRecordsRequest{Delete: records})
+}
+
+func (c *Client) updateDNSRecords(ctx context.Context, req DNSRecordsRequest) (*DNSRecordsResult, error) {
+	acctReq, err := acmeutil.CaptureAccountRequest(c.acmeClient)
+	if err != nil {
+		return nil, err
+	}
+	req.AccountRequest = acctReq
+
+	ctx, done := c.phase(ctx, "dns records", c.timeouts.Registration)
+	var res DNSRecordsResult
+	if err := done(c.localcertPost(ctx, "/records", req, &res)); err != nil {
+		return nil, fmt.Errorf("records: %w", err)
+	}
+	return &res, nil
 }
 
 func (c *Client) ProvisionDomain(ctx context.Context, domain string) (*acme.Order, error) {
-	id := acme.AuthzID{Type: "dns", Value: domain}
-	order, err := c.acmeClient.AuthorizeOrder(ctx, []acme.AuthzID{id})
+	return c.ProvisionDomains(ctx, []string{domain})
+}
+
+// ProvisionDomains orders a certificate for names, which must all be the
+// assigned domain or names under it, and completes each authorization.
+func (c *Client) ProvisionDomains(ctx context.Context, names []string) (*acme.Order, error) {
+	order, err := c.NewOrder(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	return c.Authorize(ctx, order)
+}
+
+// NewOrder orders a certificate for names, which are IP identifiers if
+// they parse as IP addresses.
+func (c *Client) NewOrder(ctx context.Context, names []string) (*acme.Order, error) {
+	var ids []acme.AuthzID
+	hasIP := false
+	for _, name := range names {
+		if net.ParseIP(name) != nil {
+			ids = append(ids, acme.AuthzID{Type: "ip", Value: name})
+			hasIP = true
+		} else {
+			ids = append(ids, acme.AuthzID{Type: "dns", Value: name})
+		}
+	}
+	orderCtx, orderDone := c.phase(ctx, "order", c.timeouts.Order)
+	var order *acme.Order
+	var err error
+	if c.profile != "" {
+		order, err = c.newProfileOrder(orderCtx, ids)
+	} else {
+		order, err = c.acmeClient.AuthorizeOrder(orderCtx, ids)
+	}
+	orderURL := ""
+	if err = orderDone(err); err == nil {
+		orderURL = order.URI
+	}
+	audit(c.audit, "order", err, "names", strings.Join(names, ","), "profile", c.profile, "order", orderURL)
 	if err != nil {
+		if problem := problemType(err); hasIP && (problem == "unsupportedidentifier" || problem == "rejectedidentifier") {
+			return nil, fmt.Errorf("new order: the CA may not issue for IP addresses: %w", err)
+		}
 		return nil, fmt.Errorf("new order: %w", err)
 	}
 	// TODO: validate Order (?)
+	return order, nil
+}
+
+// problemType returns the lowercased ACME error type of err without its
+// "urn:ietf:params:acme:error:" prefix, or "".
+func problemType(err error) string {
+	acmeErr := &acme.Error{}
+	statusErr := &acmeutil.StatusError{}
+	switch {
+	case errors.As(err, &acmeErr):
+		return strings.ToLower(strings.TrimPrefix(acmeErr.ProblemType, "urn:ietf:params:acme:error:"))
+	case errors.As(err, &statusErr):
+		return statusErr.ShortType()
+	}
+	return ""
+}
 
-	authzURI := order.AuthzURLs[0]
-	authzReq, err := acmeutil.CaptureAuthorizationRequest(c.acmeClient, authzURI)
+// Authorize completes each authorization of order.
+func (c *Client) Authorize(ctx context.Context, order *acme.Order) (*acme.Order, error) {
+	ctx, done := c.phase(ctx, "challenges", c.timeouts.Challenge)
+	order, err := c.authorize(ctx, order)
+	return order, done(err)
+}
+
+// ResumeOrder fetches an order created earlier, such as by a process that
+// died before downloading its certificate, and completes its authorizations.
+// It returns an error if the order is for other names than names, or can no
+// longer be finalized.
+func (c *Client) ResumeOrder(ctx context.Context, orderURL string, names []string) (*acme.Order, error) {
+	order, err := c.acmeClient.GetOrder(ctx, orderURL)
 	if err != nil {
 		return nil, err
 	}
+	// The URL is only taken from a Location header, which CAs don't send
+	// when fetching an order
+	order.URI = orderURL
+	var orderNames []string
+	for _, id := range order.Identifiers {
+		orderNames = append(orderNames, id.Value)
+	}
+	if !sameNames(orderNames, names) {
+		return nil, fmt.Errorf("it is for %s", strings.Join(orderNames, ", "))
+	}
+	if !order.Expires.IsZero() && time.Now().After(order.Expires) {
+		return nil, errors.New("it has expired")
+	}
+	switch order.Status {
+	case acme.StatusPending:
+		return c.Authorize(ctx, order)
+	case acme.StatusReady, acme.StatusProcessing, acme.StatusValid:
+		return order, nil
+	}
+	return nil, fmt.Errorf("it is %s", order.Status)
+}
 
-	var provisionRes ProvisionResult
-	err = c.localcertPost("/provision", ProvisionRequest{
-		PublicKey:            &jose.JSONWebKey{Key: c.acmeClient.Key.Public()},
-		AuthorizationRequest: authzReq,
-	}, &provisionRes)
-	if err != nil {
-		return nil, fmt.Errorf("provision: %w", err)
+// sameNames reports whether a and b hold the same names in any order.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, name := range a {
+		count[strings.ToLower(name)]++
+	}
+	for _, name := range b {
+		if count[strings.ToLower(name)] == 0 {
+			return false
+		}
+		count[strings.ToLower(name)]--
+	}
+	return true
+}
+
+// authorize completes each authorization of order and waits for it to
+// become ready, removing any published challenge records afterwards.
+func (c *Client) authorize(ctx context.Context, order *acme.Order) (*acme.Order, error) {
+	var challenges []acceptedChallenge
+	for _, authzURI := range order.AuthzURLs {
+		if len(c.challengers) > 0 {
+			chal, cleanup, err := c.solve(ctx, authzURI)
+			if err != nil {
+				audit(c.audit, "challenge", err, "identifier", chal.identifier, "type", chal.typ, "authorization", authzURI)
+				return nil, err
+			}
+			if cleanup != nil {
+				defer cleanup()
+				challenges = append(challenges, chal)
+			}
+			continue
+		}
+
+		if len(order.AuthzURLs) > 1 {
+			authz, err := c.acmeClient.GetAuthorization(ctx, authzURI)
+			if err != nil {
+				return nil, fmt.Errorf("authorization: %w", err)
+			}
+			if authz.Status == acme.StatusValid {
+				continue
+			}
+			if authz.Wildcard && !hasChallenge(authz, "dns-01") {
+				return nil, fmt.Errorf("authorization for %q offers no dns-01 challenge, which wildcards require", authz.Identifier.Value)
+			}
+		}
+
+		authzReq, err := acmeutil.CaptureAuthorizationRequest(c.acmeClient, authzURI)
+		if err != nil {
+			return nil, err
+		}
+
+		var provisionRes ProvisionResult
+		err = c.localcertPost(ctx, "/provision", ProvisionRequest{
+			PublicKey:            &jose.JSONWebKey{Key: c.acmeClient.Key.Public()},
+			AuthorizationRequest: authzReq,
+		}, &provisionRes)
+		if err != nil {
+			audit(c.audit, "challenge", err, "authorization", authzURI)
+			return nil, fmt.Errorf("provision: %w", err)
+		}
+
+		_, err = c.acmeClient.Accept(ctx, &acme.Challenge{URI: provisionRes.ProvisionedChallengeURL})
+		if err != nil {
+			audit(c.audit, "challenge", err, "authorization", authzURI, "challenge", provisionRes.ProvisionedChallengeURL)
+			return nil, fmt.Errorf("challenge accept: %w", err)
+		}
+		challenges = append(challenges, acceptedChallenge{url: provisionRes.ProvisionedChallengeURL})
+	}
+
+	order, waitErr := c.acmeClient.WaitOrder(ctx, order.URI)
+	for _, accepted := range challenges {
+		// The order is only ready once every challenge has succeeded
+		var err error
+		if waitErr != nil {
+			err = waitErr
+			if chal, getErr := c.acmeClient.GetChallenge(ctx, accepted.url); getErr == nil && chal.Error != nil {
+				log.Printf("Challenge error: %#v", chal.Error)
+				err = chal.Error
+			} else if getErr == nil && chal.Status == acme.StatusValid {
+				err = nil
+			}
+		}
+		audit(c.audit, "challenge", err, "identifier", accepted.identifier, "type", accepted.typ, "challenge", accepted.url)
+	}
+	if waitErr != nil {
+		return nil, fmt.Errorf("order wait: %w", waitErr)
 	}
 
-	_, err = c.acmeClient.Accept(ctx, &acme.Challenge{URI: provisionRes.ProvisionedChallengeURL})
+	return order, nil
+}
+
+// acceptedChallenge is a challenge the CA was told to validate.
+type acceptedChallenge struct {
+	identifier, typ, url string
+}
+
+// CheckWildcardPolicy returns an error if a wildcard certificate can't be
+// issued for domain.
+func (c *Client) CheckWildcardPolicy(ctx context.Context, domain string) error {
+	dir, err := c.acmeClient.Discover(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("challenge accept: %w", err)
+		return fmt.Errorf("discover: %w", err)
+	}
+	if dir.OrderURL == "" {
+		return errors.New("ACME server doesn't support RFC 8555 orders, which wildcards require")
 	}
+	// The localcert server only assigns a wildcard domain if it will
+	// answer the DNS-01 challenges for it
+	if len(c.challengers) == 0 && !strings.HasPrefix(domain, "*.") {
+		return fmt.Errorf("localcert server assigned %q, which doesn't allow wildcard issuance", domain)
+	}
+	return nil
+}
 
-	order, err = c.acmeClient.WaitOrder(ctx, order.URI)
+// CheckIPPolicy returns an error if IP addresses can't be validated: they
+// need RFC 8555 orders, and an http-01 or tls-alpn-01 challenger, since
+// the localcert server and DNS-01 only validate domains.
+func (c *Client) CheckIPPolicy(ctx context.Context) error {
+	dir, err := c.acmeClient.Discover(ctx)
 	if err != nil {
-		if chal, err := c.acmeClient.GetChallenge(ctx, provisionRes.ProvisionedChallengeURL); err == nil {
-			log.Printf("Challenge error: %#v", chal.Error)
+		return fmt.Errorf("discover: %w", err)
+	}
+	if dir.OrderURL == "" {
+		return errors.New("ACME server doesn't support RFC 8555 orders, which IP identifiers require")
+	}
+	for _, challenger := range c.challengers {
+		if typ := challenger.Type(); typ == "http-01" || typ == "tls-alpn-01" {
+			return nil
 		}
-		return nil, fmt.Errorf("order wait: %w", err)
 	}
+	return errors.New("IP addresses can only be validated with http-01 or tls-alpn-01 challenges")
+}
 
-	return order, nil
+func hasChallenge(authz *acme.Authorization, typ string) bool {
+	for _, chal := range authz.Challenges {
+		if chal.Type == typ {
+			return true
+		}
+	}
+	return false
 }
 
+// GetCertificate finalizes order with a CSR signed by certKey, which can be
+// any crypto.Signer, and returns the issued chain.
 func (c *Client) GetCertificate(ctx context.Context, order *acme.Order, certKey crypto.Signer) ([][]byte, error) {
-	name := order.Identifiers[0].Value
-	req := &x509.CertificateRequest{
-		Subject:  pkix.Name{CommonName: name},
-		DNSNames: []string{name},
+	var altNames []string
+	for _, id := range order.Identifiers[1:] {
+		altNames = append(altNames, id.Value)
 	}
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, req, certKey)
+	csrBytes, err := CreateCSR(order.Identifiers[0].Value, certKey, altNames...)
 	if err != nil {
-		return nil, fmt.Errorf("create csr: %w", err)
+		return nil, err
 	}
+	return c.FinalizeCSR(ctx, order, csrBytes)
+}
 
-	bundle, _, err := c.acmeClient.CreateOrderCert(ctx, order.FinalizeURL, csrBytes, true)
-	return bundle, err
+// FinalizeCSR finalizes order with a DER-encoded CSR, which must request
+// exactly the order's names, and returns the issued chain. An order that
+// was finalized already, as a resumed one may be, is only downloaded.
+func (c *Client) FinalizeCSR(ctx context.Context, order *acme.Order, csrBytes []byte) ([][]byte, error) {
+	if order.Status == acme.StatusProcessing || order.Status == acme.StatusValid {
+		bundle, certURL, err := c.download(ctx, order)
+		if err != nil {
+			return nil, err
+		}
+		if c.chain != "" {
+			bundle = c.selectChain(ctx, certURL, bundle)
+		}
+		return bundle, nil
+	}
+	finalizeCtx, finalizeDone := c.phase(ctx, "finalize", c.timeouts.Finalize)
+	bundle, certURL, err := c.acmeClient.CreateOrderCert(finalizeCtx, order.FinalizeURL, csrBytes, true)
+	if err = finalizeDone(err); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if bundle, certURL, err = c.redownload(ctx, order, err); err != nil {
+			return nil, err
+		}
+	}
+	if c.chain != "" {
+		bundle = c.selectChain(ctx, certURL, bundle)
+	}
+	return bundle, nil
 }
 
-func (c *Client) localcertPost(urlSuffix string, req interface{}, res interface{}) error {
-	url := c.serverURL + urlSuffix
-	if *flagDebug {
-		fmt.Println("json url: ", url)
+// redownload fetches the certificate for order again after finalizing
+// failed with err, in case it was issued with only the download failing.
+func (c *Client) redownload(ctx context.Context, order *acme.Order, err error) ([][]byte, string, error) {
+	ctx, done := c.phase(ctx, "download", c.timeouts.Download)
+	order, orderErr := c.acmeClient.GetOrder(ctx, order.URI)
+	if orderErr != nil || order.Status != acme.StatusValid || order.CertURL == "" {
+		done(nil)
+		return nil, "", err
 	}
-	body, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("json encode: %s", err)
+	bundle, err := c.acmeClient.FetchCert(ctx, order.CertURL, true)
+	if err = done(err); err != nil {
+		return nil, "", fmt.Errorf("download: %w", err)
 	}
+	return bundle, order.CertURL, nil
+}
 
-	if *flagDebug {
-		b, err := ioutil.ReadAll(bytes.NewReader(body))
-		fmt.Println("json req: ", string(b), err)
+// download waits for order to be issued and fetches its certificate.
+func (c *Client) download(ctx context.Context, order *acme.Order) ([][]byte, string, error) {
+	ctx, done := c.phase(ctx, "download", c.timeouts.Download)
+	order, err := c.acmeClient.WaitOrder(ctx, order.URI)
+	var bundle [][]byte
+	if err == nil {
+		bundle, err = c.acmeClient.FetchCert(ctx, order.CertURL, true)
 	}
-	// var resp http.Response
-	// var err error 
-	resp, err := c.acmeClient.HTTPClient.Post(url, "application/json", bytes.NewReader(body))
+	if err = done(err); err != nil {
+		return nil, "", fmt.Errorf("download: %w", err)
+	}
+	return bundle, order.CertURL, nil
+}
 
+// RevokeCertificate revokes cert, signing the request with key, or with the
+// ACME account key if key is nil.
+func (c *Client) RevokeCertificate(ctx context.Context, cert []byte, key crypto.Signer, reason acme.CRLReasonCode) error {
+	err := c.acmeClient.RevokeCert(ctx, key, cert, reason)
+	serial := ""
+	if parsed, parseErr := x509.ParseCertificate(cert); parseErr == nil {
+		serial = parsed.SerialNumber.Text(16)
+	}
+	audit(c.audit, "revocation", err, "serial", serial, "reason", strconv.Itoa(int(reason)))
 	if err != nil {
-		return err
+		return fmt.Errorf("revoke: %w", err)
 	}
+	return nil
+}
 
-	if *flagDebug {
-		fmt.Println("json resp: ", resp)
-	}
+// CreateCSR returns a DER-encoded CSR for name and any altNames.
+func CreateCSR(name string, certKey crypto.Signer, altNames ...string) ([]byte, error) {
+	return CreateCSRWithExtensions(name, certKey, nil, altNames...)
+}
 
-	if *flagDebug {
-		d, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Println(string(d))
+func (c *Client) localcertPost(ctx context.Context, urlSuffix string, req interface{}, res interface{}) error {
+	url := c.serverURL + urlSuffix
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("json encode: %s", err)
 	}
 
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.acmeClient.HTTPClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 	if statusErr := acmeutil.ErrorFromResponse(resp); statusErr != nil {
-		if *flagDebug {
-			fmt.Println("json statusErr: ", statusErr)
-			body, err := ioutil.ReadAll(resp.Body)
-			fmt.Println("json body: ", string(body), err)
-		}	
 		return statusErr
 	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
 
 	err = json.NewDecoder(resp.Body).Decode(res)
 	if err != nil {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wildone/localcert/internal/acmetest"
+)
+
+// The end-to-end tests run the test binary itself as localcert, with
+// runAsLocalcert set in its environment.
+const runAsLocalcert = "LOCALCERT_E2E_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runAsLocalcert) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type e2eResult struct {
+	Domain          string `json:"domain"`
+	Serial          string `json:"serial"`
+	Renewed         bool   `json:"renewed"`
+	CertificateFile string `json:"certificateFile"`
+	KeyFile         string `json:"keyFile"`
+}
+
+// localcert runs localcert with args against server, with its files in
+// dataDir, and returns its stdout.
+func localcert(t *testing.T, server *acmetest.Server, dataDir string, args ...string) ([]byte, error) {
+	t.Helper()
+	args = append([]string{
+		"-dataDir", dataDir,
+		"-serverUrl", server.LocalcertURL(),
+		"-testCA", server.DirectoryURL(),
+		"-acceptTerms",
+		"-json",
+	}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	// Keep the user's config file and LOCALCERT_* settings out of it
+	home := filepath.Join(dataDir, "home")
+	cmd.Env = []string{runAsLocalcert + "=1", "HOME=" + home, "USERPROFILE=" + home, "XDG_CONFIG_HOME=" + filepath.Join(home, ".config"), "APPDATA=" + filepath.Join(home, "AppData")}
+	for _, env := range os.Environ() {
+		if name := strings.SplitN(env, "=", 2)[0]; name == "PATH" || name == "SYSTEMROOT" || name == "TMPDIR" || name == "TEMP" {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	t.Logf("localcert %s:\n%s", strings.Join(args, " "), stderr.Bytes())
+	return stdout.Bytes(), err
+}
+
+func provisionResult(t *testing.T, server *acmetest.Server, dataDir string, args ...string) e2eResult {
+	t.Helper()
+	out, err := localcert(t, server, dataDir, append(args, "provision")...)
+	if err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	var result e2eResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatalf("provision result %q: %v", out, err)
+	}
+	return result
+}
+
+// checkCertificate checks that the certificate file holds the certificate
+// of result, issued by server.
+func checkCertificate(t *testing.T, server *acmetest.Server, result e2eResult) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(result.CertificateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("%s holds no certificate", result.CertificateFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(server.CA()); err != nil {
+		t.Errorf("certificate isn't issued by the test CA: %v", err)
+	}
+	if cert.SerialNumber.Text(16) != result.Serial {
+		t.Errorf("certificate serial = %s, result serial = %s", cert.SerialNumber.Text(16), result.Serial)
+	}
+	if cert.Subject.CommonName != result.Domain {
+		t.Errorf("certificate is for %s, want %s", cert.Subject.CommonName, result.Domain)
+	}
+	if _, err := os.Stat(result.KeyFile); err != nil {
+		t.Errorf("key file: %v", err)
+	}
+	return cert
+}
+
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs localcert against a test CA")
+	}
+	server := acmetest.NewServer()
+	defer server.Close()
+	dataDir := t.TempDir()
+
+	first := provisionResult(t, server, dataDir)
+	if !first.Renewed {
+		t.Error("first provision didn't issue a certificate")
+	}
+	if first.Domain == "" {
+		t.Fatal("first provision wasn't assigned a domain")
+	}
+	if !strings.HasPrefix(first.CertificateFile, filepath.Join(dataDir, "test-ca")) {
+		t.Errorf("certificate %s isn't kept apart under test-ca", first.CertificateFile)
+	}
+	checkCertificate(t, server, first)
+	if orders := server.Orders(); orders != 1 {
+		t.Errorf("%d orders after the first provision, want 1", orders)
+	}
+
+	// Up to date: nothing is ordered
+	again := provisionResult(t, server, dataDir)
+	if again.Renewed || again.Serial != first.Serial {
+		t.Errorf("second provision replaced certificate %s with %s", first.Serial, again.Serial)
+	}
+	if orders := server.Orders(); orders != 1 {
+		t.Errorf("%d orders after an up to date provision, want 1", orders)
+	}
+
+	renewed := provisionResult(t, server, dataDir, "-forceRenew")
+	if !renewed.Renewed || renewed.Serial == first.Serial {
+		t.Errorf("forced renewal kept certificate %s", first.Serial)
+	}
+	if renewed.Domain != first.Domain {
+		t.Errorf("renewal is for %s, want %s", renewed.Domain, first.Domain)
+	}
+	cert := checkCertificate(t, server, renewed)
+	if orders := server.Orders(); orders != 2 {
+		t.Errorf("%d orders after the renewal, want 2", orders)
+	}
+
+	out, err := localcert(t, server, dataDir, "-reason", "superseded", "revoke")
+	if err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	var revoked struct {
+		Serial string `json:"serial"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(out, &revoked); err != nil {
+		t.Fatalf("revoke result %q: %v", out, err)
+	}
+	if revoked.Serial != renewed.Serial || revoked.Reason != "superseded" {
+		t.Errorf("revoke result = %+v, want serial %s revoked as superseded", revoked, renewed.Serial)
+	}
+	if !server.Revoked(cert.SerialNumber) {
+		t.Error("the test CA didn't revoke the certificate")
+	}
+	if first, _ := new(big.Int).SetString(first.Serial, 16); server.Revoked(first) {
+		t.Error("the test CA revoked the first certificate too")
+	}
+}
+
+func TestEndToEndFailedOrder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs localcert against a test CA")
+	}
+	server := acmetest.NewServer()
+	defer server.Close()
+	server.SetFailOrders(true)
+	dataDir := t.TempDir()
+
+	_, err := localcert(t, server, dataDir, "provision")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() == 0 {
+		t.Fatalf("provision with failing orders = %v, want a nonzero exit", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "test-ca", "live", "fullchain.pem")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed provision wrote a certificate: %v", err)
+	}
+}
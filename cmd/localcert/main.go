@@ -2,20 +2,101 @@ package main
 
 import (
 	"flag"
-	"log"
+	"os"
+	"strings"
 
 	"github.com/wildone/localcert/internal/cli"
 )
 
 func main() {
+	os.Args = append(os.Args[:1], flagsFirst(os.Args[1:])...)
 	flag.Parse()
 	subcmd := flag.Arg(0)
 	switch flag.Arg(0) {
 	case "provision", "":
 		cli.Provision()
+	case "init":
+		cli.Init()
+	case "daemon":
+		cli.Daemon()
+	case "serve":
+		cli.Serve()
 	case "test":
 		cli.Test()
+	case "verify":
+		cli.Verify()
+	case "probe":
+		cli.Probe()
+	case "export", "export-config":
+		cli.Export()
+	case "gen-csr":
+		cli.GenCSR()
+	case "import-cert":
+		cli.ImportCert()
+	case "import":
+		cli.Import()
+	case "backup":
+		cli.Backup()
+	case "restore":
+		cli.Restore()
+	case "config":
+		cli.ConfigCommand()
+	case "control":
+		cli.Control()
+	case "dns":
+		cli.DNSRecords()
+	case "domain":
+		cli.Domain()
+	case "account":
+		cli.Account()
+	case "revoke":
+		cli.Revoke()
+	case "doctor":
+		cli.Doctor()
+	case "limits":
+		cli.Limits()
+	case "status", "inspect":
+		cli.Status()
+	case "trust":
+		cli.Trust()
+	case "install-systemd":
+		cli.InstallSystemd()
+	case "install-service":
+		cli.InstallService()
 	default:
-		log.Fatalf("Invalid subcommand %q", subcmd)
+		cli.InvalidSubcommand(subcmd)
 	}
 }
+
+// flagsFirst moves flags given after the subcommand, as in "localcert
+// export-config -server nginx", in front of it: the flag package stops at
+// the first argument.
+func flagsFirst(args []string) []string {
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			rest = append(rest, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := flag.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	return append(append(flags, "--"), rest...)
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,114 @@
+package localcert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	// id-pe-tlsfeature (RFC 7633)
+	oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	// status_request TLS extension
+	tlsFeatureStatusRequest = 5
+)
+
+// MustStapleExtension is the TLS Feature extension requesting OCSP
+// Must-Staple: clients reject the certificate unless the server staples a
+// valid OCSP response.
+var MustStapleExtension = pkix.Extension{
+	Id:    oidTLSFeature,
+	Value: []byte{0x30, 0x03, 0x02, 0x01, byte(tlsFeatureStatusRequest)},
+}
+
+// HasMustStaple reports whether cert requires OCSP stapling.
+func HasMustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CreateCSRWithExtensions returns a DER-encoded CSR for name and any
+// altNames that also requests extensions, such as MustStapleExtension.
+// altNames that parse as IP addresses are requested as such.
+func CreateCSRWithExtensions(name string, certKey crypto.Signer, extensions []pkix.Extension, altNames ...string) ([]byte, error) {
+	req := &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: name},
+		DNSNames:        []string{name},
+		ExtraExtensions: extensions,
+	}
+	for _, altName := range altNames {
+		if ip := net.ParseIP(altName); ip != nil {
+			req.IPAddresses = append(req.IPAddresses, ip)
+		} else {
+			req.DNSNames = append(req.DNSNames, altName)
+		}
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, req, certKey)
+	if err != nil {
+		return nil, fmt.Errorf("create csr: %w", err)
+	}
+	return csrBytes, nil
+}
+
+// ParseCSR parses a PEM or DER-encoded CSR and checks its signature.
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != pemutil.CertificateRequestType && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("unexpected PEM type %q", block.Type)
+		}
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("csr signature: %w", err)
+	}
+	if len(CSRNames(csr)) == 0 {
+		return nil, errors.New("csr names no domains")
+	}
+	return csr, nil
+}
+
+// CSRNames returns the names csr requests, its common name first, and then
+// any IP addresses.
+func CSRNames(csr *x509.CertificateRequest) []string {
+	var names []string
+	if csr.Subject.CommonName != "" {
+		names = append(names, csr.Subject.CommonName)
+	}
+	for _, name := range csr.DNSNames {
+		if !strings.EqualFold(name, csr.Subject.CommonName) {
+			names = append(names, name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if ip.String() != csr.Subject.CommonName {
+			names = append(names, ip.String())
+		}
+	}
+	return names
+}
@@ -0,0 +1,128 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare publishes records with the Cloudflare API.
+type Cloudflare struct {
+	// APIToken needs Zone:Read and DNS:Edit permissions.
+	APIToken string
+	// ZoneID is looked up from the record name if empty.
+	ZoneID     string
+	HTTPClient *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+func (p *Cloudflare) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	record := cloudflareRecord{Type: "TXT", Name: strings.TrimSuffix(fqdn, "."), Content: value, TTL: 120}
+	return p.do(ctx, "POST", "/zones/"+zoneID+"/dns_records", record, nil)
+}
+
+func (p *Cloudflare) CleanUp(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"type": {"TXT"}, "name": {strings.TrimSuffix(fqdn, ".")}, "content": {value}}
+	var records []cloudflareRecord
+	if err := p.do(ctx, "GET", "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := p.do(ctx, "DELETE", "/zones/"+zoneID+"/dns_records/"+record.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Cloudflare) zoneID(ctx context.Context, fqdn string) (string, error) {
+	if p.ZoneID != "" {
+		return p.ZoneID, nil
+	}
+	for _, candidate := range zoneCandidates(fqdn) {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		query := url.Values{"name": {strings.TrimSuffix(candidate, ".")}}
+		if err := p.do(ctx, "GET", "/zones?"+query.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			p.ZoneID = zones[0].ID
+			return p.ZoneID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone found for %s", fqdn)
+}
+
+func (p *Cloudflare) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPI+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: decode response: %w", method, path, err)
+	}
+	if !res.Success {
+		var msgs []string
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		if len(msgs) == 0 {
+			msgs = append(msgs, resp.Status)
+		}
+		return errors.New("cloudflare: " + strings.Join(msgs, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(res.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// Package dnsprovider implements localcert.ChallengeSolver for DNS providers,
+// for issuing certificates for domains that aren't delegated to the
+// localcert service.
+package dnsprovider
+
+import (
+	"strings"
+)
+
+// zoneCandidates returns fqdn and each of its parent domains, each with a
+// trailing dot, most specific first.
+func zoneCandidates(fqdn string) []string {
+	fqdn = strings.TrimSuffix(fqdn, ".")
+	var candidates []string
+	for {
+		candidates = append(candidates, fqdn+".")
+		i := strings.IndexByte(fqdn, '.')
+		if i < 0 {
+			return candidates
+		}
+		fqdn = fqdn[i+1:]
+	}
+}
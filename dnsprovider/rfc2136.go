@@ -0,0 +1,108 @@
+package dnsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RFC2136 publishes records with DNS UPDATE messages, optionally signed with
+// TSIG.
+type RFC2136 struct {
+	// Nameserver is the host:port of the primary server for the zone.
+	Nameserver string
+	// Zone is looked up with SOA queries to Nameserver if empty.
+	Zone string
+
+	TSIGKeyName string
+	// TSIGSecret is base64-encoded.
+	TSIGSecret string
+	// TSIGAlgorithm is dns.HmacSHA256 if empty.
+	TSIGAlgorithm string
+
+	TTL uint32
+}
+
+func (p *RFC2136) Present(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, true)
+}
+
+func (p *RFC2136) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, false)
+}
+
+func (p *RFC2136) update(ctx context.Context, fqdn, value string, insert bool) error {
+	zone, err := p.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = 60
+	}
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(fqdn), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		Txt: []string{value},
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	if insert {
+		msg.Insert([]dns.RR{rr})
+	} else {
+		msg.Remove([]dns.RR{rr})
+	}
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136: update %s: %s", fqdn, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+func (p *RFC2136) zone(ctx context.Context, fqdn string) (string, error) {
+	if p.Zone != "" {
+		return dns.Fqdn(p.Zone), nil
+	}
+	for _, candidate := range zoneCandidates(fqdn) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(candidate, dns.TypeSOA)
+		resp, err := p.exchange(ctx, msg)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range resp.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Name == candidate {
+				p.Zone = candidate
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("rfc2136: no zone found for %s", fqdn)
+}
+
+func (p *RFC2136) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if p.Nameserver == "" {
+		return nil, errors.New("rfc2136: no nameserver configured")
+	}
+	client := &dns.Client{Net: "tcp", Timeout: 30 * time.Second}
+	if p.TSIGKeyName != "" {
+		algorithm := p.TSIGAlgorithm
+		if algorithm == "" {
+			algorithm = dns.HmacSHA256
+		}
+		keyName := dns.Fqdn(p.TSIGKeyName)
+		client.TsigSecret = map[string]string{keyName: p.TSIGSecret}
+		msg.SetTsig(keyName, dns.Fqdn(algorithm), 300, time.Now().Unix())
+	}
+	resp, _, err := client.ExchangeContext(ctx, msg, p.Nameserver)
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	return resp, nil
+}
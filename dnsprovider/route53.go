@@ -0,0 +1,225 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert/internal/awssig"
+)
+
+const (
+	route53API       = "https://route53.amazonaws.com/2013-04-01"
+	route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+	route53TTL       = 60
+)
+
+// Route53 publishes records in Amazon Route 53.
+type Route53 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// HostedZoneID is looked up from the record name if empty.
+	HostedZoneID string
+	HTTPClient   *http.Client
+}
+
+type route53RecordSet struct {
+	Name    string          `xml:"Name"`
+	Type    string          `xml:"Type"`
+	TTL     int             `xml:"TTL"`
+	Records []route53Record `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+func (p *Route53) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	existing, err := p.txtRecordSet(ctx, zoneID, fqdn)
+	if err != nil {
+		return err
+	}
+	records := []route53Record{{Value: quoteTXT(value)}}
+	if existing != nil {
+		for _, record := range existing.Records {
+			if record.Value == quoteTXT(value) {
+				return nil
+			}
+		}
+		records = append(existing.Records, records...)
+	}
+	changeID, err := p.change(ctx, zoneID, "UPSERT", route53RecordSet{Name: fqdn, Type: "TXT", TTL: route53TTL, Records: records})
+	if err != nil {
+		return err
+	}
+	return p.waitForSync(ctx, changeID)
+}
+
+func (p *Route53) CleanUp(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	existing, err := p.txtRecordSet(ctx, zoneID, fqdn)
+	if err != nil || existing == nil {
+		return err
+	}
+	var remaining []route53Record
+	for _, record := range existing.Records {
+		if record.Value != quoteTXT(value) {
+			remaining = append(remaining, record)
+		}
+	}
+	switch {
+	case len(remaining) == len(existing.Records):
+		return nil
+	case len(remaining) == 0:
+		_, err = p.change(ctx, zoneID, "DELETE", *existing)
+	default:
+		_, err = p.change(ctx, zoneID, "UPSERT", route53RecordSet{Name: fqdn, Type: "TXT", TTL: existing.TTL, Records: remaining})
+	}
+	return err
+}
+
+func quoteTXT(value string) string {
+	return `"` + value + `"`
+}
+
+func (p *Route53) zoneID(ctx context.Context, fqdn string) (string, error) {
+	if p.HostedZoneID != "" {
+		return p.HostedZoneID, nil
+	}
+	for _, candidate := range zoneCandidates(fqdn) {
+		var res struct {
+			HostedZones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		query := url.Values{"dnsname": {candidate}, "maxitems": {"1"}}
+		if err := p.do(ctx, "GET", "/hostedzonesbyname?"+query.Encode(), nil, &res); err != nil {
+			return "", err
+		}
+		if len(res.HostedZones) > 0 && res.HostedZones[0].Name == candidate {
+			p.HostedZoneID = strings.TrimPrefix(res.HostedZones[0].ID, "/hostedzone/")
+			return p.HostedZoneID, nil
+		}
+	}
+	return "", fmt.Errorf("route53: no hosted zone found for %s", fqdn)
+}
+
+func (p *Route53) txtRecordSet(ctx context.Context, zoneID, fqdn string) (*route53RecordSet, error) {
+	var res struct {
+		RecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	query := url.Values{"name": {fqdn}, "type": {"TXT"}, "maxitems": {"1"}}
+	if err := p.do(ctx, "GET", "/hostedzone/"+zoneID+"/rrset?"+query.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+	if len(res.RecordSets) == 0 || res.RecordSets[0].Name != fqdn || res.RecordSets[0].Type != "TXT" {
+		return nil, nil
+	}
+	return &res.RecordSets[0], nil
+}
+
+func (p *Route53) change(ctx context.Context, zoneID, action string, recordSet route53RecordSet) (string, error) {
+	type change struct {
+		Action    string           `xml:"Action"`
+		RecordSet route53RecordSet `xml:"ResourceRecordSet"`
+	}
+	req := struct {
+		XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Changes []change `xml:"ChangeBatch>Changes>Change"`
+	}{
+		XMLNS:   route53Namespace,
+		Changes: []change{{Action: action, RecordSet: recordSet}},
+	}
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		ID string `xml:"ChangeInfo>Id"`
+	}
+	if err := p.do(ctx, "POST", "/hostedzone/"+zoneID+"/rrset/", body, &res); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(res.ID, "/change/"), nil
+}
+
+// waitForSync waits for Route 53 to apply a change on all of its servers.
+func (p *Route53) waitForSync(ctx context.Context, changeID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	for {
+		var res struct {
+			Status string `xml:"ChangeInfo>Status"`
+		}
+		if err := p.do(ctx, "GET", "/change/"+changeID, nil, &res); err != nil {
+			return err
+		}
+		if res.Status == "INSYNC" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("route53: waiting for change %s: %w", changeID, ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (p *Route53) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, route53API+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	awssig.Sign(req, body, p.AccessKeyID, p.SecretAccessKey, p.SessionToken, "us-east-1", "route53", time.Now())
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("route53: %w", err)
+	}
+	defer resp.Body.Close()
+	resBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("route53: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		var errRes struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(resBody, &errRes) != nil || errRes.Code == "" {
+			return fmt.Errorf("route53: %s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("route53: %s: %s", errRes.Code, errRes.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(resBody, result); err != nil {
+		return errors.New("route53: decode response: " + err.Error())
+	}
+	return nil
+}
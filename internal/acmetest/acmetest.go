@@ -0,0 +1,663 @@
+// Package acmetest is a fake ACME CA and localcert server for tests. It
+// implements enough of RFC 8555 for golang.org/x/crypto/acme, validates
+// every challenge it is asked to, and issues from a throwaway CA.
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	problemPrefix = "urn:ietf:params:acme:error:"
+
+	// DomainSuffix is the parent of the domains the fake localcert server
+	// assigns.
+	DomainSuffix = ".user.localcert.test"
+)
+
+// Server is a fake ACME CA and localcert server. The zero value isn't
+// usable; use NewServer.
+type Server struct {
+	// Lifetime is how long issued certificates are valid for; 90 days if
+	// zero.
+	Lifetime time.Duration
+
+	srv    *httptest.Server
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu          sync.Mutex
+	nextID      int
+	accounts    map[string]*account // by URL
+	accountKeys map[string]string   // account URL by key thumbprint
+	orders      map[string]*order
+	authzs      map[string]*authz
+	certs       map[string][][]byte
+	revoked     map[string]bool // by serial
+	orderCount  int
+	failOrders  bool
+}
+
+type account struct {
+	url    string
+	key    *jose.JSONWebKey
+	domain string
+}
+
+type order struct {
+	id          string
+	account     string
+	status      string
+	identifiers []identifier
+	authzs      []string
+	certURL     string
+}
+
+type authz struct {
+	id         string
+	order      *order
+	identifier identifier
+	status     string
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer() *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("acmetest: generate CA key: %v", err))
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(fmt.Sprintf("acmetest: create CA certificate: %v", err))
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("acmetest: parse CA certificate: %v", err))
+	}
+	s := &Server{
+		caKey:       key,
+		caCert:      caCert,
+		nextID:      1,
+		accounts:    make(map[string]*account),
+		accountKeys: make(map[string]string),
+		orders:      make(map[string]*order),
+		authzs:      make(map[string]*authz),
+		certs:       make(map[string][][]byte),
+		revoked:     make(map[string]bool),
+	}
+	// Handlers read srv for the URLs, so it's set before serving
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.srv.Start()
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// DirectoryURL is the ACME directory URL.
+func (s *Server) DirectoryURL() string {
+	return s.srv.URL + "/directory"
+}
+
+// LocalcertURL is the localcert server URL.
+func (s *Server) LocalcertURL() string {
+	return s.srv.URL + "/localcert"
+}
+
+// CA returns the certificate that issues the server's certificates.
+func (s *Server) CA() *x509.Certificate {
+	return s.caCert
+}
+
+// Orders returns how many orders have been placed, including failed ones.
+func (s *Server) Orders() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orderCount
+}
+
+// Revoked reports whether the certificate with serial has been revoked.
+func (s *Server) Revoked(serial *big.Int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[serial.String()]
+}
+
+// SetFailOrders makes new orders fail, with an unauthorized problem, until
+// it is called with false.
+func (s *Server) SetFailOrders(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failOrders = fail
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.nonce())
+	w.Header().Set("Cache-Control", "no-store")
+	path := r.URL.Path
+	switch {
+	case path == "/directory":
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   s.srv.URL + "/acme/new-nonce",
+			"newAccount": s.srv.URL + "/acme/new-account",
+			"newOrder":   s.srv.URL + "/acme/new-order",
+			"revokeCert": s.srv.URL + "/acme/revoke-cert",
+			"keyChange":  s.srv.URL + "/acme/key-change",
+			"meta":       map[string]interface{}{},
+		})
+		return
+	case path == "/acme/new-nonce":
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.Method != http.MethodPost:
+		s.problem(w, http.StatusMethodNotAllowed, "malformed", "method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if strings.HasPrefix(path, "/localcert/") {
+		s.serveLocalcert(w, strings.TrimPrefix(path, "/localcert"), body)
+		return
+	}
+
+	req, err := s.verify(body, s.srv.URL+path)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case path == "/acme/new-account":
+		s.newAccount(w, req)
+	case path == "/acme/new-order":
+		s.newOrder(w, req)
+	case path == "/acme/revoke-cert":
+		s.revokeCert(w, req)
+	case strings.HasPrefix(path, "/acme/acct/"):
+		s.getAccount(w, req)
+	case strings.HasPrefix(path, "/acme/order/"):
+		s.getOrder(w, req, strings.TrimPrefix(path, "/acme/order/"))
+	case strings.HasPrefix(path, "/acme/authz/"):
+		s.getAuthz(w, req, strings.TrimPrefix(path, "/acme/authz/"))
+	case strings.HasPrefix(path, "/acme/chall/"):
+		s.acceptChallenge(w, req, strings.TrimPrefix(path, "/acme/chall/"))
+	case strings.HasPrefix(path, "/acme/finalize/"):
+		s.finalize(w, req, strings.TrimPrefix(path, "/acme/finalize/"))
+	case strings.HasPrefix(path, "/acme/cert/"):
+		s.getCert(w, req, strings.TrimPrefix(path, "/acme/cert/"))
+	default:
+		s.problem(w, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+// request is a verified JWS request.
+type request struct {
+	payload []byte
+	// account is the signer's account, if it has one
+	account *account
+	// key is the signer's key, if it signed with a JWK
+	key *jose.JSONWebKey
+}
+
+// verify checks the signature of a JWS request to url.
+func (s *Server) verify(body []byte, url string) (*request, error) {
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse jws: %w", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("expected 1 signature, got %d", len(jws.Signatures))
+	}
+	header := jws.Signatures[0].Protected
+	if header.ExtraHeaders["url"] != url {
+		return nil, fmt.Errorf("signed url %v isn't %s", header.ExtraHeaders["url"], url)
+	}
+
+	req := &request{}
+	var key interface{}
+	s.mu.Lock()
+	if header.JSONWebKey != nil {
+		req.key = header.JSONWebKey
+		key = header.JSONWebKey.Key
+		if accountURL, ok := s.accountKeys[thumbprint(header.JSONWebKey)]; ok {
+			req.account = s.accounts[accountURL]
+		}
+	} else if acct, ok := s.accounts[header.KeyID]; ok {
+		req.account = acct
+		key = acct.key.Key
+	}
+	s.mu.Unlock()
+	if key == nil {
+		return nil, fmt.Errorf("unknown account %q", header.KeyID)
+	}
+	if req.payload, err = jws.Verify(key); err != nil {
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+	return req, nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, req *request) {
+	if req.key == nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "new account requests must be signed with a JWK")
+		return
+	}
+	var payload struct {
+		OnlyReturnExisting bool `json:"onlyReturnExisting"`
+	}
+	json.Unmarshal(req.payload, &payload)
+	if req.account != nil {
+		w.Header().Set("Location", req.account.url)
+		s.writeJSON(w, http.StatusOK, map[string]string{"status": "valid"})
+		return
+	}
+	if payload.OnlyReturnExisting {
+		s.problem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for this key")
+		return
+	}
+	id := s.newID()
+	acct := &account{
+		url:    s.srv.URL + "/acme/acct/" + id,
+		key:    req.key,
+		domain: "d" + id + DomainSuffix,
+	}
+	s.accounts[acct.url] = acct
+	s.accountKeys[thumbprint(req.key)] = acct.url
+	w.Header().Set("Location", acct.url)
+	s.writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, req *request) {
+	if req.account == nil {
+		s.problem(w, http.StatusUnauthorized, "unauthorized", "no account")
+		return
+	}
+	w.Header().Set("Location", req.account.url)
+	s.writeJSON(w, http.StatusOK, map[string]string{"status": "valid"})
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, req *request) {
+	if req.account == nil {
+		s.problem(w, http.StatusUnauthorized, "unauthorized", "no account")
+		return
+	}
+	s.orderCount++
+	if s.failOrders {
+		s.problem(w, http.StatusForbidden, "unauthorized", "orders are failing")
+		return
+	}
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil || len(payload.Identifiers) == 0 {
+		s.problem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+	o := &order{
+		id:          s.newID(),
+		account:     req.account.url,
+		status:      "pending",
+		identifiers: payload.Identifiers,
+	}
+	for _, id := range payload.Identifiers {
+		a := &authz{id: s.newID(), order: o, identifier: id, status: "pending"}
+		s.authzs[a.id] = a
+		o.authzs = append(o.authzs, a.id)
+	}
+	s.orders[o.id] = o
+	w.Header().Set("Location", s.srv.URL+"/acme/order/"+o.id)
+	s.writeJSON(w, http.StatusCreated, s.orderJSON(o))
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, req *request, id string) {
+	o, ok := s.orders[id]
+	if !ok || req.account == nil || o.account != req.account.url {
+		s.problem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	w.Header().Set("Location", s.srv.URL+"/acme/order/"+o.id)
+	s.writeJSON(w, http.StatusOK, s.orderJSON(o))
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, req *request, id string) {
+	a, ok := s.authzs[id]
+	if !ok || req.account == nil || a.order.account != req.account.url {
+		s.problem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.authzJSON(a))
+}
+
+// acceptChallenge validates a challenge, and with it its authorization, as
+// soon as the client asks.
+func (s *Server) acceptChallenge(w http.ResponseWriter, req *request, id string) {
+	a, ok := s.authzs[strings.SplitN(id, "-", 2)[0]]
+	if !ok || req.account == nil || a.order.account != req.account.url {
+		s.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+	a.status = "valid"
+	ready := true
+	for _, authzID := range a.order.authzs {
+		ready = ready && s.authzs[authzID].status == "valid"
+	}
+	if ready && a.order.status == "pending" {
+		a.order.status = "ready"
+	}
+	for _, chal := range s.authzJSON(a).Challenges {
+		if strings.HasSuffix(chal.URL, "/"+id) {
+			s.writeJSON(w, http.StatusOK, chal)
+			return
+		}
+	}
+	s.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+}
+
+func (s *Server) finalize(w http.ResponseWriter, req *request, id string) {
+	o, ok := s.orders[id]
+	if !ok || req.account == nil || o.account != req.account.url {
+		s.problem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if o.status != "ready" {
+		s.problem(w, http.StatusForbidden, "orderNotReady", "order is "+o.status)
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	json.Unmarshal(req.payload, &payload)
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	chain, err := s.issue(csr, o.identifiers)
+	if err != nil {
+		s.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	o.status = "valid"
+	o.certURL = s.srv.URL + "/acme/cert/" + o.id
+	s.certs[o.id] = chain
+	w.Header().Set("Location", s.srv.URL+"/acme/order/"+o.id)
+	s.writeJSON(w, http.StatusOK, s.orderJSON(o))
+}
+
+func (s *Server) issue(csr *x509.CertificateRequest, ids []identifier) ([][]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	lifetime := s.Lifetime
+	if lifetime == 0 {
+		lifetime = 90 * 24 * time.Hour
+	}
+	notBefore := time.Now().Add(-time.Minute).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: ids[0].Value},
+		NotBefore:      notBefore,
+		NotAfter:       notBefore.Add(lifetime),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		AuthorityKeyId: s.caCert.SubjectKeyId,
+	}
+	for _, id := range ids {
+		template.DNSNames = append(template.DNSNames, id.Value)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{der, s.caCert.Raw}, nil
+}
+
+func (s *Server) getCert(w http.ResponseWriter, req *request, id string) {
+	chain, ok := s.certs[id]
+	if !ok || req.account == nil || s.orders[id].account != req.account.url {
+		s.problem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	for _, der := range chain {
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+}
+
+func (s *Server) revokeCert(w http.ResponseWriter, req *request) {
+	var payload struct {
+		Certificate string `json:"certificate"`
+	}
+	json.Unmarshal(req.payload, &payload)
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil || cert.CheckSignatureFrom(s.caCert) != nil {
+		s.problem(w, http.StatusNotFound, "malformed", "certificate wasn't issued here")
+		return
+	}
+	// Either the certificate key or the account that ordered it may revoke
+	authorized := req.key != nil && samePublicKey(req.key.Key, cert.PublicKey)
+	for orderID, chain := range s.certs {
+		if string(chain[0]) == string(der) && req.account != nil && s.orders[orderID].account == req.account.url {
+			authorized = true
+		}
+	}
+	if !authorized {
+		s.problem(w, http.StatusForbidden, "unauthorized", "not authorized to revoke")
+		return
+	}
+	if s.revoked[cert.SerialNumber.String()] {
+		s.problem(w, http.StatusBadRequest, "alreadyRevoked", "already revoked")
+		return
+	}
+	s.revoked[cert.SerialNumber.String()] = true
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveLocalcert answers the localcert server's API: every account is
+// assigned a domain of its own, and challenges are "provisioned" by
+// pointing the client at them, since the CA validates every one.
+func (s *Server) serveLocalcert(w http.ResponseWriter, path string, body []byte) {
+	switch path {
+	case "/domain", "/domain/renew":
+		var req struct {
+			AccountRequest []byte `json:"signedAccountRequest"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+			return
+		}
+		signed, err := s.verify(req.AccountRequest, s.srv.URL+"/acme/new-account")
+		if err != nil || signed.account == nil {
+			s.problem(w, http.StatusUnauthorized, "unauthorized", "no account")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]string{"localcertDomain": signed.account.domain})
+	case "/provision":
+		var req struct {
+			AuthorizationRequest []byte `json:"signedAuthorizationRequest"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+			return
+		}
+		jws, err := jose.ParseSigned(string(req.AuthorizationRequest))
+		if err != nil || len(jws.Signatures) != 1 {
+			s.problem(w, http.StatusBadRequest, "malformed", "invalid authorization request")
+			return
+		}
+		authzURL, _ := jws.Signatures[0].Protected.ExtraHeaders["url"].(string)
+		signed, err := s.verify(req.AuthorizationRequest, authzURL)
+		if err != nil || signed.account == nil {
+			s.problem(w, http.StatusUnauthorized, "unauthorized", "invalid authorization request")
+			return
+		}
+		s.mu.Lock()
+		a, ok := s.authzs[strings.TrimPrefix(authzURL, s.srv.URL+"/acme/authz/")]
+		s.mu.Unlock()
+		if !ok || !strings.HasSuffix(strings.TrimPrefix(a.identifier.Value, "*."), strings.TrimPrefix(signed.account.domain, "*.")) {
+			s.problem(w, http.StatusForbidden, "unauthorized", "not the account's domain")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]string{
+			"authorizationURL":        authzURL,
+			"provisionedChallengeURL": s.srv.URL + "/acme/chall/" + a.id + "-dns-01",
+		})
+	default:
+		s.problem(w, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+type challengeJSON struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type authzJSON struct {
+	Identifier identifier      `json:"identifier"`
+	Status     string          `json:"status"`
+	Expires    time.Time       `json:"expires"`
+	Challenges []challengeJSON `json:"challenges"`
+	Wildcard   bool            `json:"wildcard,omitempty"`
+}
+
+func (s *Server) authzJSON(a *authz) authzJSON {
+	v := authzJSON{
+		Identifier: identifier{Type: a.identifier.Type, Value: strings.TrimPrefix(a.identifier.Value, "*.")},
+		Status:     a.status,
+		Expires:    time.Now().Add(24 * time.Hour),
+		Wildcard:   strings.HasPrefix(a.identifier.Value, "*."),
+	}
+	for _, typ := range []string{"dns-01", "http-01", "tls-alpn-01"} {
+		if typ != "dns-01" && (v.Wildcard || a.identifier.Type != "dns") {
+			continue
+		}
+		v.Challenges = append(v.Challenges, challengeJSON{
+			Type:   typ,
+			URL:    s.srv.URL + "/acme/chall/" + a.id + "-" + typ,
+			Token:  "token-" + a.id,
+			Status: a.status,
+		})
+	}
+	return v
+}
+
+func (s *Server) orderJSON(o *order) map[string]interface{} {
+	var authzURLs []string
+	for _, id := range o.authzs {
+		authzURLs = append(authzURLs, s.srv.URL+"/acme/authz/"+id)
+	}
+	v := map[string]interface{}{
+		"status":         o.status,
+		"expires":        time.Now().Add(24 * time.Hour),
+		"identifiers":    o.identifiers,
+		"authorizations": authzURLs,
+		"finalize":       s.srv.URL + "/acme/finalize/" + o.id,
+	}
+	if o.certURL != "" {
+		v["certificate"] = o.certURL
+	}
+	return v
+}
+
+func (s *Server) newID() string {
+	id := fmt.Sprint(s.nextID)
+	s.nextID++
+	return id
+}
+
+func (s *Server) nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":   problemPrefix + typ,
+		"detail": detail,
+		"status": status,
+	})
+}
+
+func thumbprint(key *jose.JSONWebKey) string {
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(sum)
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	aDER, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bDER, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return sha256.Sum256(aDER) == sha256.Sum256(bDER)
+}
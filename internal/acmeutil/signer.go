@@ -0,0 +1,96 @@
+package acmeutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// SigningKey returns the JOSE signing key for key: the key itself if go-jose
+// handles its type, and otherwise an adapter that signs with key.Sign, for
+// keys held in hardware tokens, TPMs or cloud KMSes.
+func SigningKey(key crypto.Signer) (jose.SigningKey, error) {
+	var alg jose.SignatureAlgorithm
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		alg = jose.RS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			alg = jose.ES256
+		case 384:
+			alg = jose.ES384
+		case 521:
+			alg = jose.ES512
+		}
+	case ed25519.PublicKey:
+		alg = jose.EdDSA
+	}
+	if alg == "" {
+		return jose.SigningKey{}, fmt.Errorf("unsupported key type %T", key.Public())
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return jose.SigningKey{Algorithm: alg, Key: key}, nil
+	}
+	return jose.SigningKey{Algorithm: alg, Key: opaqueSigner{key: key, alg: alg}}, nil
+}
+
+// opaqueSigner is a jose.OpaqueSigner for any crypto.Signer.
+type opaqueSigner struct {
+	key crypto.Signer
+	alg jose.SignatureAlgorithm
+}
+
+func (s opaqueSigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.key.Public(), Algorithm: string(s.alg)}
+}
+
+func (s opaqueSigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{s.alg}
+}
+
+func (s opaqueSigner) SignPayload(payload []byte, alg jose.SignatureAlgorithm) ([]byte, error) {
+	var hash crypto.Hash
+	switch alg {
+	case jose.RS256, jose.ES256:
+		hash = crypto.SHA256
+	case jose.ES384:
+		hash = crypto.SHA384
+	case jose.ES512:
+		hash = crypto.SHA512
+	case jose.EdDSA:
+		// Ed25519 signs the message itself
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write(payload)
+	sig, err := s.key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := s.key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	// JWS wants r and s side by side rather than ASN.1 (RFC 7518, section
+	// 3.4)
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, fmt.Errorf("parsing ECDSA signature: %w", err)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	rs.R.FillBytes(out[:size])
+	rs.S.FillBytes(out[size:])
+	return out, nil
+}
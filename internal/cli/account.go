@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/wildone/localcert"
+)
+
+type accountResult struct {
+	URL          string   `json:"url"`
+	Status       string   `json:"status"`
+	Contact      []string `json:"contact"`
+	KeyType      string   `json:"keyType"`
+	DirectoryURL string   `json:"directoryUrl"`
+	Terms        string   `json:"acceptedTerms,omitempty"`
+}
+
+// Account runs the account subcommands: show, rotate-key, update-contact
+// and deactivate.
+func Account() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	action := flag.Arg(1)
+	switch action {
+	case "show", "rotate-key", "update-contact", "deactivate":
+	case "":
+		fatal("Usage: localcert account show|rotate-key|update-contact|deactivate")
+	default:
+		fatalf("Invalid account subcommand %q", action)
+	}
+
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+	if err := refreshSharedAccount(config); err != nil {
+		fatal(err)
+	}
+	accountURL := config.ACME.PrivateKey.KeyID
+	if accountURL == "" {
+		fatalf("No ACME account is registered in %s yet; run localcert init or provision first", config.ACMEAccountFile)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	client := config.Manager().Config.Client()
+
+	var account *acme.Account
+	switch action {
+	case "show":
+		account, err = client.Account(ctx, accountURL)
+	case "update-contact":
+		var contact []string
+		for _, arg := range flag.Args()[2:] {
+			if !strings.Contains(arg, ":") {
+				arg = "mailto:" + arg
+			}
+			contact = append(contact, arg)
+		}
+		if len(contact) == 0 {
+			fatal("Usage: localcert account update-contact <email>...")
+		}
+		if account, err = client.UpdateContact(ctx, accountURL, contact); err == nil {
+			infof("Updated account contacts")
+		}
+	case "rotate-key":
+		err = rotateAccountKey(ctx, config, client)
+		if err == nil {
+			account, err = config.Manager().Config.Client().Account(ctx, accountURL)
+		}
+	case "deactivate":
+		err = deactivateAccount(ctx, config, client)
+		if err == nil {
+			return
+		}
+	}
+	if err != nil {
+		fatal("Error: ", err)
+	}
+
+	result := accountResult{
+		URL:          accountURL,
+		Status:       account.Status,
+		Contact:      account.Contact,
+		KeyType:      string(localcert.KeyTypeOf(config.acmeKey.Public())),
+		DirectoryURL: config.ACME.DirectoryURL,
+		Terms:        config.ACME.AcceptedTerms,
+	}
+	fmt.Printf("Account:    %s\n", result.URL)
+	fmt.Printf("Status:     %s\n", result.Status)
+	fmt.Printf("Contact:    %s\n", strings.Join(result.Contact, ", "))
+	fmt.Printf("Key type:   %s\n", result.KeyType)
+	fmt.Printf("Directory:  %s\n", result.DirectoryURL)
+	if result.Terms != "" {
+		fmt.Printf("Terms:      %s\n", result.Terms)
+	}
+	printResult(result)
+}
+
+// rotateAccountKey replaces the account key with a new one of the
+// configured type. The new key is saved alongside the account file before
+// the CA switches to it, so a failed write can't lose the account.
+func rotateAccountKey(ctx context.Context, config *Config, client *localcert.Client) error {
+	newKey, err := localcert.GenerateKey(config.accountKeyType())
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	oldKey, accountFile := config.acmeKey, config.ACMEAccountFile
+	pendingFile := accountFile + ".new"
+
+	config.acmeKey, config.ACME.PrivateKey.Key = newKey, newKey
+	pending, err := config.encodeACMEAccount()
+	config.acmeKey, config.ACME.PrivateKey.Key = oldKey, oldKey
+	if err == nil {
+		err = config.store.WriteFile(pendingFile, pending, filePerm)
+	}
+	if err != nil {
+		return fmt.Errorf("write new key: %w", err)
+	}
+
+	if err := client.RotateKey(ctx, config.ACME.PrivateKey.KeyID, newKey); err != nil {
+		if removeErr := config.store.Remove(pendingFile); removeErr != nil {
+			warnf("Error removing %s: %v", pendingFile, removeErr)
+		}
+		return err
+	}
+	config.acmeKey, config.ACME.PrivateKey.Key = newKey, newKey
+	if err := config.WriteACMEAccountFile(); err != nil {
+		return fmt.Errorf("the CA now expects the new key, which is in %s; move it to %s: %w", pendingFile, accountFile, err)
+	}
+	if err := config.store.Remove(pendingFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("Error removing %s: %v", pendingFile, err)
+	}
+	infof("Rotated the account key to a new %s key", localcert.KeyTypeOf(newKey.Public()))
+	return nil
+}
+
+// deactivateAccount deactivates the account after confirmation and moves
+// its file aside, so that the next provision registers a new account.
+func deactivateAccount(ctx context.Context, config *Config, client *localcert.Client) error {
+	if !interactive() {
+		return errors.New("deactivation can't be undone, so it asks for confirmation and must be run in a terminal without -nonInteractive")
+	}
+	question := fmt.Sprintf("Permanently deactivate ACME account %s? Its certificates stay valid, but it can't order new ones", config.ACME.PrivateKey.KeyID)
+	if !askYesNo(bufio.NewReader(os.Stdin), question, false) {
+		return errors.New("not deactivated")
+	}
+	if err := client.DeactivateAccount(ctx); err != nil {
+		return err
+	}
+	infof("Deactivated account %s", config.ACME.PrivateKey.KeyID)
+
+	fileBytes, err := config.store.ReadFile(config.ACMEAccountFile)
+	if err == nil {
+		err = config.store.WriteFile(config.ACMEAccountFile+".deactivated", fileBytes, filePerm)
+	}
+	if err == nil {
+		err = config.store.Remove(config.ACMEAccountFile)
+	}
+	if err != nil {
+		return fmt.Errorf("moving aside %s: %w", config.ACMEAccountFile, err)
+	}
+	infof("Moved %s to %s.deactivated", config.ACMEAccountFile, config.ACMEAccountFile)
+	return nil
+}
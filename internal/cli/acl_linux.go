@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+const (
+	aclVersion  = 2
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+type posixACL []aclEntry
+
+// readACL returns the extended POSIX ACL for path, or nil if it only has the
+// minimal ACL implied by its mode bits.
+func readACL(path string) posixACL {
+	buf := make([]byte, 1024)
+	n, err := syscall.Getxattr(path, "system.posix_acl_access", buf)
+	if err != nil || n < 4 || binary.LittleEndian.Uint32(buf) != aclVersion {
+		return nil
+	}
+	var acl posixACL
+	for b := buf[4:n]; len(b) >= 8; b = b[8:] {
+		acl = append(acl, aclEntry{
+			tag:  binary.LittleEndian.Uint16(b[0:]),
+			perm: binary.LittleEndian.Uint16(b[2:]),
+			id:   binary.LittleEndian.Uint32(b[4:]),
+		})
+	}
+	return acl
+}
+
+// allows follows the access check algorithm from acl(5).
+func (acl posixACL) allows(id *identity, uid, gid uint32, perm uint32) bool {
+	mask := uint16(7)
+	for _, e := range acl {
+		if e.tag == aclMask {
+			mask = e.perm
+		}
+	}
+	want := uint16(perm)
+	for _, e := range acl {
+		if e.tag == aclUserObj && id.uid == uid {
+			return e.perm&want == want
+		}
+	}
+	for _, e := range acl {
+		if e.tag == aclUser && e.id == id.uid {
+			return e.perm&mask&want == want
+		}
+	}
+	matchedGroup := false
+	for _, e := range acl {
+		if (e.tag == aclGroupObj && id.gids[gid]) || (e.tag == aclGroup && id.gids[e.id]) {
+			matchedGroup = true
+			if e.perm&mask&want == want {
+				return true
+			}
+		}
+	}
+	if matchedGroup {
+		return false
+	}
+	for _, e := range acl {
+		if e.tag == aclOther {
+			return e.perm&want == want
+		}
+	}
+	return false
+}
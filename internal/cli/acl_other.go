@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package cli
+
+type posixACL []struct{}
+
+func readACL(path string) posixACL {
+	return nil
+}
+
+func (acl posixACL) allows(id *identity, uid, gid uint32, perm uint32) bool {
+	return false
+}
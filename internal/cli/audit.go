@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var flagAuditLog = flag.String("auditLog", "", "file to append a JSON line to for each action taken: account registration, order, challenge, issuance, revocation and certificate or key file write (default <dataDir>/audit.log)")
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time    time.Time         `json:"time"`
+	PID     int               `json:"pid"`
+	Profile string            `json:"profile,omitempty"`
+	Action  string            `json:"action"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// auditMu keeps lines from concurrent profiles whole.
+var auditMu sync.Mutex
+
+// audit appends event to the audit log. Failing to is only logged, so it
+// never fails the action.
+func (c *Config) audit(event localcert.AuditEvent) {
+	record := auditRecord{
+		Time:    time.Now().UTC(),
+		PID:     os.Getpid(),
+		Profile: c.Profile,
+		Action:  event.Action,
+		Outcome: "ok",
+		Details: event.Details,
+	}
+	if event.Err != nil {
+		record.Outcome = "failed"
+		record.Error = event.Err.Error()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		warnf("Error encoding %s: %v", c.AuditFile, err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(c.AuditFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerm)
+	if err != nil {
+		warnf("Error writing %s: %v", c.AuditFile, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		warnf("Error writing %s: %v", c.AuditFile, err)
+	}
+}
+
+// auditWrite records writing the file name.
+func (c *Config) auditWrite(name string, err error) {
+	c.audit(localcert.AuditEvent{Action: "write", Details: map[string]string{"path": name}, Err: err})
+}
@@ -0,0 +1,387 @@
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+	"github.com/wildone/localcert/internal/seal"
+)
+
+var flagBackupPassphrase = flag.String("backupPassphrase", "", "passphrase to encrypt the backup with, or to decrypt it with on restore (or set LOCALCERT_BACKUP_PASSPHRASE)")
+
+// backupVersion is the version of the backup layout this build writes.
+const backupVersion = 1
+
+// backupManifest describes a backup. The archive holds it as
+// manifest.json, along with the config file under its own name,
+// acme_account.json, privkey.pem and cert.pem. The keys are unencrypted
+// inside the sealed archive.
+type backupManifest struct {
+	Version    int       `json:"version"`
+	Created    time.Time `json:"created"`
+	Profile    string    `json:"profile,omitempty"`
+	Domain     string    `json:"domain"`
+	ConfigFile string    `json:"configFile,omitempty"`
+}
+
+type backupResult struct {
+	File       string `json:"file"`
+	Profile    string `json:"profile,omitempty"`
+	Domain     string `json:"domain"`
+	ConfigFile string `json:"configFile,omitempty"`
+}
+
+// Backup writes the config file and the profile's ACME account, certificate
+// key and chain to a file, encrypted with the backup passphrase. It only
+// replaces an earlier backup.
+func Backup() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	name := flag.Arg(1)
+	if name == "" {
+		fatal("Usage: localcert backup <backup.lcb>")
+	}
+	if err := checkBackupFile(name); err != nil {
+		fatal("Error: ", err)
+	}
+	if config.signer != nil {
+		fatal("The certificate key is kept in a hardware token, so it can't be backed up")
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	if config.ACME.PrivateKey.KeyID == "" {
+		fatalf("No registered ACME account in %s to back up; provision a certificate first", config.ACMEAccountFile)
+	}
+	chainBytes, err := config.store.ReadFile(config.CertificateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fatalf("No certificate in %s to back up; provision one first", config.CertificateFile)
+	} else if err != nil {
+		fatalf("Error reading %q: %v", config.CertificateFile, err)
+	}
+	chain, err := pemutil.DecodePEMChain(chainBytes, pemutil.CertificateType)
+	if err != nil {
+		fatalf("Error reading %q: %v", config.CertificateFile, err)
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		fatalf("Error reading %q: %v", config.CertificateFile, err)
+	}
+	keyPEM, err := config.keyPEM()
+	if err != nil {
+		fatal("Error reading certificate key: ", err)
+	}
+	account, err := json.MarshalIndent(ACMEAccount{
+		DirectoryURL:  config.ACME.DirectoryURL,
+		PrivateKey:    &jose.JSONWebKey{Key: config.acmeKey, KeyID: config.ACME.PrivateKey.KeyID},
+		AcceptedTerms: config.ACME.AcceptedTerms,
+	}, "", "  ")
+	if err != nil {
+		fatal("Error encoding ACME account: ", err)
+	}
+
+	manifest := backupManifest{
+		Version: backupVersion,
+		Created: time.Now().UTC(),
+		Profile: config.Profile,
+		Domain:  cert.Subject.CommonName,
+	}
+	entries := []bundleEntry{
+		{"acme_account.json", 0600, account},
+		{"privkey.pem", 0600, keyPEM},
+		{"cert.pem", 0644, chainBytes},
+	}
+	configName, err := configFileName()
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	if configName != "" {
+		configBytes, err := os.ReadFile(configName)
+		if err != nil {
+			fatal("Error: ", err)
+		}
+		manifest.ConfigFile = filepath.Base(configName)
+		entries = append(entries, bundleEntry{manifest.ConfigFile, 0600, configBytes})
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fatal("Error encoding manifest: ", err)
+	}
+	entries = append([]bundleEntry{{"manifest.json", 0644, manifestBytes}}, entries...)
+
+	passphrase, err := backupPassphrase(true)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	var archive bytes.Buffer
+	if err := writeTarGz(&archive, "localcert", manifest.Created, entries); err != nil {
+		fatal("Error writing backup: ", err)
+	}
+	sealed, err := seal.Seal(archive.Bytes(), passphrase)
+	if err != nil {
+		fatal("Error encrypting backup: ", err)
+	}
+	if err := writeFileAtomic(name, sealed, 0600); err != nil {
+		fatalf("Error writing %q: %v", name, err)
+	}
+	what := "the ACME account and certificate for " + manifest.Domain
+	if configName != "" {
+		what += " with " + configName
+	}
+	infof("Backed up %s to %s", what, name)
+	printResult(backupResult{File: name, Profile: manifest.Profile, Domain: manifest.Domain, ConfigFile: configName})
+}
+
+// checkBackupFile returns an error if name exists and isn't a backup, so
+// that a mistyped name doesn't replace a file with a backup.
+func checkBackupFile(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, seal.MagicSize)
+	if _, err := io.ReadFull(f, header); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if !seal.IsSealed(header) {
+		return fmt.Errorf("%q exists and isn't a localcert backup; not replacing it", name)
+	}
+	return nil
+}
+
+// Restore sets up a profile from a backup written by backup: the config
+// file, unless the same one is in place already, then the ACME account,
+// certificate key and chain, re-encrypted as the restored config says.
+func Restore() {
+	flag.Parse()
+	err := applyEnv()
+	initOutput()
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+	name := flag.Arg(1)
+	if name == "" {
+		fatal("Usage: localcert restore <backup.lcb>")
+	}
+	sealed, err := os.ReadFile(name)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	passphrase, err := backupPassphrase(false)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	archive, err := seal.Open(sealed, passphrase)
+	if err != nil {
+		fatalf("Error opening %q: %v", name, err)
+	}
+	manifest, files, err := readBackup(archive)
+	if err != nil {
+		fatalf("Error reading %q: %v", name, err)
+	}
+
+	configName := ""
+	if manifest.ConfigFile != "" {
+		if configName, err = restoreConfigFile(manifest.ConfigFile, files[manifest.ConfigFile]); err != nil {
+			fatal("Error restoring config file: ", err)
+		}
+	}
+	profile := *flagProfile
+	if profile == "" {
+		profile = manifest.Profile
+	}
+	config, err := getProfileConfig(profile)
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	if config.ACME.PrivateKey.KeyID != "" {
+		fatalf("%s already holds ACME account %s; restore into another -dataDir or -profile", config.ACMEAccountFile, config.ACME.PrivateKey.KeyID)
+	}
+	var account ACMEAccount
+	if err := json.Unmarshal(files["acme_account.json"], &account); err != nil || account.PrivateKey == nil {
+		fatalf("Error reading %q: invalid ACME account", name)
+	}
+	accountKey, ok := account.PrivateKey.Key.(crypto.Signer)
+	if !ok {
+		fatalf("Error reading %q: invalid ACME account key type %T", name, account.PrivateKey.Key)
+	}
+	if dirURL, err := resolveACMEDirectoryURL(); err == nil && dirURL != "" && dirURL != account.DirectoryURL {
+		fatalf("The backed up account is with %s, not -acmeUrl %s", account.DirectoryURL, dirURL)
+	}
+	keyDER, err := pemutil.DecodePEM(files["privkey.pem"], pemutil.PrivateKeyType)
+	if err != nil {
+		fatalf("Error reading %q: certificate key: %v", name, err)
+	}
+	key, err := localcert.ParsePrivateKey(keyDER)
+	if err != nil {
+		fatalf("Error reading %q: certificate key: %v", name, err)
+	}
+	chain, err := pemutil.DecodePEMChain(files["cert.pem"], pemutil.CertificateType)
+	if err != nil {
+		fatalf("Error reading %q: certificate: %v", name, err)
+	}
+
+	config.acmeKey = accountKey
+	config.ACME = &account
+	if err := config.WriteACMEAccountFile(); err != nil {
+		fatal("Error writing ACME account: ", err)
+	}
+	infof("Restored ACME account %s", account.PrivateKey.KeyID)
+
+	result, err := config.Manager().ImportKeyPair(key, chain)
+	if err != nil {
+		fatal("Error restoring certificate: ", err)
+	}
+	if err := config.WriteDomainFile(result.Domain); err != nil {
+		warnf("%v", err)
+	}
+	if err := postIssuance(config, result); err != nil {
+		fatal("Error: ", err)
+	}
+	printCertInfo(config, result.Certificate)
+	printResult(backupResult{File: name, Profile: profile, Domain: result.Domain, ConfigFile: configName})
+}
+
+// backupPassphrase returns -backupPassphrase, or prompts for it, twice if
+// confirm is set.
+func backupPassphrase(confirm bool) ([]byte, error) {
+	if *flagBackupPassphrase != "" {
+		return []byte(*flagBackupPassphrase), nil
+	}
+	if !interactive() {
+		return nil, errors.New("backups are encrypted; pass -backupPassphrase or set LOCALCERT_BACKUP_PASSPHRASE")
+	}
+	passphrase, err := readPassphrase("Backup passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := readPassphrase("Confirm backup passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if again != passphrase {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+	if passphrase == "" {
+		return nil, errors.New("empty backup passphrase")
+	}
+	return []byte(passphrase), nil
+}
+
+// readBackup reads the manifest and files of a decrypted backup archive.
+func readBackup(archive []byte) (*backupManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[path.Base(header.Name)] = content
+	}
+
+	var manifest backupManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return nil, nil, fmt.Errorf("manifest: %w", err)
+	}
+	if manifest.Version < 1 || manifest.Version > backupVersion {
+		return nil, nil, fmt.Errorf("backup version %d isn't one this localcert reads (%d); upgrade localcert", manifest.Version, backupVersion)
+	}
+	for _, name := range []string{"acme_account.json", "privkey.pem", "cert.pem", manifest.ConfigFile} {
+		if _, ok := files[name]; name != "" && !ok {
+			return nil, nil, fmt.Errorf("%s is missing", name)
+		}
+	}
+	return &manifest, files, nil
+}
+
+// restoreConfigFile writes a backed up config file to -config, or to the
+// config file in use, or under its own name in the default data
+// directory, converting it to the destination's format. It refuses to
+// replace a different config file.
+func restoreConfigFile(base string, data []byte) (string, error) {
+	name, err := configFileName()
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		dir, err := defaultDataDir()
+		if err != nil {
+			return "", err
+		}
+		name = filepath.Join(dir, base)
+	}
+	if configFormat(name) != configFormat(base) {
+		raw, err := decodeConfig(base, data)
+		if err != nil {
+			return "", fmt.Errorf("decode %s: %w", base, err)
+		}
+		if data, err = encodeConfig(name, raw); err != nil {
+			return "", err
+		}
+	}
+
+	existing, err := os.ReadFile(name)
+	if err == nil {
+		if !bytes.Equal(existing, data) {
+			return "", fmt.Errorf("%q already exists and differs from the backed up config; merge them, or pass -config to restore it to another file", name)
+		}
+		infof("Config file %q is already in place", name)
+		return name, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(name, data, 0600); err != nil {
+		return "", fmt.Errorf("write %q: %w", name, err)
+	}
+	infof("Restored config file %q", name)
+	return name, nil
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wildone/localcert/internal/seal"
+)
+
+func TestCheckBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	sealed, err := seal.Seal([]byte("archive"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"backup.lcb": sealed,
+		"nginx.conf": []byte("server {\n}\n"),
+		"short":      []byte("LC"),
+		"empty":      nil,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"missing.lcb", true},
+		{"backup.lcb", true},
+		{"nginx.conf", false},
+		{"short", false},
+		{"empty", false},
+	}
+	for _, test := range tests {
+		err := checkBackupFile(filepath.Join(dir, test.name))
+		if test.ok && err != nil {
+			t.Errorf("checkBackupFile(%s) = %v, want nil", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("checkBackupFile(%s) allowed replacing a file that isn't a backup", test.name)
+		}
+	}
+}
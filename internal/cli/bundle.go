@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagBundleFile       = flag.String("bundleFile", "", "path to a .tar.gz or .zip bundle of all outputs, regenerated on issuance")
+	flagBundleIncludeKey = flag.Bool("bundleIncludeKey", false, "include the certificate private key in the bundle")
+)
+
+type certMetadata struct {
+	Domain            string    `json:"domain"`
+	Serial            string    `json:"serial"`
+	FingerprintSHA256 string    `json:"fingerprintSHA256"`
+	NotBefore         time.Time `json:"notBefore"`
+	NotAfter          time.Time `json:"notAfter"`
+}
+
+func fingerprintSHA256(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+func newCertMetadata(cert *x509.Certificate) certMetadata {
+	return certMetadata{
+		Domain:            cert.Subject.CommonName,
+		Serial:            cert.SerialNumber.Text(16),
+		FingerprintSHA256: fingerprintSHA256(cert),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+	}
+}
+
+type bundleEntry struct {
+	name    string
+	mode    int64
+	content []byte
+}
+
+func (c *Config) WriteBundle(certChain [][]byte) (bool, error) {
+	if c.BundleFile == "" {
+		return false, nil
+	}
+	var gzipped bool
+	switch {
+	case strings.HasSuffix(c.BundleFile, ".tar.gz"), strings.HasSuffix(c.BundleFile, ".tgz"):
+		gzipped = true
+	case strings.HasSuffix(c.BundleFile, ".zip"):
+	default:
+		return false, fmt.Errorf("unsupported bundle extension %q", filepath.Ext(c.BundleFile))
+	}
+
+	cert, err := x509.ParseCertificate(certChain[0])
+	if err != nil {
+		return false, fmt.Errorf("parse certificate: %w", err)
+	}
+	metadata, err := json.MarshalIndent(newCertMetadata(cert), "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("encode metadata: %w", err)
+	}
+
+	dir := strings.TrimPrefix(cert.Subject.CommonName, "*.")
+	entries := []bundleEntry{
+		{"cert.pem", 0644, pemutil.EncodePEMChain(pemutil.CertificateType, certChain[:1])},
+		{"chain.pem", 0644, pemutil.EncodePEMChain(pemutil.CertificateType, certChain[1:])},
+		{"fullchain.pem", 0644, pemutil.EncodePEMChain(pemutil.CertificateType, certChain)},
+	}
+	if c.BundleIncludeKey {
+		key, err := c.store.ReadFile(c.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("read %q: %w", c.KeyFile, err)
+		}
+		entries = append(entries, bundleEntry{"privkey.pem", 0600, key})
+	}
+	entries = append(entries, bundleEntry{"metadata.json", 0644, metadata})
+
+	// Entries are timestamped with the certificate so identical inputs produce
+	// identical archives and unchanged bundles can be skipped.
+	var archive bytes.Buffer
+	if gzipped {
+		err = writeTarGz(&archive, dir, cert.NotBefore, entries)
+	} else {
+		err = writeZip(&archive, dir, cert.NotBefore, entries)
+	}
+	if err != nil {
+		return false, fmt.Errorf("write bundle: %w", err)
+	}
+	if existing, err := os.ReadFile(c.BundleFile); err == nil && bytes.Equal(existing, archive.Bytes()) {
+		return false, nil
+	}
+
+	mode := c.CertFileMode
+	if c.BundleIncludeKey {
+		mode = c.KeyFileMode
+	}
+	if err := writeFileAtomic(c.BundleFile, archive.Bytes(), mode); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writeTarGz(w io.Writer, dir string, modTime time.Time, entries []bundleEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(dir, entry.name),
+			Mode:     entry.mode,
+			Size:     int64(len(entry.content)),
+			ModTime:  modTime,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeZip(w io.Writer, dir string, modTime time.Time, entries []bundleEntry) error {
+	zw := zip.NewWriter(w)
+	header := &zip.FileHeader{Name: dir + "/", Modified: modTime}
+	header.SetMode(os.ModeDir | 0755)
+	if _, err := zw.CreateHeader(header); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     path.Join(dir, entry.name),
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		header.SetMode(os.FileMode(entry.mode))
+		f, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(entry.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
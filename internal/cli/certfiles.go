@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagLeafFile      = flag.String("leafFile", "", "path to write the certificate alone, without intermediates (default <dataDir>/live/cert.pem)")
+	flagChainFile     = flag.String("chainFile", "", "path to write the intermediate certificates (default <dataDir>/live/chain.pem)")
+	flagFullChainFile = flag.String("fullChainFile", "", "path to write the certificate followed by its intermediates (default <dataDir>/live/fullchain.pem)")
+	flagCombinedFile  = flag.String("combinedFile", "", "path to write the key followed by the full chain, as HAProxy expects (not written unless set)")
+)
+
+// certFile is one of the concatenations servers are pointed at, or an
+// -outputs template.
+type certFile struct {
+	name    string
+	mode    os.FileMode
+	content func(certChain [][]byte) ([]byte, error)
+}
+
+func (c *Config) certFiles() []certFile {
+	files := []certFile{
+		{c.LeafFile, c.CertFileMode, func(certChain [][]byte) ([]byte, error) {
+			return pemutil.EncodePEMChain(pemutil.CertificateType, certChain[:1]), nil
+		}},
+		{c.ChainFile, c.CertFileMode, func(certChain [][]byte) ([]byte, error) {
+			return pemutil.EncodePEMChain(pemutil.CertificateType, certChain[1:]), nil
+		}},
+		{c.FullChainFile, c.CertFileMode, func(certChain [][]byte) ([]byte, error) {
+			return pemutil.EncodePEMChain(pemutil.CertificateType, certChain), nil
+		}},
+	}
+	if c.CombinedFile != "" {
+		files = append(files, certFile{c.CombinedFile, c.KeyFileMode, func(certChain [][]byte) ([]byte, error) {
+			key, err := c.keyPEM()
+			if err != nil {
+				return nil, err
+			}
+			return append(key, pemutil.EncodePEMChain(pemutil.CertificateType, certChain)...), nil
+		}})
+	}
+	for _, output := range c.Outputs {
+		output := output
+		files = append(files, certFile{output.Path, output.fileMode(c), func(certChain [][]byte) ([]byte, error) {
+			return output.render(c, certChain)
+		}})
+	}
+	return files
+}
+
+// writeCertFiles writes the leaf, chain, full chain and combined files. If
+// onlyMissing is set, existing files are left alone.
+func writeCertFiles(config *Config, certChain [][]byte, onlyMissing bool) error {
+	for _, file := range config.certFiles() {
+		if onlyMissing {
+			if _, err := os.Stat(file.name); err == nil {
+				continue
+			}
+		}
+		content, err := file.content(certChain)
+		if err != nil {
+			return fmt.Errorf("writing %q: %w", file.name, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(file.name), dirMode(file.mode)); err != nil {
+			return fmt.Errorf("writing %q: %w", file.name, err)
+		}
+		err = writeFileAtomic(file.name, content, file.mode)
+		config.auditWrite(file.name, err)
+		if err != nil {
+			return fmt.Errorf("writing %q: %w", file.name, err)
+		}
+		debugf("Wrote %s", file.name)
+	}
+	return nil
+}
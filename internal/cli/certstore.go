@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wildone/localcert/internal/pkcs12"
+)
+
+var (
+	flagCertStore         = flag.Bool("certStore", false, "after issuance, import the certificate and key into the Windows certificate store or macOS Keychain, replacing the previous one")
+	flagCertStoreLocation = flag.String("certStoreLocation", "", "Windows store location, LocalMachine or CurrentUser (default LocalMachine), or macOS keychain path (default the default keychain)")
+	flagFriendlyName      = flag.String("friendlyName", "", "friendly name of the -certStore entry (default localcert, or localcert-<profile>)")
+)
+
+// installCertStore imports the certificate chain and key into the platform
+// certificate store under config.FriendlyName, removing the entry for the
+// previous certificate.
+func installCertStore(config *Config, certChain [][]byte, previous *x509.Certificate) error {
+	if !config.CertStore {
+		return nil
+	}
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return err
+	}
+	key, err := config.Manager().CertificateKey()
+	if err != nil {
+		return err
+	}
+
+	// The PFX only exists long enough to import it, so a random password
+	// is enough
+	passwordBytes := make([]byte, 18)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return err
+	}
+	password := base64.RawURLEncoding.EncodeToString(passwordBytes)
+	pfx, err := pkcs12.Encode(key, certs[0], certs[1:], password, config.FriendlyName)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "localcert-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	pfxFile := filepath.Join(dir, "cert.pfx")
+	if err := os.WriteFile(pfxFile, pfx, 0600); err != nil {
+		return err
+	}
+
+	if err := importPFX(config.CertStoreLocation, config.FriendlyName, pfxFile, password, previous); err != nil {
+		return fmt.Errorf("installing certificate in store: %w", err)
+	}
+	infof("Certificate (store): %q", config.FriendlyName)
+	return nil
+}
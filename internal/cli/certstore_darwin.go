@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func importPFX(keychain, friendlyName, pfxFile, password string, previous *x509.Certificate) error {
+	args := []string{"import", pfxFile, "-f", "pkcs12", "-P", password}
+	if keychain != "" {
+		args = append(args, "-k", keychain)
+	}
+	if out, err := exec.Command("security", args...).CombinedOutput(); err != nil && !bytes.Contains(out, []byte("already exists")) {
+		return fmt.Errorf("security import: %w: %s", err, out)
+	}
+
+	// The friendly name becomes the identity's label, which isn't unique, so
+	// remove the previous identity by its hash instead
+	if previous == nil {
+		return nil
+	}
+	args = []string{"delete-identity", "-Z", fmt.Sprintf("%X", sha1.Sum(previous.Raw))}
+	if keychain != "" {
+		args = append(args, keychain)
+	}
+	if out, err := exec.Command("security", args...).CombinedOutput(); err != nil && !bytes.Contains(out, []byte("could not be found")) {
+		warnf("removing previous certificate from keychain: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package cli
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+func importPFX(location, friendlyName, pfxFile, password string, previous *x509.Certificate) error {
+	return errors.New("-certStore is only supported on Windows and macOS")
+}
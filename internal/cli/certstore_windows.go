@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// importScript imports the PFX into the My store of the given location and
+// removes any other certificate with the same friendly name, along with its
+// key. Arguments are passed in the environment to avoid quoting them.
+const importScript = `$ErrorActionPreference = 'Stop'
+$store = "Cert:\$env:LOCALCERT_STORE_LOCATION\My"
+$password = ConvertTo-SecureString -String $env:LOCALCERT_PFX_PASSWORD -AsPlainText -Force
+$cert = Import-PfxCertificate -FilePath $env:LOCALCERT_PFX_FILE -CertStoreLocation $store -Password $password
+$cert.FriendlyName = $env:LOCALCERT_FRIENDLY_NAME
+Get-ChildItem $store | Where-Object { $_.FriendlyName -eq $env:LOCALCERT_FRIENDLY_NAME -and $_.Thumbprint -ne $cert.Thumbprint } | Remove-Item -DeleteKey
+`
+
+func importPFX(location, friendlyName, pfxFile, password string, previous *x509.Certificate) error {
+	if location == "" {
+		location = "LocalMachine"
+	}
+	if location != "LocalMachine" && location != "CurrentUser" {
+		return fmt.Errorf("unknown store location %q; use LocalMachine or CurrentUser", location)
+	}
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", importScript)
+	cmd.Env = append(os.Environ(),
+		"LOCALCERT_STORE_LOCATION="+location,
+		"LOCALCERT_PFX_FILE="+pfxFile,
+		"LOCALCERT_PFX_PASSWORD="+password,
+		"LOCALCERT_FRIENDLY_NAME="+friendlyName,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("powershell: %w: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/wildone/localcert"
+)
+
+var (
+	flagChallenge         = flag.String("challenge", "", "comma-separated challenge types to complete for -domain instead of the localcert server, in order of preference: dns-01 (with -dnsProvider), http-01 or tls-alpn-01 (default dns-01 with -dnsProvider)")
+	flagWebroot           = flag.String("webroot", "", "with -challenge http-01, write the responses under this web server document root instead of listening on -httpChallengeAddr")
+	flagHTTPChallengeAddr = flag.String("httpChallengeAddr", ":80", "address to answer http-01 challenges on while they are pending")
+	flagTLSChallengeAddr  = flag.String("tlsChallengeAddr", ":443", "address to answer tls-alpn-01 challenges on while they are pending")
+)
+
+// challengers returns the -challenge challengers, or the -dnsProvider
+// solver's alone, or none to use the localcert server.
+func challengers() ([]localcert.Challenger, error) {
+	solver, err := challengeSolver()
+	if err != nil {
+		return nil, err
+	}
+	types := *flagChallenge
+	if types == "" {
+		if solver == nil {
+			return nil, nil
+		}
+		types = "dns-01"
+	}
+	var challengers []localcert.Challenger
+	for _, typ := range strings.Split(types, ",") {
+		switch strings.TrimSpace(typ) {
+		case "dns-01":
+			if solver == nil {
+				return nil, errors.New("-challenge dns-01 requires -dnsProvider")
+			}
+			challengers = append(challengers, localcert.DNS01(solver))
+		case "http-01":
+			if *flagWebroot != "" {
+				challengers = append(challengers, localcert.HTTP01Webroot{Dir: *flagWebroot})
+			} else {
+				challengers = append(challengers, &localcert.HTTP01Server{Addr: *flagHTTPChallengeAddr})
+			}
+		case "tls-alpn-01":
+			challengers = append(challengers, &localcert.TLSALPN01Server{Addr: *flagTLSChallengeAddr})
+		default:
+			return nil, fmt.Errorf("unknown -challenge %q; want dns-01, http-01 or tls-alpn-01", typ)
+		}
+	}
+	return challengers, nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var flagWaitUntilValid = flag.Bool("waitUntilValid", false, "if a new certificate isn't valid yet by the local clock, which is then behind the CA's, wait until it is before installing it and running hooks; serve keeps serving the previous one meanwhile")
+
+// maxClockSkew is how far the local clock may be from the CA's before
+// certificates look not yet valid, or renewals run late.
+const maxClockSkew = time.Minute
+
+// clockSkews holds how far the local clock was ahead of each server's, from
+// the Date header of its last response.
+var clockSkews = struct {
+	sync.Mutex
+	byHost map[string]time.Duration
+}{byHost: map[string]time.Duration{}}
+
+// clockTransport records the clock skew of each response.
+type clockTransport struct {
+	next http.RoundTripper
+}
+
+func (t clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		if skew, ok := responseSkew(start, resp); ok {
+			clockSkews.Lock()
+			clockSkews.byHost[req.URL.Host] = skew
+			clockSkews.Unlock()
+		}
+	}
+	return resp, err
+}
+
+// responseSkew returns how far the local clock is ahead of the server's,
+// from the Date header of resp to a request started at start.
+func responseSkew(start time.Time, resp *http.Response) (time.Duration, bool) {
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	// Date has a resolution of a second; compare against the middle of
+	// the request
+	local := start.Add(time.Since(start) / 2)
+	return local.Sub(serverTime).Round(time.Second), true
+}
+
+// serverClockSkew returns the clock skew last seen with the server at
+// rawURL.
+func serverClockSkew(rawURL string) (time.Duration, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, false
+	}
+	clockSkews.Lock()
+	defer clockSkews.Unlock()
+	skew, ok := clockSkews.byHost[u.Host]
+	return skew, ok
+}
+
+// checkClock warns if the local clock is off the ACME server's, or so far
+// behind that cert isn't valid yet, and with -waitUntilValid waits until
+// it is.
+func checkClock(ctx context.Context, config *Config, cert *x509.Certificate) error {
+	if skew, ok := serverClockSkew(acmeDirectoryURL(config)); ok && skew > maxClockSkew {
+		warnf("The local clock is %s ahead of the ACME server's, so renewals run late; check its time sync (NTP)", skew)
+	} else if ok && skew < -maxClockSkew {
+		warnf("The local clock is %s behind the ACME server's, so new certificates may look not yet valid; check its time sync (NTP)", -skew)
+	}
+	wait := time.Until(cert.NotBefore)
+	if wait <= 0 {
+		return nil
+	}
+	warnf("The new certificate isn't valid until %s, %s from now by the local clock", cert.NotBefore.Format(time.RFC3339), wait.Round(time.Second))
+	if !*flagWaitUntilValid {
+		return nil
+	}
+	infof("Waiting until it is valid before installing it")
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
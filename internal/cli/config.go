@@ -2,18 +2,23 @@ package cli
 
 import (
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/cron"
+	"github.com/wildone/localcert/internal/pemutil"
 	"golang.org/x/crypto/acme"
 	"gopkg.in/square/go-jose.v2"
 )
@@ -29,147 +34,704 @@ var (
 	flagDataDir          = flag.String("dataDir", "", "default data directory")
 	flagServerURL        = flag.String("serverUrl", defaultServerURL, "localcert server URL")
 	flagACMEDirectoryURL = flag.String("acmeUrl", "", "ACME directory URL")
+	flagStaging          = flag.Bool("staging", false, "use the CA's staging environment, keeping its account and certificate under <dataDir>/staging")
+	flagTestCA           = flag.String("testCA", "", "ACME directory URL of a test CA such as Pebble to issue from instead of -acmeUrl, keeping its account and certificate under <dataDir>/test-ca")
 	flagACMEAccountFile  = flag.String("acmeAccount", "", "path to ACME account file")
+	flagEABKeyID         = flag.String("eabKeyId", "", "external account binding key ID, for CAs that require one")
+	flagEABHMACKey       = flag.String("eabHmacKey", "", "base64url external account binding HMAC key (or set LOCALCERT_EAB_HMAC_KEY)")
+	flagPreferredChain   = flag.String("preferredChain", "", "when the CA offers alternate chains, use the one whose topmost certificate is issued by this common name, e.g. \"ISRG Root X1\"")
+	flagACMEProfile      = flag.String("acmeProfile", "", "certificate profile to order, such as tlsserver or shortlived, for CAs that advertise profiles in their directory (default the CA's default profile)")
+	flagMaxRetries       = flag.Int("maxRetries", localcert.DefaultMaxRetries, "retries of each failed ACME request, with backoff and honoring Retry-After (0 disables retries)")
 	flagCertificateFile  = flag.String("localCert", "", "path to localcert certificate")
 	flagKeyFile          = flag.String("localKey", "", "path to localcert certificate key")
+	flagWildcard         = flag.Bool("wildcard", false, "request both *.<domain> and the bare assigned domain")
+	flagSubdomains       = flag.String("subdomains", "", "comma-separated subdomains of the assigned domain to add to the certificate, e.g. app,api.app")
+	flagIPAddresses      = flag.String("ipAddresses", "", "comma-separated IP addresses to add to the certificate, for services reached by address; the CA must support IP identifiers, validated with -challenge http-01 or tls-alpn-01")
+	flagKeyType          = flag.String("keyType", string(localcert.DefaultKeyType), "key type for new keys: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519")
 )
 
+// sandboxDir is the subdirectory of the data dir that keeps the account
+// and certificate of -staging or -testCA, or "" for production.
+func sandboxDir() string {
+	switch {
+	case *flagTestCA != "":
+		return "test-ca"
+	case *flagStaging:
+		return "staging"
+	}
+	return ""
+}
+
+// stagingDirectoryURLs maps production ACME directories to their staging
+// environments.
+var stagingDirectoryURLs = map[string]string{
+	acme.LetsEncryptURL:                          "https://acme-staging-v02.api.letsencrypt.org/directory",
+	"https://dv.acme-v02.api.pki.goog/directory": "https://dv.acme-v02.test-api.pki.goog/directory",
+}
+
 type Config struct {
+	Profile         string
 	DataDir         string
+	HostID          string
 	ServerURL       string
 	ACMEAccountFile string
 	CertificateFile string
 	KeyFile         string
+	KeyType         localcert.KeyType
+	KeyRotation     string
+	Domain          string
+	Wildcard        bool
+	Subdomains      []string
+	IPAddresses     []net.IP
+	MustStaple      bool
+	CSR             []byte
+	Renewal         localcert.RenewalPolicy
+	Schedule        *cron.Schedule
+	HistoryFile     string
+	FailuresFile    string
+	MetadataFile    string
+	AuditFile       string
+	OrderFile       string
+
+	PreviousKeyFile      string
+	PreviousKeyRetention time.Duration
+
+	LeafFile      string
+	ChainFile     string
+	FullChainFile string
+	CombinedFile  string
+	OCSPFile      string
+
+	CertFileMode os.FileMode
+	KeyFileMode  os.FileMode
+	// FileUID and FileGID own the certificate and key files; -1 leaves
+	// them be.
+	FileUID int
+	FileGID int
+
+	BundleFile       string
+	BundleIncludeKey bool
+
+	VerifyReadableBy     string
+	VerifyReadableAction string
+
+	ExportFormats  []string
+	Outputs        []outputTemplate
+	PKCS12File     string
+	PKCS12Password string
+
+	DERFile          string
+	DERChainFile     string
+	KeystoreFile     string
+	KeystoreType     string
+	KeystoreAlias    string
+	KeystorePassword string
+
+	EscalateBefore   []time.Duration
+	EscalateFailures int
+
+	KubeSecretNamespace string
+	KubeSecretName      string
 
-	ACME    *ACMEAccount
-	acmeKey crypto.Signer
+	DeployTargets []string
+
+	StdoutFormat string
+
+	CertStore         bool
+	CertStoreLocation string
+	FriendlyName      string
+
+	ACME          *ACMEAccount
+	acmeKey       crypto.Signer
+	keyPassphrase []byte
+	signer        crypto.Signer
+	store         localcert.Store
+	eab           *acme.ExternalAccountBinding
+	challengers   []localcert.Challenger
+
+	// accountVersion is the version of the shared account file last read or
+	// written.
+	accountVersion string
+
+	// domainChange and lifetimeChange are the domain and certificate
+	// lifetime changes of the last provisioning run, if it made them.
+	domainChange   *DomainChangeEvent
+	lifetimeChange *LifetimeChangeEvent
 }
 
 func GetConfig() (*Config, error) {
 	flag.Parse()
-	dataDir := *flagDataDir
-	if dataDir == "" {
-		userConfigDir, err := os.UserConfigDir()
+	err := applyEnv()
+	initOutput()
+	if err != nil {
+		return nil, ConfigError{Err: err}
+	}
+	return getProfileConfig(*flagProfile)
+}
+
+func defaultDataDir() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("user config dir: %w", err)
+	}
+	return filepath.Join(userConfigDir, "localcert"), nil
+}
+
+// getProfileConfig returns the configuration for the named config file
+// profile, or for no profile if it is empty.
+func getProfileConfig(profile string) (*Config, error) {
+	config, err := loadProfileConfig(profile)
+	if err != nil {
+		return nil, ConfigError{Err: err}
+	}
+	return config, nil
+}
+
+func loadProfileConfig(profile string) (*Config, error) {
+	file, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := file.apply(profile); err != nil {
+		return nil, err
+	}
+	if err := initLogging(); err != nil {
+		return nil, err
+	}
+	if err := initTracing(); err != nil {
+		return nil, err
+	}
+	if err := initHTTPClient(); err != nil {
+		return nil, err
+	}
+	if err := initUmask(); err != nil {
+		return nil, err
+	}
+
+	baseDir := *flagDataDir
+	if baseDir == "" {
+		baseDir, err = defaultDataDir()
 		if err != nil {
-			return nil, fmt.Errorf("user config dir: %w", err)
+			return nil, err
 		}
-		dataDir = filepath.Join(userConfigDir, "localcert")
-
-		// In the common case of the default dataDir not yet existing, try creating it
+	}
+	dataDir, err := layoutDataDir(baseDir, profile)
+	if err != nil {
+		return nil, err
+	}
+	// In the common case of the default dataDir not yet existing, try creating it
+	if dataDir != *flagDataDir && !*flagDryRun {
 		if _, err := os.Stat(dataDir); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(dataDir, filePerm)
+			err := os.MkdirAll(dataDir, filePerm)
 			if err != nil {
 				return nil, fmt.Errorf("create default config dir: %w", err)
 			}
 		}
 	}
 
+	acmeDirectoryURL, err := resolveACMEDirectoryURL()
+	if err != nil {
+		return nil, err
+	}
+	// Keep staging and test certificates from replacing real ones
+	if sandbox := sandboxDir(); sandbox != "" {
+		dataDir = filepath.Join(dataDir, sandbox)
+		if !*flagDryRun {
+			if err := os.MkdirAll(dataDir, filePerm); err != nil {
+				return nil, fmt.Errorf("create %s dir: %w", sandbox, err)
+			}
+		}
+	}
+
+	store, storeDir, err := configStore(baseDir, dataDir, profile)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := store.(localcert.FileStore); !ok && *flagVerifyReadableBy != "" {
+		return nil, errors.New("-verifyReadableBy only applies to certificates stored in files")
+	}
+	if _, ok := store.(*dryRunStore); *flagDryRun && !ok {
+		store = newDryRunStore(store)
+	}
+
 	acmeAccountFile := *flagACMEAccountFile
 	if acmeAccountFile == "" {
-		acmeAccountFile = filepath.Join(dataDir, "acme_account.json")
+		acmeAccountFile = filepath.Join(storeDir, "acme_account.json")
+	}
+
+	hostID, err := sharedHostID()
+	if err != nil {
+		return nil, err
+	}
+	// Hosts sharing the account keep everything else to themselves
+	if hostID != "" {
+		dataDir = filepath.Join(dataDir, "hosts", hostID)
+		storeDir = filepath.Join(storeDir, "hosts", hostID)
+		if !*flagDryRun {
+			if err := os.MkdirAll(dataDir, filePerm); err != nil {
+				return nil, fmt.Errorf("create host dir: %w", err)
+			}
+		}
 	}
 
 	certificateFile := *flagCertificateFile
 	if certificateFile == "" {
-		certificateFile = filepath.Join(dataDir, "cert.pem")
+		certificateFile = filepath.Join(storeDir, "cert.pem")
 	}
 
 	keyFile := *flagKeyFile
 	if keyFile == "" {
-		keyFile = filepath.Join(dataDir, "privkey.pem")
+		keyFile = filepath.Join(storeDir, "privkey.pem")
+	}
+
+	keyType, err := localcert.ParseKeyType(*flagKeyType)
+	if err != nil {
+		return nil, err
+	}
+	keyRotation, err := parseKeyRotation(*flagKeyRotation)
+	if err != nil {
+		return nil, err
+	}
+
+	if *flagRenewBeforePercent < 0 || *flagRenewBeforePercent >= 100 {
+		return nil, fmt.Errorf("-renewBeforePercent %v out of range", *flagRenewBeforePercent)
+	}
+	beforeFraction, err := renewalBeforeFraction(*flagRenewBeforePercent / 100)
+	if err != nil {
+		return nil, err
+	}
+	renewal := localcert.RenewalPolicy{
+		Before:         *flagRenewBefore,
+		BeforeFraction: beforeFraction,
+	}
+
+	liveFile := func(name, def string) string {
+		if name == "" {
+			name = filepath.Join(dataDir, "live", def)
+		}
+		return name
+	}
+
+	exportFormats, err := parseExportFormats(*flagExportFormats)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := parseOutputs(*flagOutputs)
+	if err != nil {
+		return nil, err
+	}
+	escalateBefore, err := parseEscalateBefore(*flagEscalateBefore)
+	if err != nil {
+		return nil, err
+	}
+	pkcs12File := *flagPKCS12File
+	if pkcs12File == "" {
+		pkcs12File = filepath.Join(dataDir, "cert.pfx")
+	}
+	pkcs12Password := *flagPKCS12Password
+	if pkcs12Password == "" {
+		pkcs12Password = os.Getenv("LOCALCERT_PKCS12_PASSWORD")
+	}
+	derFile := *flagDERFile
+	if derFile == "" {
+		derFile = filepath.Join(dataDir, "cert.der")
+	}
+	derChainFile := *flagDERChainFile
+	if derChainFile == "" {
+		derChainFile = filepath.Join(dataDir, "chain.der")
+	}
+	keystoreFile := *flagKeystoreFile
+	if keystoreFile == "" {
+		keystoreFile = filepath.Join(dataDir, "keystore.p12")
+		if *flagKeystoreType == "jks" {
+			keystoreFile = filepath.Join(dataDir, "keystore.jks")
+		}
+	}
+	// The keystore settings only matter when the keystore is exported
+	if containsString(exportFormats, "keystore") {
+		if *flagKeystoreType != "pkcs12" && *flagKeystoreType != "jks" {
+			return nil, fmt.Errorf("unknown -keystoreType %q; use pkcs12 or jks", *flagKeystoreType)
+		}
+		if *flagKeystorePassword == "" {
+			return nil, errors.New("-keystorePassword can't be empty; Java requires one")
+		}
+	}
+	switch *flagVerifyReadableAction {
+	case "fail", "warn":
+	default:
+		return nil, fmt.Errorf("unknown -verifyReadableAction %q; use fail or warn", *flagVerifyReadableAction)
 	}
 
 	config := &Config{
+		Profile:         profile,
 		DataDir:         dataDir,
+		HostID:          hostID,
 		ServerURL:       *flagServerURL,
 		ACMEAccountFile: acmeAccountFile,
 		CertificateFile: certificateFile,
 		KeyFile:         keyFile,
+		KeyType:         keyType,
+		KeyRotation:     keyRotation,
+		Wildcard:        *flagWildcard,
+		Subdomains:      parseList(*flagSubdomains),
+		MustStaple:      *flagMustStaple,
+		Renewal:         renewal,
+		HistoryFile:     filepath.Join(dataDir, "history.json"),
+		FailuresFile:    filepath.Join(dataDir, "renewal_failures.json"),
+		MetadataFile:    *flagOutputMetadata,
+		AuditFile:       *flagAuditLog,
+		OrderFile:       filepath.Join(storeDir, "pending_order"),
+
+		LeafFile:      liveFile(*flagLeafFile, "cert.pem"),
+		ChainFile:     liveFile(*flagChainFile, "chain.pem"),
+		FullChainFile: liveFile(*flagFullChainFile, "fullchain.pem"),
+		CombinedFile:  *flagCombinedFile,
+		OCSPFile:      *flagOCSPFile,
+
+		BundleFile:       *flagBundleFile,
+		BundleIncludeKey: *flagBundleIncludeKey,
+
+		VerifyReadableBy:     *flagVerifyReadableBy,
+		VerifyReadableAction: *flagVerifyReadableAction,
+
+		ExportFormats:  exportFormats,
+		Outputs:        outputs,
+		PKCS12File:     pkcs12File,
+		PKCS12Password: pkcs12Password,
+
+		DERFile:          derFile,
+		DERChainFile:     derChainFile,
+		KeystoreFile:     keystoreFile,
+		KeystoreType:     *flagKeystoreType,
+		KeystoreAlias:    *flagKeystoreAlias,
+		KeystorePassword: *flagKeystorePassword,
+
+		EscalateBefore:   escalateBefore,
+		EscalateFailures: *flagEscalateFailures,
+
+		store: store,
+	}
+	if config.MetadataFile == "" {
+		config.MetadataFile = filepath.Join(dataDir, "state.json")
+	}
+	if config.AuditFile == "" {
+		config.AuditFile = filepath.Join(dataDir, "audit.log")
+	}
+	config.KubeSecretNamespace, config.KubeSecretName = parseKubeSecret(*flagKubeSecret)
+	if err := parseFilePolicy(config); err != nil {
+		return nil, err
+	}
+	if config.IPAddresses, err = parseIPAddresses(*flagIPAddresses); err != nil {
+		return nil, err
+	}
+	if *flagSchedule != "" {
+		if config.Schedule, err = cron.Parse(*flagSchedule); err != nil {
+			return nil, fmt.Errorf("-schedule: %w", err)
+		}
+		if config.Schedule.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("-schedule %q never matches", *flagSchedule)
+		}
+	}
+	if config.DeployTargets, err = parseDeployTargets(*flagDeploy); err != nil {
+		return nil, err
+	}
+	if config.StdoutFormat, err = parseStdoutFormat(*flagStdout); err != nil {
+		return nil, err
+	}
+	if config.StdoutFormat != "" && *flagJSON {
+		return nil, errors.New("-stdout can't be combined with -json")
+	}
+	if *flagCertStore {
+		if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+			return nil, errors.New("-certStore is only supported on Windows and macOS")
+		}
+		config.CertStore = true
+		config.CertStoreLocation = *flagCertStoreLocation
+		config.FriendlyName = *flagFriendlyName
+		if config.FriendlyName == "" {
+			config.FriendlyName = "localcert"
+			if profile != "" {
+				config.FriendlyName += "-" + profile
+			}
+		}
+	}
+	if config.signer, err = hsmSigner(keyType); err != nil {
+		return nil, err
+	}
+	if config.signer != nil {
+		config.KeyFile = ""
+	} else if config.keyPassphrase, err = keyPassphrase(store, acmeAccountFile, keyFile); err != nil {
+		return nil, err
+	} else {
+		config.PreviousKeyFile = keyFile + ".previous"
+		config.PreviousKeyRetention = *flagPreviousKeyRetention
 	}
-	if err := config.readOrGenerateACMEAccount(); err != nil {
+	if err := config.readOrGenerateACMEAccount(acmeDirectoryURL); err != nil {
 		return nil, err
 	}
+	if config.eab, err = externalAccountBinding(); err != nil {
+		return nil, err
+	}
+	if config.challengers, err = challengers(); err != nil {
+		return nil, err
+	}
+	if config.CSR, err = readUserCSR(); err != nil {
+		return nil, err
+	}
+	if len(config.challengers) > 0 {
+		if *flagDomain == "" && config.CSR == nil {
+			return nil, errors.New("-dnsProvider and -challenge require -domain")
+		}
+		config.Domain = *flagDomain
+	}
+	debugf("Using data dir %s, account %s, certificate %s, key %s", dataDir, acmeAccountFile, certificateFile, config.KeyFile)
 	return config, nil
 }
 
-func (c *Config) ReadOrGenerateCertificateKey() (crypto.Signer, error) {
-	keyBytes, err := os.ReadFile(c.KeyFile)
-	if err == nil {
-		key, err := x509.ParseECPrivateKey(keyBytes)
-		if err != nil {
-			return nil, fmt.Errorf("decode: %w", err)
-		}
-		return key, nil
-	} else if errors.Is(err, os.ErrNotExist) {
-		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			return nil, fmt.Errorf("generate: %w", err)
+// resolveACMEDirectoryURL returns the -acmeUrl directory, or its staging
+// environment with -staging.
+func resolveACMEDirectoryURL() (string, error) {
+	dirURL := *flagACMEDirectoryURL
+	if *flagTestCA != "" {
+		if *flagStaging {
+			return "", errors.New("-testCA and -staging can't be combined")
 		}
+		return *flagTestCA, nil
+	}
+	if !*flagStaging {
+		return dirURL, nil
+	}
+	if dirURL == "" {
+		dirURL = defaultACMEDirectoryURL
+	}
+	stagingURL, ok := stagingDirectoryURLs[dirURL]
+	if !ok {
+		return "", fmt.Errorf("no known staging environment for ACME directory %q; pass it with -acmeUrl instead", dirURL)
+	}
+	return stagingURL, nil
+}
 
-		keyBytes, err := x509.MarshalECPrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("encode: %w", err)
+func externalAccountBinding() (*acme.ExternalAccountBinding, error) {
+	hmacKey := *flagEABHMACKey
+	if hmacKey == "" {
+		hmacKey = os.Getenv("LOCALCERT_EAB_HMAC_KEY")
+	}
+	if *flagEABKeyID == "" && hmacKey == "" {
+		return nil, nil
+	}
+	if *flagEABKeyID == "" || hmacKey == "" {
+		return nil, errors.New("external account binding needs both -eabKeyId and -eabHmacKey")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmacKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode -eabHmacKey: %w", err)
+	}
+	return &acme.ExternalAccountBinding{KID: *flagEABKeyID, Key: key}, nil
+}
+
+// parseIPAddresses parses the comma-separated -ipAddresses value.
+func parseIPAddresses(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, item := range parseList(s) {
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, fmt.Errorf("-ipAddresses: invalid IP address %q", item)
 		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
 
-		err = WritePEMFile(c.KeyFile, privateKeyPEMType, keyBytes)
-		if err != nil {
-			return nil, fmt.Errorf("write %q: %w", c.KeyFile, err)
+// parseList splits a comma-separated flag value.
+func parseList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
+	}
+	return list
+}
 
-		return key, nil
-	} else {
-		return nil, fmt.Errorf("read %q: %w", c.KeyFile, err)
+// outputPaths are the files consumers (servers, export snippets) should
+// reference for the current certificate.
+type outputPaths struct {
+	Leaf      string
+	Chain     string
+	FullChain string
+	Combined  string
+	Key       string
+}
+
+func (c *Config) outputPaths() outputPaths {
+	return outputPaths{
+		Leaf:      c.LeafFile,
+		Chain:     c.ChainFile,
+		FullChain: c.FullChainFile,
+		Combined:  c.CombinedFile,
+		Key:       c.KeyFile,
 	}
 }
 
 func (c *Config) ReadCertificate() (*x509.Certificate, error) {
-	certBytes, err := ReadPEMFile(c.CertificateFile, certificatePEMType)
+	data, err := c.store.ReadFile(c.CertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", c.CertificateFile, err)
+	}
+	certBytes, err := pemutil.DecodePEM(data, pemutil.CertificateType)
 	if err != nil {
 		return nil, fmt.Errorf("read %q: %w", c.CertificateFile, err)
 	}
 	return x509.ParseCertificate(certBytes)
 }
 
-func (c *Config) Client() *localcert.Client {
-	return localcert.Config{
-		ACMEPrivateKey:     c.ACME.PrivateKey.Key.(crypto.Signer),
-		ACMEDirectoryURL:   c.ACME.DirectoryURL,
-		LocalCertServerURL: c.ServerURL,
-	}.Client()
+func (c *Config) ReadCertificateChain() ([][]byte, error) {
+	data, err := c.store.ReadFile(c.CertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", c.CertificateFile, err)
+	}
+	chain, err := pemutil.DecodePEMChain(data, pemutil.CertificateType)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", c.CertificateFile, err)
+	}
+	return chain, nil
 }
 
-func WriteDomainFile(domain string) error {
-	dataDir := *flagDataDir
-	domainfile := filepath.Join(dataDir, "domain")
-	f, err := os.Create(domainfile)
+func parseChain(certChain [][]byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, der := range certChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
 
-	if err != nil {
-        return fmt.Errorf("encode: %w", err)
-    }
+func (c *Config) Manager() *localcert.Manager {
+	retry := localcert.RetryPolicy{MaxRetries: *flagMaxRetries}
+	if retry.MaxRetries == 0 {
+		retry.MaxRetries = -1
+	}
+	return &localcert.Manager{
+		Config: localcert.Config{
+			ACMEPrivateKey:         c.acmeKey,
+			ACMEDirectoryURL:       c.ACME.DirectoryURL,
+			ExternalAccountBinding: c.eab,
+			Challengers:            c.challengers,
+			LocalCertServerURL:     c.ServerURL,
+			HTTPClient:             httpClient,
+			Retry:                  retry,
+			Timeouts:               timeouts(),
+			PreferredChain:         *flagPreferredChain,
+			Profile:                *flagACMEProfile,
+			Logf:                   infof,
+			StartSpan:              phaseSpan,
+			Audit:                  c.audit,
+		},
+		CertificateFile: c.CertificateFile,
+		KeyFile:         c.KeyFile,
+		OrderFile:       c.OrderFile,
+		Store:           c.store,
+		KeyPassphrase:   c.keyPassphrase,
+		KeyType:         c.KeyType,
+		PreviousKeyFile: c.previousKeyFile(),
+		CertFileMode:    c.CertFileMode,
+		KeyFileMode:     c.KeyFileMode,
+		Signer:          c.signer,
+		Domain:          c.Domain,
+		Wildcard:        c.Wildcard,
+		Subdomains:      c.Subdomains,
+		IPAddresses:     c.IPAddresses,
+		MustStaple:      c.MustStaple,
+		CSR:             c.CSR,
+		AccountURL:      c.ACME.PrivateKey.KeyID,
+		AcceptedTerms:   c.ACME.AcceptedTerms,
+		SaveAccount:     c.saveRegisteredAccount,
+		AcceptTerms: func(termsURI string) bool {
+			PromptRequireAcceptTerms(termsURI)
+			return true
+		},
+		Renewal:   c.Renewal,
+		IgnoreARI: !*flagARI,
+		Logf:      infof,
+	}
+}
+
+// domainFile returns the file the domain of the profile's certificate is
+// kept in, next to its state, so that profiles, sandboxes and hosts don't
+// share it.
+func (c *Config) domainFile() string {
+	return filepath.Join(c.DataDir, "domain")
+}
 
-	f.WriteString(domain)
-	f.Close()
+// WriteDomainFile records domain as the domain of the profile's
+// certificate.
+func (c *Config) WriteDomainFile(domain string) error {
+	if err := os.WriteFile(c.domainFile(), []byte(domain), 0644); err != nil {
+		return fmt.Errorf("write domain file: %w", err)
+	}
 	return nil
-	
+}
+
+// readDomainFile returns the domain WriteDomainFile last wrote, or "" if
+// there is none.
+func (c *Config) readDomainFile() string {
+	data, err := os.ReadFile(c.domainFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
 }
 
 func (c *Config) WriteACMEAccountFile() error {
-	fileBytes, err := json.MarshalIndent(c.ACME, "", "  ")
+	fileBytes, err := c.encodeACMEAccount()
+	if err != nil {
+		return err
+	}
+	return c.writeACMEAccountData(fileBytes)
+}
+
+// encodeACMEAccount returns the account file contents, with the key
+// encrypted if there's a passphrase.
+func (c *Config) encodeACMEAccount() ([]byte, error) {
+	account := *c.ACME
+	if c.keyPassphrase != nil {
+		der, err := localcert.EncryptPrivateKey(c.acmeKey, c.keyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt: %w", err)
+		}
+		account.PrivateKey = &jose.JSONWebKey{Key: c.acmeKey.Public(), KeyID: c.ACME.PrivateKey.KeyID}
+		account.EncryptedPrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: pemutil.EncryptedPrivateKeyType, Bytes: der}))
+	}
+	fileBytes, err := json.MarshalIndent(account, "", "  ")
 	if err != nil {
-		return fmt.Errorf("encode: %w", err)
+		return nil, fmt.Errorf("encode: %w", err)
 	}
-	return os.WriteFile(c.ACMEAccountFile, fileBytes, filePerm)
+	return fileBytes, nil
 }
 
 type ACMEAccount struct {
-	DirectoryURL  string           `json:"directoryURL"`
-	PrivateKey    *jose.JSONWebKey `json:"privateKey"`
-	AcceptedTerms string           `json:"acceptedTerms"`
+	DirectoryURL string `json:"directoryURL"`
+	// PrivateKey holds only the public key when the private key is
+	// encrypted into EncryptedPrivateKey.
+	PrivateKey          *jose.JSONWebKey `json:"privateKey"`
+	EncryptedPrivateKey string           `json:"encryptedPrivateKey,omitempty"`
+	AcceptedTerms       string           `json:"acceptedTerms"`
 }
 
-func (c *Config) readOrGenerateACMEAccount() error {
-	dirURL := *flagACMEDirectoryURL
-	fileBytes, err := os.ReadFile(c.ACMEAccountFile)
+// accountKeyType is the configured key type, except that ACME doesn't
+// support Ed25519 account keys.
+func (c *Config) accountKeyType() localcert.KeyType {
+	if c.KeyType == localcert.KeyTypeEd25519 {
+		return localcert.DefaultKeyType
+	}
+	return c.KeyType
+}
+
+func (c *Config) readOrGenerateACMEAccount(dirURL string) error {
+	fileBytes, err := c.readACMEAccountFile()
 	if err == nil {
 		c.ACME = &ACMEAccount{}
 		err := json.Unmarshal(fileBytes, c.ACME)
@@ -177,19 +739,54 @@ func (c *Config) readOrGenerateACMEAccount() error {
 			return fmt.Errorf("decode acmeAccount: %w", err)
 		}
 
-		if dirURL != "" && dirURL != c.ACME.DirectoryURL {
-			return fmt.Errorf("acmeAccount directory URL %q != acmeUrl %q", c.ACME.DirectoryURL, dirURL)
+		// Older account files left the default directory URL empty
+		accountDirURL := c.ACME.DirectoryURL
+		if accountDirURL == "" {
+			accountDirURL = defaultACMEDirectoryURL
+		}
+		if dirURL != "" && dirURL != accountDirURL {
+			return fmt.Errorf("acmeAccount directory URL %q != acmeUrl %q", accountDirURL, dirURL)
 		}
 
-		jwk := c.ACME.PrivateKey
-		acmeKey, ok := jwk.Key.(crypto.Signer)
-		if !ok {
-			return fmt.Errorf("decode acmeAccount: invalid privateKey type %T", jwk.Key)
+		if c.ACME.EncryptedPrivateKey != "" {
+			block, _ := pem.Decode([]byte(c.ACME.EncryptedPrivateKey))
+			if block == nil || block.Type != pemutil.EncryptedPrivateKeyType {
+				return errors.New("decode acmeAccount: invalid encryptedPrivateKey")
+			}
+			if c.keyPassphrase == nil {
+				return errors.New("decode acmeAccount: key is encrypted and no passphrase was given")
+			}
+			if c.acmeKey, err = localcert.DecryptPrivateKey(block.Bytes, c.keyPassphrase); err != nil {
+				return fmt.Errorf("decode acmeAccount: %w", err)
+			}
+			c.ACME.PrivateKey.Key = c.acmeKey
+			c.ACME.EncryptedPrivateKey = ""
+		} else {
+			jwk := c.ACME.PrivateKey
+			acmeKey, ok := jwk.Key.(crypto.Signer)
+			if !ok {
+				return fmt.Errorf("decode acmeAccount: invalid privateKey type %T", jwk.Key)
+			}
+			c.acmeKey = acmeKey
+
+			// Encrypt the key now rather than waiting for the next write
+			if c.keyPassphrase != nil {
+				if err := c.WriteACMEAccountFile(); errors.Is(err, localcert.ErrConflict) {
+					return c.readOrGenerateACMEAccount(dirURL)
+				} else if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Replacing the account key would register a new account (and get a
+		// new domain), so the configured type only applies to new accounts
+		if keyType := localcert.KeyTypeOf(c.acmeKey.Public()); keyType != c.accountKeyType() {
+			infof("Existing ACME account key is %s, not %s; keeping it", keyType, c.accountKeyType())
 		}
-		c.acmeKey = acmeKey
 		return nil
 	} else if errors.Is(err, os.ErrNotExist) {
-		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		key, err := localcert.GenerateKey(c.accountKeyType())
 		if err != nil {
 			return fmt.Errorf("generate key: %w", err)
 		}
@@ -197,10 +794,19 @@ func (c *Config) readOrGenerateACMEAccount() error {
 			dirURL = defaultACMEDirectoryURL
 		}
 		c.ACME = &ACMEAccount{
-			DirectoryURL: *flagACMEDirectoryURL,
+			DirectoryURL: dirURL,
 			PrivateKey:   &jose.JSONWebKey{Key: key},
 		}
 		c.acmeKey = key
+		// Hosts sharing the account must all register this key rather than
+		// one each
+		if c.sharedStore() != nil {
+			if err := c.WriteACMEAccountFile(); errors.Is(err, localcert.ErrConflict) {
+				return c.readOrGenerateACMEAccount(dirURL)
+			} else if err != nil {
+				return err
+			}
+		}
 		return nil
 	} else {
 		return fmt.Errorf("read %q: %w", c.ACMEAccountFile, err)
@@ -0,0 +1,257 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/wildone/localcert/internal/toml"
+)
+
+var (
+	flagConfigFile = flag.String("config", "", "path to a config file in JSON, or in YAML or TOML by its .yaml, .yml or .toml extension (default <user config dir>/localcert/config.json, .yaml, .yml or .toml, if one exists)")
+	flagProfile    = flag.String("profile", "", "name of the config file profile to use")
+	flagAll        = flag.Bool("all", false, "with provision, provision every profile in the config file")
+)
+
+// configFile holds settings keyed by flag name. Top-level settings apply to
+// every profile; a profile's settings override them, and flags given on the
+// command line override both.
+type configFile struct {
+	Name     string
+	Settings map[string]interface{}
+	Profiles map[string]map[string]interface{}
+}
+
+// commandLineFlags are the flags set on the command line, recorded before
+// any config file settings are applied.
+var commandLineFlags map[string]bool
+
+func readConfigFile() (*configFile, error) {
+	name, err := configFileName()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return &configFile{}, nil
+	}
+
+	fileBytes, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", name, err)
+	}
+	raw, err := decodeConfig(name, fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("decode %q: %w", name, err)
+	}
+	version, err := configFileVersion(raw)
+	if err != nil {
+		return nil, fmt.Errorf("config file %q: %w", name, err)
+	}
+	// Loading only migrates in memory; localcert config migrate rewrites
+	// the file
+	changed, err := migrateConfig(raw, version)
+	if err != nil {
+		return nil, fmt.Errorf("config file %q: %w", name, err)
+	}
+	if changed {
+		warnf("Config file %q is version %d; run localcert config migrate to update it to version %d", name, version, configVersion)
+	}
+	if err := validateConfigFile(raw); err != nil {
+		return nil, fmt.Errorf("config file %q: %w", name, err)
+	}
+
+	file := &configFile{Name: name, Settings: map[string]interface{}{}, Profiles: map[string]map[string]interface{}{}}
+	for key, value := range raw {
+		switch key {
+		case "version":
+		case "profiles":
+			for profile, settings := range value.(map[string]interface{}) {
+				file.Profiles[profile] = settings.(map[string]interface{})
+			}
+		default:
+			file.Settings[key] = value
+		}
+	}
+	return file, nil
+}
+
+// configFileName returns the -config file, or the one found in the default
+// data directory, or "" if there is none.
+func configFileName() (string, error) {
+	if *flagConfigFile != "" {
+		return *flagConfigFile, nil
+	}
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return findConfigFile(dataDir), nil
+}
+
+// configFileNames are the config files looked for in the default data
+// directory, in order.
+var configFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+func findConfigFile(dir string) string {
+	for _, base := range configFileNames {
+		name := filepath.Join(dir, base)
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			return name
+		}
+	}
+	return ""
+}
+
+// configFormat returns the encoding of the config file name by its
+// extension: yaml, toml, or json for any other.
+func configFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// decodeConfig decodes the config file name into the values JSON decodes
+// to, whatever its format.
+func decodeConfig(name string, data []byte) (map[string]interface{}, error) {
+	if format := configFormat(name); format != "json" {
+		var decoded interface{}
+		var err error
+		if format == "yaml" {
+			err = yaml.Unmarshal(data, &decoded)
+		} else {
+			decoded, err = toml.Unmarshal(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Bring numbers, dates and nested maps to their JSON types
+		if data, err = json.Marshal(decoded); err != nil {
+			return nil, err
+		}
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	return raw, nil
+}
+
+// encodeConfig encodes the config file name in its format.
+func encodeConfig(name string, raw map[string]interface{}) ([]byte, error) {
+	switch configFormat(name) {
+	case "yaml":
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(raw); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case "toml":
+		return toml.Marshal(raw)
+	}
+	data, err := json.MarshalIndent(raw, "", "  ")
+	return append(data, '\n'), err
+}
+
+func (f *configFile) profileNames() []string {
+	var names []string
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func recordCommandLineFlags() {
+	if commandLineFlags == nil {
+		commandLineFlags = map[string]bool{}
+		flag.Visit(func(fl *flag.Flag) {
+			commandLineFlags[fl.Name] = true
+		})
+	}
+}
+
+// apply sets the flags from the top-level settings and the named profile,
+// resetting any other flag not given on the command line to its default.
+// Environment variables override both.
+func (f *configFile) apply(profile string) error {
+	recordCommandLineFlags()
+
+	settings := map[string]interface{}{}
+	for key, value := range f.Settings {
+		settings[key] = value
+	}
+	if profile != "" {
+		profileSettings, ok := f.Profiles[profile]
+		if !ok {
+			return fmt.Errorf("config file %q has no profile %q", f.Name, profile)
+		}
+		for key, value := range profileSettings {
+			settings[key] = value
+		}
+	}
+
+	flag.VisitAll(func(fl *flag.Flag) {
+		if !commandLineFlags[fl.Name] {
+			fl.Value.Set(fl.DefValue)
+		}
+	})
+	for key, value := range settings {
+		fl := flag.Lookup(key)
+		switch {
+		case fl == nil, key == "config", key == "profile", key == "all":
+			return fmt.Errorf("config file %q: unknown setting %q", f.Name, key)
+		case commandLineFlags[key]:
+			continue
+		}
+		s, ok := settingString(key, value)
+		if !ok {
+			return fmt.Errorf("config file %q: setting %q must be a string, number or boolean", f.Name, key)
+		}
+		if err := fl.Value.Set(s); err != nil {
+			return fmt.Errorf("config file %q: setting %q: %w", f.Name, key, err)
+		}
+	}
+	return applyEnv()
+}
+
+// settingString returns a setting's value as a flag value. Lists and
+// objects are only taken by jsonSettings, as JSON.
+func settingString(key string, value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case []interface{}, map[string]interface{}:
+		if !jsonSettings[key] {
+			return "", false
+		}
+		data, err := json.Marshal(value)
+		return string(data), err == nil
+	case string:
+		return value, true
+	case bool:
+		return strconv.FormatBool(value), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	}
+	return "", false
+}
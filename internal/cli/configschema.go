@@ -0,0 +1,286 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configVersion is the version of the config file layout this build reads
+// and writes. A file without a "version" is read as this version.
+const configVersion = 1
+
+// configMigrations[v] upgrades a decoded version v config file to version
+// v+1 in place, reporting whether it changed any setting. Version 1 is the
+// first layout, so there are none yet.
+var configMigrations = map[int]func(raw map[string]interface{}) (bool, error){}
+
+// configFileVersion returns the layout version of a decoded config file,
+// refusing files written for a newer localcert.
+func configFileVersion(raw map[string]interface{}) (int, error) {
+	value, ok := raw["version"]
+	if !ok {
+		return configVersion, nil
+	}
+	n, ok := value.(float64)
+	if !ok || n < 1 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("version: must be a whole number from 1, not %s", settingJSON(value))
+	}
+	if int(n) > configVersion {
+		return 0, fmt.Errorf("version %d is newer than this localcert reads (%d); upgrade localcert", int(n), configVersion)
+	}
+	return int(n), nil
+}
+
+// migrateConfig upgrades the decoded config file raw from version to the
+// current layout, reporting whether that changed any setting.
+func migrateConfig(raw map[string]interface{}, version int) (bool, error) {
+	changed := false
+	for v := version; v < configVersion; v++ {
+		migrate, ok := configMigrations[v]
+		if !ok {
+			continue
+		}
+		migrated, err := migrate(raw)
+		if err != nil {
+			return false, fmt.Errorf("migrate from version %d: %w", v, err)
+		}
+		changed = changed || migrated
+	}
+	if version < configVersion {
+		raw["version"] = float64(configVersion)
+	}
+	return changed, nil
+}
+
+// migrateConfigFile rewrites the config file name in the current layout if
+// a migration changes it, keeping the original as <name>.v<version>.bak,
+// and returns the backup's name, or "" if the file was left alone.
+func migrateConfigFile(name string) (string, error) {
+	fileBytes, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("read %q: %w", name, err)
+	}
+	raw, err := decodeConfig(name, fileBytes)
+	if err != nil {
+		return "", fmt.Errorf("decode %q: %w", name, err)
+	}
+	version, err := configFileVersion(raw)
+	if err != nil {
+		return "", fmt.Errorf("config file %q: %w", name, err)
+	}
+	changed, err := migrateConfig(raw, version)
+	if err != nil {
+		return "", fmt.Errorf("config file %q: %w", name, err)
+	}
+	if !changed {
+		return "", nil
+	}
+	if err := validateConfigFile(raw); err != nil {
+		return "", fmt.Errorf("config file %q: %w", name, err)
+	}
+	migrated, err := encodeConfig(name, raw)
+	if err != nil {
+		return "", fmt.Errorf("encode %q: %w", name, err)
+	}
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", name, version)
+	if err := writeFileAtomic(backup, fileBytes, perm); err != nil {
+		return "", fmt.Errorf("back up %q: %w", name, err)
+	}
+	if err := writeFileAtomic(name, migrated, perm); err != nil {
+		return "", fmt.Errorf("write %q: %w", name, err)
+	}
+	return backup, nil
+}
+
+// ConfigCommand manages the config file: "config migrate" rewrites it in
+// the current layout.
+func ConfigCommand() {
+	flag.Parse()
+	initOutput()
+	switch action := flag.Arg(1); action {
+	case "migrate":
+	case "":
+		fatal("Usage: localcert config migrate")
+	default:
+		fatalf("Invalid config subcommand %q", action)
+	}
+	name, err := configFileName()
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	if name == "" {
+		fatal("There is no config file to migrate; localcert init writes one")
+	}
+	backup, err := migrateConfigFile(name)
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	if backup == "" {
+		infof("Config file %q is already in the current layout (version %d)", name, configVersion)
+		return
+	}
+	infof("Migrated config file %q to version %d; the previous file is kept as %q", name, configVersion, backup)
+}
+
+// validateConfigFile checks every setting of a decoded config file, in
+// every profile, against the flags, reporting each problem with its path.
+func validateConfigFile(raw map[string]interface{}) error {
+	var problems []string
+	for _, key := range sortedKeys(raw) {
+		switch key {
+		case "version":
+		case "profiles":
+			profiles, ok := raw[key].(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("profiles: must be an object of profiles, not %s", settingJSON(raw[key])))
+				continue
+			}
+			for _, profile := range sortedKeys(profiles) {
+				path := "profiles." + profile
+				settings, ok := profiles[profile].(map[string]interface{})
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s: must be an object of settings, not %s", path, settingJSON(profiles[profile])))
+					continue
+				}
+				for _, key := range sortedKeys(settings) {
+					if err := validateSetting(key, settings[key]); err != nil {
+						problems = append(problems, fmt.Sprintf("%s.%s: %v", path, key, err))
+					}
+				}
+			}
+		default:
+			if err := validateSetting(key, raw[key]); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	}
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(problems[0])
+	}
+	return fmt.Errorf("%d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+}
+
+// validateSetting checks that a setting names a flag and holds a valid
+// value for it.
+func validateSetting(key string, value interface{}) error {
+	switch key {
+	case "config", "profile", "all":
+		return fmt.Errorf("-%s can only be given on the command line", key)
+	case "version":
+		return errors.New("version can only be set at the top level")
+	}
+	fl := flag.Lookup(key)
+	if fl == nil {
+		if suggestion := suggestSetting(key); suggestion != "" {
+			return fmt.Errorf("unknown setting; did you mean %q?", suggestion)
+		}
+		return errors.New("unknown setting")
+	}
+	s, ok := settingString(key, value)
+	if !ok {
+		return fmt.Errorf("must be a string, number or boolean, not %s", settingJSON(value))
+	}
+	// Try the value out on the flag, which apply resets anyway
+	prev := fl.Value.String()
+	err := fl.Value.Set(s)
+	fl.Value.Set(prev)
+	if err != nil {
+		return fmt.Errorf("invalid value %s: must be %s", settingJSON(value), settingKind(fl))
+	}
+	return nil
+}
+
+// settingKind describes the values a flag takes.
+func settingKind(fl *flag.Flag) string {
+	switch fl.Value.(flag.Getter).Get().(type) {
+	case bool:
+		return "true or false"
+	case time.Duration:
+		return "a duration such as \"90m\" or \"720h\""
+	case int, int64, uint, uint64:
+		return "a whole number"
+	case float64:
+		return "a number"
+	}
+	return "a string"
+}
+
+// suggestSetting returns the flag name closest to key, such as for a
+// different case, dashes or a typo, or "" if none is close.
+func suggestSetting(key string) string {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+	}
+	maxDistance := 2
+	if len(key) <= 4 {
+		maxDistance = 1
+	}
+	best, bestDistance := "", maxDistance+1
+	flag.VisitAll(func(fl *flag.Flag) {
+		if fl.Name == "config" || fl.Name == "profile" || fl.Name == "all" {
+			return
+		}
+		if distance := editDistance(normalize(key), normalize(fl.Name)); distance < bestDistance {
+			best, bestDistance = fl.Name, distance
+		}
+	})
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func settingJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
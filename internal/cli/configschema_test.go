@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileVersion(t *testing.T) {
+	tests := []struct {
+		raw     map[string]interface{}
+		version int
+		ok      bool
+	}{
+		{map[string]interface{}{}, configVersion, true},
+		{map[string]interface{}{"version": float64(1)}, 1, true},
+		{map[string]interface{}{"version": float64(0)}, 0, false},
+		{map[string]interface{}{"version": 1.5}, 0, false},
+		{map[string]interface{}{"version": "1"}, 0, false},
+		{map[string]interface{}{"version": float64(configVersion + 1)}, 0, false},
+	}
+	for _, test := range tests {
+		version, err := configFileVersion(test.raw)
+		if test.ok && (err != nil || version != test.version) {
+			t.Errorf("configFileVersion(%v) = %d, %v; want %d", test.raw, version, err, test.version)
+		} else if !test.ok && err == nil {
+			t.Errorf("configFileVersion(%v) = %d, want an error", test.raw, version)
+		}
+	}
+}
+
+// A file without a version is in the current layout, so neither loading
+// nor migrating it rewrites it.
+func TestUnversionedConfigFileIsLeftAlone(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	content := "# Shared settings\nkeyType: ecdsa-p384\nacceptTerms: true # see the CA's terms\n"
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev string) { *flagConfigFile = prev }(*flagConfigFile)
+	*flagConfigFile = name
+
+	file, err := readConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Settings["keyType"] != "ecdsa-p384" {
+		t.Errorf("keyType = %v, want ecdsa-p384", file.Settings["keyType"])
+	}
+	backup, err := migrateConfigFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != "" {
+		t.Errorf("migrateConfigFile backed the file up as %q", backup)
+	}
+
+	if got, err := os.ReadFile(name); err != nil || string(got) != content {
+		t.Errorf("config file is now %q, %v; want it unchanged", got, err)
+	}
+	matches, err := filepath.Glob(name + ".v*.bak")
+	if err != nil || len(matches) > 0 {
+		t.Errorf("backups written: %v, %v", matches, err)
+	}
+}
@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagControlSocket = flag.String("controlSocket", "", "in daemon mode, serve a control API on this Unix socket path, or on a localhost host:port over HTTP, for the control command")
+
+// controlActions are the control API's endpoints, each under /<action>,
+// and what the control command prints for them.
+var controlActions = map[string]string{
+	"status":        "GET",
+	"renew-now":     "POST",
+	"reload-config": "POST",
+	"get-cert":      "GET",
+}
+
+// controlRequest asks the daemon loop to renew or reload now. The loop
+// sends the outcome to reply, which has room for it.
+type controlRequest struct {
+	action string
+	reply  chan controlReply
+}
+
+type controlReply struct {
+	config *Config
+	result *localcert.Result
+	err    error
+}
+
+// controlServer serves the control API of a running daemon. A nil
+// *controlServer serves nothing.
+type controlServer struct {
+	requests chan controlRequest
+
+	mu        sync.Mutex
+	config    *Config
+	nextCheck time.Time
+	lastError string
+}
+
+type controlStatus struct {
+	Profile     string        `json:"profile,omitempty"`
+	NextCheck   time.Time     `json:"nextCheck"`
+	LastError   string        `json:"lastError,omitempty"`
+	Certificate *statusResult `json:"certificate,omitempty"`
+}
+
+type reloadResult struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+// serveControl serves the control API on -controlSocket, if set.
+func serveControl(config *Config) *controlServer {
+	if *flagControlSocket == "" {
+		return nil
+	}
+	l, err := listenControl(*flagControlSocket)
+	if err != nil {
+		fatal("Control socket error: ", err)
+	}
+	s := &controlServer{requests: make(chan controlRequest), config: config}
+	mux := http.NewServeMux()
+	for action, method := range controlActions {
+		mux.HandleFunc("/"+action, s.handler(action, method))
+	}
+	go func() {
+		fatal("Control socket error: ", http.Serve(l, mux))
+	}()
+	infof("Serving the control API on %s", *flagControlSocket)
+	return s
+}
+
+// listenControl listens on a Unix socket, readable only by this user, if
+// addr is a path, and on a loopback TCP address otherwise.
+func listenControl(addr string) (net.Listener, error) {
+	if !strings.Contains(addr, "/") && !strings.Contains(addr, `\`) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("%s isn't a localhost address", addr)
+		}
+		return net.Listen("tcp", addr)
+	}
+	// Replace the socket of a daemon that didn't shut down cleanly
+	if info, err := os.Lstat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(addr)
+	}
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *controlServer) handler(action, method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Browsers send an Origin, so web pages can't drive the daemon
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "cross-origin requests aren't allowed", http.StatusForbidden)
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, method+" only", http.StatusMethodNotAllowed)
+			return
+		}
+		switch action {
+		case "status":
+			s.serveStatus(w)
+		case "get-cert":
+			s.serveCert(w)
+		default:
+			req := controlRequest{action: action, reply: make(chan controlReply, 1)}
+			select {
+			case s.requests <- req:
+			case <-r.Context().Done():
+				return
+			}
+			reply := <-req.reply
+			if reply.err != nil {
+				writeControlError(w, reply.err)
+			} else if action == "reload-config" {
+				writeControlJSON(w, reloadResult{Reloaded: true})
+			} else {
+				writeControlJSON(w, newCertResult(reply.config, reply.result))
+			}
+		}
+	}
+}
+
+func (s *controlServer) serveStatus(w http.ResponseWriter) {
+	s.mu.Lock()
+	config := s.config
+	status := controlStatus{Profile: config.Profile, NextCheck: s.nextCheck, LastError: s.lastError}
+	s.mu.Unlock()
+
+	result, _, err := certStatus(config)
+	if err == nil {
+		status.Certificate = &result
+	} else if !errors.Is(err, os.ErrNotExist) {
+		writeControlError(w, err)
+		return
+	}
+	writeControlJSON(w, status)
+}
+
+func (s *controlServer) serveCert(w http.ResponseWriter) {
+	s.mu.Lock()
+	config := s.config
+	s.mu.Unlock()
+
+	chain, err := config.ReadCertificateChain()
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(pemutil.EncodePEMChain(pemutil.CertificateType, chain))
+}
+
+func writeControlJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeControlError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, os.ErrNotExist) {
+		code = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResult{Error: err.Error(), Type: exitTypes[exitCode(err)]})
+}
+
+// requestChan returns the channel of renew and reload requests, nil (and
+// so never ready) without a control socket.
+func (s *controlServer) requestChan() <-chan controlRequest {
+	if s == nil {
+		return nil
+	}
+	return s.requests
+}
+
+func (s *controlServer) setConfig(config *Config) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+func (s *controlServer) setNextCheck(at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextCheck = at
+}
+
+func (s *controlServer) recordRenewal(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = ""
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+// Control sends a command to a running daemon over its -controlSocket.
+func Control() {
+	flag.Parse()
+	err := applyEnv()
+	initOutput()
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+	file, err := readConfigFile()
+	if err == nil {
+		err = file.apply(*flagProfile)
+	}
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+	action := flag.Arg(1)
+	method, ok := controlActions[action]
+	if !ok {
+		fatal("Usage: localcert -controlSocket <socket> control status|renew-now|reload-config|get-cert")
+	}
+	if *flagControlSocket == "" {
+		fatal("Config error: ", ConfigError{Err: errors.New("control requires -controlSocket, as the daemon was started with")})
+	}
+
+	socket, addr := *flagControlSocket, *flagControlSocket
+	transport := &http.Transport{}
+	if strings.Contains(socket, "/") || strings.Contains(socket, `\`) {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		addr = "localcert"
+	}
+	req, err := http.NewRequest(method, "http://"+addr+"/"+action, nil)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		fatal("Error contacting the daemon: ", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fatal("Error reading the daemon's response: ", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResult errorResult
+		if json.Unmarshal(body, &errResult) != nil || errResult.Error == "" {
+			fatalf("Daemon returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		fatalf("Daemon error: %s", errResult.Error)
+	}
+
+	if action == "get-cert" {
+		os.Stdout.Write(body)
+		return
+	}
+	if *flagJSON {
+		os.Stdout.Write(body)
+		return
+	}
+	switch action {
+	case "status":
+		var status controlStatus
+		if err := json.Unmarshal(body, &status); err != nil {
+			fatal("Error decoding the daemon's response: ", err)
+		}
+		if status.Certificate != nil {
+			fmt.Printf("Domain:      %s\n", status.Certificate.Domain)
+			fmt.Printf("Days left:   %d\n", status.Certificate.DaysRemaining)
+			fmt.Printf("Status:      %s\n", status.Certificate.Status)
+		} else {
+			fmt.Println("No certificate yet")
+		}
+		fmt.Printf("Next check:  %s\n", status.NextCheck.Local().Format(time.RFC3339))
+		if status.LastError != "" {
+			fmt.Printf("Last error:  %s\n", status.LastError)
+		}
+	case "renew-now":
+		var result certResult
+		if err := json.Unmarshal(body, &result); err != nil {
+			fatal("Error decoding the daemon's response: ", err)
+		}
+		fmt.Printf("Renewed %s; expires %s\n", result.Domain, result.NotAfter.Local().Format(time.RFC3339))
+	case "reload-config":
+		fmt.Println("Reloaded the daemon's config")
+	}
+}
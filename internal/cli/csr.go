@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagCSRFile    = flag.String("csrFile", "", "path to the certificate signing request written by gen-csr")
+	flagDomain     = flag.String("domain", "", "domain to issue for with -dnsProvider or -challenge, or for gen-csr (defaults to the existing certificate's domain)")
+	flagMustStaple = flag.Bool("mustStaple", false, "request OCSP Must-Staple, so that clients reject the certificate unless the server staples an OCSP response (see -ocspFile)")
+	flagUseCSR     = flag.String("useCsr", "", "path to a PEM or DER certificate signing request to order with as is, instead of generating one; its names are issued for, and -localKey should hold its key")
+)
+
+func GenCSR() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	domain := *flagDomain
+	if domain == "" {
+		cert, err := config.ReadCertificate()
+		if errors.Is(err, os.ErrNotExist) {
+			fatal("No existing certificate; pass -domain to choose the CSR domain")
+		} else if err != nil {
+			fatal("Error reading existing certificate: ", err)
+		}
+		domain = cert.Subject.CommonName
+	}
+
+	certKey, err := config.Manager().CertificateKey()
+	if err != nil {
+		fatal("Certificate key error: ", err)
+	}
+	var extensions []pkix.Extension
+	if config.MustStaple {
+		extensions = append(extensions, localcert.MustStapleExtension)
+	}
+	csr, err := localcert.CreateCSRWithExtensions(domain, certKey, extensions)
+	if err != nil {
+		fatal("Error creating CSR: ", err)
+	}
+
+	csrFile := *flagCSRFile
+	if csrFile == "" {
+		csrFile = filepath.Join(config.DataDir, "cert.csr")
+	}
+	if err := pemutil.WritePEMFile(csrFile, pemutil.CertificateRequestType, csr, filePerm); err != nil {
+		fatalf("Error writing CSR %q: %v", csrFile, err)
+	}
+	infof("CSR for domain %q written to: %s", domain, csrFile)
+	infof("Once signed, install the certificate with: localcert import-cert <file>")
+	printResult(csrResult{Domain: domain, CSRFile: csrFile})
+}
+
+type csrResult struct {
+	Domain  string `json:"domain"`
+	CSRFile string `json:"csrFile"`
+}
+
+func ImportCert() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	name := flag.Arg(1)
+	if name == "" {
+		fatal("Usage: localcert import-cert <certificate chain file>")
+	}
+	certChain, err := pemutil.ReadPEMChainFile(name, pemutil.CertificateType)
+	if err != nil {
+		fatalf("Error reading %q: %v", name, err)
+	}
+	result, err := config.Manager().ImportCertificate(certChain)
+	if err != nil {
+		fatal("Error importing certificate: ", err)
+	}
+	if err := postIssuance(config, result); err != nil {
+		fatal("Error: ", err)
+	}
+	printCertInfo(config, result.Certificate)
+	printResult(newCertResult(config, result))
+}
+
+// readUserCSR reads the -useCsr file. The CSR names the domains and holds
+// the key, so flags choosing either can't be combined with it.
+func readUserCSR() ([]byte, error) {
+	if *flagUseCSR == "" {
+		return nil, nil
+	}
+	for _, fl := range []struct {
+		name string
+		set  bool
+	}{
+		{"wildcard", *flagWildcard},
+		{"subdomains", *flagSubdomains != ""},
+		{"ipAddresses", *flagIPAddresses != ""},
+		{"mustStaple", *flagMustStaple},
+		{"pkcs11Uri", *flagPKCS11URI != ""},
+	} {
+		if fl.set {
+			return nil, fmt.Errorf("-useCsr can't be combined with -%s; put it in the CSR instead", fl.name)
+		}
+	}
+	data, err := os.ReadFile(*flagUseCSR)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := localcert.ParseCSR(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *flagUseCSR, err)
+	}
+	return csr.Raw, nil
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/wildone/localcert/internal/ct"
+)
+
+var (
+	flagCTMinSCTs = flag.Int("ctMinSCTs", 0, "after issuance, require SCTs from at least this many Certificate Transparency logs embedded in the certificate (0 disables)")
+	flagCTLogList = flag.String("ctLogList", "", "file or URL of a CT log list in the v3 JSON format; only SCTs whose signatures verify against a listed log then count")
+	flagCTAction  = flag.String("ctAction", "fail", "what to do when the certificate has too few SCTs: fail or warn")
+)
+
+// checkSCTs checks that a newly issued certificate carries SCTs from at
+// least -ctMinSCTs logs, which a certificate the CA didn't log (or logged
+// under another name) won't.
+func checkSCTs(certChain [][]byte) error {
+	if *flagCTMinSCTs <= 0 {
+		return nil
+	}
+	err := verifySCTs(certChain, *flagCTMinSCTs)
+	if err != nil && *flagCTAction == "warn" {
+		warnf("Certificate Transparency: %v", err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("certificate transparency: %w", err)
+	}
+	return nil
+}
+
+func verifySCTs(certChain [][]byte, min int) error {
+	cert, err := x509.ParseCertificate(certChain[0])
+	if err != nil {
+		return err
+	}
+	scts, err := ct.EmbeddedSCTs(cert)
+	if err != nil {
+		return err
+	}
+
+	var logs map[[32]byte]*ct.Log
+	var issuer *x509.Certificate
+	if *flagCTLogList != "" {
+		if logs, err = readLogList(*flagCTLogList); err != nil {
+			return err
+		}
+		if len(certChain) < 2 {
+			return fmt.Errorf("verifying SCTs needs the issuer, but the chain has no intermediate")
+		}
+		if issuer, err = x509.ParseCertificate(certChain[1]); err != nil {
+			return err
+		}
+	}
+
+	seen := map[[32]byte]bool{}
+	for _, sct := range scts {
+		if logs != nil {
+			log, ok := logs[sct.LogID]
+			if !ok {
+				debugf("Ignoring SCT from unlisted log %x", sct.LogID)
+				continue
+			}
+			if err := sct.Verify(log, cert, issuer); err != nil {
+				warnf("SCT from %s: %v", log.Description, err)
+				continue
+			}
+			debugf("Verified SCT from %s, timestamp %s", log.Description, sct.Timestamp)
+		}
+		seen[sct.LogID] = true
+	}
+	if len(seen) < min {
+		what := "SCTs"
+		if logs != nil {
+			what = "verified SCTs"
+		}
+		return fmt.Errorf("certificate has %s from %d logs, fewer than the %d required", what, len(seen), min)
+	}
+	infof("Certificate Transparency: SCTs from %d logs", len(seen))
+	return nil
+}
+
+func readLogList(name string) (map[[32]byte]*ct.Log, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://") {
+		client := httpClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+		var resp *http.Response
+		if resp, err = client.Get(name); err != nil {
+			return nil, fmt.Errorf("fetch log list: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch log list: %s", resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read log list: %w", err)
+	}
+	return ct.ParseLogList(data)
+}
@@ -0,0 +1,338 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var (
+	flagRenewJitter      = flag.Duration("renewJitter", time.Hour, "maximum random delay added before a scheduled renewal in daemon mode")
+	flagRetryInterval    = flag.Duration("retryInterval", time.Minute, "initial delay before retrying a failed renewal in daemon mode")
+	flagMaxRetryInterval = flag.Duration("maxRetryInterval", 6*time.Hour, "maximum delay between renewal retries in daemon mode")
+	flagSchedule         = flag.String("schedule", "", "in daemon mode, a cron expression such as \"0 3 * * *\" for when to check for renewal, in local time, instead of when the certificate is due")
+	flagScheduleSplay    = flag.Duration("scheduleSplay", 0, "maximum random delay added to each -schedule time, so a fleet of hosts doesn't check at the same minute")
+	flagWatchInterval    = flag.Duration("watchInterval", 10*time.Second, "in daemon mode, how often to check whether another process replaced the certificate files, to re-read them; 0 to only re-read them on SIGHUP")
+	flagCheckInterval    = flag.Duration("checkInterval", 0, "in daemon mode, the longest to sleep between checks of the renewal time, fetching the CA's renewal information again when it asks, so that renewal isn't late after the machine was suspended (default 5m with -shortLived, otherwise until renewal is due)")
+)
+
+func Daemon() {
+	logger.timestamps = true
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	if runAsService(config) {
+		return
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	runDaemon(ctx, config, nil)
+}
+
+// runDaemon keeps the certificate renewed until ctx is done, calling
+// certUpdated, if set, after each successful renewal check and whenever the
+// certificate files are re-read.
+func runDaemon(ctx context.Context, config *Config, certUpdated func()) {
+	if *flagProbeTarget != "" && *flagProbeInterval > 0 {
+		go probeLoop(config, *flagProbeTarget, *flagProbeInterval)
+	}
+
+	var daemonMetrics *metrics
+	if *flagMetricsAddr != "" {
+		daemonMetrics = newMetrics(config)
+		serveMetrics(*flagMetricsAddr, daemonMetrics)
+		if err := initHTTPClient(); err != nil {
+			fatal("Config error: ", ConfigError{Err: err})
+		}
+	}
+
+	control := serveControl(config)
+	distribution := serveDistribution(config)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	var watchTick <-chan time.Time
+	if *flagWatchInterval > 0 {
+		ticker := time.NewTicker(*flagWatchInterval)
+		defer ticker.Stop()
+		watchTick = ticker.C
+	}
+	certFiles := statCertFiles(config)
+
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go sdWatchdogLoop(interval)
+	}
+	sdNotify("READY=1")
+
+	rand.Seed(time.Now().UnixNano())
+	// Carry on with the backoff of failures from before a restart
+	retryDelay, retryAt := savedRetry(config)
+	var schedule renewalSchedule
+	for {
+		var wait time.Duration
+		polling := false
+		if retryDelay > 0 {
+			wait = time.Until(retryAt)
+			announceRenewalCheck(retryAt)
+			control.setNextCheck(retryAt)
+		} else {
+			// Between checks, only fetch the CA's renewal information again
+			// when it asks
+			if schedule.due.IsZero() || (!schedule.recheck.IsZero() && !time.Now().Before(schedule.recheck)) {
+				prev := schedule.due
+				if schedule = scheduleRenewal(config); !schedule.due.Equal(prev) {
+					announceRenewalCheck(schedule.due)
+				}
+			}
+			control.setNextCheck(schedule.due)
+			// Measured by the wall clock, which keeps counting while the
+			// machine is suspended, unlike time.After
+			wait = time.Until(schedule.due)
+			if interval := checkInterval(); interval > 0 && wait > interval {
+				wait, polling = interval, true
+			}
+		}
+
+		// rereadCert drops everything worked out from the certificate files,
+		// which another process may have replaced
+		rereadCert := func() {
+			certFiles = statCertFiles(config)
+			retryDelay = 0
+			schedule = renewalSchedule{}
+			distribution.reload()
+			if certUpdated != nil {
+				certUpdated()
+			}
+		}
+		reload := func() error {
+			sdNotify("RELOADING=1")
+			defer sdNotify("READY=1")
+			newConfig, err := GetConfig()
+			if err != nil {
+				errorf("Config error; keeping previous config: %v", err)
+				return err
+			}
+			config = newConfig
+			daemonMetrics.setConfig(config)
+			control.setConfig(config)
+			distribution.setConfig(config)
+			rereadCert()
+			return nil
+		}
+
+		force := false
+		var renewReply chan controlReply
+		select {
+		case <-time.After(wait):
+			if polling {
+				continue
+			}
+		case <-ctx.Done():
+			infof("Shutting down")
+			sdNotify("STOPPING=1")
+			return
+		case <-sighup:
+			infof("Received SIGHUP; reloading config and certificate")
+			if reload() != nil {
+				rereadCert()
+			}
+			continue
+		case <-watchTick:
+			if !certFiles.changed(config) {
+				continue
+			}
+			infof("Certificate files changed on disk; re-reading them")
+			rereadCert()
+			continue
+		case req := <-control.requestChan():
+			if req.action == "reload-config" {
+				infof("Reloading config on control request")
+				req.reply <- controlReply{err: reload()}
+				continue
+			}
+			infof("Renewing on control request")
+			force, renewReply = true, req.reply
+		}
+		schedule = renewalSchedule{}
+
+		result, err := provision(ctx, config, force)
+		certFiles = statCertFiles(config)
+		control.recordRenewal(err)
+		if renewReply != nil {
+			renewReply <- controlReply{config: config, result: result, err: err}
+		}
+		if err != nil && ctx.Err() != nil {
+			infof("Shutting down")
+			sdNotify("STOPPING=1")
+			return
+		} else if cooldownErr := (CooldownError{}); errors.As(err, &cooldownErr) {
+			warnf("Renewal blocked: %v", err)
+			retryDelay = cooldownErr.Remaining
+		} else if rateErr := (RateLimitError{}); errors.As(err, &rateErr) {
+			warnf("Renewal blocked: %v", err)
+			retryDelay = time.Until(rateErr.RetryAt())
+		} else if err != nil {
+			daemonMetrics.recordRenewal(false)
+			retryDelay = capRetryDelay(config, nextRetryDelay(retryDelay))
+			// Don't come back before a rate limit has lifted
+			if rateErr := (localcert.RateLimitedError{}); errors.As(err, &rateErr) && time.Until(rateErr.RetryAfter) > retryDelay {
+				retryDelay = time.Until(rateErr.RetryAfter)
+			}
+			errorf("Renewal error (retrying in %s): %v", retryDelay, err)
+			saveRetry(config, retryDelay, time.Now().Add(retryDelay))
+		} else {
+			daemonMetrics.recordRenewal(true)
+			printResult(newCertResult(config, result))
+			retryDelay = 0
+			distribution.reload()
+			if certUpdated != nil {
+				certUpdated()
+			}
+		}
+		retryAt = time.Now().Add(retryDelay)
+	}
+}
+
+// certFileState is the certificate and key files as last seen by the
+// daemon; nil for storage backends other than files.
+type certFileState []os.FileInfo
+
+func statCertFiles(config *Config) certFileState {
+	if _, ok := config.store.(localcert.FileStore); !ok {
+		return nil
+	}
+	state := certFileState{nil, nil}
+	for i, name := range []string{config.CertificateFile, config.KeyFile} {
+		if fi, err := os.Stat(name); err == nil {
+			state[i] = fi
+		}
+	}
+	return state
+}
+
+// changed reports whether the files have changed since s.
+func (s certFileState) changed(config *Config) bool {
+	current := statCertFiles(config)
+	if len(current) != len(s) {
+		return true
+	}
+	for i := range s {
+		if localcert.FileChanged(s[i], current[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// renewalSchedule is when the daemon next checks for renewal, and when it
+// fetches the CA's renewal information again in case that moved, if the CA
+// has any.
+type renewalSchedule struct {
+	due     time.Time
+	recheck time.Time
+}
+
+func announceRenewalCheck(at time.Time) {
+	next := at.Format(time.RFC3339)
+	infof("Next renewal check at %s", next)
+	sdNotify("STATUS=Next renewal check at " + next)
+}
+
+// scheduleRenewal returns when the certificate is due for renewal, with
+// jitter so a fleet of hosts doesn't renew in lockstep, or when its OCSP
+// staple or SSH certificate is due for refresh if that's sooner.
+func scheduleRenewal(config *Config) renewalSchedule {
+	// Round(0) drops the monotonic clock reading, so times compare by the
+	// wall clock
+	now := time.Now().Round(0)
+	cert, err := config.ReadCertificate()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			errorf("Error reading certificate: %v", err)
+		}
+		return renewalSchedule{due: now}
+	}
+	due, recheck := config.Manager().RenewalSchedule(context.Background(), cert)
+	schedule := renewalSchedule{due: due, recheck: recheck.Round(0)}
+	if !due.After(now) {
+		schedule.due = now
+		return schedule
+	}
+	if config.Schedule != nil {
+		schedule.due = nextScheduledCheck(config, cert, now)
+	} else {
+		schedule.due = due.Add(renewalJitter(cert, due))
+	}
+	// Come back sooner to refresh the OCSP staple or the SSH certificate
+	if certChain, err := config.ReadCertificateChain(); err == nil {
+		if ocspWait, ok := untilOCSPRefresh(config, certChain); ok && now.Add(ocspWait).Before(schedule.due) {
+			schedule.due = now.Add(ocspWait)
+		}
+		if sshWait, ok := untilSSHRenewal(certChain); ok && now.Add(sshWait).Before(schedule.due) {
+			schedule.due = now.Add(sshWait)
+		}
+	}
+	return schedule
+}
+
+// renewalJitter returns a delay of up to -renewJitter, chosen from the
+// serial so that rechecks agree. It is at most a tenth of the time left
+// after due, for short-lived certificates.
+func renewalJitter(cert *x509.Certificate, due time.Time) time.Duration {
+	max := *flagRenewJitter
+	if left := cert.NotAfter.Sub(due) / 10; left < max {
+		max = left
+	}
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(cert.SerialNumber.Bytes())
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// nextScheduledCheck returns the next -schedule time after now, splayed,
+// including one that has passed but whose splay hasn't.
+func nextScheduledCheck(config *Config, cert *x509.Certificate, now time.Time) time.Time {
+	at := config.Schedule.Next(now.Add(-*flagScheduleSplay))
+	for !at.IsZero() {
+		if splayed := at.Add(scheduleSplay(cert, at)); splayed.After(now) {
+			return splayed
+		}
+		at = config.Schedule.Next(at)
+	}
+	return now
+}
+
+// scheduleSplay returns a delay of up to -scheduleSplay for the scheduled
+// time at, chosen from the serial so that rechecks agree.
+func scheduleSplay(cert *x509.Certificate, at time.Time) time.Duration {
+	if *flagScheduleSplay <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(cert.SerialNumber.Bytes())
+	h.Write([]byte(at.UTC().Format(time.RFC3339)))
+	return time.Duration(h.Sum64() % uint64(*flagScheduleSplay))
+}
+
+func nextRetryDelay(prev time.Duration) time.Duration {
+	if prev <= 0 {
+		return *flagRetryInterval
+	}
+	next := prev * 2
+	if next > *flagMaxRetryInterval {
+		next = *flagMaxRetryInterval
+	}
+	return next
+}
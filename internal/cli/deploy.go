@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert/internal/deploy"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagDeploy = flag.String("deploy", "", "space-separated targets to push the certificate, chain and key to after each renewal: ssh://user@host/dir?reload=<command>, s3://bucket/prefix?region=<region>, or an https:// URL to PUT them under")
+
+const deployTimeout = 5 * time.Minute
+
+// parseDeployTargets checks the -deploy targets, returning their URLs.
+func parseDeployTargets(s string) ([]string, error) {
+	specs := strings.Fields(s)
+	for _, spec := range specs {
+		if _, err := deploy.Parse(spec, nil); err != nil {
+			return nil, fmt.Errorf("-deploy: %w", err)
+		}
+	}
+	return specs, nil
+}
+
+// deployCertificate pushes the certificate to each -deploy target that
+// doesn't have it yet. Targets that fail are retried on the next run.
+func deployCertificate(ctx context.Context, config *Config, certChain [][]byte) error {
+	if len(config.DeployTargets) == 0 {
+		return nil
+	}
+	state, err := config.readDeployState()
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(certChain[0])
+	if err != nil {
+		return err
+	}
+	serial := cert.SerialNumber.Text(16)
+
+	var files []deploy.File
+	var failed []string
+	for _, spec := range config.DeployTargets {
+		name := redactURL(spec)
+		if state[name] == serial {
+			continue
+		}
+		if files == nil {
+			if files, err = config.deployFiles(certChain); err != nil {
+				return err
+			}
+		}
+		target, err := deploy.Parse(spec, httpClient)
+		if err == nil {
+			deployCtx, cancel := context.WithTimeout(ctx, deployTimeout)
+			err = target.Deploy(deployCtx, files)
+			cancel()
+		}
+		if err != nil {
+			errorf("Error deploying to %s: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		infof("Deployed to %s", name)
+		state[name] = serial
+		if err := config.writeDeployState(state); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("deploying to %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c *Config) deployFiles(certChain [][]byte) ([]deploy.File, error) {
+	key, err := c.keyPEM()
+	if err != nil {
+		return nil, err
+	}
+	return []deploy.File{
+		{Name: "cert.pem", Data: pemutil.EncodePEMChain(pemutil.CertificateType, certChain[:1])},
+		{Name: "chain.pem", Data: pemutil.EncodePEMChain(pemutil.CertificateType, certChain[1:])},
+		{Name: "fullchain.pem", Data: pemutil.EncodePEMChain(pemutil.CertificateType, certChain)},
+		{Name: "privkey.pem", Data: key, Secret: true},
+	}, nil
+}
+
+// deployState maps each target to the serial of the certificate last
+// deployed to it.
+type deployState map[string]string
+
+func (c *Config) deployStateFile() string {
+	return filepath.Join(c.DataDir, "deployed.json")
+}
+
+func (c *Config) readDeployState() (deployState, error) {
+	state := deployState{}
+	data, err := os.ReadFile(c.deployStateFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decode %q: %w", c.deployStateFile(), err)
+	}
+	return state, nil
+}
+
+func (c *Config) writeDeployState(state deployState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.deployStateFile(), append(data, '\n'), filePerm)
+}
+
+// redactURL hides any password in a target URL, for logs and the state
+// file.
+func redactURL(spec string) string {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return spec
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagDistributeAddr     = flag.String("distributeAddr", "", "in daemon mode, serve the certificate over HTTPS at this address to other hosts, as /fullchain.pem, /cert.pem and /chain.pem, and the key as /privkey.pem when -distributeToken or -distributeClientCA is set")
+	flagDistributeToken    = flag.String("distributeToken", "", "bearer token that lets requests to the -distributeAddr server fetch the key (or set LOCALCERT_DISTRIBUTE_TOKEN)")
+	flagDistributeClientCA = flag.String("distributeClientCA", "", "PEM file of CA certificates whose client certificates may fetch the key from the -distributeAddr server")
+)
+
+// distributor serves the certificate, and to authorized clients its key,
+// to other hosts. Its methods do nothing on a nil *distributor, so callers
+// needn't check whether -distributeAddr is set.
+type distributor struct {
+	mu     sync.Mutex
+	config *Config
+	source *localcert.CertSource
+}
+
+// serveDistribution serves the certificate on -distributeAddr, if set,
+// with the certificate itself.
+func serveDistribution(config *Config) *distributor {
+	if *flagDistributeAddr == "" {
+		return nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *flagDistributeClientCA != "" {
+		pool, err := readCertPool(*flagDistributeClientCA)
+		if err != nil {
+			fatal("Config error: ", ConfigError{Err: fmt.Errorf("-distributeClientCA: %w", err)})
+		}
+		// Clients without a certificate can still fetch the chain
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = pool
+	}
+	d := &distributor{}
+	d.setConfig(config)
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		d.mu.Lock()
+		source := d.source
+		d.mu.Unlock()
+		return source.GetCertificate(hello)
+	}
+
+	mux := http.NewServeMux()
+	for _, name := range []string{"/fullchain.pem", "/cert.pem", "/chain.pem", "/privkey.pem"} {
+		mux.HandleFunc(name, d.serveFile)
+	}
+	server := &http.Server{
+		Addr:              *flagDistributeAddr,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		fatal("Distribution server error: ", server.ListenAndServeTLS("", ""))
+	}()
+	infof("Serving the certificate on https://%s/fullchain.pem", *flagDistributeAddr)
+	return d
+}
+
+func readCertPool(name string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %q", name)
+	}
+	return pool, nil
+}
+
+func (d *distributor) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	d.mu.Lock()
+	config := d.config
+	d.mu.Unlock()
+
+	if r.URL.Path == "/privkey.pem" {
+		d.serveKey(w, r, config)
+		return
+	}
+	chain, err := config.ReadCertificateChain()
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "no certificate yet", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("Distribution server error: %v", err)
+		http.Error(w, "error reading certificate", http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Path {
+	case "/cert.pem":
+		chain = chain[:1]
+	case "/chain.pem":
+		chain = chain[1:]
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pemutil.EncodePEMChain(pemutil.CertificateType, chain))
+}
+
+func (d *distributor) serveKey(w http.ResponseWriter, r *http.Request, config *Config) {
+	if *flagDistributeToken == "" && *flagDistributeClientCA == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !keyAuthorized(r) {
+		warnf("Refused the certificate key to %s", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="localcert"`)
+		http.Error(w, "a client certificate or bearer token is required for the key", http.StatusUnauthorized)
+		return
+	}
+	if config.signer != nil {
+		http.Error(w, "the key is kept in a hardware token", http.StatusNotFound)
+		return
+	}
+	keyPEM, err := config.keyPEM()
+	if err != nil {
+		errorf("Distribution server error: %v", err)
+		http.Error(w, "error reading key", http.StatusInternalServerError)
+		return
+	}
+	infof("Served the certificate key to %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(keyPEM)
+}
+
+// keyAuthorized reports whether r presented a client certificate signed by
+// -distributeClientCA or the -distributeToken.
+func keyAuthorized(r *http.Request) bool {
+	if *flagDistributeClientCA != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if *flagDistributeToken == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(*flagDistributeToken)) == 1
+}
+
+func (d *distributor) setConfig(config *Config) {
+	if d == nil {
+		return
+	}
+	source := config.Manager().CertSource()
+	source.OCSPFile = config.OCSPFile
+	source.HTTPClient = httpClient
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config, d.source = config, source
+}
+
+// reload picks up a renewed certificate, which storage backends other than
+// files don't report.
+func (d *distributor) reload() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	source := d.source
+	d.mu.Unlock()
+	if err := source.Reload(); err != nil {
+		errorf("Error reloading certificate: %v", err)
+	}
+}
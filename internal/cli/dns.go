@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/dnsprovider"
+)
+
+var flagDNSProvider = flag.String("dnsProvider", "", "solve DNS-01 challenges for -domain with cloudflare, route53 or rfc2136 instead of the localcert server")
+
+// challengeSolver returns the -dnsProvider solver, configured from the
+// provider's environment variables.
+func challengeSolver() (localcert.ChallengeSolver, error) {
+	switch *flagDNSProvider {
+	case "":
+		return nil, nil
+	case "cloudflare":
+		p := &dnsprovider.Cloudflare{
+			APIToken: os.Getenv("CLOUDFLARE_API_TOKEN"),
+			ZoneID:   os.Getenv("CLOUDFLARE_ZONE_ID"),
+		}
+		if p.APIToken == "" {
+			return nil, errors.New("cloudflare: CLOUDFLARE_API_TOKEN is not set")
+		}
+		return p, nil
+	case "route53":
+		p := &dnsprovider.Route53{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			HostedZoneID:    os.Getenv("AWS_HOSTED_ZONE_ID"),
+		}
+		if p.AccessKeyID == "" || p.SecretAccessKey == "" {
+			return nil, errors.New("route53: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+		}
+		return p, nil
+	case "rfc2136":
+		p := &dnsprovider.RFC2136{
+			Nameserver:    os.Getenv("RFC2136_NAMESERVER"),
+			Zone:          os.Getenv("RFC2136_ZONE"),
+			TSIGKeyName:   os.Getenv("RFC2136_TSIG_KEY"),
+			TSIGSecret:    os.Getenv("RFC2136_TSIG_SECRET"),
+			TSIGAlgorithm: os.Getenv("RFC2136_TSIG_ALGORITHM"),
+		}
+		if p.Nameserver == "" {
+			return nil, errors.New("rfc2136: RFC2136_NAMESERVER is not set")
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown -dnsProvider %q", *flagDNSProvider)
+	}
+}
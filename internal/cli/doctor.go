@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+const doctorTimeout = 30 * time.Second
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // pass, warn, fail or skip
+	Detail string `json:"detail"`
+}
+
+type doctorResult struct {
+	OK     bool          `json:"ok"`
+	Checks []doctorCheck `json:"checks"`
+}
+
+// Doctor checks the whole setup, from the config to the CA, printing a
+// line per check and exiting nonzero if any fails.
+func Doctor() {
+	var result doctorResult
+	report := func(name, status, format string, args ...interface{}) {
+		check := doctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)}
+		fmt.Printf("%-4s  %-12s %s\n", strings.ToUpper(check.Status), check.Name, check.Detail)
+		result.Checks = append(result.Checks, check)
+	}
+	finish := func() {
+		result.OK = true
+		for _, check := range result.Checks {
+			if check.Status == "fail" {
+				result.OK = false
+			}
+		}
+		printResult(result)
+		if !result.OK {
+			os.Exit(1)
+		}
+	}
+
+	config, err := GetConfig()
+	if err != nil {
+		report("config", "fail", "%v", err)
+		finish()
+		return
+	}
+	if config.ACME.PrivateKey.KeyID == "" {
+		report("config", "warn", "no ACME account is registered in %s yet", config.ACMEAccountFile)
+	} else {
+		report("config", "pass", "data dir %s, account %s", config.DataDir, config.ACME.PrivateKey.KeyID)
+	}
+
+	if _, ok := config.store.(localcert.FileStore); !ok {
+		report("permissions", "skip", "files are kept in -storage %s", *flagStorage)
+	} else if err := checkSecretFiles(config); err != nil {
+		report("permissions", "fail", "%v", err)
+	} else if err := config.VerifyReadable(); err != nil && !errors.Is(err, errReadableUnsupported) {
+		report("permissions", "fail", "%q can't read the certificate files: %v", config.VerifyReadableBy, err)
+	} else {
+		report("permissions", "pass", "the key and account files are private to their owner")
+	}
+
+	cert, certErr := checkCertificate(config, report)
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	if certErr != nil {
+		report("dns", "skip", "no certificate to take names from")
+	} else {
+		checkDNS(ctx, config, cert, report)
+	}
+	checkACMEDirectory(ctx, config, report)
+	finish()
+}
+
+// checkSecretFiles returns an error if a file holding a private key is
+// accessible to anyone but its owner.
+func checkSecretFiles(config *Config) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	for _, name := range []string{config.ACMEAccountFile, config.KeyFile, config.CombinedFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			return fmt.Errorf("%s is accessible to group or others (mode %04o)", name, fi.Mode().Perm())
+		}
+	}
+	return nil
+}
+
+// checkCertificate checks that the certificate matches its key and chains
+// to a trusted root.
+func checkCertificate(config *Config, report func(name, status, format string, args ...interface{})) (*x509.Certificate, error) {
+	tlsCert, err := config.Manager().Certificate()
+	if errors.Is(err, os.ErrNotExist) {
+		report("key", "warn", "no certificate yet; run localcert provision")
+		report("chain", "skip", "no certificate yet")
+		return nil, err
+	} else if err != nil {
+		report("key", "fail", "%v", err)
+		report("chain", "skip", "certificate can't be loaded")
+		return nil, err
+	}
+	report("key", "pass", "%s matches its key", config.CertificateFile)
+
+	intermediates := x509.NewCertPool()
+	certs, err := parseChain(tlsCert.Certificate)
+	if err != nil {
+		report("chain", "fail", "%v", err)
+		return nil, err
+	}
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := tlsCert.Leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	if err != nil {
+		report("chain", "fail", "%v", err)
+	} else {
+		root := chains[0][len(chains[0])-1]
+		report("chain", "pass", "chains to %s; expires %s", root.Subject.CommonName, tlsCert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return tlsCert.Leaf, nil
+}
+
+// checkDNS resolves the certificate's names. Under a domain the localcert
+// server assigned, localhost.<domain> must resolve to the loopback address.
+func checkDNS(ctx context.Context, config *Config, cert *x509.Certificate, report func(name, status, format string, args ...interface{})) {
+	var names []string
+	for _, name := range cert.DNSNames {
+		if len(config.challengers) == 0 && strings.HasPrefix(name, "*.") {
+			names = append(names, "localhost."+strings.TrimPrefix(name, "*."))
+		} else if !strings.HasPrefix(name, "*.") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		report("dns", "skip", "the certificate only has wildcard names")
+		return
+	}
+	for _, name := range names {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+		if err != nil {
+			report("dns", "fail", "%v", err)
+			return
+		}
+		if strings.HasPrefix(name, "localhost.") && len(config.challengers) == 0 && !containsString(addrs, "127.0.0.1") {
+			report("dns", "fail", "%s resolves to %s, not 127.0.0.1", name, strings.Join(addrs, ", "))
+			return
+		}
+	}
+	report("dns", "pass", "%s resolve", strings.Join(names, ", "))
+}
+
+// checkACMEDirectory fetches the CA's directory, using its Date header to
+// check the local clock.
+func checkACMEDirectory(ctx context.Context, config *Config, report func(name, status, format string, args ...interface{})) {
+	dirURL := acmeDirectoryURL(config)
+	client := httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", dirURL, nil)
+	if err != nil {
+		report("acme", "fail", "%v", err)
+		report("clock", "skip", "ACME directory unreachable")
+		return
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		report("acme", "fail", "%v", err)
+		report("clock", "skip", "ACME directory unreachable")
+		return
+	}
+	defer resp.Body.Close()
+	var dir struct {
+		NewNonce string `json:"newNonce"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		report("acme", "fail", "%s returned %s", dirURL, resp.Status)
+	} else if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil || dir.NewNonce == "" {
+		report("acme", "fail", "%s isn't an ACME directory", dirURL)
+	} else {
+		report("acme", "pass", "%s is reachable", dirURL)
+	}
+
+	skew, ok := responseSkew(start, resp)
+	if !ok {
+		report("clock", "skip", "the ACME server sent no Date header")
+		return
+	}
+	if skew < -maxClockSkew || skew > maxClockSkew {
+		report("clock", "fail", "local clock is %s off the ACME server's", skew)
+	} else {
+		report("clock", "pass", "local clock is within %s of the ACME server's", maxClockSkew)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
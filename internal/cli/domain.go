@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/wildone/localcert"
+)
+
+var flagRevokeOnDomainChange = flag.Bool("revokeOnDomainChange", false, "when the localcert server assigns a new domain, revoke the certificate for the old one, which may be assigned to someone else")
+
+const domainUsage = "Usage: localcert domain show|release|renew-registration"
+
+type domainResult struct {
+	Domain              string     `json:"domain"`
+	Status              string     `json:"status,omitempty"`
+	RegistrationExpires *time.Time `json:"registrationExpires,omitempty"`
+	CertificateDomain   string     `json:"certificateDomain,omitempty"`
+	Released            bool       `json:"released,omitempty"`
+}
+
+// Domain runs the domain subcommands, which manage the domain the localcert
+// server has assigned the account.
+func Domain() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	action := flag.Arg(1)
+	switch action {
+	case "show", "release", "renew-registration":
+	case "":
+		fatal(domainUsage)
+	default:
+		fatalf("Invalid domain subcommand %q", action)
+	}
+	if len(config.challengers) > 0 {
+		fatal("domain manages the domain the localcert server assigns, which -dnsProvider and -challenge domains don't use")
+	}
+	if config.ACME.PrivateKey.KeyID == "" {
+		fatalf("No ACME account is registered in %s yet; run localcert init or provision first", config.ACMEAccountFile)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	client := config.Manager().Config.Client()
+
+	result := domainResult{}
+	if cert, err := config.ReadCertificate(); err == nil {
+		result.CertificateDomain = cert.Subject.CommonName
+	}
+	switch action {
+	case "show":
+		var res *localcert.DomainResult
+		if res, err = client.DomainInfo(ctx); err == nil {
+			result.Domain, result.Status, result.RegistrationExpires = res.Domain, res.Status, res.Expires
+		}
+	case "renew-registration":
+		var res *localcert.DomainResult
+		if res, err = client.RenewDomainRegistration(ctx); err == nil {
+			result.Domain, result.Status, result.RegistrationExpires = res.Domain, res.Status, res.Expires
+			infof("Renewed the registration of %s", res.Domain)
+		}
+	case "release":
+		if err = releaseDomain(ctx, config, client); err == nil {
+			return
+		}
+	}
+	if err != nil {
+		fatal("Error: ", err)
+	}
+
+	fmt.Printf("Domain:       %s\n", result.Domain)
+	if result.Status != "" {
+		fmt.Printf("Status:       %s\n", result.Status)
+	}
+	if result.RegistrationExpires != nil {
+		fmt.Printf("Registered:   until %s (%s left)\n", result.RegistrationExpires.Local().Format(time.RFC3339), formatDays(time.Until(*result.RegistrationExpires)))
+	}
+	if result.CertificateDomain != "" {
+		fmt.Printf("Certificate:  %s\n", result.CertificateDomain)
+		if result.CertificateDomain != result.Domain {
+			warnf("The certificate is for %s, not the assigned domain; run localcert -forceRenew to switch to %s", result.CertificateDomain, result.Domain)
+		}
+	}
+	printResult(result)
+}
+
+// releaseDomain releases the assigned domain after confirmation, then
+// offers to revoke its certificate and to provision one for a new domain.
+func releaseDomain(ctx context.Context, config *Config, client *localcert.Client) error {
+	if !interactive() {
+		return errors.New("releasing the domain can't be undone, so it asks for confirmation and must be run in a terminal without -nonInteractive")
+	}
+	res, err := client.DomainInfo(ctx)
+	if err != nil {
+		return err
+	}
+	in := bufio.NewReader(os.Stdin)
+	question := fmt.Sprintf("Release %s? The localcert server may assign it to someone else, and you get a new domain", res.Domain)
+	if !askYesNo(in, question, false) {
+		return errors.New("not released")
+	}
+
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		return err
+	}
+	if err := client.ReleaseDomain(ctx); err != nil {
+		unlock()
+		return err
+	}
+	infof("Released %s", res.Domain)
+	// Don't fall back to the released domain while the server is down
+	if err := os.Remove(config.domainFile()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("Error removing the cached domain: %v", err)
+	}
+	cert, err := config.ReadCertificate()
+	if err == nil && cert.Subject.CommonName == res.Domain && time.Now().Before(cert.NotAfter) {
+		question := fmt.Sprintf("Revoke the certificate for %s, which stays valid until %s otherwise?", res.Domain, cert.NotAfter.Local().Format(time.RFC3339))
+		if askYesNo(in, question, true) {
+			if _, err := config.Manager().Revoke(ctx, acme.CRLReasonCessationOfOperation, false); err != nil {
+				errorf("Error revoking the certificate: %v", err)
+			} else {
+				infof("Revoked the certificate for %s", res.Domain)
+			}
+		}
+	}
+	unlock()
+
+	if !askYesNo(in, "Get a new domain and a certificate for it now?", true) {
+		infof("The next provision gets a new domain and a certificate for it")
+		printResult(domainResult{Domain: res.Domain, Released: true})
+		return nil
+	}
+	result, err := provision(ctx, config, true)
+	if err != nil {
+		return err
+	}
+	printResult(newCertResult(config, result))
+	return nil
+}
+
+// newDomainChangedNotification returns the domainChanged notification of
+// event, with the same domains and time as the -json result and state file.
+func newDomainChangedNotification(config *Config, event DomainChangeEvent) NotificationEvent {
+	n := newNotificationEvent(config, notifyDomainChanged)
+	n.OldDomain, n.NewDomain, n.Timestamp = event.OldDomain, event.NewDomain, event.Timestamp
+	if n.Domain == "" {
+		n.Domain = event.NewDomain
+	}
+	return n
+}
+
+// domainChanged guides the user through a new domain the localcert server
+// has assigned, replacing oldDomain, once its certificate is in place.
+func domainChanged(ctx context.Context, config *Config, oldDomain string, result *localcert.Result) {
+	event := newDomainChangeEvent(oldDomain, result.Domain)
+	config.domainChange = &event
+	logEvent(event)
+	notify(newDomainChangedNotification(config, event))
+
+	previous := "The previous one, for the old domain, has been replaced."
+	if _, ok := config.store.(localcert.FileStore); ok && *flagBackups > 0 {
+		previous = fmt.Sprintf("The previous one, for the old domain, is kept as %s.bak.1.", config.CertificateFile)
+	}
+	revoked := "Unless revoked, it stays valid until it expires; -revokeOnDomainChange revokes it."
+	if *flagRevokeOnDomainChange && result.Previous != nil {
+		client := config.Manager().Config.Client()
+		if err := client.RevokeCertificate(ctx, result.Previous.Raw, nil, acme.CRLReasonCessationOfOperation); err != nil {
+			errorf("Error revoking the certificate for %s: %v", oldDomain, err)
+			revoked = "Revoking it failed, so it stays valid until it expires."
+		} else {
+			revoked = "It has been revoked, as the old domain may be assigned to someone else."
+		}
+	}
+	warnf(`The localcert server has assigned you a new domain!
+
+  Old domain: %q
+  New domain: %q
+
+The certificate files now hold a certificate for the new domain.
+%s
+%s
+
+To finish moving to the new domain:
+  - Update anything that names the old domain, such as server_name in server
+    configs, bookmarks and clients; localcert export-config prints configs
+  - Set any DNS records you had under the old domain again with localcert dns
+  - See when its registration expires with localcert domain show
+`, oldDomain, result.Domain, previous, revoked)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDomainFilePerDataDir(t *testing.T) {
+	base := t.TempDir()
+	web := &Config{DataDir: filepath.Join(base, "profiles", "web")}
+	staging := &Config{DataDir: filepath.Join(base, "profiles", "web", "staging")}
+	db := &Config{DataDir: filepath.Join(base, "profiles", "db")}
+	for _, config := range []*Config{web, staging, db} {
+		if err := os.MkdirAll(config.DataDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := web.WriteDomainFile("web.localcert.dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := staging.WriteDomainFile("staging.localcert.dev"); err != nil {
+		t.Fatal(err)
+	}
+	if got := web.readDomainFile(); got != "web.localcert.dev" {
+		t.Errorf("web domain = %q, want web.localcert.dev", got)
+	}
+	if got := staging.readDomainFile(); got != "staging.localcert.dev" {
+		t.Errorf("staging domain = %q, want staging.localcert.dev", got)
+	}
+	// A profile without a domain file of its own falls back to its
+	// certificate's domain rather than another profile's
+	if got := db.readDomainFile(); got != "" {
+		t.Errorf("db domain = %q, want none", got)
+	}
+	if _, err := os.Stat(filepath.Join(base, "domain")); !os.IsNotExist(err) {
+		t.Errorf("domain file written to the base data dir: %v", err)
+	}
+}
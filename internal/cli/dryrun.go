@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var (
+	flagDryRun        = flag.Bool("dryRun", false, "print what provisioning would do, without issuing a certificate or writing any files")
+	flagDryRunStaging = flag.Bool("dryRunStaging", false, "with -dryRun, also issue a throwaway certificate from the CA's staging environment, keeping it in memory")
+)
+
+type planResult struct {
+	Profile       string   `json:"profile,omitempty"`
+	Domain        string   `json:"domain,omitempty"`
+	Names         []string `json:"names,omitempty"`
+	KeyType       string   `json:"keyType"`
+	Renew         bool     `json:"renew"`
+	Reason        string   `json:"reason"`
+	Actions       []string `json:"actions"`
+	StagingSerial string   `json:"stagingSerial,omitempty"`
+}
+
+// dryRun prints the plan for provisioning config, reading the existing
+// state but writing nothing.
+func dryRun(ctx context.Context, config *Config) error {
+	plan, err := newPlan(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Dry run; no certificate will be issued and no files written.")
+	if plan.Domain != "" {
+		fmt.Printf("Domain:    %s\n", plan.Domain)
+		fmt.Printf("Names:     %s\n", strings.Join(plan.Names, ", "))
+	} else {
+		fmt.Printf("Domain:    assigned by %s\n", config.ServerURL)
+	}
+	fmt.Printf("Key type:  %s\n", plan.KeyType)
+	fmt.Printf("Renew:     %t (%s)\n", plan.Renew, plan.Reason)
+	if len(plan.Actions) > 0 {
+		fmt.Println("Actions:")
+		for _, action := range plan.Actions {
+			fmt.Printf("  - %s\n", action)
+		}
+	}
+
+	if *flagDryRunStaging {
+		if plan.StagingSerial, err = issueStaging(ctx, config); err != nil {
+			return fmt.Errorf("staging issuance: %w", err)
+		}
+	}
+	printResult(plan)
+	return nil
+}
+
+func newPlan(ctx context.Context, config *Config) (*planResult, error) {
+	manager := config.Manager()
+	plan := &planResult{
+		Profile: config.Profile,
+		Domain:  config.Domain,
+		KeyType: string(config.KeyType),
+		Renew:   true,
+		Reason:  "No existing certificate",
+	}
+	if config.signer != nil {
+		plan.KeyType = "PKCS #11 key"
+	}
+
+	cert, err := config.ReadCertificate()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading existing certificate %q: %w", config.CertificateFile, err)
+	}
+	if cert != nil {
+		plan.Domain = cert.Subject.CommonName
+		if *flagForceRenew {
+			plan.Reason = "Renewal forced with -forceRenew"
+		} else {
+			plan.Renew, plan.Reason = renewalReason(ctx, config, manager, cert)
+		}
+	}
+	if config.CSR != nil {
+		csr, err := x509.ParseCertificateRequest(config.CSR)
+		if err != nil {
+			return nil, err
+		}
+		plan.Names = localcert.CSRNames(csr)
+		plan.Domain = plan.Names[0]
+		plan.KeyType = string(localcert.KeyTypeOf(csr.PublicKey))
+	} else if plan.Domain != "" {
+		plan.Names = manager.Names(plan.Domain)
+	}
+	if !plan.Renew {
+		return plan, nil
+	}
+
+	if *flagMinRenewInterval > 0 && !*flagOverrideCooldown {
+		last, err := config.LastIssuance()
+		if err != nil {
+			return nil, fmt.Errorf("reading issuance history: %w", err)
+		}
+		if last != nil {
+			if remaining := time.Until(last.IssuedAt.Add(*flagMinRenewInterval)); remaining > 0 {
+				plan.Renew = false
+				plan.Reason = CooldownError{LastIssuedAt: last.IssuedAt, Remaining: remaining}.Error()
+				return plan, nil
+			}
+		}
+	}
+	if err := checkRateLimits(config, plan.Names); errors.As(err, &RateLimitError{}) {
+		plan.Renew = false
+		plan.Reason = err.Error()
+		return plan, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	action := func(format string, args ...interface{}) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf(format, args...))
+	}
+	if config.ACME.PrivateKey.KeyID == "" {
+		action("Register a new ACME account with %s", config.ACME.DirectoryURL)
+	}
+	if *flagPreRenewHook != "" {
+		action("Run preRenew hook: %s", *flagPreRenewHook)
+	}
+	if plan.Domain == "" {
+		action("Get a domain assigned by %s", config.ServerURL)
+	}
+	action("Order a certificate from %s", config.ACME.DirectoryURL)
+	if config.CSR != nil {
+		action("Order with the CSR in %s", *flagUseCSR)
+	} else if config.signer == nil {
+		if _, err := config.store.ReadFile(config.KeyFile); errors.Is(err, os.ErrNotExist) {
+			action("Generate a new %s key in %s", config.KeyType, config.KeyFile)
+		} else if err != nil {
+			return nil, fmt.Errorf("reading key %q: %w", config.KeyFile, err)
+		} else if rotate, err := keyRotationDue(config, cert); err != nil {
+			return nil, err
+		} else if rotate || cert != nil && localcert.KeyTypeOf(cert.PublicKey) != config.KeyType {
+			action("Replace the key in %s with a new %s key", config.KeyFile, config.KeyType)
+			if config.previousKeyFile() != "" {
+				action("Keep the replaced key as %s for %s", config.PreviousKeyFile, config.PreviousKeyRetention)
+			}
+		}
+	}
+	if *flagCTMinSCTs > 0 {
+		action("Check for SCTs from at least %d Certificate Transparency logs", *flagCTMinSCTs)
+	}
+	action("Write the certificate to %s", config.CertificateFile)
+	for _, file := range config.certFiles() {
+		action("Write %s", file.name)
+	}
+	if config.OCSPFile != "" {
+		action("Fetch the OCSP response into %s", config.OCSPFile)
+	}
+	if config.BundleFile != "" {
+		action("Write the bundle to %s", config.BundleFile)
+	}
+	if len(config.ExportFormats) > 0 {
+		action("Export as %s", strings.Join(config.ExportFormats, ", "))
+	}
+	if config.KubeSecretName != "" {
+		action("Update Kubernetes secret %s/%s", config.KubeSecretNamespace, config.KubeSecretName)
+	}
+	for _, spec := range config.DeployTargets {
+		action("Deploy to %s", redactURL(spec))
+	}
+	if config.CertStore {
+		action("Install into the %s certificate store as %q", config.CertStoreLocation, config.FriendlyName)
+	}
+	if *flagPostRenewHook != "" {
+		action("Run postRenew hook: %s", *flagPostRenewHook)
+	}
+	return plan, nil
+}
+
+// issueStaging issues a certificate from the staging environment of the
+// configured CA, returning its serial. Its account, key and certificate
+// are only kept in memory.
+func issueStaging(ctx context.Context, config *Config) (string, error) {
+	// A test CA stands in for staging
+	if !*flagStaging && *flagTestCA == "" {
+		// Later -all profiles are back in production
+		flag.Set("staging", "true")
+		commandLineFlags["staging"] = true
+		defer func() {
+			flag.Set("staging", "false")
+			delete(commandLineFlags, "staging")
+		}()
+		var err error
+		if config, err = getProfileConfig(config.Profile); err != nil {
+			return "", err
+		}
+	}
+	infof("Issuing a throwaway certificate from %s...", config.ACME.DirectoryURL)
+	result, err := config.Manager().Renew(ctx)
+	if err != nil {
+		return "", err
+	}
+	serial := result.Certificate.SerialNumber.Text(16)
+	infof("Staging issued %s (serial %s), expiring %s", strings.Join(result.Certificate.DNSNames, ", "), serial, result.Certificate.NotAfter.Format(time.RFC3339))
+	return serial, nil
+}
+
+// dryRunStore keeps writes in memory, so that -dryRun leaves the real store
+// untouched.
+type dryRunStore struct {
+	base  localcert.Store // nil if nothing is stored yet
+	files map[string][]byte
+}
+
+func newDryRunStore(base localcert.Store) *dryRunStore {
+	return &dryRunStore{base: base, files: map[string][]byte{}}
+}
+
+func (s *dryRunStore) ReadFile(name string) ([]byte, error) {
+	if data, ok := s.files[name]; ok {
+		if data == nil {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+		}
+		return append([]byte(nil), data...), nil
+	}
+	if s.base == nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return s.base.ReadFile(name)
+}
+
+func (s *dryRunStore) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	s.files[name] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *dryRunStore) Remove(name string) error {
+	s.files[name] = nil
+	return nil
+}
@@ -0,0 +1,11 @@
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func disableEcho(f *os.File) (restore func(), err error) {
+	return disableEchoIoctl(f, unix.TIOCGETA, unix.TIOCSETA)
+}
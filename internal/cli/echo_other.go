@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package cli
+
+import "os"
+
+// disableEcho isn't implemented here, so the passphrase is echoed.
+func disableEcho(f *os.File) (restore func(), err error) {
+	return func() {}, nil
+}
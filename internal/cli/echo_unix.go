@@ -0,0 +1,25 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func disableEchoIoctl(f *os.File, getReq, setReq uint) (restore func(), err error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, getReq)
+	if err != nil {
+		return nil, err
+	}
+	saved := *termios
+	termios.Lflag &^= unix.ECHO
+	termios.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, setReq, termios); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, setReq, &saved) }, nil
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// envName returns the environment variable a flag can be set with, e.g.
+// LOCALCERT_DATA_DIR for -dataDir.
+func envName(flagName string) string {
+	var b strings.Builder
+	b.WriteString("LOCALCERT_")
+	for i, r := range flagName {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// applyEnv sets each flag not given on the command line from its
+// LOCALCERT_* environment variable, if set. Hooks run with LOCALCERT_DOMAIN
+// describing the certificate, so -domain isn't read from the environment.
+func applyEnv() error {
+	recordCommandLineFlags()
+	var err error
+	flag.VisitAll(func(fl *flag.Flag) {
+		if err != nil || commandLineFlags[fl.Name] || fl.Name == "domain" {
+			return
+		}
+		name := envName(fl.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := fl.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("%s: %w", name, setErr)
+			}
+		}
+	})
+	return err
+}
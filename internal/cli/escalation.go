@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	flagEscalateBefore   = flag.String("escalateBefore", "168h,72h,24h", "comma-separated times left before expiry at which renewals that are still failing send a renewalStillFailing notification, once each per run of failures")
+	flagEscalateFailures = flag.Int("escalateFailures", 0, "also send a renewalStillFailing notification after this many renewals in a row have failed; 0 for none")
+)
+
+// Retries never wait longer than this fraction of the time the certificate
+// has left, so they come faster as expiry approaches.
+const retryFractionOfRemaining = 20
+
+const notifyRenewalStillFailing = "renewalStillFailing"
+
+// failureState is the run of renewal failures since the last success, kept
+// in FailuresFile so that the daemon's backoff and escalations survive a
+// restart.
+type failureState struct {
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"firstFailure"`
+	LastFailure  time.Time `json:"lastFailure"`
+	LastError    string    `json:"lastError"`
+	// RetryDelay is the daemon's current backoff, as a Go duration
+	RetryDelay string     `json:"retryDelay,omitempty"`
+	NextRetry  *time.Time `json:"nextRetry,omitempty"`
+	// Escalations are the thresholds already notified, such as
+	// "72h0m0s left" or "5 failures"
+	Escalations []string `json:"escalations,omitempty"`
+}
+
+func parseEscalateBefore(s string) ([]time.Duration, error) {
+	var thresholds []time.Duration
+	for _, item := range parseList(s) {
+		d, err := time.ParseDuration(item)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("-escalateBefore: invalid duration %q", item)
+		}
+		thresholds = append(thresholds, d)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds, nil
+}
+
+// readFailureState returns the current run of failures, the zero state if
+// the last renewal succeeded.
+func readFailureState(config *Config) (failureState, error) {
+	var state failureState
+	data, err := os.ReadFile(config.FailuresFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%s: %w", config.FailuresFile, err)
+	}
+	return state, nil
+}
+
+func writeFailureState(config *Config, state failureState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(config.FailuresFile, append(data, '\n'), 0644)
+}
+
+// recordRenewalFailure adds err to the run of failures, and sends a
+// renewalStillFailing notification if that crosses a threshold.
+func recordRenewalFailure(config *Config, err error) {
+	state, readErr := readFailureState(config)
+	if readErr != nil {
+		warnf("Error reading renewal failures: %v", readErr)
+	}
+	now := time.Now().UTC()
+	if state.Failures == 0 {
+		state.FirstFailure = now
+	}
+	state.Failures++
+	state.LastFailure = now
+	state.LastError = err.Error()
+
+	if threshold := escalationThreshold(config, &state); threshold != "" {
+		n := newNotificationEvent(config, notifyRenewalStillFailing)
+		n.Error = state.LastError
+		n.Failures = state.Failures
+		n.FailingSince = &state.FirstFailure
+		warnf("Renewal has failed %s in a row since %s (%s)", failedTimes(state.Failures), state.FirstFailure.Local().Format(time.RFC3339), threshold)
+		notify(n)
+	}
+	if err := writeFailureState(config, state); err != nil {
+		warnf("Error recording renewal failure: %v", err)
+	}
+}
+
+// escalationThreshold returns the threshold the run of failures in state
+// has newly crossed, recording it, or "" if none. Crossing several at once,
+// as after downtime, counts as crossing the last.
+func escalationThreshold(config *Config, state *failureState) string {
+	notified := map[string]bool{}
+	for _, threshold := range state.Escalations {
+		notified[threshold] = true
+	}
+	var crossed []string
+	if config.EscalateFailures > 0 && state.Failures >= config.EscalateFailures {
+		crossed = append(crossed, fmt.Sprintf("%d failures", config.EscalateFailures))
+	}
+	if cert, err := config.ReadCertificate(); err == nil {
+		left := time.Until(cert.NotAfter)
+		for _, before := range config.EscalateBefore {
+			if left <= before {
+				crossed = append(crossed, before.String()+" left")
+			}
+		}
+	}
+	var threshold string
+	for _, c := range crossed {
+		if !notified[c] {
+			state.Escalations = append(state.Escalations, c)
+			threshold = c
+		}
+	}
+	return threshold
+}
+
+func failedTimes(n int) string {
+	if n == 1 {
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+// clearRenewalFailures ends the run of failures after a successful renewal.
+func clearRenewalFailures(config *Config) {
+	state, err := readFailureState(config)
+	if err != nil || state.Failures == 0 {
+		return
+	}
+	infof("Renewal succeeded after failing %s", failedTimes(state.Failures))
+	if err := os.Remove(config.FailuresFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("Error clearing renewal failures: %v", err)
+	}
+}
+
+// savedRetry returns the backoff and next retry time of a daemon that
+// stopped while retrying a failed renewal, or zero values if there's none.
+func savedRetry(config *Config) (time.Duration, time.Time) {
+	state, err := readFailureState(config)
+	if err != nil {
+		warnf("Error reading renewal failures: %v", err)
+		return 0, time.Time{}
+	}
+	if state.Failures == 0 || state.RetryDelay == "" {
+		return 0, time.Time{}
+	}
+	delay, err := time.ParseDuration(state.RetryDelay)
+	if err != nil || delay <= 0 || state.NextRetry == nil {
+		return 0, time.Time{}
+	}
+	return delay, *state.NextRetry
+}
+
+// saveRetry records the daemon's backoff after a failed renewal, if it's
+// still in a run of failures.
+func saveRetry(config *Config, delay time.Duration, at time.Time) {
+	state, err := readFailureState(config)
+	if err != nil || state.Failures == 0 {
+		return
+	}
+	at = at.UTC()
+	state.RetryDelay, state.NextRetry = delay.String(), &at
+	if err := writeFailureState(config, state); err != nil {
+		warnf("Error recording renewal failure: %v", err)
+	}
+}
+
+// capRetryDelay shortens delay to a twentieth of the time the certificate
+// has left, but not below -retryInterval.
+func capRetryDelay(config *Config, delay time.Duration) time.Duration {
+	cert, err := config.ReadCertificate()
+	if err != nil {
+		return delay
+	}
+	limit := time.Until(cert.NotAfter) / retryFractionOfRemaining
+	if limit < *flagRetryInterval {
+		limit = *flagRetryInterval
+	}
+	if delay > limit {
+		return limit
+	}
+	return delay
+}
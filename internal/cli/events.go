@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type DomainChangeEvent struct {
+	Event     string    `json:"event"`
+	OldDomain string    `json:"oldDomain"`
+	NewDomain string    `json:"newDomain"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newDomainChangeEvent(oldDomain, newDomain string) DomainChangeEvent {
+	return DomainChangeEvent{
+		Event:     "domainChanged",
+		OldDomain: oldDomain,
+		NewDomain: newDomain,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+func logEvent(event interface{}) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		errorf("Error encoding event %#v: %v", event, err)
+		return
+	}
+	infof("event: %s", b)
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/acmeutil"
+)
+
+// Exit codes, so that scripts can tell failures apart. status and verify
+// report their findings with their own codes.
+const (
+	exitError       = 1 // anything not covered below
+	exitConfig      = 2
+	exitNetwork     = 3
+	exitACME        = 4
+	exitRateLimited = 5
+	exitTerms       = 6
+	exitStorage     = 7
+	exitInterrupted = 130
+)
+
+// exitTypes names the exit codes in JSON error results.
+var exitTypes = map[int]string{
+	exitError:       "error",
+	exitConfig:      "config",
+	exitNetwork:     "network",
+	exitACME:        "acme",
+	exitRateLimited: "rateLimited",
+	exitTerms:       "termsNotAccepted",
+	exitStorage:     "storage",
+	exitInterrupted: "interrupted",
+}
+
+// ConfigError is an invalid flag, environment variable or config file, or
+// any other failure to load the configuration.
+type ConfigError struct {
+	Err error
+}
+
+func (e ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidSubcommand exits with a usage error.
+func InvalidSubcommand(name string) {
+	fatalCode(exitConfig, fmt.Sprintf("Invalid subcommand %q", name))
+}
+
+// exitCode classifies err.
+func exitCode(err error) int {
+	var (
+		configErr   ConfigError
+		termsErr    localcert.TermsNotAcceptedError
+		rateErr     localcert.RateLimitedError
+		cooldownErr CooldownError
+		limitErr    RateLimitError
+		acmeErr     *acme.Error
+		orderErr    *acme.OrderError
+		authzErr    *acme.AuthorizationError
+		statusErr   *acmeutil.StatusError
+		netErr      net.Error
+		pathErr     *fs.PathError
+		linkErr     *os.LinkError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &termsErr):
+		return exitTerms
+	case errors.As(err, &rateErr), errors.As(err, &cooldownErr), errors.As(err, &limitErr):
+		return exitRateLimited
+	case errors.As(err, &acmeErr), errors.As(err, &orderErr), errors.As(err, &authzErr), errors.As(err, &statusErr):
+		return exitACME
+	case errors.As(err, &pathErr), errors.As(err, &linkErr):
+		return exitStorage
+	case errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitError
+}
@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	flagExportFormat = flag.String("format", "", "export snippet format: nginx, apache, haproxy, caddy or traefik")
+	flagExportServer = flag.String("server", "", "with export-config, the server to write a TLS configuration snippet for: nginx, apache, haproxy, caddy or traefik")
+	flagExportOut    = flag.String("out", "", "with export or export-config, the file to update the managed block of with the snippet")
+)
+
+// The snippets follow Mozilla's "intermediate" recommendations: TLS 1.2 with
+// forward-secret AEAD ciphers, and TLS 1.3.
+const intermediateCiphers = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305"
+
+var snippetTemplates = map[string]func(paths outputPaths) string{
+	"nginx": func(paths outputPaths) string {
+		return fmt.Sprintf(`ssl_certificate     %s;
+ssl_certificate_key %s;
+ssl_protocols       TLSv1.2 TLSv1.3;
+ssl_ciphers         %s;
+ssl_prefer_server_ciphers off;
+ssl_session_timeout 1d;
+ssl_session_cache   shared:localcert:10m;
+ssl_session_tickets off;
+`, paths.FullChain, paths.Key, intermediateCiphers)
+	},
+	"apache": func(paths outputPaths) string {
+		return fmt.Sprintf(`SSLEngine on
+SSLCertificateFile    %s
+SSLCertificateKeyFile %s
+SSLProtocol           -all +TLSv1.2 +TLSv1.3
+SSLCipherSuite        %s
+SSLHonorCipherOrder   off
+SSLSessionTickets     off
+`, paths.FullChain, paths.Key, intermediateCiphers)
+	},
+	"haproxy": func(paths outputPaths) string {
+		if paths.Combined != "" {
+			return fmt.Sprintf(`bind :443 ssl crt %s ssl-min-ver TLSv1.2 ciphers %s
+`, paths.Combined, intermediateCiphers)
+		}
+		return fmt.Sprintf(`# HAProxy loads the key from "<crt>.key" unless it is appended to the crt file
+# (see -combinedFile):
+#   ln -s %s %s.key
+bind :443 ssl crt %s ssl-min-ver TLSv1.2 ciphers %s
+`, paths.Key, paths.FullChain, paths.FullChain, intermediateCiphers)
+	},
+	"caddy": func(paths outputPaths) string {
+		return fmt.Sprintf(`tls %s %s {
+	protocols tls1.2 tls1.3
+}
+`, paths.FullChain, paths.Key)
+	},
+	// Dynamic configuration for Traefik's file provider.
+	"traefik": func(paths outputPaths) string {
+		return fmt.Sprintf(`tls:
+  certificates:
+    - certFile: %s
+      keyFile: %s
+  options:
+    default:
+      minVersion: VersionTLS12
+      cipherSuites:
+        - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+        - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+        - TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+        - TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+        - TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305
+        - TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305
+`, paths.FullChain, paths.Key)
+	},
+}
+
+func Export() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+
+	format, formatFlag := *flagExportFormat, "-format"
+	if *flagExportServer != "" {
+		format, formatFlag = *flagExportServer, "-server"
+	}
+	if format == "" && flag.Arg(0) == "export" {
+		fatal("Usage: localcert export -format <server> [-out <file>]\n" +
+			"To back up the ACME account and certificate, use localcert backup <backup.lcb>")
+	}
+	template, ok := snippetTemplates[format]
+	if !ok {
+		fatalf("Invalid %s %q; expected nginx, apache, haproxy, caddy or traefik", formatFlag, format)
+	}
+	snippet := template(config.outputPaths())
+
+	if *flagExportOut == "" {
+		if *flagJSON {
+			printResult(exportResult{Format: format, Snippet: snippet})
+		} else {
+			fmt.Print(snippet)
+		}
+		return
+	}
+	if err := writeManagedBlock(*flagExportOut, format, snippet); err != nil {
+		fatalf("Error writing %q: %v", *flagExportOut, err)
+	}
+	infof("Wrote %s configuration to %s", format, *flagExportOut)
+	printResult(exportResult{Format: format, Snippet: snippet, File: *flagExportOut})
+}
+
+type exportResult struct {
+	Format  string `json:"format"`
+	Snippet string `json:"snippet"`
+	File    string `json:"file,omitempty"`
+}
+
+// writeManagedBlock replaces the localcert-managed block in name with
+// content, appending it if the file has none, and leaves the rest of the
+// file untouched.
+func writeManagedBlock(name, format, content string) error {
+	begin := fmt.Sprintf("# BEGIN localcert managed %s config", format)
+	end := fmt.Sprintf("# END localcert managed %s config", format)
+	block := begin + "\n" + content + end + "\n"
+
+	existing, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// The server's config keeps its mode and owner when replaced
+	info, err := os.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var out []byte
+	beginIdx := bytes.Index(existing, []byte(begin))
+	endIdx := bytes.Index(existing, []byte(end))
+	if beginIdx >= 0 && endIdx > beginIdx {
+		rest := existing[endIdx+len(end):]
+		rest = bytes.TrimPrefix(rest, []byte("\n"))
+		out = append(out, existing[:beginIdx]...)
+		out = append(out, block...)
+		out = append(out, rest...)
+	} else {
+		out = append(out, existing...)
+		if len(out) > 0 && !strings.HasSuffix(string(out), "\n") {
+			out = append(out, '\n')
+		}
+		out = append(out, block...)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info != nil {
+		mode = info.Mode().Perm()
+		if err := copyOwner(tmp, info); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
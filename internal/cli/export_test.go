@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteManagedBlockKeepsMode(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "nginx.conf")
+	if err := os.WriteFile(name, []byte("worker_processes 1;\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's mode is subject to the umask
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := writeManagedBlock(name, "nginx", "ssl_certificate /etc/cert.pem;\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "worker_processes 1;\n") || strings.Count(string(data), "ssl_certificate") != 1 {
+		t.Errorf("config = %q, want the original with one managed block", data)
+	}
+}
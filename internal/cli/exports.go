@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/jks"
+	"github.com/wildone/localcert/internal/pkcs12"
+)
+
+var (
+	flagExportFormats    = flag.String("exportFormats", "", "comma-separated extra formats written after each issuance: pkcs12, der or keystore")
+	flagPKCS12File       = flag.String("pkcs12File", "", "path to the PKCS #12 export (default <dataDir>/cert.pfx)")
+	flagPKCS12Password   = flag.String("pkcs12Password", "", "password for the PKCS #12 export (or set LOCALCERT_PKCS12_PASSWORD)")
+	flagDERFile          = flag.String("derFile", "", "path to the der export of the certificate (default <dataDir>/cert.der)")
+	flagDERChainFile     = flag.String("derChainFile", "", "path to the der export of the intermediates, one after another (default <dataDir>/chain.der)")
+	flagKeystoreFile     = flag.String("keystoreFile", "", "path to the Java keystore export (default <dataDir>/keystore.p12, or keystore.jks with -keystoreType jks)")
+	flagKeystoreType     = flag.String("keystoreType", "pkcs12", "type of the Java keystore export: pkcs12, which Java 9 and later default to, or jks for older Java")
+	flagKeystoreAlias    = flag.String("keystoreAlias", "localcert", "alias of the key entry in the Java keystore export, such as tomcat")
+	flagKeystorePassword = flag.String("keystorePassword", "changeit", "password for the Java keystore export and its key entry (or set LOCALCERT_KEYSTORE_PASSWORD)")
+)
+
+func parseExportFormats(s string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(s, ",") {
+		format = strings.TrimSpace(format)
+		switch format {
+		case "":
+			continue
+		case "pkcs12", "der", "keystore":
+		default:
+			return nil, fmt.Errorf("unknown export format %q", format)
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// writeExports writes the certificate in each configured export format. If
+// onlyMissing is set, existing exports are left alone.
+func writeExports(config *Config, certChain [][]byte, onlyMissing bool) error {
+	for _, format := range config.ExportFormats {
+		var name string
+		var write func(string, [][]byte) error
+		// files are the files the export writes, all of which must exist
+		// for it to be skipped with onlyMissing
+		var files []string
+		switch format {
+		case "pkcs12":
+			name, write = config.PKCS12File, config.writePKCS12
+		case "der":
+			name, write = config.DERFile, config.writeDER
+			if len(certChain) > 1 {
+				files = append(files, config.DERChainFile)
+			}
+		case "keystore":
+			name, write = config.KeystoreFile, config.writeKeystore
+		}
+		if onlyMissing && allExist(append(files, name)) {
+			continue
+		}
+		err := write(name, certChain)
+		config.auditWrite(name, err)
+		if err != nil {
+			return fmt.Errorf("writing %s export %q: %w", format, name, err)
+		}
+		infof("Certificate (%s): %s", format, name)
+	}
+	return nil
+}
+
+// allExist reports whether every file in names exists.
+func allExist(names []string) bool {
+	for _, name := range names {
+		if _, err := os.Stat(name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// exportFiles returns the files written by the configured export formats.
+func (c *Config) exportFiles() []string {
+	var files []string
+	for _, format := range c.ExportFormats {
+		switch format {
+		case "pkcs12":
+			files = append(files, c.PKCS12File)
+		case "der":
+			files = append(files, c.DERFile, c.DERChainFile)
+		case "keystore":
+			files = append(files, c.KeystoreFile)
+		}
+	}
+	return files
+}
+
+func (c *Config) writePKCS12(name string, certChain [][]byte) error {
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return err
+	}
+	key, err := c.Manager().CertificateKey()
+	if err != nil {
+		return err
+	}
+	pfx, err := pkcs12.Encode(key, certs[0], certs[1:], c.PKCS12Password, "")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, pfx, c.KeyFileMode)
+}
+
+// exportsNeedKey reports whether the -exportFormats formats include the key.
+func exportsNeedKey(formats string) bool {
+	for _, format := range strings.Split(formats, ",") {
+		if format = strings.TrimSpace(format); format != "" && format != "der" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDER writes the certificate to name, and the intermediates to
+// DERChainFile. A chain without intermediates has no DERChainFile, rather
+// than an empty one, which DER readers reject.
+func (c *Config) writeDER(name string, certChain [][]byte) error {
+	if err := writeFileAtomic(name, certChain[0], c.CertFileMode); err != nil {
+		return err
+	}
+	if len(certChain) == 1 {
+		// Don't leave the intermediates of an earlier chain behind
+		if err := os.Remove(c.DERChainFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	err := writeFileAtomic(c.DERChainFile, bytes.Join(certChain[1:], nil), c.CertFileMode)
+	c.auditWrite(c.DERChainFile, err)
+	return err
+}
+
+func (c *Config) writeKeystore(name string, certChain [][]byte) error {
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return err
+	}
+	key, err := c.Manager().CertificateKey()
+	if err != nil {
+		return err
+	}
+	var keystore []byte
+	if c.KeystoreType == "jks" {
+		keystore, err = jks.Encode(key, certs, c.KeystoreAlias, c.KeystorePassword)
+	} else {
+		keystore, err = pkcs12.Encode(key, certs[0], certs[1:], c.KeystorePassword, c.KeystoreAlias)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, keystore, c.KeyFileMode)
+}
+
+// writeFileAtomic replaces name without leaving it truncated on a crash.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return localcert.FileStore{}.WriteFile(name, data, perm)
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testDERConfig(t *testing.T) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	return &Config{
+		ExportFormats: []string{"der"},
+		DERFile:       filepath.Join(dir, "cert.der"),
+		DERChainFile:  filepath.Join(dir, "chain.der"),
+		CertFileMode:  0644,
+		AuditFile:     filepath.Join(dir, "audit.log"),
+	}
+}
+
+func TestWriteDER(t *testing.T) {
+	config := testDERConfig(t)
+	chain := testChain(t, "example.localcert.dev", 90*24*time.Hour)
+
+	if err := writeExports(config, chain, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{config.DERFile: chain[0], config.DERChainFile: chain[1]} {
+		if got, err := os.ReadFile(name); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s = %x, %v; want %x", filepath.Base(name), got, err, want)
+		}
+	}
+
+	// A chain without intermediates gets no chain.der, not an empty one
+	if err := writeExports(config, chain[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(config.DERFile); err != nil || !bytes.Equal(got, chain[0]) {
+		t.Errorf("cert.der = %x, %v; want the certificate", got, err)
+	}
+	if _, err := os.Stat(config.DERChainFile); !os.IsNotExist(err) {
+		t.Errorf("chain.der of a chain without intermediates: %v, want none", err)
+	}
+}
+
+func TestWriteExportsOnlyMissing(t *testing.T) {
+	config := testDERConfig(t)
+	chain := testChain(t, "example.localcert.dev", 90*24*time.Hour)
+	if err := writeExports(config, chain, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing chain.der is written again, even though cert.der exists
+	if err := os.Remove(config.DERChainFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExports(config, chain, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(config.DERChainFile); err != nil || !bytes.Equal(got, chain[1]) {
+		t.Errorf("chain.der = %x, %v; want the intermediate", got, err)
+	}
+
+	// Exports that are all there are left alone
+	if err := os.WriteFile(config.DERFile, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExports(config, chain, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(config.DERFile); string(got) != "kept" {
+		t.Error("cert.der was rewritten although every der export exists")
+	}
+	// Without intermediates, cert.der alone is complete
+	if err := os.Remove(config.DERChainFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExports(config, chain[:1], true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(config.DERFile); string(got) != "kept" {
+		t.Error("cert.der was rewritten for a chain without intermediates")
+	}
+}
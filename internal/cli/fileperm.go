@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+
+	"github.com/wildone/localcert"
+)
+
+var (
+	flagCertFileMode = flag.String("certFileMode", "0700", "octal permissions of the certificate files, e.g. 0644 to make them world-readable")
+	flagKeyFileMode  = flag.String("keyFileMode", "0700", "octal permissions of the files holding the certificate key, e.g. 0640 to share it with -fileGroup")
+	flagFileOwner    = flag.String("fileOwner", "", "user, by name or ID, to give the certificate and key files to (needs root)")
+	flagFileGroup    = flag.String("fileGroup", "", "group, by name or ID, to give the certificate and key files to, e.g. ssl-cert (needs root, or membership of the group)")
+	flagUmask        = flag.String("umask", "", "octal umask for the files and directories localcert creates, e.g. 0027")
+)
+
+// initUmask sets -umask for the process.
+func initUmask() error {
+	if *flagUmask == "" {
+		return nil
+	}
+	mask, err := parseFileMode(*flagUmask)
+	if err != nil {
+		return fmt.Errorf("-umask: %w", err)
+	}
+	return setUmask(int(mask))
+}
+
+// parseFilePolicy sets the modes and ownership of config's certificate and
+// key files from the flags.
+func parseFilePolicy(config *Config) error {
+	var err error
+	if config.CertFileMode, err = parseFileMode(*flagCertFileMode); err != nil {
+		return fmt.Errorf("-certFileMode: %w", err)
+	}
+	if config.KeyFileMode, err = parseFileMode(*flagKeyFileMode); err != nil {
+		return fmt.Errorf("-keyFileMode: %w", err)
+	}
+	config.FileUID, config.FileGID = -1, -1
+	if *flagFileOwner == "" && *flagFileGroup == "" {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return errors.New("-fileOwner and -fileGroup aren't supported on Windows")
+	}
+	if *flagFileOwner != "" {
+		if config.FileUID, err = lookupID(*flagFileOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("-fileOwner: %w", err)
+		}
+	}
+	if *flagFileGroup != "" {
+		if config.FileGID, err = lookupID(*flagFileGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("-fileGroup: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid octal mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// dirMode is the mode of a directory created for files of mode: searchable
+// by whoever may read them.
+func dirMode(mode os.FileMode) os.FileMode {
+	return filePerm | mode | (mode&0444)>>2
+}
+
+// lookupID returns a numeric ID as is, or looks up a name.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// applyFilePolicy gives the certificate and key files on local disk their
+// configured modes and ownership, so that a changed policy applies without
+// waiting for a renewal.
+func applyFilePolicy(config *Config) error {
+	certFiles := []string{config.LeafFile, config.ChainFile, config.FullChainFile}
+	keyFiles := []string{config.CombinedFile}
+	if _, ok := config.store.(localcert.FileStore); ok {
+		certFiles = append(certFiles, config.CertificateFile)
+		if config.signer == nil {
+			keyFiles = append(keyFiles, config.KeyFile, config.previousKeyFile())
+		}
+	}
+	for _, format := range config.ExportFormats {
+		switch format {
+		case "pkcs12":
+			keyFiles = append(keyFiles, config.PKCS12File)
+		case "der":
+			certFiles = append(certFiles, config.DERFile, config.DERChainFile)
+		case "keystore":
+			keyFiles = append(keyFiles, config.KeystoreFile)
+		}
+	}
+	if config.BundleFile != "" {
+		if config.BundleIncludeKey {
+			keyFiles = append(keyFiles, config.BundleFile)
+		} else {
+			certFiles = append(certFiles, config.BundleFile)
+		}
+	}
+
+	apply := func(name string, mode os.FileMode) error {
+		if name == "" {
+			return nil
+		}
+		info, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode().Perm() != mode {
+			if err := os.Chmod(name, mode); err != nil {
+				return err
+			}
+		}
+		if config.FileUID != -1 || config.FileGID != -1 {
+			if err := os.Chown(name, config.FileUID, config.FileGID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range certFiles {
+		if err := apply(name, config.CertFileMode); err != nil {
+			return err
+		}
+	}
+	for _, name := range keyFiles {
+		if err := apply(name, config.KeyFileMode); err != nil {
+			return err
+		}
+	}
+	for _, output := range config.Outputs {
+		if err := apply(output.Path, output.fileMode(config)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
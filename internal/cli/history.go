@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type IssuanceRecord struct {
+	Domain    string    `json:"domain"`
+	Serial    string    `json:"serial"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Names     []string  `json:"names,omitempty"`
+	Account   string    `json:"account,omitempty"`
+	KeyID     string    `json:"keyId,omitempty"`
+}
+
+// names returns the certificate's names; just its domain for records
+// written before names were tracked.
+func (r IssuanceRecord) names() []string {
+	if len(r.Names) == 0 {
+		return []string{r.Domain}
+	}
+	return r.Names
+}
+
+// Lifetime returns the validity period of the issued certificate, or 0 for
+// records written before validity was tracked.
+func (r IssuanceRecord) Lifetime() time.Duration {
+	if r.NotBefore.IsZero() || r.NotAfter.IsZero() {
+		return 0
+	}
+	return r.NotAfter.Sub(r.NotBefore)
+}
+
+const (
+	// historyRetention is how long issuance records are kept, well past the
+	// longest rate limit window they're counted against.
+	historyRetention = 90 * 24 * time.Hour
+	// maxHistory caps the records kept, however recent.
+	maxHistory = 1000
+)
+
+func (c *Config) ReadHistory() ([]IssuanceRecord, error) {
+	fileBytes, err := os.ReadFile(c.HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read %q: %w", c.HistoryFile, err)
+	}
+	var history []IssuanceRecord
+	if err := json.Unmarshal(fileBytes, &history); err != nil {
+		return nil, fmt.Errorf("decode %q: %w", c.HistoryFile, err)
+	}
+	return history, nil
+}
+
+func (c *Config) AppendHistory(record IssuanceRecord) error {
+	history, err := c.ReadHistory()
+	if err != nil {
+		return err
+	}
+	history = pruneHistory(append(history, record), time.Now())
+	fileBytes, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	return writeFileAtomic(c.HistoryFile, fileBytes, filePerm)
+}
+
+func (c *Config) LastIssuance() (*IssuanceRecord, error) {
+	history, err := c.ReadHistory()
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	return &history[len(history)-1], nil
+}
+
+// pruneHistory drops the records older than historyRetention, and all but
+// the newest maxHistory. It keeps the first record of the latest run of
+// certificates for the same key, which keyIssuedSince dates the key by.
+func pruneHistory(history []IssuanceRecord, now time.Time) []IssuanceRecord {
+	if len(history) == 0 {
+		return history
+	}
+	last := len(history) - 1
+	runStart := last
+	for runStart > 0 && history[runStart-1].KeyID == history[last].KeyID {
+		runStart--
+	}
+	start := 0
+	if len(history) > maxHistory {
+		start = len(history) - maxHistory
+	}
+	for start < last && now.Sub(history[start].IssuedAt) >= historyRetention {
+		start++
+	}
+	if start <= runStart {
+		return history[start:]
+	}
+	return append([]IssuanceRecord{history[runStart]}, history[start:]...)
+}
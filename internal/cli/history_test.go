@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneHistory(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	record := func(age time.Duration, keyID string) IssuanceRecord {
+		return IssuanceRecord{Serial: age.String() + keyID, IssuedAt: now.Add(-age), KeyID: keyID}
+	}
+	serials := func(history []IssuanceRecord) []string {
+		var s []string
+		for _, r := range history {
+			s = append(s, r.Serial)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		history []IssuanceRecord
+		want    []string
+	}{
+		{"empty", nil, nil},
+		{"all recent", []IssuanceRecord{record(60*day, "a"), record(30*day, "b")}, []string{"1440h0m0sa", "720h0m0sb"}},
+		{
+			"old records of other keys dropped",
+			[]IssuanceRecord{record(400*day, "a"), record(200*day, "b"), record(30*day, "c")},
+			[]string{"720h0m0sc"},
+		},
+		{
+			"first record of the current key kept",
+			[]IssuanceRecord{record(400*day, "a"), record(300*day, "b"), record(200*day, "b"), record(30*day, "b")},
+			[]string{"7200h0m0sb", "720h0m0sb"},
+		},
+		{"latest record kept however old", []IssuanceRecord{record(200*day, "a")}, []string{"4800h0m0sa"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := serials(pruneHistory(test.history, now))
+			if len(got) != len(test.want) {
+				t.Fatalf("pruneHistory = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("pruneHistory = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+
+	var many []IssuanceRecord
+	for i := 0; i < maxHistory+10; i++ {
+		many = append(many, record(time.Duration(maxHistory+10-i)*time.Minute, "k"))
+	}
+	pruned := pruneHistory(many, now)
+	if len(pruned) != maxHistory+1 || pruned[0].Serial != many[0].Serial || pruned[len(pruned)-1].Serial != many[len(many)-1].Serial {
+		t.Errorf("pruneHistory kept %d of %d records, want the first of the key and the newest %d", len(pruned), len(many), maxHistory)
+	}
+}
+
+func TestAppendHistoryPrunes(t *testing.T) {
+	config := &Config{HistoryFile: filepath.Join(t.TempDir(), "history.json")}
+	old := IssuanceRecord{Serial: "1", IssuedAt: time.Now().Add(-200 * 24 * time.Hour), KeyID: "a"}
+	recent := IssuanceRecord{Serial: "2", IssuedAt: time.Now(), KeyID: "b"}
+	for _, r := range []IssuanceRecord{old, recent} {
+		if err := config.AppendHistory(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := config.ReadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Serial != "2" {
+		t.Errorf("history = %+v, want only the recent record", history)
+	}
+	if _, err := os.Stat(config.HistoryFile); err != nil {
+		t.Error(err)
+	}
+}
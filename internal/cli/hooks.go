@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+var (
+	flagPreRenewHook  = flag.String("preRenewHook", "", "shell command run before a certificate is renewed; renewal is aborted if it fails")
+	flagPostRenewHook = flag.String("postRenewHook", "", "shell command run after a certificate is renewed, e.g. to reload a web server")
+	flagOnErrorHook   = flag.String("onErrorHook", "", "shell command run when provisioning fails; the error is in LOCALCERT_ERROR")
+)
+
+// runHook runs command with the shell, passing the certificate details in
+// LOCALCERT_* environment variables.
+func runHook(config *Config, name, command, domain string, extraEnv ...string) error {
+	if command == "" {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	paths := config.outputPaths()
+	cmd.Env = append(os.Environ(),
+		"LOCALCERT_HOOK="+name,
+		"LOCALCERT_DOMAIN="+domain,
+		"LOCALCERT_CERT_PATH="+paths.FullChain,
+		"LOCALCERT_KEY_PATH="+paths.Key,
+	)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
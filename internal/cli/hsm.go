@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/hsm"
+)
+
+var flagPKCS11URI = flag.String("pkcs11Uri", "", "PKCS #11 URI of a key on an HSM or token to use for the certificate instead of -localKey; generated there if missing")
+
+// hsmSigners keeps opened tokens across config reloads, since a token
+// session can only be opened once.
+var hsmSigners = map[string]crypto.Signer{}
+
+// hsmSigner returns the -pkcs11Uri key, or nil if it isn't set.
+func hsmSigner(keyType localcert.KeyType) (crypto.Signer, error) {
+	uri := *flagPKCS11URI
+	if uri == "" {
+		return nil, nil
+	}
+	// These all need the key itself
+	for _, fl := range []struct {
+		name string
+		set  bool
+	}{
+		{"exportFormats", exportsNeedKey(*flagExportFormats)},
+		{"kubeSecret", *flagKubeSecret != ""},
+		{"stdout", *flagStdout != ""},
+		{"certStore", *flagCertStore},
+		{"bundleIncludeKey", *flagBundleIncludeKey},
+		{"combinedFile", *flagCombinedFile != ""},
+		{"deploy", *flagDeploy != ""},
+		{"encryptKeys", *flagEncryptKeys},
+		{"localKey", *flagKeyFile != ""},
+	} {
+		if fl.set {
+			return nil, fmt.Errorf("-pkcs11Uri can't be combined with -%s; the key never leaves the token", fl.name)
+		}
+	}
+
+	if signer, ok := hsmSigners[uri]; ok {
+		return signer, nil
+	}
+	signer, err := hsm.Open(uri, keyType)
+	if err != nil {
+		return nil, err
+	}
+	hsmSigners[uri] = signer
+	return signer, nil
+}
@@ -0,0 +1,349 @@
+package cli
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagImportFrom = flag.String("from", "", "with import, the ACME client to take over the account, certificate and key of: certbot, acme.sh or lego")
+
+// importedSetup is an ACME account and certificate of another client.
+type importedSetup struct {
+	DirectoryURL  string
+	AccountURL    string
+	AccountKey    crypto.Signer
+	AcceptedTerms string
+	Key           crypto.Signer
+	Chain         [][]byte
+}
+
+// importers read another client's setup from where it keeps a
+// certificate.
+var importers = map[string]struct {
+	usage string
+	read  func(path string) (*importedSetup, error)
+}{
+	"certbot": {"<live dir, e.g. /etc/letsencrypt/live/example.com>", importCertbot},
+	"acme.sh": {"<domain dir, e.g. ~/.acme.sh/example.com>", importAcmeSh},
+	"lego":    {"<certificate, e.g. ~/.lego/certificates/example.com.crt>", importLego},
+}
+
+// Import takes over the ACME account, certificate and key of another ACME
+// client, so that localcert renews them without registering again.
+func Import() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	importer, ok := importers[*flagImportFrom]
+	path := flag.Arg(1)
+	if !ok || path == "" {
+		fatal("Usage: localcert import -from certbot " + importers["certbot"].usage + "\n" +
+			"       localcert import -from acme.sh " + importers["acme.sh"].usage + "\n" +
+			"       localcert import -from lego " + importers["lego"].usage)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	if config.ACME.PrivateKey.KeyID != "" {
+		fatalf("%s already holds ACME account %s; import into another -dataDir or -profile", config.ACMEAccountFile, config.ACME.PrivateKey.KeyID)
+	}
+	setup, err := importer.read(path)
+	if err != nil {
+		fatalf("Error importing from %s: %v", *flagImportFrom, err)
+	}
+	if dirURL, err := resolveACMEDirectoryURL(); err == nil && dirURL != "" && dirURL != setup.DirectoryURL {
+		fatalf("The %s account is with %s, not -acmeUrl %s", *flagImportFrom, setup.DirectoryURL, dirURL)
+	}
+
+	config.acmeKey = setup.AccountKey
+	config.ACME = &ACMEAccount{
+		DirectoryURL:  setup.DirectoryURL,
+		PrivateKey:    &jose.JSONWebKey{Key: setup.AccountKey, KeyID: setup.AccountURL},
+		AcceptedTerms: setup.AcceptedTerms,
+	}
+	if err := config.WriteACMEAccountFile(); err != nil {
+		fatal("Error writing ACME account: ", err)
+	}
+	infof("Imported ACME account %s", setup.AccountURL)
+
+	result, err := config.Manager().ImportKeyPair(setup.Key, setup.Chain)
+	if err != nil {
+		fatal("Error importing certificate: ", err)
+	}
+	if err := config.WriteDomainFile(result.Domain); err != nil {
+		warnf("%v", err)
+	}
+	if err := postIssuance(config, result); err != nil {
+		fatal("Error: ", err)
+	}
+	printCertInfo(config, result.Certificate)
+	if config.Domain == "" && config.CSR == nil {
+		infof("To renew it, set -domain %s, and how to solve its challenges (such as -dnsProvider or -challenge), in the config file", result.Domain)
+	}
+	infof("Stop %s from renewing it too, such as by removing its cron job or timer", *flagImportFrom)
+	printResult(newCertResult(config, result))
+}
+
+// importCertbot reads a certbot lineage from its live directory, along
+// with the account its renewal config names.
+func importCertbot(liveDir string) (*importedSetup, error) {
+	liveDir = filepath.Clean(liveDir)
+	root := filepath.Dir(filepath.Dir(liveDir))
+	renewalConf := filepath.Join(root, "renewal", filepath.Base(liveDir)+".conf")
+	conf, err := readKeyValueFile(renewalConf)
+	if err != nil {
+		return nil, err
+	}
+	if conf["server"] == "" || conf["account"] == "" {
+		return nil, fmt.Errorf("%s names no server or account", renewalConf)
+	}
+	server, err := url.Parse(conf["server"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: server: %w", renewalConf, err)
+	}
+	accountDir := filepath.Join(root, "accounts", server.Host, filepath.FromSlash(strings.TrimPrefix(server.Path, "/")), conf["account"])
+
+	setup := &importedSetup{DirectoryURL: conf["server"]}
+	keyBytes, err := os.ReadFile(filepath.Join(accountDir, "private_key.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(keyBytes, &jwk); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filepath.Join(accountDir, "private_key.json"), err)
+	}
+	var ok bool
+	if setup.AccountKey, ok = jwk.Key.(crypto.Signer); !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", filepath.Join(accountDir, "private_key.json"), jwk.Key)
+	}
+	var regr struct {
+		URI            string `json:"uri"`
+		TermsOfService string `json:"terms_of_service"`
+	}
+	if err := readJSONFile(filepath.Join(accountDir, "regr.json"), &regr); err != nil {
+		return nil, err
+	}
+	setup.AccountURL, setup.AcceptedTerms = regr.URI, regr.TermsOfService
+
+	if setup.Key, err = readImportKey(filepath.Join(liveDir, "privkey.pem")); err != nil {
+		return nil, err
+	}
+	if setup.Chain, err = readImportChain(filepath.Join(liveDir, "fullchain.pem")); err != nil {
+		return nil, err
+	}
+	return setup, nil
+}
+
+// importAcmeSh reads an acme.sh domain directory, along with the account
+// for its CA.
+func importAcmeSh(domainDir string) (*importedSetup, error) {
+	domainDir = filepath.Clean(domainDir)
+	domain := strings.TrimSuffix(filepath.Base(domainDir), "_ecc")
+	domainConf := filepath.Join(domainDir, domain+".conf")
+	conf, err := readKeyValueFile(domainConf)
+	if err != nil {
+		return nil, err
+	}
+	if conf["Le_API"] == "" {
+		return nil, fmt.Errorf("%s names no CA (Le_API)", domainConf)
+	}
+	server, err := url.Parse(conf["Le_API"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: Le_API: %w", domainConf, err)
+	}
+	// acme.sh leaves the port out of the CA's directory
+	caDir := filepath.Join(filepath.Dir(domainDir), "ca", server.Hostname(), filepath.FromSlash(strings.TrimPrefix(server.Path, "/")))
+	caConf, err := readKeyValueFile(filepath.Join(caDir, "ca.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if caConf["ACCOUNT_URL"] == "" {
+		return nil, fmt.Errorf("%s names no ACCOUNT_URL", filepath.Join(caDir, "ca.conf"))
+	}
+
+	setup := &importedSetup{DirectoryURL: conf["Le_API"], AccountURL: caConf["ACCOUNT_URL"]}
+	if setup.AccountKey, err = readImportKey(filepath.Join(caDir, "account.key")); err != nil {
+		return nil, err
+	}
+	if setup.Key, err = readImportKey(filepath.Join(domainDir, domain+".key")); err != nil {
+		return nil, err
+	}
+	if setup.Chain, err = readImportChain(filepath.Join(domainDir, "fullchain.cer")); err != nil {
+		return nil, err
+	}
+	return setup, nil
+}
+
+// importLego reads a lego certificate, along with the account for the CA
+// it came from. lego doesn't record the CA's directory, so it is found by
+// the certificate URL's host among the known ones, unless -acmeUrl is set.
+func importLego(certFile string) (*importedSetup, error) {
+	certFile = filepath.Clean(certFile)
+	base := strings.TrimSuffix(certFile, ".crt")
+	root := filepath.Dir(filepath.Dir(certFile))
+
+	var resource struct {
+		CertURL string `json:"certUrl"`
+	}
+	if err := readJSONFile(base+".json", &resource); err != nil {
+		return nil, err
+	}
+	certURL, err := url.Parse(resource.CertURL)
+	if err != nil || certURL.Host == "" {
+		return nil, fmt.Errorf("%s: invalid certUrl %q", base+".json", resource.CertURL)
+	}
+	dirURL, err := resolveACMEDirectoryURL()
+	if err != nil {
+		return nil, err
+	}
+	if dirURL == "" {
+		if dirURL = knownDirectoryURL(certURL.Host); dirURL == "" {
+			return nil, fmt.Errorf("the certificate is from %s; pass its ACME directory URL with -acmeUrl", certURL.Host)
+		}
+	}
+
+	hostDir := filepath.Join(root, "accounts", strings.ReplaceAll(certURL.Host, ":", "_"))
+	emails, err := subdirs(hostDir)
+	if err != nil {
+		return nil, err
+	}
+	switch len(emails) {
+	case 0:
+		return nil, fmt.Errorf("no accounts in %s", hostDir)
+	case 1:
+	default:
+		return nil, fmt.Errorf("%s holds several accounts (%s); move the others away to import one", hostDir, strings.Join(emails, ", "))
+	}
+	accountDir := filepath.Join(hostDir, emails[0])
+	var account struct {
+		Registration struct {
+			URI string `json:"uri"`
+		} `json:"registration"`
+	}
+	if err := readJSONFile(filepath.Join(accountDir, "account.json"), &account); err != nil {
+		return nil, err
+	}
+
+	setup := &importedSetup{DirectoryURL: dirURL, AccountURL: account.Registration.URI}
+	if setup.AccountKey, err = readImportKey(filepath.Join(accountDir, "keys", emails[0]+".key")); err != nil {
+		return nil, err
+	}
+	if setup.Key, err = readImportKey(base + ".key"); err != nil {
+		return nil, err
+	}
+	if setup.Chain, err = readImportChain(certFile); err != nil {
+		return nil, err
+	}
+	// Without --bundle, the issuer is kept apart
+	if len(setup.Chain) == 1 {
+		if issuer, err := pemutil.ReadPEMChainFile(base+".issuer.crt", pemutil.CertificateType); err == nil {
+			setup.Chain = append(setup.Chain, issuer...)
+		}
+	}
+	return setup, nil
+}
+
+// knownDirectoryURL returns the ACME directory of a well-known CA by its
+// host.
+func knownDirectoryURL(host string) string {
+	dirURLs := []string{defaultACMEDirectoryURL, acme.LetsEncryptURL}
+	for production, staging := range stagingDirectoryURLs {
+		dirURLs = append(dirURLs, production, staging)
+	}
+	for _, dirURL := range dirURLs {
+		if u, err := url.Parse(dirURL); err == nil && u.Host == host {
+			return dirURL
+		}
+	}
+	return ""
+}
+
+func readImportKey(name string) (crypto.Signer, error) {
+	block, err := pemutil.ReadPEMBlockFile(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := localcert.ParsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return key, nil
+}
+
+func readImportChain(name string) ([][]byte, error) {
+	chain, err := pemutil.ReadPEMChainFile(name, pemutil.CertificateType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return chain, nil
+}
+
+func readJSONFile(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// readKeyValueFile reads the key = value lines of a certbot renewal config
+// or an acme.sh KEY='value' config, ignoring sections and comments.
+func readKeyValueFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, "=")
+		if i < 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(line[:i])] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return values, nil
+}
+
+func subdirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wildone/localcert"
+)
+
+// Init walks through first-time setup: it asks where to keep files, which
+// key type to use and which CA to use, writes them to the config file and
+// registers the ACME account.
+func Init() {
+	flag.Parse()
+	initOutput()
+	if !interactive() {
+		fatal("init asks questions, so it must be run in a terminal without -nonInteractive")
+	}
+	in := bufio.NewReader(os.Stdin)
+
+	dir, err := defaultDataDir()
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	defaultName := findConfigFile(dir)
+	if defaultName == "" {
+		defaultName = filepath.Join(dir, "config.json")
+	}
+	name := *flagConfigFile
+	if name == "" {
+		name = defaultName
+	}
+	file := map[string]interface{}{}
+	if fileBytes, err := os.ReadFile(name); err == nil {
+		if file, err = decodeConfig(name, fileBytes); err != nil {
+			fatalf("Error decoding %q: %v", name, err)
+		}
+		version, err := configFileVersion(file)
+		if err == nil {
+			_, err = migrateConfig(file, version)
+		}
+		if err != nil {
+			fatalf("Error in %q: %v", name, err)
+		}
+		if *flagProfile == "" && !askYesNo(in, fmt.Sprintf("%s already exists. Replace it?", name), false) {
+			os.Exit(1)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fatal("Error: ", err)
+	}
+
+	if *flagProfile != "" {
+		fmt.Printf("Setting up profile %q in %s.\n\n", *flagProfile, name)
+	} else {
+		fmt.Printf("Setting up localcert in %s.\n\n", name)
+	}
+	settings := map[string]interface{}{}
+
+	baseDir := *flagDataDir
+	if baseDir == "" {
+		if baseDir, err = defaultDataDir(); err != nil {
+			fatal("Error: ", err)
+		}
+	}
+	defaultDir, err := layoutDataDir(baseDir, *flagProfile)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	dataDir := ask(in, "Directory for the ACME account, key and certificate", defaultDir)
+	if dataDir != defaultDir || *flagDataDir != "" {
+		settings["dataDir"] = dataDir
+	}
+	certFile := ask(in, "Certificate file", filepath.Join(dataDir, "cert.pem"))
+	if certFile != filepath.Join(dataDir, "cert.pem") {
+		settings["localCert"] = certFile
+	}
+	keyFile := ask(in, "Certificate key file", filepath.Join(dataDir, "privkey.pem"))
+	if keyFile != filepath.Join(dataDir, "privkey.pem") {
+		settings["localKey"] = keyFile
+	}
+
+	for {
+		answer := ask(in, "Key type (rsa2048, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519)", *flagKeyType)
+		keyType, err := localcert.ParseKeyType(answer)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if keyType != localcert.DefaultKeyType {
+			settings["keyType"] = string(keyType)
+		}
+		break
+	}
+
+	defaultACMEURL := *flagACMEDirectoryURL
+	if defaultACMEURL == "" {
+		defaultACMEURL = defaultACMEDirectoryURL
+	}
+	if acmeURL := ask(in, "ACME directory URL of the CA", defaultACMEURL); acmeURL != defaultACMEDirectoryURL {
+		settings["acmeUrl"] = acmeURL
+	}
+
+	if *flagProfile != "" {
+		profiles, _ := file["profiles"].(map[string]interface{})
+		if profiles == nil {
+			profiles = map[string]interface{}{}
+		}
+		profiles[*flagProfile] = settings
+		file["profiles"] = profiles
+	} else {
+		file = settings
+	}
+	file["version"] = configVersion
+	fileBytes, err := encodeConfig(name, file)
+	if err != nil {
+		fatal("Error encoding config file: ", err)
+	}
+	for _, dir := range []string{filepath.Dir(name), dataDir} {
+		if err := os.MkdirAll(dir, filePerm); err != nil {
+			fatal("Error: ", err)
+		}
+	}
+	if err := writeFileAtomic(name, fileBytes, 0600); err != nil {
+		fatal("Error writing config file: ", err)
+	}
+	fmt.Printf("\nWrote %s.\n", name)
+
+	// Register with the settings just written
+	flag.Set("config", name)
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+	ctx, stop := interruptContext()
+	defer stop()
+	if err := config.Manager().Register(ctx); err != nil {
+		fatal("Error registering ACME account: ", err)
+	}
+	infof("Registered ACME account %s", config.ACME.PrivateKey.KeyID)
+
+	cmd := "localcert"
+	if name != defaultName {
+		cmd += " -config " + name
+	}
+	if *flagProfile != "" {
+		cmd += " -profile " + *flagProfile
+	}
+	fmt.Printf("\nSetup is done. Run `%s` to issue your first certificate.\n", cmd)
+}
+
+// ask prints question and returns the answer, or def if it's left empty.
+func ask(in *bufio.Reader, question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, err := in.ReadString('\n')
+	if err != nil {
+		fatal("Error reading answer: ", err)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def
+	}
+	return answer
+}
+
+func askYesNo(in *bufio.Reader, question string, def bool) bool {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		switch strings.ToLower(ask(in, question+" ("+choices+")", "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	flagKeyRotation          = flag.String("keyRotation", keyRotationNever, "when renewing, generate a new certificate key: always, yearly, or never (only when -keyType changes)")
+	flagPreviousKeyRetention = flag.Duration("previousKeyRetention", 7*24*time.Hour, "how long to keep a replaced certificate key as <localKey>.previous, for clients that pin it to roll over (0 to not keep it)")
+)
+
+const (
+	keyRotationNever  = "never"
+	keyRotationAlways = "always"
+	keyRotationYearly = "yearly"
+
+	yearlyKeyAge = 365 * 24 * time.Hour
+)
+
+func parseKeyRotation(s string) (string, error) {
+	switch s {
+	case keyRotationNever, keyRotationAlways, keyRotationYearly:
+		return s, nil
+	}
+	return "", fmt.Errorf("-keyRotation %q: must be always, yearly or never", s)
+}
+
+// publicKeyID identifies a key in the issuance history by the SHA-256 of
+// its SubjectPublicKeyInfo.
+func publicKeyID(pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyIssuedSince returns when the first of the latest run of certificates
+// for the key with keyID was issued, or the zero time if the history has
+// none.
+func keyIssuedSince(history []IssuanceRecord, keyID string) time.Time {
+	var since time.Time
+	for i := len(history) - 1; i >= 0 && history[i].KeyID == keyID; i-- {
+		since = history[i].IssuedAt
+	}
+	return since
+}
+
+// keyRotationDue reports whether -keyRotation calls for a new key on the
+// renewal of cert.
+func keyRotationDue(config *Config, cert *x509.Certificate) (bool, error) {
+	if config.CSR != nil || config.signer != nil || cert == nil {
+		return false, nil
+	}
+	switch config.KeyRotation {
+	case keyRotationAlways:
+		return true, nil
+	case keyRotationYearly:
+		history, err := config.ReadHistory()
+		if err != nil {
+			return false, fmt.Errorf("reading issuance history: %w", err)
+		}
+		since := keyIssuedSince(history, publicKeyID(cert.PublicKey))
+		return !since.IsZero() && time.Since(since) >= yearlyKeyAge, nil
+	}
+	return false, nil
+}
+
+// prunePreviousKey deletes the replaced certificate key once
+// -previousKeyRetention has passed since the first certificate for the
+// current key.
+func prunePreviousKey(config *Config, cert *x509.Certificate) error {
+	if config.PreviousKeyFile == "" || cert == nil {
+		return nil
+	}
+	if _, err := config.store.ReadFile(config.PreviousKeyFile); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read %q: %w", config.PreviousKeyFile, err)
+	}
+	history, err := config.ReadHistory()
+	if err != nil {
+		return fmt.Errorf("reading issuance history: %w", err)
+	}
+	since := keyIssuedSince(history, publicKeyID(cert.PublicKey))
+	if since.IsZero() || time.Since(since) < config.PreviousKeyRetention {
+		return nil
+	}
+	if err := config.store.Remove(config.PreviousKeyFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove %q: %w", config.PreviousKeyFile, err)
+	}
+	infof("Removed the previous certificate key %s", config.PreviousKeyFile)
+	return nil
+}
+
+// previousKeyFile is where the Manager keeps a replaced key; nowhere if
+// -previousKeyRetention is 0.
+func (c *Config) previousKeyFile() string {
+	if c.PreviousKeyRetention <= 0 {
+		return ""
+	}
+	return c.PreviousKeyFile
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/wildone/localcert/internal/kube"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagKubeSecret  = flag.String("kubeSecret", "", "[namespace/]name of a Kubernetes TLS Secret to write the certificate and key to")
+	flagKubeconfig  = flag.String("kubeconfig", "", "kubeconfig file for -kubeSecret (default in-cluster service account, $KUBECONFIG or ~/.kube/config)")
+	flagKubeContext = flag.String("kubeContext", "", "kubeconfig context for -kubeSecret (default the current context)")
+)
+
+// parseKubeSecret splits a -kubeSecret value into its namespace, which may be
+// empty, and name.
+func parseKubeSecret(s string) (namespace, name string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return "", s
+}
+
+// writeKubeSecret writes the certificate chain and key to the -kubeSecret
+// Secret, if one is configured.
+func writeKubeSecret(ctx context.Context, config *Config, certChain [][]byte) error {
+	if config.KubeSecretName == "" {
+		return nil
+	}
+	client, err := kube.NewClient(*flagKubeconfig, *flagKubeContext)
+	if err != nil {
+		return fmt.Errorf("kubernetes client: %w", err)
+	}
+	keyPEM, err := config.keyPEM()
+	if err != nil {
+		return err
+	}
+	certPEM := pemutil.EncodePEMChain(pemutil.CertificateType, certChain)
+	if err := client.ApplyTLSSecret(ctx, config.KubeSecretNamespace, config.KubeSecretName, certPEM, keyPEM); err != nil {
+		return fmt.Errorf("writing Kubernetes Secret %q: %w", *flagKubeSecret, err)
+	}
+	infof("Certificate (Kubernetes Secret): %s", *flagKubeSecret)
+	return nil
+}
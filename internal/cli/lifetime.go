@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var flagLifetimeTolerance = flag.Duration("lifetimeTolerance", 24*time.Hour, "warn when a new certificate's lifetime differs from the previous one by more than this")
+
+type LifetimeChangeEvent struct {
+	Event       string    `json:"event"`
+	Domain      string    `json:"domain"`
+	OldLifetime string    `json:"oldLifetime"`
+	NewLifetime string    `json:"newLifetime"`
+	NextRenewal time.Time `json:"nextRenewal"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// previousLifetime returns the lifetime of the last issued certificate,
+// preferring the issuance history over the certificate being replaced.
+func previousLifetime(config *Config, prev *x509.Certificate) time.Duration {
+	last, err := config.LastIssuance()
+	if err != nil {
+		errorf("Error reading issuance history: %v", err)
+	} else if last != nil && last.Lifetime() > 0 {
+		return last.Lifetime()
+	}
+	if prev != nil {
+		return prev.NotAfter.Sub(prev.NotBefore)
+	}
+	return 0
+}
+
+// checkLifetimeChange warns when cert's lifetime differs from
+// prevLifetime by more than -lifetimeTolerance, returning the change, or
+// nil if there is none.
+func checkLifetimeChange(config *Config, prevLifetime time.Duration, cert *x509.Certificate) *LifetimeChangeEvent {
+	newLifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if prevLifetime == 0 {
+		return nil
+	}
+	diff := newLifetime - prevLifetime
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= *flagLifetimeTolerance {
+		return nil
+	}
+
+	next := config.Renewal.RenewalTime(cert)
+	event := &LifetimeChangeEvent{
+		Event:       "lifetimeChanged",
+		Domain:      cert.Subject.CommonName,
+		OldLifetime: prevLifetime.String(),
+		NewLifetime: newLifetime.String(),
+		NextRenewal: next,
+		Timestamp:   time.Now().UTC(),
+	}
+	logEvent(event)
+
+	change := "shortened"
+	if newLifetime > prevLifetime {
+		change = "lengthened"
+	}
+	warnf("The CA has %s the certificate lifetime!\n\n  Previous lifetime: %s\n  New lifetime:      %s\n\nRun localcert again after %s to renew this certificate.",
+		change, formatDays(prevLifetime), formatDays(newLifetime), next.Format(time.RFC3339))
+	return event
+}
+
+func formatDays(d time.Duration) string {
+	return fmt.Sprintf("%.1f days", d.Hours()/24)
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/wildone/localcert"
+)
+
+func TestCheckLifetimeChange(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name         string
+		prevLifetime time.Duration
+		newLifetime  time.Duration
+		changed      bool
+	}{
+		{"shortened", 90 * day, 45 * day, true},
+		{"lengthened", 45 * day, 90 * day, true},
+		{"within tolerance", 90 * day, 90*day + 12*time.Hour, false},
+		{"no previous certificate", 0, 90 * day, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := testChain(t, "example.localcert.dev", test.newLifetime)
+			cert, err := x509.ParseCertificate(chain[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &Config{ACME: &ACMEAccount{PrivateKey: &jose.JSONWebKey{}}}
+
+			change := checkLifetimeChange(config, test.prevLifetime, cert)
+			if !test.changed {
+				if change != nil {
+					t.Errorf("checkLifetimeChange = %+v, want no change", change)
+				}
+				return
+			}
+			if change == nil {
+				t.Fatal("checkLifetimeChange found no change")
+			}
+			if change.OldLifetime != test.prevLifetime.String() || change.NewLifetime != test.newLifetime.String() {
+				t.Errorf("lifetimes = %s and %s, want %s and %s", change.OldLifetime, change.NewLifetime, test.prevLifetime, test.newLifetime)
+			}
+			if want := config.Renewal.RenewalTime(cert); !change.NextRenewal.Equal(want) {
+				t.Errorf("next renewal = %s, want %s", change.NextRenewal, want)
+			}
+
+			// The change reaches the -json result and the renewed event
+			config.lifetimeChange = change
+			data, err := json.Marshal(newCertResult(config, &localcert.Result{Domain: cert.Subject.CommonName, Certificate: cert, Renewed: true}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result struct {
+				LifetimeChange struct {
+					OldLifetime string `json:"oldLifetime"`
+					NewLifetime string `json:"newLifetime"`
+				} `json:"lifetimeChange"`
+			}
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.LifetimeChange.OldLifetime != change.OldLifetime || result.LifetimeChange.NewLifetime != change.NewLifetime {
+				t.Errorf("-json result %s doesn't have the lifetime change", data)
+			}
+
+			n := NotificationEvent{Event: notifyRenewed, Domain: cert.Subject.CommonName, NotAfter: &cert.NotAfter, OldLifetime: change.OldLifetime, NewLifetime: change.NewLifetime}
+			want := "from " + formatDays(test.prevLifetime) + " to " + formatDays(test.newLifetime)
+			if msg := n.Message(); !strings.Contains(msg, want) {
+				t.Errorf("renewed message %q doesn't say %q", msg, want)
+			}
+		})
+	}
+}
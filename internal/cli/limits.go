@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/wildone/localcert"
+)
+
+var flagOverrideRateLimits = flag.Bool("overrideRateLimits", false, "issue even if the issuance history shows that it would exceed one of the CA's rate limits")
+
+// rateLimit is a limit on issuance that a CA is known to enforce. The local
+// issuance history only holds this profile's certificates, so its counts
+// are a lower bound.
+type rateLimit struct {
+	name   string
+	max    int
+	window time.Duration
+	// keys returns what an issuance of names by account counts towards.
+	keys func(names []string, account string) []string
+}
+
+// knownRateLimits are the CAs' rate limits, by ACME directory URL.
+var knownRateLimits = map[string][]rateLimit{
+	acme.LetsEncryptURL: {
+		{name: "certificatesPerRegisteredDomain", max: 50, window: 7 * 24 * time.Hour, keys: registeredDomains},
+		{name: "duplicateCertificates", max: 5, window: 7 * 24 * time.Hour, keys: nameSet},
+		{name: "newOrdersPerAccount", max: 300, window: 3 * time.Hour, keys: accountKey},
+	},
+}
+
+// registeredDomains approximates the registered domains of names by their
+// last two labels.
+func registeredDomains(names []string, _ string) []string {
+	var domains []string
+	for _, name := range names {
+		labels := strings.Split(strings.ToLower(strings.TrimPrefix(name, "*.")), ".")
+		if len(labels) > 2 {
+			labels = labels[len(labels)-2:]
+		}
+		if domain := strings.Join(labels, "."); !containsString(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func nameSet(names []string, _ string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := make([]string, len(names))
+	for i, name := range names {
+		sorted[i] = strings.ToLower(name)
+	}
+	sort.Strings(sorted)
+	return []string{strings.Join(sorted, ",")}
+}
+
+func accountKey(_ []string, account string) []string {
+	if account == "" {
+		return nil
+	}
+	return []string{account}
+}
+
+// rateLimitStanding is how much of a rate limit the history has used.
+type rateLimitStanding struct {
+	Limit  string `json:"limit"`
+	Key    string `json:"key"`
+	Count  int    `json:"count"`
+	Max    int    `json:"max"`
+	Window string `json:"window"`
+	// NextFree is when the oldest issuance counted leaves the window.
+	NextFree *time.Time `json:"nextFree,omitempty"`
+}
+
+func (s rateLimitStanding) String() string {
+	msg := fmt.Sprintf("%d of %d %s for %s in the last %s", s.Count, s.Max, s.Limit, s.Key, s.Window)
+	if s.NextFree != nil {
+		msg += fmt.Sprintf("; the next frees up at %s", s.NextFree.Format(time.RFC3339))
+	}
+	return msg
+}
+
+// RateLimitError is an issuance refused because the history shows that the
+// CA would reject it for exceeding a rate limit.
+type RateLimitError struct {
+	Standing rateLimitStanding
+}
+
+func (e RateLimitError) Error() string {
+	return "rate limit reached: " + e.Standing.String()
+}
+
+// RetryAt returns when the issuance would be within the limit.
+func (e RateLimitError) RetryAt() time.Time {
+	if e.Standing.NextFree == nil {
+		return time.Time{}
+	}
+	return *e.Standing.NextFree
+}
+
+// rateLimitStandings counts the issuances in the history towards each of
+// the CA's known rate limits that an issuance for names would count
+// towards.
+func rateLimitStandings(config *Config, names []string) ([]rateLimitStanding, error) {
+	limits := knownRateLimits[acmeDirectoryURL(config)]
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	history, err := config.ReadHistory()
+	if err != nil {
+		return nil, fmt.Errorf("reading issuance history: %w", err)
+	}
+	account := config.ACME.PrivateKey.KeyID
+	now := time.Now()
+	var standings []rateLimitStanding
+	for _, limit := range limits {
+		for _, key := range limit.keys(names, account) {
+			standing := rateLimitStanding{Limit: limit.name, Key: key, Max: limit.max, Window: formatWindow(limit.window)}
+			for _, record := range history {
+				if now.Sub(record.IssuedAt) >= limit.window || !containsString(limit.keys(record.names(), record.Account), key) {
+					continue
+				}
+				standing.Count++
+				if nextFree := record.IssuedAt.Add(limit.window); standing.NextFree == nil || nextFree.Before(*standing.NextFree) {
+					standing.NextFree = &nextFree
+				}
+			}
+			standings = append(standings, standing)
+		}
+	}
+	return standings, nil
+}
+
+// checkRateLimits refuses an issuance for names that the history shows
+// would exceed a rate limit, unless -overrideRateLimits is set, and warns
+// about one that would use up most of a limit.
+func checkRateLimits(config *Config, names []string) error {
+	standings, err := rateLimitStandings(config, names)
+	if err != nil {
+		return err
+	}
+	for _, standing := range standings {
+		switch {
+		case standing.Count >= standing.Max && !*flagOverrideRateLimits:
+			return RateLimitError{Standing: standing}
+		case standing.Count >= standing.Max:
+			warnf("Issuing despite the rate limit: %s", standing)
+		case (standing.Count+1)*5 > standing.Max*4:
+			warnf("Issuing leaves %d of %d %s for %s in the last %s", standing.Max-standing.Count-1, standing.Max, standing.Limit, standing.Key, standing.Window)
+		}
+	}
+	return nil
+}
+
+// issuanceNames returns the names the next certificate will be issued for,
+// as far as they are known before ordering it.
+func issuanceNames(config *Config, manager *localcert.Manager, cert *x509.Certificate) []string {
+	if config.CSR != nil {
+		if csr, err := x509.ParseCertificateRequest(config.CSR); err == nil {
+			return localcert.CSRNames(csr)
+		}
+		return nil
+	}
+	if config.Domain != "" {
+		return manager.Names(config.Domain)
+	}
+	if cert != nil {
+		return manager.Names(cert.Subject.CommonName)
+	}
+	return nil
+}
+
+func acmeDirectoryURL(config *Config) string {
+	if config.ACME.DirectoryURL == "" {
+		return defaultACMEDirectoryURL
+	}
+	return config.ACME.DirectoryURL
+}
+
+func formatWindow(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return fmt.Sprintf("%d hours", d/time.Hour)
+}
+
+type limitsResult struct {
+	DirectoryURL string              `json:"directoryURL"`
+	Names        []string            `json:"names"`
+	Limits       []rateLimitStanding `json:"limits"`
+}
+
+// Limits shows how much of the CA's known rate limits the issuance history
+// has used, exiting with 1 if any is reached.
+func Limits() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	cert, err := config.ReadCertificate()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal("Error reading certificate: ", err)
+	}
+	result := limitsResult{DirectoryURL: acmeDirectoryURL(config), Names: issuanceNames(config, config.Manager(), cert)}
+	if result.Limits, err = rateLimitStandings(config, result.Names); err != nil {
+		fatal("Error: ", err)
+	}
+
+	if len(knownRateLimits[result.DirectoryURL]) == 0 {
+		fmt.Printf("No rate limits are known for %s\n", result.DirectoryURL)
+	}
+	reached := false
+	for _, standing := range result.Limits {
+		status := "ok"
+		if standing.Count >= standing.Max {
+			status, reached = "reached", true
+		}
+		fmt.Printf("%-8s %s\n", strings.ToUpper(status), standing)
+	}
+	printResult(result)
+	if reached {
+		os.Exit(1)
+	}
+}
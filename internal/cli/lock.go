@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var flagWait = flag.Duration("wait", 0, "how long to wait for another running localcert using the same dataDir to finish, instead of failing straight away")
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// lockDataDir takes an advisory lock on the data dir, so that concurrent
+// runs (cron and a human, say) don't corrupt the account and key files.
+// It waits up to -wait for another process to release it.
+func lockDataDir(config *Config) (unlock func(), err error) {
+	name := filepath.Join(config.DataDir, "localcert.lock")
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(*flagWait)
+	for waiting := false; ; waiting = true {
+		err = tryLock(f)
+		if !errors.Is(err, errLocked) || !time.Now().Before(deadline) {
+			break
+		}
+		if !waiting {
+			infof("Waiting for another localcert instance to finish...")
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	if errors.Is(err, errLocked) {
+		pid, _ := os.ReadFile(name)
+		f.Close()
+		if pid := strings.TrimSpace(string(pid)); pid != "" {
+			return nil, fmt.Errorf("another localcert instance (pid %s) is running with dataDir %q; pass -wait to wait for it", pid, config.DataDir)
+		}
+		return nil, fmt.Errorf("another localcert instance is running with dataDir %q; pass -wait to wait for it", config.DataDir)
+	} else if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock %q: %w", name, err)
+	}
+
+	debugf("Locked %s", name)
+
+	// Record who holds the lock for the error above
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		f.Truncate(0)
+		f.Close()
+	}, nil
+}
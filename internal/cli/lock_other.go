@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package cli
+
+import "os"
+
+// tryLock isn't implemented here, so concurrent runs aren't prevented.
+func tryLock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	flagQuiet     = flag.Bool("quiet", false, "only print warnings and errors")
+	flagVerbose   = flag.Bool("verbose", false, "also print debug messages, such as why a certificate is or isn't renewed")
+	flagDebug     = flag.Bool("debug", false, "like -verbose, and also log every ACME and localcert server request and response, with keys redacted")
+	flagLogFormat = flag.String("logFormat", "text", "log message format: text, or json for one object per line")
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "debug"
+	case levelInfo:
+		return "info"
+	case levelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+var logger = struct {
+	sync.Mutex
+	level logLevel
+	json  bool
+	// timestamps prefixes text messages with the time, for long-running
+	// commands.
+	timestamps bool
+}{level: levelInfo}
+
+// initLogging applies the logging flags.
+func initLogging() error {
+	logger.level = levelInfo
+	switch {
+	case *flagDebug, *flagVerbose:
+		logger.level = levelDebug
+	case *flagQuiet:
+		logger.level = levelWarn
+	}
+	switch *flagLogFormat {
+	case "text":
+	case "json":
+		logger.json = true
+	default:
+		return fmt.Errorf("unknown -logFormat %q", *flagLogFormat)
+	}
+	if *flagQuiet && (*flagVerbose || *flagDebug) {
+		return errors.New("-quiet can't be combined with -verbose or -debug")
+	}
+	return nil
+}
+
+// logf writes a message at level. Debug and info messages go to stdout (or
+// stderr with -json), and warnings and errors to stderr.
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < logger.level {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	var w io.Writer = os.Stdout
+	if level >= levelWarn {
+		w = os.Stderr
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+	if logger.json {
+		json.NewEncoder(w).Encode(struct {
+			Time  time.Time `json:"time"`
+			Level string    `json:"level"`
+			Msg   string    `json:"msg"`
+		}{time.Now(), level.String(), msg})
+		return
+	}
+	switch level {
+	case levelDebug:
+		msg = "debug: " + msg
+	case levelWarn:
+		msg = "Warning: " + msg
+	}
+	if logger.timestamps {
+		msg = time.Now().Format("2006/01/02 15:04:05 ") + msg
+	}
+	fmt.Fprintln(w, msg)
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
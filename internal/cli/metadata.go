@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var flagOutputMetadata = flag.String("outputMetadata", "", "path to write the certificate's metadata to as JSON after each run, for monitoring and config management tools (default <dataDir>/state.json)")
+
+// certState is the metadata file: the current certificate, where its files
+// are, and how the last run went.
+type certState struct {
+	Profile string `json:"profile,omitempty"`
+	*certMetadata
+	Names           []string    `json:"names,omitempty"`
+	IPAddresses     []string    `json:"ipAddresses,omitempty"`
+	Issuer          string      `json:"issuer,omitempty"`
+	FingerprintSHA1 string      `json:"fingerprintSHA1,omitempty"`
+	KeyType         string      `json:"keyType,omitempty"`
+	Files           *stateFiles `json:"files"`
+
+	LastAttempt   time.Time  `json:"lastAttempt"`
+	LastResult    string     `json:"lastResult"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorType string     `json:"lastErrorType,omitempty"`
+	LastRenewal   *time.Time `json:"lastRenewal,omitempty"`
+
+	// DomainChange is set when the last run moved to a domain the
+	// localcert server newly assigned
+	DomainChange *DomainChangeEvent `json:"domainChange,omitempty"`
+}
+
+type stateFiles struct {
+	Certificate string `json:"certificate"`
+	Leaf        string `json:"leaf"`
+	Chain       string `json:"chain"`
+	Combined    string `json:"combined,omitempty"`
+	Key         string `json:"key,omitempty"`
+	OCSP        string `json:"ocsp,omitempty"`
+	Bundle      string `json:"bundle,omitempty"`
+	History     string `json:"history"`
+}
+
+// writeMetadata records the outcome of a provisioning run, err, along with
+// the certificate now in place, in the metadata file. Failing to is only
+// logged, so it never fails the run.
+func writeMetadata(config *Config, result *localcert.Result, err error) {
+	paths := config.outputPaths()
+	state := certState{
+		Profile: config.Profile,
+		Files: &stateFiles{
+			Certificate: paths.FullChain,
+			Leaf:        paths.Leaf,
+			Chain:       paths.Chain,
+			Combined:    paths.Combined,
+			Key:         paths.Key,
+			OCSP:        config.OCSPFile,
+			Bundle:      config.BundleFile,
+			History:     config.HistoryFile,
+		},
+		LastAttempt:  time.Now().UTC(),
+		DomainChange: config.domainChange,
+	}
+	switch {
+	case err != nil:
+		state.LastResult = "failed"
+		state.LastError = err.Error()
+		state.LastErrorType = exitTypes[exitCode(err)]
+	case result.Renewed:
+		state.LastResult = "renewed"
+	default:
+		state.LastResult = "current"
+	}
+
+	var cert *x509.Certificate
+	if result != nil {
+		cert = result.Certificate
+	} else if cert, err = config.ReadCertificate(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("Error reading certificate for %s: %v", config.MetadataFile, err)
+	}
+	if cert != nil {
+		metadata := newCertMetadata(cert)
+		sha1Sum := sha1.Sum(cert.Raw)
+		state.certMetadata = &metadata
+		state.Names = cert.DNSNames
+		for _, ip := range cert.IPAddresses {
+			state.IPAddresses = append(state.IPAddresses, ip.String())
+		}
+		state.Issuer = cert.Issuer.CommonName
+		state.FingerprintSHA1 = hex.EncodeToString(sha1Sum[:])
+		state.KeyType = string(localcert.KeyTypeOf(cert.PublicKey))
+	}
+	if last, err := config.LastIssuance(); err == nil && last != nil {
+		state.LastRenewal = &last.IssuedAt
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		warnf("Error encoding %s: %v", config.MetadataFile, err)
+		return
+	}
+	if err := writeFileAtomic(config.MetadataFile, append(data, '\n'), 0644); err != nil {
+		warnf("Error writing %s: %v", config.MetadataFile, err)
+	}
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var flagMetricsAddr = flag.String("metricsAddr", "", "in daemon mode, serve Prometheus metrics on /metrics at this address, e.g. :9464")
+
+// httpClient is used for ACME and localcert server requests; nil means
+// http.DefaultClient.
+var httpClient *http.Client
+
+// requestMetrics, if set, times the requests of httpClient.
+var requestMetrics *metrics
+
+var requestDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics collects the daemon's state for the Prometheus /metrics endpoint.
+// Its methods do nothing on a nil *metrics, so callers needn't check whether
+// -metricsAddr is set.
+type metrics struct {
+	mu               sync.Mutex
+	config           *Config
+	attempts         map[string]int64
+	lastRenewal      time.Time
+	lastRenewalOK    bool
+	requestDurations map[string]*histogram
+}
+
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newMetrics(config *Config) *metrics {
+	return &metrics{
+		config:           config,
+		attempts:         map[string]int64{"success": 0, "failure": 0},
+		requestDurations: map[string]*histogram{},
+	}
+}
+
+// serveMetrics serves m on addr and returns an HTTP client that records
+// request latencies into it.
+func serveMetrics(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		fatal("Metrics server error: ", http.ListenAndServe(addr, mux))
+	}()
+	infof("Serving metrics on %s/metrics", addr)
+	requestMetrics = m
+}
+
+func (m *metrics) setConfig(config *Config) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config = config
+}
+
+func (m *metrics) recordRenewal(ok bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		m.attempts["success"]++
+	} else {
+		m.attempts["failure"]++
+	}
+	m.lastRenewal = time.Now()
+	m.lastRenewalOK = ok
+}
+
+func (m *metrics) recordRequest(host string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.requestDurations[host]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(requestDurationBuckets))}
+		m.requestDurations[host] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range requestDurationBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cert, err := m.config.ReadCertificate(); err == nil {
+		writeMetricHeader(w, "localcert_cert_not_after_timestamp", "gauge", "Expiry time of the current certificate in seconds since the epoch.")
+		fmt.Fprintf(w, "localcert_cert_not_after_timestamp{domain=%s} %d\n", quoteLabel(cert.Subject.CommonName), cert.NotAfter.Unix())
+	}
+
+	writeMetricHeader(w, "localcert_renewal_attempts_total", "counter", "Renewal attempts by result.")
+	for _, result := range []string{"success", "failure"} {
+		fmt.Fprintf(w, "localcert_renewal_attempts_total{result=%s} %d\n", quoteLabel(result), m.attempts[result])
+	}
+
+	if !m.lastRenewal.IsZero() {
+		ok := 0
+		if m.lastRenewalOK {
+			ok = 1
+		}
+		writeMetricHeader(w, "localcert_last_renewal_success", "gauge", "Whether the last renewal attempt succeeded.")
+		fmt.Fprintf(w, "localcert_last_renewal_success %d\n", ok)
+		writeMetricHeader(w, "localcert_last_renewal_timestamp", "gauge", "Time of the last renewal attempt in seconds since the epoch.")
+		fmt.Fprintf(w, "localcert_last_renewal_timestamp %d\n", m.lastRenewal.Unix())
+	}
+
+	writeMetricHeader(w, "localcert_acme_request_duration_seconds", "histogram", "Latency of ACME and localcert server requests by host.")
+	var hosts []string
+	for host := range m.requestDurations {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		h := m.requestDurations[host]
+		for i, le := range requestDurationBuckets {
+			fmt.Fprintf(w, "localcert_acme_request_duration_seconds_bucket{host=%s,le=\"%g\"} %d\n", quoteLabel(host), le, h.counts[i])
+		}
+		fmt.Fprintf(w, "localcert_acme_request_duration_seconds_bucket{host=%s,le=\"+Inf\"} %d\n", quoteLabel(host), h.count)
+		fmt.Fprintf(w, "localcert_acme_request_duration_seconds_sum{host=%s} %g\n", quoteLabel(host), h.sum)
+		fmt.Fprintf(w, "localcert_acme_request_duration_seconds_count{host=%s} %d\n", quoteLabel(host), h.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+type metricsTransport struct {
+	metrics *metrics
+	next    http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.metrics.recordRequest(req.URL.Host, time.Since(start))
+	return resp, err
+}
@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	flagNotifyWebhook = flag.String("notifyWebhook", "", "URL to POST a JSON event to on renewal, renewal failure or approaching expiry")
+	flagNotifySlack   = flag.String("notifySlack", "", "Slack incoming webhook URL to notify on renewal, renewal failure or approaching expiry")
+	flagNotifyEmail   = flag.String("notifyEmail", "", "comma-separated addresses to email on renewal, renewal failure or approaching expiry")
+	flagNotifyBefore  = flag.Duration("notifyBefore", 7*24*time.Hour, "notify when the certificate expires within this long without being renewed")
+	flagSMTPServer    = flag.String("smtpServer", "", "host:port of the SMTP server for -notifyEmail")
+	flagSMTPFrom      = flag.String("smtpFrom", "", "sender address for -notifyEmail (default localcert@<hostname>)")
+	flagSMTPUser      = flag.String("smtpUser", "", "SMTP username for -notifyEmail; the password is read from LOCALCERT_SMTP_PASSWORD")
+)
+
+const (
+	notifyRenewed       = "renewed"
+	notifyRenewalFailed = "renewalFailed"
+	notifyExpiring      = "expiring"
+	notifyDomainChanged = "domainChanged"
+)
+
+type NotificationEvent struct {
+	Event     string     `json:"event"`
+	Profile   string     `json:"profile,omitempty"`
+	Domain    string     `json:"domain,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+
+	// Failures and FailingSince describe the run of failed renewals of a
+	// renewalStillFailing event
+	Failures     int        `json:"failures,omitempty"`
+	FailingSince *time.Time `json:"failingSince,omitempty"`
+
+	// OldDomain and NewDomain are the domains of a domainChanged event, as
+	// in its DomainChangeEvent
+	OldDomain string `json:"oldDomain,omitempty"`
+	NewDomain string `json:"newDomain,omitempty"`
+
+	// OldLifetime and NewLifetime are set on a renewed event when the CA
+	// changed the certificate lifetime
+	OldLifetime string `json:"oldLifetime,omitempty"`
+	NewLifetime string `json:"newLifetime,omitempty"`
+}
+
+// newNotificationEvent returns an event about the current certificate,
+// if there is one.
+func newNotificationEvent(config *Config, event string) NotificationEvent {
+	n := NotificationEvent{Event: event, Profile: config.Profile, Timestamp: time.Now().UTC()}
+	if cert, err := config.ReadCertificate(); err == nil {
+		n.Domain = cert.Subject.CommonName
+		n.NotAfter = &cert.NotAfter
+	}
+	return n
+}
+
+func (n NotificationEvent) Message() string {
+	var msg string
+	switch n.Event {
+	case notifyRenewed:
+		msg = fmt.Sprintf("Renewed certificate for %s", n.Domain)
+		if n.NotAfter != nil {
+			msg += fmt.Sprintf("; it expires %s", n.NotAfter.Format(time.RFC3339))
+		}
+		if n.NewLifetime != "" {
+			oldLifetime, _ := time.ParseDuration(n.OldLifetime)
+			newLifetime, _ := time.ParseDuration(n.NewLifetime)
+			msg += fmt.Sprintf("; the CA changed the certificate lifetime from %s to %s", formatDays(oldLifetime), formatDays(newLifetime))
+		}
+	case notifyRenewalFailed:
+		if n.Domain == "" {
+			msg = fmt.Sprintf("Provisioning a certificate failed: %s", n.Error)
+		} else {
+			msg = fmt.Sprintf("Renewing certificate for %s failed: %s", n.Domain, n.Error)
+		}
+	case notifyDomainChanged:
+		msg = fmt.Sprintf("The localcert server assigned a new domain, %s, replacing %s; a certificate for it is in place", n.Domain, n.OldDomain)
+	case notifyRenewalStillFailing:
+		msg = fmt.Sprintf("Renewing certificate for %s has failed %s in a row since %s", n.Domain, failedTimes(n.Failures), n.FailingSince.Format(time.RFC3339))
+		if n.NotAfter != nil {
+			if remaining := time.Until(*n.NotAfter); remaining > 0 {
+				msg += fmt.Sprintf(", and it expires in %s", formatDays(remaining))
+			} else {
+				msg += ", and it has expired"
+			}
+		}
+		msg += ": " + n.Error
+	case notifyExpiring:
+		if remaining := time.Until(*n.NotAfter); remaining > 0 {
+			msg = fmt.Sprintf("Certificate for %s expires in %s and hasn't been renewed", n.Domain, formatDays(remaining))
+		} else {
+			msg = fmt.Sprintf("Certificate for %s has expired and hasn't been renewed", n.Domain)
+		}
+	}
+	if n.Profile != "" {
+		msg = fmt.Sprintf("[%s] %s", n.Profile, msg)
+	}
+	return msg
+}
+
+// notify sends n to every configured sink. Failures are logged rather than
+// returned so they never affect provisioning.
+func notify(n NotificationEvent) {
+	if *flagNotifyWebhook != "" {
+		if err := postJSON(*flagNotifyWebhook, n); err != nil {
+			errorf("Webhook notification error: %v", err)
+		}
+	}
+	if *flagNotifySlack != "" {
+		if err := postJSON(*flagNotifySlack, map[string]string{"text": n.Message()}); err != nil {
+			errorf("Slack notification error: %v", err)
+		}
+	}
+	if *flagNotifyEmail != "" {
+		if err := sendEmail(parseList(*flagNotifyEmail), n); err != nil {
+			errorf("Email notification error: %v", err)
+		}
+	}
+}
+
+// notifyIfExpiring sends an expiring notification if the current
+// certificate expires within -notifyBefore.
+func notifyIfExpiring(config *Config) {
+	n := newNotificationEvent(config, notifyExpiring)
+	if n.NotAfter == nil || time.Until(*n.NotAfter) > *flagNotifyBefore {
+		return
+	}
+	notify(n)
+}
+
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(to []string, n NotificationEvent) error {
+	if *flagSMTPServer == "" {
+		return errors.New("-notifyEmail needs -smtpServer")
+	}
+	host, _, err := net.SplitHostPort(*flagSMTPServer)
+	if err != nil {
+		return fmt.Errorf("-smtpServer: %w", err)
+	}
+	from := *flagSMTPFrom
+	if from == "" {
+		hostname, _ := os.Hostname()
+		from = "localcert@" + hostname
+	}
+	var auth smtp.Auth
+	if *flagSMTPUser != "" {
+		auth = smtp.PlainAuth("", *flagSMTPUser, os.Getenv("LOCALCERT_SMTP_PASSWORD"), host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: localcert: %s %s\r\n", n.Event, n.Domain)
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", n.Message())
+	return smtp.SendMail(*flagSMTPServer, auth, from, to, msg.Bytes())
+}
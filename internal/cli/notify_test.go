@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+func TestRenewedMessage(t *testing.T) {
+	notAfter := time.Date(2026, 12, 15, 10, 42, 37, 0, time.UTC)
+	tests := []struct {
+		name string
+		n    NotificationEvent
+		want string
+	}{
+		{
+			"expiry",
+			NotificationEvent{Event: notifyRenewed, Domain: "example.localcert.dev", NotAfter: &notAfter},
+			"Renewed certificate for example.localcert.dev; it expires 2026-12-15T10:42:37Z",
+		},
+		{
+			"unknown expiry",
+			NotificationEvent{Event: notifyRenewed, Domain: "example.localcert.dev"},
+			"Renewed certificate for example.localcert.dev",
+		},
+		{
+			"profile",
+			NotificationEvent{Event: notifyRenewed, Profile: "web", Domain: "example.localcert.dev"},
+			"[web] Renewed certificate for example.localcert.dev",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if msg := test.n.Message(); msg != test.want {
+				t.Errorf("Message() = %q, want %q", msg, test.want)
+			}
+		})
+	}
+}
+
+func TestMessagesWithoutNotAfter(t *testing.T) {
+	for _, event := range []string{notifyRenewed, notifyRenewalFailed, notifyDomainChanged} {
+		n := NotificationEvent{Event: event, Domain: "example.localcert.dev", Error: "failed"}
+		if msg := n.Message(); !strings.Contains(msg, "example.localcert.dev") {
+			t.Errorf("%s message %q doesn't name the domain", event, msg)
+		}
+	}
+}
+
+func TestDomainChangedNotification(t *testing.T) {
+	dir := t.TempDir()
+	chain := testChain(t, "new.localcert.dev", 90*24*time.Hour)
+	config := &Config{CertificateFile: filepath.Join(dir, "cert.pem"), store: localcert.FileStore{}}
+	if err := os.WriteFile(config.CertificateFile, pemutil.EncodePEMChain(pemutil.CertificateType, chain), 0600); err != nil {
+		t.Fatal(err)
+	}
+	event := newDomainChangeEvent("old.localcert.dev", "new.localcert.dev")
+
+	data, err := json.Marshal(newDomainChangedNotification(config, event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload DomainChangeEvent
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload != event {
+		t.Errorf("notification payload %s doesn't carry %+v", data, event)
+	}
+	n := newDomainChangedNotification(config, event)
+	if want := "assigned a new domain, new.localcert.dev, replacing old.localcert.dev"; !strings.Contains(n.Message(), want) {
+		t.Errorf("Message() = %q, want it to contain %q", n.Message(), want)
+	}
+}
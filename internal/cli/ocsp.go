@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var flagOCSPFile = flag.String("ocspFile", "", "path to keep the certificate's OCSP response in for servers to staple, refreshed before it goes stale (not written unless set)")
+
+// ocspRetryInterval is how soon the daemon retries a failed OCSP refresh.
+const ocspRetryInterval = 5 * time.Minute
+
+// updateOCSP refreshes the -ocspFile staple if it is missing, stale or for
+// another certificate. Responders have outages, and servers keep stapling
+// the previous response meanwhile, so failures only warn.
+func updateOCSP(ctx context.Context, config *Config, certChain [][]byte) {
+	if !wantsOCSP(config, certChain) {
+		if config.OCSPFile != "" {
+			warnf("Not writing %s: the certificate names no OCSP responder", config.OCSPFile)
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	staple, written, err := localcert.UpdateOCSPFile(ctx, httpClient, localcert.FileStore{}, config.OCSPFile, certChain)
+	if err != nil {
+		warnf("Error updating OCSP response %s: %v", config.OCSPFile, err)
+		return
+	}
+	if written {
+		infof("OCSP response:        %s (next update %s)", config.OCSPFile, staple.Response.NextUpdate.Format(time.RFC3339))
+	}
+}
+
+// untilOCSPRefresh returns how long until the -ocspFile staple is due for
+// refresh, and false if there is no staple to keep.
+func untilOCSPRefresh(config *Config, certChain [][]byte) (time.Duration, bool) {
+	if !wantsOCSP(config, certChain) {
+		return 0, false
+	}
+	raw, err := localcert.FileStore{}.ReadFile(config.OCSPFile)
+	if err != nil {
+		return ocspRetryInterval, true
+	}
+	staple, err := localcert.ParseOCSP(raw, certChain)
+	if err != nil {
+		return ocspRetryInterval, true
+	}
+	// Past the refresh time, the last refresh failed
+	if wait := time.Until(staple.RefreshTime()); wait > 0 {
+		return wait, true
+	}
+	return ocspRetryInterval, true
+}
+
+// wantsOCSP reports whether -ocspFile is set and the certificate names an
+// OCSP responder to get the staple from.
+func wantsOCSP(config *Config, certChain [][]byte) bool {
+	if config.OCSPFile == "" {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(certChain[0])
+	return err == nil && len(leaf.OCSPServer) > 0
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var flagJSON = flag.Bool("json", false, "print results as JSON on stdout; progress messages go to stderr")
+
+var (
+	// jsonOut receives JSON results and -stdout output. With either flag,
+	// os.Stdout is pointed at stderr so the progress messages printed
+	// everywhere else don't mix with them.
+	jsonOut    io.Writer = os.Stdout
+	outputOnce sync.Once
+)
+
+func initOutput() {
+	outputOnce.Do(func() {
+		if *flagJSON || *flagStdout != "" {
+			jsonOut = os.Stdout
+			os.Stdout = os.Stderr
+		}
+	})
+}
+
+// printResult writes v as a line of JSON if -json is set.
+func printResult(v interface{}) {
+	if !*flagJSON {
+		return
+	}
+	if err := json.NewEncoder(jsonOut).Encode(v); err != nil {
+		errorf("Error encoding result: %v", err)
+	}
+}
+
+type errorResult struct {
+	Profile string `json:"profile,omitempty"`
+	Error   string `json:"error"`
+	Type    string `json:"type"`
+}
+
+// fatal logs an error and exits with the code for the error among v,
+// reporting the message as a JSON error result if -json is set.
+func fatal(v ...interface{}) {
+	code := exitError
+	for _, arg := range v {
+		if err, ok := arg.(error); ok {
+			code = exitCode(err)
+		}
+	}
+	fatalCode(code, fmt.Sprint(v...))
+}
+
+func fatalCode(code int, msg string) {
+	if *flagJSON {
+		printResult(errorResult{Error: msg, Type: exitTypes[code]})
+	} else {
+		errorf("%s", msg)
+	}
+	os.Exit(code)
+}
+
+func fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
+}
+
+type certResult struct {
+	Profile         string    `json:"profile,omitempty"`
+	Domain          string    `json:"domain"`
+	Names           []string  `json:"names"`
+	Serial          string    `json:"serial"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+	Renewed         bool      `json:"renewed"`
+	CertificateFile string    `json:"certificateFile"`
+	LeafFile        string    `json:"leafFile"`
+	ChainFile       string    `json:"chainFile"`
+	CombinedFile    string    `json:"combinedFile,omitempty"`
+	OCSPFile        string    `json:"ocspFile,omitempty"`
+	KeyFile         string    `json:"keyFile"`
+	BundleFile      string    `json:"bundleFile,omitempty"`
+	PKCS12File      string    `json:"pkcs12File,omitempty"`
+	DERFile         string    `json:"derFile,omitempty"`
+	KeystoreFile    string    `json:"keystoreFile,omitempty"`
+	AccountURL      string    `json:"accountUrl,omitempty"`
+
+	DomainChange   *DomainChangeEvent   `json:"domainChange,omitempty"`
+	LifetimeChange *LifetimeChangeEvent `json:"lifetimeChange,omitempty"`
+}
+
+func newCertResult(config *Config, result *localcert.Result) certResult {
+	paths := config.outputPaths()
+	cr := certResult{
+		Profile:         config.Profile,
+		Domain:          result.Domain,
+		Names:           result.Certificate.DNSNames,
+		Serial:          result.Certificate.SerialNumber.Text(16),
+		NotBefore:       result.Certificate.NotBefore,
+		NotAfter:        result.Certificate.NotAfter,
+		Renewed:         result.Renewed,
+		CertificateFile: paths.FullChain,
+		LeafFile:        paths.Leaf,
+		ChainFile:       paths.Chain,
+		CombinedFile:    paths.Combined,
+		OCSPFile:        config.OCSPFile,
+		KeyFile:         paths.Key,
+		BundleFile:      config.BundleFile,
+		AccountURL:      config.ACME.PrivateKey.KeyID,
+		DomainChange:    config.domainChange,
+		LifetimeChange:  config.lifetimeChange,
+	}
+	for _, format := range config.ExportFormats {
+		switch format {
+		case "pkcs12":
+			cr.PKCS12File = config.PKCS12File
+		case "der":
+			cr.DERFile = config.DERFile
+		case "keystore":
+			cr.KeystoreFile = config.KeystoreFile
+		}
+	}
+	return cr
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagOutputs = flag.String("outputs", "", `JSON array of files to render from templates after each issuance, as [{"template": "...", "path": "..."}] with an optional octal "mode"; config files can give it as a list (see README)`)
+
+// jsonSettings are the settings that take JSON, which config files can
+// give as lists and objects rather than strings.
+var jsonSettings = map[string]bool{"outputs": true}
+
+// outputTemplate is a file rendered from a template, for layouts the
+// standard files don't cover.
+type outputTemplate struct {
+	Template string `json:"template"`
+	Path     string `json:"path"`
+	Mode     string `json:"mode,omitempty"`
+
+	tmpl *template.Template
+	mode os.FileMode
+}
+
+var outputFuncs = template.FuncMap{
+	// der decodes PEM blocks into their concatenated DER bytes
+	"der": func(s string) string {
+		var der []byte
+		rest := []byte(s)
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				return string(der)
+			}
+			der = append(der, block.Bytes...)
+		}
+	},
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"join":   strings.Join,
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+}
+
+func parseOutputs(s string) ([]outputTemplate, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var outputs []outputTemplate
+	if err := json.Unmarshal([]byte(s), &outputs); err != nil {
+		return nil, fmt.Errorf("-outputs: %w", err)
+	}
+	for i := range outputs {
+		output := &outputs[i]
+		if output.Path == "" {
+			return nil, fmt.Errorf("-outputs: output %d has no path", i+1)
+		}
+		var err error
+		if output.tmpl, err = template.New(output.Path).Funcs(outputFuncs).Option("missingkey=error").Parse(output.Template); err != nil {
+			return nil, fmt.Errorf("-outputs: %w", err)
+		}
+		if output.Mode != "" {
+			if output.mode, err = parseFileMode(output.Mode); err != nil {
+				return nil, fmt.Errorf("-outputs: %q: %w", output.Path, err)
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// fileMode returns the output's mode: -keyFileMode if its template uses the
+// key, and -certFileMode otherwise, unless it sets its own.
+func (o outputTemplate) fileMode(config *Config) os.FileMode {
+	switch {
+	case o.mode != 0:
+		return o.mode
+	case strings.Contains(o.Template, ".Key"):
+		return config.KeyFileMode
+	}
+	return config.CertFileMode
+}
+
+// outputData is what output templates are rendered with.
+type outputData struct {
+	config *Config
+
+	Domain        string
+	Names         []string
+	Serial        string
+	NotBefore     time.Time
+	NotAfter      time.Time
+	Leaf          string
+	Intermediates string
+	FullChain     string
+	SHA256        string
+	SHA1          string
+}
+
+// Key returns the certificate key in PEM, read only for templates that
+// use it.
+func (d outputData) Key() (string, error) {
+	key, err := d.config.keyPEM()
+	return string(key), err
+}
+
+func (o outputTemplate) render(config *Config, certChain [][]byte) ([]byte, error) {
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return nil, err
+	}
+	leaf := certs[0]
+	sha256Sum, sha1Sum := sha256.Sum256(leaf.Raw), sha1.Sum(leaf.Raw)
+	data := outputData{
+		config:        config,
+		Domain:        leaf.Subject.CommonName,
+		Names:         leaf.DNSNames,
+		Serial:        leaf.SerialNumber.Text(16),
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		Leaf:          string(pemutil.EncodePEMChain(pemutil.CertificateType, certChain[:1])),
+		Intermediates: string(pemutil.EncodePEMChain(pemutil.CertificateType, certChain[1:])),
+		FullChain:     string(pemutil.EncodePEMChain(pemutil.CertificateType, certChain)),
+		SHA256:        hex.EncodeToString(sha256Sum[:]),
+		SHA1:          hex.EncodeToString(sha1Sum[:]),
+	}
+	for _, ip := range leaf.IPAddresses {
+		data.Names = append(data.Names, ip.String())
+	}
+	var b strings.Builder
+	if err := o.tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group of the file info describes, as far
+// as this process is permitted to.
+func copyOwner(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := f.Chown(int(st.Uid), int(st.Gid))
+	if errors.Is(err, fs.ErrPermission) {
+		// Only root can give files away, but the group may still be ours
+		err = f.Chown(-1, int(st.Gid))
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return err
+}
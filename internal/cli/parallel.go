@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var flagParallel = flag.Int("parallel", 1, "with -all, provision up to this many profiles at once, each in its own localcert process")
+
+// profileOutcome is how provisioning a profile went, for the summary.
+type profileOutcome struct {
+	Profile  string
+	Renewed  bool
+	NotAfter time.Time
+	Err      string
+	Code     int
+}
+
+func newProfileOutcome(profile string, result *localcert.Result, err error) profileOutcome {
+	if err != nil {
+		return profileOutcome{Profile: profile, Err: err.Error(), Code: exitCode(err)}
+	}
+	outcome := profileOutcome{Profile: profile}
+	if result != nil {
+		outcome.Renewed, outcome.NotAfter = result.Renewed, result.Certificate.NotAfter
+	}
+	return outcome
+}
+
+// provisionParallel provisions profiles in up to -parallel child processes.
+// The profiles' settings are applied to the global flags, so they can't
+// share a process.
+func provisionParallel(ctx context.Context, profiles []string) []profileOutcome {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	outcomes := make([]profileOutcome, len(profiles))
+	var outputMu sync.Mutex
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < *flagParallel && i < len(profiles); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				outcomes[i] = runProfileProcess(ctx, exe, profiles[i], &outputMu)
+			}
+		}()
+	}
+	for i := range profiles {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return outcomes
+}
+
+// runProfileProcess provisions one profile in a child process, prefixing
+// its messages with the profile name and passing on its JSON result.
+func runProfileProcess(ctx context.Context, exe, profile string, outputMu *sync.Mutex) profileOutcome {
+	args := []string{"-profile=" + profile, "-json"}
+	flag.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "all", "profile", "parallel", "json":
+		default:
+			args = append(args, "-"+fl.Name+"="+fl.Value.String())
+		}
+	})
+	args = append(args, "provision")
+
+	var stdout bytes.Buffer
+	stderr := &prefixWriter{prefix: fmt.Sprintf("[%s] ", profile), w: os.Stderr, mu: outputMu}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, stderr
+	runErr := cmd.Run()
+	stderr.Flush()
+
+	outcome := profileOutcome{Profile: profile}
+	var results bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(stdout.Bytes()))
+	for scanner.Scan() {
+		var line struct {
+			Profile  string    `json:"profile"`
+			Error    string    `json:"error"`
+			Type     string    `json:"type"`
+			Renewed  bool      `json:"renewed"`
+			NotAfter time.Time `json:"notAfter"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		outcome.Err = strings.TrimPrefix(line.Error, "Error: ")
+		outcome.Renewed, outcome.NotAfter = line.Renewed, line.NotAfter
+		if line.Profile != "" {
+			results.Write(scanner.Bytes())
+			results.WriteByte('\n')
+		} else {
+			// Errors the child died of don't name the profile
+			json.NewEncoder(&results).Encode(errorResult{Profile: profile, Error: line.Error, Type: line.Type})
+		}
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(runErr, &exitErr):
+		outcome.Code = exitErr.ExitCode()
+		if outcome.Code < 0 {
+			outcome.Code = exitInterrupted
+		}
+		if outcome.Err == "" {
+			outcome.Err = runErr.Error()
+		}
+	case runErr != nil:
+		outcome.Err, outcome.Code = runErr.Error(), exitError
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if outcome.Code != 0 {
+		errorf("Profile %q error: %s", profile, outcome.Err)
+	}
+	if *flagJSON {
+		jsonOut.Write(results.Bytes())
+	}
+	return outcome
+}
+
+// printProvisionSummary lists how each profile went, returning the exit
+// code: that of the failures if they agree.
+func printProvisionSummary(outcomes []profileOutcome) int {
+	code, renewed, failed := 0, 0, 0
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.Code != 0 && code == 0:
+			code = outcome.Code
+		case outcome.Code != 0 && code != outcome.Code:
+			code = exitError
+		}
+		if outcome.Code != 0 {
+			failed++
+		} else if outcome.Renewed {
+			renewed++
+		}
+	}
+	fmt.Printf("\n%d profiles: %d renewed, %d up to date, %d failed\n", len(outcomes), renewed, len(outcomes)-renewed-failed, failed)
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.Code != 0:
+			fmt.Printf("  %-20s failed: %s\n", outcome.Profile, outcome.Err)
+		case outcome.NotAfter.IsZero():
+			fmt.Printf("  %-20s ok\n", outcome.Profile)
+		case outcome.Renewed:
+			fmt.Printf("  %-20s renewed, expires %s\n", outcome.Profile, outcome.NotAfter.Format(time.RFC3339))
+		default:
+			fmt.Printf("  %-20s up to date, expires %s\n", outcome.Profile, outcome.NotAfter.Format(time.RFC3339))
+		}
+	}
+	return code
+}
+
+// prefixWriter writes whole lines to w, each with prefix, holding mu so
+// that lines from concurrent writers don't mix.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes any unterminated last line.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
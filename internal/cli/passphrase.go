@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var (
+	flagKeyPassphrase = flag.String("keyPassphrase", "", "passphrase to encrypt the certificate and ACME account keys with (or set LOCALCERT_KEY_PASSPHRASE)")
+	flagEncryptKeys   = flag.Bool("encryptKeys", false, "encrypt the certificate and ACME account keys, prompting for a passphrase unless -keyPassphrase is set")
+)
+
+// promptedPassphrase keeps a passphrase entered at the prompt for config
+// reloads.
+var promptedPassphrase []byte
+
+// keyPassphrase returns the passphrase for the key files, prompting for it
+// if the keys are (or are to be) encrypted and none was given. It returns
+// nil if the keys aren't encrypted.
+func keyPassphrase(store localcert.Store, acmeAccountFile, keyFile string) ([]byte, error) {
+	if *flagKeyPassphrase != "" {
+		return []byte(*flagKeyPassphrase), nil
+	}
+	if promptedPassphrase != nil {
+		return promptedPassphrase, nil
+	}
+	existing := isEncryptedKeyFile(store, keyFile) || isEncryptedAccountFile(store, acmeAccountFile)
+	if !existing && !*flagEncryptKeys {
+		return nil, nil
+	}
+	if !interactive() {
+		return nil, errors.New("the keys are encrypted; pass -keyPassphrase or set LOCALCERT_KEY_PASSPHRASE")
+	}
+
+	passphrase, err := readPassphrase("Key passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !existing {
+		confirm, err := readPassphrase("Confirm key passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm != passphrase {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+	if passphrase == "" {
+		return nil, errors.New("empty key passphrase")
+	}
+	promptedPassphrase = []byte(passphrase)
+	return promptedPassphrase, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore, err := disableEcho(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("disable terminal echo: %w", err)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	restore()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func isEncryptedKeyFile(store localcert.Store, name string) bool {
+	data, err := store.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	block, err := pemutil.DecodePEMBlock(data)
+	return err == nil && block.Type == pemutil.EncryptedPrivateKeyType
+}
+
+func isEncryptedAccountFile(store localcert.Store, name string) bool {
+	fileBytes, err := store.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	var account ACMEAccount
+	return json.Unmarshal(fileBytes, &account) == nil && account.EncryptedPrivateKey != ""
+}
+
+// keyPEM returns the certificate key as unencrypted PEM, for outputs that
+// can't take a passphrase.
+func (c *Config) keyPEM() ([]byte, error) {
+	key, err := c.Manager().CertificateKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := localcert.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemutil.PrivateKeyType, Bytes: der}), nil
+}
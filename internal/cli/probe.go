@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	flagProbeInterval = flag.Duration("probeInterval", 0, "how often to check that -probeTarget serves the current certificate (0 probes once)")
+	flagProbeTarget   = flag.String("probeTarget", "", "host:port of the TLS endpoint to probe")
+)
+
+type ProbeMismatchEvent struct {
+	Event     string    `json:"event"`
+	Target    string    `json:"target"`
+	Expected  string    `json:"expectedFingerprint"`
+	Served    string    `json:"servedFingerprint"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func dialEndpoint(addr string) (*tls.ConnectionState, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	return &state, nil
+}
+
+// probeEndpoint checks that addr serves cert, returning the served leaf.
+func probeEndpoint(addr string, cert *x509.Certificate) (*x509.Certificate, error) {
+	state, err := dialEndpoint(addr)
+	if err != nil {
+		return nil, err
+	}
+	served := state.PeerCertificates[0]
+	if fingerprintSHA256(served) != fingerprintSHA256(cert) {
+		return served, fmt.Errorf("serving certificate %s, expected %s", fingerprintSHA256(served), fingerprintSHA256(cert))
+	}
+	return served, nil
+}
+
+func Probe() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	if *flagProbeTarget == "" {
+		fatal("Missing -probeTarget host:port")
+	}
+
+	if *flagProbeInterval <= 0 {
+		printResult(probeOnce(config, *flagProbeTarget))
+		return
+	}
+	probeLoop(config, *flagProbeTarget, *flagProbeInterval)
+}
+
+func probeLoop(config *Config, target string, interval time.Duration) {
+	for {
+		printResult(probeOnce(config, target))
+		time.Sleep(interval)
+	}
+}
+
+type probeResult struct {
+	Target    string    `json:"target"`
+	OK        bool      `json:"ok"`
+	Expected  string    `json:"expectedFingerprint,omitempty"`
+	Served    string    `json:"servedFingerprint,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func probeOnce(config *Config, target string) probeResult {
+	result := probeResult{Target: target, Timestamp: time.Now().UTC()}
+
+	// Re-read every time so a renewal is picked up
+	cert, err := config.ReadCertificate()
+	if err != nil {
+		errorf("Probe error reading certificate: %v", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Expected = fingerprintSHA256(cert)
+	served, err := probeEndpoint(target, cert)
+	if served != nil {
+		result.Served = fingerprintSHA256(served)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if served == nil && err != nil {
+		errorf("Probe error connecting to %s: %v", target, err)
+	} else if err != nil {
+		errorf("Probe ALERT: %s is not serving the current certificate: %v", target, err)
+		logEvent(ProbeMismatchEvent{
+			Event:     "probeMismatch",
+			Target:    target,
+			Expected:  fingerprintSHA256(cert),
+			Served:    fingerprintSHA256(served),
+			Timestamp: time.Now().UTC(),
+		})
+	} else {
+		infof("Probe OK: %s is serving the current certificate", target)
+		result.OK = true
+	}
+	return result
+}
@@ -1,131 +1,413 @@
 package cli
 
 import (
-	"bytes"
 	"context"
 	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/otlp"
 )
 
-var flagForceRenew = flag.Bool("forceRenew", false, "force renewel of certificate with > 30 days until expiration")
+var (
+	flagForceRenew         = flag.Bool("forceRenew", false, "force renewal of a certificate that isn't due for renewal")
+	flagRenewBefore        = flag.Duration("renewBefore", localcert.DefaultRenewBefore, "renew this long before expiry (certificates with shorter lifetimes renew two thirds of the way through)")
+	flagARI                = flag.Bool("ari", true, "follow the CA's suggested renewal window (ACME Renewal Information) when it has one")
+	flagRenewBeforePercent = flag.Float64("renewBeforePercent", 0, "renew once less than this percentage of the lifetime remains, instead of -renewBefore")
+	flagRenewAt            = flag.String("renewAt", "", "renew once this fraction of the lifetime has passed, such as 2/3 or 0.75, instead of -renewBefore (default 2/3 with -shortLived)")
+	flagMinRenewInterval   = flag.Duration("minRenewInterval", 0, "minimum time between successful issuances (0 disables the cooldown)")
+	flagOverrideCooldown   = flag.Bool("overrideCooldown", false, "issue even if within -minRenewInterval of the last issuance")
+)
+
+type CooldownError struct {
+	LastIssuedAt time.Time
+	Remaining    time.Duration
+}
+
+func (ce CooldownError) Error() string {
+	return fmt.Sprintf("last certificate was issued at %s; refusing to issue again for another %s", ce.LastIssuedAt, ce.Remaining.Round(time.Second))
+}
 
 func Provision() {
+	if *flagAll {
+		provisionAll()
+		return
+	}
+
 	config, err := GetConfig()
 	if err != nil {
-		log.Fatal("Config error: ", err)
+		fatal("Config error: ", err)
 	}
 
-	client := config.Client()
-	ctx := context.Background()
+	ctx, stop := interruptContext()
+	defer stop()
+	if *flagDryRun {
+		if err := dryRun(ctx, config); err != nil {
+			fatal("Error: ", err)
+		}
+		return
+	}
+	result, err := provision(ctx, config, *flagForceRenew)
+	if cooldownErr := (CooldownError{}); errors.As(err, &cooldownErr) && !*flagJSON {
+		errorf("Last certificate was issued at %s; refusing to issue again for another %s", cooldownErr.LastIssuedAt, cooldownErr.Remaining.Round(time.Second))
+		errorf("Pass -overrideCooldown to issue anyway.")
+		os.Exit(exitRateLimited)
+	} else if rateErr := (RateLimitError{}); errors.As(err, &rateErr) && !*flagJSON {
+		errorf("Rate limit reached: %s", rateErr.Standing)
+		errorf("Pass -overrideRateLimits to issue anyway.")
+		os.Exit(exitRateLimited)
+	} else if errors.Is(err, context.Canceled) {
+		fatalCode(exitInterrupted, "Interrupted")
+	} else if err != nil {
+		fatal("Error: ", err)
+	}
+	printResult(newCertResult(config, result))
+	if err := writeStdout(config, result); err != nil {
+		fatal("Error writing certificate to stdout: ", err)
+	}
+}
+
+// provisionAll provisions every config file profile, continuing past
+// failures.
+func provisionAll() {
+	flag.Parse()
+	err := applyEnv()
+	initOutput()
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+	file, err := readConfigFile()
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+	profiles := file.profileNames()
+	if len(profiles) == 0 {
+		fatalCode(exitConfig, "Config error: -all requires profiles in the config file")
+	}
+
+	if *flagParallel > 1 && *flagStdout != "" {
+		fatalCode(exitConfig, "Config error: -stdout can't be combined with -parallel")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	var outcomes []profileOutcome
+	if *flagParallel > 1 {
+		outcomes = provisionParallel(ctx, profiles)
+	} else {
+		for _, profile := range profiles {
+			if ctx.Err() != nil {
+				fatalCode(exitInterrupted, "Interrupted")
+			}
+			infof("=== Profile %q ===", profile)
+			config, err := getProfileConfig(profile)
+			var result *localcert.Result
+			if err == nil && *flagDryRun {
+				err = dryRun(ctx, config)
+			} else if err == nil {
+				result, err = provision(ctx, config, *flagForceRenew)
+				if err == nil {
+					printResult(newCertResult(config, result))
+					err = writeStdout(config, result)
+				}
+			}
+			if err != nil {
+				errorf("Profile %q error: %v", profile, err)
+				printResult(errorResult{Profile: profile, Error: err.Error(), Type: exitTypes[exitCode(err)]})
+			}
+			outcomes = append(outcomes, newProfileOutcome(profile, result, err))
+		}
+	}
+	if code := printProvisionSummary(outcomes); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// provision renews the configured certificate if needed (or if force is set),
+// returning the current certificate.
+func provision(ctx context.Context, config *Config, force bool) (result *localcert.Result, err error) {
+	ctx, span := tracer.Start(ctx, "provision", otlp.KindInternal)
+	if config.Profile != "" {
+		span.SetAttribute("localcert.profile", config.Profile)
+	}
+	defer func() {
+		if result != nil {
+			span.SetAttribute("localcert.domain", result.Domain)
+			span.SetAttribute("localcert.renewed", result.Renewed)
+		}
+		span.End(err)
+	}()
+
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	config.domainChange, config.lifetimeChange = nil, nil
+	defer func() { writeMetadata(config, result, err) }()
+
+	defer func() {
+		// Neither a cooldown, a rate limit reached locally nor an
+		// interruption is a failed renewal
+		cooldownErr, rateErr := CooldownError{}, RateLimitError{}
+		if err != nil && !errors.As(err, &cooldownErr) && !errors.As(err, &rateErr) && !errors.Is(err, context.Canceled) {
+			if hookErr := runHook(config, "onError", *flagOnErrorHook, "", "LOCALCERT_ERROR="+err.Error()); hookErr != nil {
+				errorf("%v", hookErr)
+			}
+			n := newNotificationEvent(config, notifyRenewalFailed)
+			n.Error = err.Error()
+			notify(n)
+			notifyIfExpiring(config)
+			recordRenewalFailure(config, err)
+		} else if err == nil {
+			clearRenewalFailures(config)
+		}
+	}()
+
+	if err := refreshSharedAccount(config); err != nil {
+		return nil, err
+	}
+	manager := config.Manager()
 
 	cert, err := config.ReadCertificate()
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Fatalf("Error reading existing certificate %q: %v", config.CertificateFile, err)
+		return nil, fmt.Errorf("reading existing certificate %q: %w", config.CertificateFile, err)
+	}
+
+	if err := prunePreviousKey(config, cert); err != nil {
+		return nil, err
 	}
 
 	var certDomain string
 	if cert != nil {
 		certDomain = cert.Subject.CommonName
-		WriteDomainFile(certDomain)
-		fmt.Printf("Found existing certificate for domain %q\n", certDomain)
-		if !*flagForceRenew {
-			expiresIn := time.Until(cert.NotAfter)
-			if expiresIn > 30*24*time.Hour {
-				fmt.Println("Existing certificate expires in > 30 days and doesn't need to be renewed")
+		if err := config.WriteDomainFile(certDomain); err != nil {
+			warnf("%v", err)
+		}
+		infof("Found existing certificate for domain %q", certDomain)
+		if !force {
+			debugf("Checking whether certificate %s (expires %s) needs renewal", cert.SerialNumber.Text(16), cert.NotAfter.Format(time.RFC3339))
+			renew, reason := renewalReason(ctx, config, manager, cert)
+			infof("%s", reason)
+			if !renew {
+				if err := manager.CheckPair(cert); errors.Is(err, localcert.ErrKeyMismatch) && manager.InterruptedRenewal(cert) {
+					infof("The renewal of this certificate was interrupted before storing its key; storing it now")
+					if err := manager.FinishRenewal(); err != nil {
+						return nil, err
+					}
+				} else if err != nil {
+					return nil, fmt.Errorf("%w; renew with -forceRenew to replace the certificate", err)
+				}
+			}
+			if !renew {
+				certChain, err := config.ReadCertificateChain()
+				if err != nil {
+					return nil, fmt.Errorf("reading certificate chain: %w", err)
+				}
+				if err := writeCertFiles(config, certChain, true); err != nil {
+					return nil, err
+				}
+				updateOCSP(ctx, config, certChain)
+				if err := updateSSHCert(ctx, config, certChain); err != nil {
+					return nil, err
+				}
+				if err := writeBundle(config, certChain); err != nil {
+					return nil, err
+				}
+				if err := writeExports(config, certChain, true); err != nil {
+					return nil, err
+				}
+				if err := applyFilePolicy(config); err != nil {
+					return nil, fmt.Errorf("file permissions: %w", err)
+				}
+				if err := writeKubeSecret(ctx, config, certChain); err != nil {
+					return nil, err
+				}
+				if err := deployCertificate(ctx, config, certChain); err != nil {
+					return nil, err
+				}
 				printCertInfo(config, cert)
-				os.Exit(0)
-			} else if expiresIn > 0 {
-				fmt.Println("Existing certificate expires in < 30 days and will be renewed")
-			} else {
-				fmt.Println("Existing certificate has expired and will be renewed")
+				notifyIfExpiring(config)
+				return &localcert.Result{Domain: certDomain, Chain: certChain, Certificate: cert, Previous: cert}, nil
 			}
 		}
 	}
 
-	termsRetry := false
-	for {
-		account, err := client.EnsureRegistration(ctx, config.ACME.AcceptedTerms, config.ACME.PrivateKey.KeyID)
-		if termsErr := (localcert.TermsNotAcceptedError{}); !termsRetry && errors.As(err, &termsErr) {
-			PromptRequireAcceptTerms(termsErr.URI)
-			config.ACME.AcceptedTerms = termsErr.URI
-			termsRetry = true
-			continue
-		} else if err != nil {
-			log.Fatal("Registration error: ", err)
+	if *flagMinRenewInterval > 0 && !*flagOverrideCooldown {
+		last, err := config.LastIssuance()
+		if err != nil {
+			return nil, fmt.Errorf("reading issuance history: %w", err)
+		}
+		if last != nil {
+			if remaining := time.Until(last.IssuedAt.Add(*flagMinRenewInterval)); remaining > 0 {
+				return nil, CooldownError{LastIssuedAt: last.IssuedAt, Remaining: remaining}
+			}
 		}
-		config.ACME.PrivateKey.KeyID = account.URI
-		break
 	}
-	if err := config.WriteACMEAccountFile(); err != nil {
-		log.Fatalf("Error writing acmeAccount file %q: %v", config.ACMEAccountFile, err)
+	if err := checkRateLimits(config, issuanceNames(config, manager, cert)); err != nil {
+		return nil, err
+	}
+
+	if err := runHook(config, "preRenew", *flagPreRenewHook, certDomain); err != nil {
+		return nil, err
 	}
 
-	domain, err := client.GetDomain()
-	WriteDomainFile(certDomain)
+	if manager.RotateKey, err = keyRotationDue(config, cert); err != nil {
+		return nil, err
+	}
+	// Lets a renewal go ahead while the localcert server is unreachable.
+	// Without a domain file of this profile's own, the existing
+	// certificate's domain is used.
+	manager.CachedDomain = config.readDomainFile()
+	result, err = manager.Renew(ctx)
 	if err != nil {
-		log.Fatal("Error getting localcert domain name: ", err)
+		return nil, err
+	}
+	if result.CachedDomain {
+		warnCachedDomain(config, result.Domain)
+	}
+	if err := checkClock(ctx, config, result.Certificate); err != nil {
+		return nil, err
+	}
+	if err := config.WriteDomainFile(result.Domain); err != nil {
+		warnf("%v", err)
 	}
 
-	if certDomain != "" && certDomain != domain {
-		fmt.Print("The localcert server has assigned you a new domain!\n\n")
-		fmt.Printf("  Old domain: %q\n", certDomain)
-		fmt.Printf("  New domain: %q\n\n", domain)
+	if err := postIssuance(config, result); err != nil {
+		return nil, err
+	}
+	if certDomain != "" && certDomain != result.Domain {
+		domainChanged(ctx, config, certDomain, result)
+	}
+	if err := runHook(config, "postRenew", *flagPostRenewHook, result.Domain); err != nil {
+		return nil, err
 	}
 
-	if !*flagForceRenew {
-		fmt.Printf("Provisioning domain %q...\n", domain)
-	} else {
-		fmt.Printf("Reprovisioning domain %q...\n", domain)
+	printCertInfo(config, result.Certificate)
+	n := newNotificationEvent(config, notifyRenewed)
+	if n.NotAfter == nil {
+		// The certificate couldn't be read back, as from a store that is
+		// unavailable
+		n.Domain, n.NotAfter = result.Domain, &result.Certificate.NotAfter
 	}
-	order, err := client.ProvisionDomain(ctx, domain)
-	if err != nil {
-		if !*flagForceRenew {
-			log.Fatal("Error provisioning domain: ", err)
-		} else {
-			log.Fatal("Error reprovisioning domain: ", err)
-		}
+	if change := config.lifetimeChange; change != nil {
+		n.OldLifetime, n.NewLifetime = change.OldLifetime, change.NewLifetime
 	}
+	notify(n)
+	return result, nil
+}
 
-	certKey, err := config.ReadOrGenerateCertificateKey()
-	if err != nil {
-		log.Fatal("Certificate key error: ", err)
+// warnCachedDomain warns that domain was renewed without the localcert
+// server confirming it, and how long ago it last did.
+func warnCachedDomain(config *Config, domain string) {
+	since := ""
+	if last, err := config.LastIssuance(); err == nil && last != nil && last.Domain == domain {
+		since = fmt.Sprintf(", which it last did %s ago", formatDays(time.Since(last.IssuedAt)))
+	}
+	warnf("The localcert server couldn't confirm that %q is still your domain%s; a newly assigned domain will be picked up once the server is reachable", domain, since)
+}
+
+// renewalReason reports whether the existing cert is due for renewal, and
+// why.
+func renewalReason(ctx context.Context, config *Config, manager *localcert.Manager, cert *x509.Certificate) (bool, string) {
+	due := manager.RenewalTime(ctx, cert)
+	if !manager.NeedsRenewalAt(cert, due) {
+		return false, fmt.Sprintf("Existing certificate isn't due for renewal until %s", due.Format(time.RFC3339))
+	} else if keyType := localcert.KeyTypeOf(cert.PublicKey); keyType != config.KeyType && config.CSR == nil {
+		return true, fmt.Sprintf("Existing certificate key is %s, not %s, and will be renewed", keyType, config.KeyType)
+	} else if localcert.HasMustStaple(cert) != config.MustStaple && config.CSR == nil {
+		if config.MustStaple {
+			return true, "Existing certificate isn't must-staple and will be renewed"
+		}
+		return true, "Existing certificate is must-staple, which -mustStaple no longer asks for, and will be renewed"
+	} else if missing := manager.MissingNames(cert); len(missing) > 0 {
+		return true, fmt.Sprintf("Existing certificate doesn't cover %s and will be renewed", strings.Join(missing, ", "))
+	} else if time.Until(cert.NotAfter) > 0 {
+		return true, fmt.Sprintf("Existing certificate expires in %s and will be renewed", formatDays(time.Until(cert.NotAfter)))
 	}
+	return true, "Existing certificate has expired and will be renewed"
+}
 
-	fmt.Printf("Domain provisioned; waiting for certificate generation...\n")
-	certChain, err := client.GetCertificate(ctx, order, certKey)
+// postIssuance runs the steps that follow writing a newly issued
+// certificate.
+func postIssuance(config *Config, result *localcert.Result) error {
+	cert := result.Certificate
+	prevLifetime := previousLifetime(config, result.Previous)
+	err := config.AppendHistory(IssuanceRecord{
+		Domain:    cert.Subject.CommonName,
+		Serial:    cert.SerialNumber.Text(16),
+		IssuedAt:  time.Now(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Names:     cert.DNSNames,
+		Account:   config.ACME.PrivateKey.KeyID,
+		KeyID:     publicKeyID(cert.PublicKey),
+	})
 	if err != nil {
-		log.Fatal("Error fetching certificate: ", err)
+		return fmt.Errorf("writing issuance history: %w", err)
 	}
-	cert, err = x509.ParseCertificate(certChain[0])
-	if err != nil {
-		log.Fatal("Error parsing generated certificate: ", err)
+	config.lifetimeChange = checkLifetimeChange(config, prevLifetime, cert)
+	if err := checkSCTs(result.Chain); err != nil {
+		return err
+	}
+	if err := writeCertFiles(config, result.Chain, false); err != nil {
+		return err
+	}
+	updateOCSP(context.Background(), config, result.Chain)
+	if err := updateSSHCert(context.Background(), config, result.Chain); err != nil {
+		return err
+	}
+	if err := writeBundle(config, result.Chain); err != nil {
+		return err
+	}
+	if err := writeExports(config, result.Chain, false); err != nil {
+		return err
+	}
+	if err := applyFilePolicy(config); err != nil {
+		return fmt.Errorf("file permissions: %w", err)
 	}
+	if err := writeKubeSecret(context.Background(), config, result.Chain); err != nil {
+		return err
+	}
+	if err := deployCertificate(context.Background(), config, result.Chain); err != nil {
+		return err
+	}
+	if err := installCertStore(config, result.Chain, result.Previous); err != nil {
+		return err
+	}
+	return verifyReadable(config)
+}
 
-	var buf bytes.Buffer
-	for _, certBytes := range certChain {
-		err := pem.Encode(&buf, &pem.Block{Type: certificatePEMType, Bytes: certBytes})
-		if err != nil {
-			log.Fatal("Error writing certificate: ", err)
-		}
+func writeBundle(config *Config, certChain [][]byte) error {
+	changed, err := config.WriteBundle(certChain)
+	if changed || err != nil {
+		config.auditWrite(config.BundleFile, err)
 	}
-	err = os.WriteFile(config.CertificateFile, buf.Bytes(), filePerm)
 	if err != nil {
-		log.Fatal("Error writing certificate: ", err)
+		return fmt.Errorf("writing bundle %q: %w", config.BundleFile, err)
 	}
-
-	printCertInfo(config, cert)
+	if changed {
+		infof("Bundle written:  %s", config.BundleFile)
+	}
+	return nil
 }
 
 func printCertInfo(config *Config, cert *x509.Certificate) {
-	fmt.Print("\nCertificate expires ", cert.NotAfter, "\n\n")
-	fmt.Println("Certificate (chain): ", config.CertificateFile)
-	fmt.Println("Certificate privkey: ", config.KeyFile)
+	paths := config.outputPaths()
+	if len(cert.DNSNames) > 1 {
+		infof("Certificate names: %s", strings.Join(cert.DNSNames, ", "))
+	}
+	infof("Certificate expires %s", cert.NotAfter)
+	infof("Certificate (chain):  %s", paths.FullChain)
+	infof("Certificate (leaf):   %s", paths.Leaf)
+	infof("Intermediates:        %s", paths.Chain)
+	if paths.Combined != "" {
+		infof("Key and chain:        %s", paths.Combined)
+	}
+	infof("Certificate privkey:  %s", paths.Key)
 }
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var (
+	flagACMEProxyURL = flag.String("acmeProxyUrl", "", "http://, https:// or socks5:// proxy for ACME and localcert server requests (default HTTPS_PROXY from the environment)")
+	flagACMECABundle = flag.String("acmeCaBundle", "", "PEM file of root certificates to trust for ACME and localcert server requests besides the system's, such as a TLS-intercepting proxy's")
+)
+
+// initHTTPClient sets httpClient for -acmeProxyUrl and -acmeCaBundle,
+// recording the servers' clock skew, tracing requests with -debug and to
+// the OTLP endpoint, and timing them for the daemon's metrics.
+func initHTTPClient() error {
+	transport, err := acmeTransport()
+	if err != nil {
+		return err
+	}
+	transport = clockTransport{transport}
+	if *flagDebug {
+		transport = traceTransport{transport}
+	}
+	if tracer != nil {
+		transport = spanTransport{transport}
+	}
+	if requestMetrics != nil {
+		transport = metricsTransport{requestMetrics, transport}
+	}
+	httpClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// acmeTransport returns the transport for ACME and localcert server
+// requests: http.DefaultTransport, which honors HTTPS_PROXY, unless
+// -acmeProxyUrl or -acmeCaBundle is set.
+func acmeTransport() (http.RoundTripper, error) {
+	if *flagACMEProxyURL == "" && *flagACMECABundle == "" {
+		return http.DefaultTransport, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *flagACMEProxyURL != "" {
+		proxyURL, err := url.Parse(*flagACMEProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("-acmeProxyUrl: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("-acmeProxyUrl %q: must be an http://, https:// or socks5:// URL", proxyURL.Redacted())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if *flagACMECABundle != "" {
+		data, err := os.ReadFile(*flagACMECABundle)
+		if err != nil {
+			return nil, fmt.Errorf("-acmeCaBundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("-acmeCaBundle: no certificates in %q", *flagACMECABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return transport, nil
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+var (
+	flagVerifyReadableBy     = flag.String("verifyReadableBy", "", "user[:group] that must be able to read the certificate and key")
+	flagVerifyReadableAction = flag.String("verifyReadableAction", "fail", "what to do when -verifyReadableBy can't read the files: fail or warn")
+)
+
+var errReadableUnsupported = errors.New("readability checks are not supported on this platform")
+
+func (c *Config) VerifyReadable() error {
+	if c.VerifyReadableBy == "" {
+		return nil
+	}
+	names := []string{c.CertificateFile, c.KeyFile}
+	for _, file := range c.certFiles() {
+		names = append(names, file.name)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := checkReadableBy(c.VerifyReadableBy, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyReadable(config *Config) error {
+	err := config.VerifyReadable()
+	if errors.Is(err, errReadableUnsupported) {
+		warnf("skipping -verifyReadableBy check: %v", err)
+	} else if err != nil {
+		if config.VerifyReadableAction == "warn" {
+			warnf("%q can't read the certificate files: %v", config.VerifyReadableBy, err)
+		} else {
+			return fmt.Errorf("%q can't read the certificate files: %w", config.VerifyReadableBy, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	permRead    = 4
+	permExecute = 1
+)
+
+type identity struct {
+	uid  uint32
+	gids map[uint32]bool
+}
+
+func lookupIdentity(spec string) (*identity, error) {
+	userName, groupName := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		userName, groupName = spec[:i], spec[i+1:]
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: invalid uid %q", userName, u.Uid)
+	}
+	id := &identity{uid: uint32(uid), gids: map[uint32]bool{}}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		gids = []string{u.Gid}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		gids = append(gids, g.Gid)
+	}
+	for _, gid := range gids {
+		if n, err := strconv.ParseUint(gid, 10, 32); err == nil {
+			id.gids[uint32(n)] = true
+		}
+	}
+	return id, nil
+}
+
+func checkReadableBy(spec, name string) error {
+	id, err := lookupIdentity(spec)
+	if err != nil {
+		return fmt.Errorf("lookup %q: %w", spec, err)
+	}
+	if id.uid == 0 {
+		return nil
+	}
+
+	path, err := filepath.Abs(name)
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Every parent directory must be searchable and the file itself readable
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if err := checkAccess(id, dir, permExecute); err != nil {
+			return err
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return checkAccess(id, path, permRead)
+}
+
+func checkAccess(id *identity, path string, perm uint32) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errReadableUnsupported
+	}
+	mode := uint32(fi.Mode().Perm())
+	uid, gid := uint32(st.Uid), uint32(st.Gid)
+
+	var granted bool
+	if acl := readACL(path); acl != nil {
+		granted = acl.allows(id, uid, gid, perm)
+	} else {
+		switch {
+		case id.uid == uid:
+			granted = (mode>>6)&perm == perm
+		case id.gids[gid]:
+			granted = (mode>>3)&perm == perm
+		default:
+			granted = mode&perm == perm
+		}
+	}
+	if !granted {
+		what := "read"
+		if perm == permExecute {
+			what = "search"
+		}
+		return fmt.Errorf("no %s permission on %q (mode %04o, owner %d:%d)", what, path, mode, uid, gid)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+package cli
+
+func checkReadableBy(identity, name string) error {
+	return errReadableUnsupported
+}
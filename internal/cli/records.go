@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/wildone/localcert"
+)
+
+const dnsUsage = "Usage: localcert dns list | set A|AAAA <address> [name] | delete A|AAAA [name]"
+
+type dnsRecordsResult struct {
+	Domain  string                `json:"domain"`
+	Records []localcert.DNSRecord `json:"records"`
+}
+
+// DNSRecords runs the dns subcommands, which manage the records the
+// localcert server serves under the assigned domain, such as an A record
+// pointing it at a LAN address.
+func DNSRecords() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	if len(config.challengers) > 0 {
+		fatal("dns manages records on the localcert server, which -dnsProvider and -challenge domains don't use")
+	}
+	if config.ACME.PrivateKey.KeyID == "" {
+		fatalf("No ACME account is registered in %s yet; run localcert init or provision first", config.ACMEAccountFile)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	client := config.Manager().Config.Client()
+
+	var res *localcert.DNSRecordsResult
+	args := flag.Args()[1:]
+	switch {
+	case len(args) == 0:
+		fatal(dnsUsage)
+	case args[0] == "list" && len(args) == 1:
+		res, err = client.DNSRecords(ctx)
+	case args[0] == "set" && (len(args) == 3 || len(args) == 4):
+		record := localcert.DNSRecord{Type: strings.ToUpper(args[1]), Value: args[2]}
+		if len(args) == 4 {
+			record.Name = args[3]
+		}
+		if err := checkDNSRecord(record); err != nil {
+			fatal(err)
+		}
+		if res, err = client.SetDNSRecords(ctx, []localcert.DNSRecord{record}); err == nil {
+			infof("Set %s %s to %s", recordName(record.Name, res.Domain), record.Type, record.Value)
+		}
+	case args[0] == "delete" && (len(args) == 2 || len(args) == 3):
+		record := localcert.DNSRecord{Type: strings.ToUpper(args[1])}
+		if len(args) == 3 {
+			record.Name = args[2]
+		}
+		if err := checkDNSRecord(record); err != nil {
+			fatal(err)
+		}
+		if res, err = client.DeleteDNSRecords(ctx, []localcert.DNSRecord{record}); err == nil {
+			infof("Deleted %s %s", recordName(record.Name, res.Domain), record.Type)
+		}
+	case args[0] == "list" || args[0] == "set" || args[0] == "delete":
+		fatal(dnsUsage)
+	default:
+		fatalf("Invalid dns subcommand %q", args[0])
+	}
+	if err != nil {
+		fatal("Error: ", err)
+	}
+
+	for _, record := range res.Records {
+		fmt.Printf("%-40s %-5s %s\n", recordName(record.Name, res.Domain), record.Type, record.Value)
+	}
+	if len(res.Records) == 0 {
+		fmt.Printf("No records under %s\n", strings.TrimPrefix(res.Domain, "*."))
+	}
+	printResult(dnsRecordsResult{Domain: res.Domain, Records: res.Records})
+}
+
+// checkDNSRecord checks a record before it goes to the server: only
+// address records are supported, and their values must be addresses of
+// the right family.
+func checkDNSRecord(record localcert.DNSRecord) error {
+	if record.Type != "A" && record.Type != "AAAA" {
+		return fmt.Errorf("unsupported record type %q; expected A or AAAA", record.Type)
+	}
+	if strings.HasSuffix(record.Name, ".") || strings.Contains(record.Name, "*") {
+		return fmt.Errorf("invalid name %q; give it relative to the assigned domain, without wildcards", record.Name)
+	}
+	if record.Value == "" {
+		return nil
+	}
+	ip := net.ParseIP(record.Value)
+	if record.Type == "A" && (ip == nil || ip.To4() == nil) {
+		return fmt.Errorf("%q isn't an IPv4 address", record.Value)
+	}
+	if record.Type == "AAAA" && (ip == nil || ip.To4() != nil) {
+		return fmt.Errorf("%q isn't an IPv6 address", record.Value)
+	}
+	return nil
+}
+
+// recordName returns the full name of a record under domain.
+func recordName(name, domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	if name == "" {
+		return domain
+	}
+	return name + "." + domain
+}
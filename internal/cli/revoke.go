@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/wildone/localcert"
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	flagRevokeReason      = flag.String("reason", "unspecified", "revocation reason for revoke: unspecified, keyCompromise, affiliationChanged, superseded or cessationOfOperation")
+	flagRevokeWithCertKey = flag.Bool("revokeWithCertKey", false, "sign the revocation with the certificate key instead of the ACME account key")
+	flagDeleteKey         = flag.Bool("deleteKey", false, "after revoke, delete the certificate, its key and any exports")
+)
+
+var revocationReasons = map[string]acme.CRLReasonCode{
+	"unspecified":          acme.CRLReasonUnspecified,
+	"keyCompromise":        acme.CRLReasonKeyCompromise,
+	"affiliationChanged":   acme.CRLReasonAffiliationChanged,
+	"superseded":           acme.CRLReasonSuperseded,
+	"cessationOfOperation": acme.CRLReasonCessationOfOperation,
+}
+
+type revokeResult struct {
+	Domain  string   `json:"domain"`
+	Serial  string   `json:"serial"`
+	Reason  string   `json:"reason"`
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+func Revoke() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	reason, ok := revocationReasons[*flagRevokeReason]
+	if !ok {
+		fatalf("Invalid -reason %q", *flagRevokeReason)
+	}
+	unlock, err := lockDataDir(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer unlock()
+
+	ctx, stop := interruptContext()
+	defer stop()
+	cert, err := config.Manager().Revoke(ctx, reason, *flagRevokeWithCertKey)
+	if err != nil {
+		fatal("Error: ", err)
+	}
+	infof("Revoked certificate for domain %q (serial %s)", cert.Subject.CommonName, cert.SerialNumber.Text(16))
+	result := revokeResult{
+		Domain: cert.Subject.CommonName,
+		Serial: cert.SerialNumber.Text(16),
+		Reason: *flagRevokeReason,
+	}
+
+	if !*flagDeleteKey {
+		if reason == acme.CRLReasonKeyCompromise {
+			warnf("Delete %s before renewing; a compromised key can't be reused.", config.KeyFile)
+		}
+		infof("Run with -forceRenew to issue a replacement.")
+		printResult(result)
+		return
+	}
+	// The certificate and key may be in Vault; the rest are local files
+	type storedFile struct {
+		store localcert.Store
+		name  string
+	}
+	files := []storedFile{
+		{config.store, config.CertificateFile},
+		{config.store, config.KeyFile},
+		{localcert.FileStore{}, config.BundleFile},
+	}
+	for _, file := range config.certFiles() {
+		files = append(files, storedFile{localcert.FileStore{}, file.name})
+	}
+	files = append(files, storedFile{localcert.FileStore{}, config.OCSPFile})
+	for _, name := range config.exportFiles() {
+		files = append(files, storedFile{localcert.FileStore{}, name})
+	}
+	// Backups hold older keys, which are no safer
+	if _, ok := config.store.(localcert.FileStore); ok {
+		for _, name := range []string{config.CertificateFile, config.KeyFile} {
+			if name == "" {
+				continue
+			}
+			backups, _ := filepath.Glob(name + ".bak.*")
+			for _, backup := range backups {
+				files = append(files, storedFile{localcert.FileStore{}, backup})
+			}
+		}
+	}
+	for _, file := range files {
+		name := file.name
+		if name == "" {
+			continue
+		}
+		if err := file.store.Remove(name); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			fatalf("Error deleting %q: %v", name, err)
+		}
+		infof("Deleted:  %s", name)
+		result.Deleted = append(result.Deleted, name)
+	}
+	printResult(result)
+}
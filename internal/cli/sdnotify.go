@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state to the systemd service manager. It does nothing when
+// not started by systemd with Type=notify.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		errorf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		errorf("sd_notify: %v", err)
+	}
+}
+
+// sdWatchdogInterval returns how often to ping the systemd watchdog, or 0 if
+// WatchdogSec isn't set for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	// Ping at half the timeout, as sd_watchdog_enabled(3) recommends
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+func sdWatchdogLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		sdNotify("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+var (
+	flagServeAddr  = flag.String("serveAddr", ":443", "address for the serve command to listen for HTTPS on")
+	flagServeDir   = flag.String("dir", "", "with serve, the directory of static files to serve")
+	flagServeProxy = flag.String("proxy", "", "with serve, the http:// URL of a backend to proxy requests to")
+)
+
+// Serve runs an HTTPS server for a directory or a backend with the
+// certificate, renewing it like the daemon and picking up each renewal
+// without a restart.
+func Serve() {
+	logger.timestamps = true
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	handler, err := serveHandler(*flagServeDir, *flagServeProxy)
+	if err != nil {
+		fatal("Config error: ", ConfigError{Err: err})
+	}
+
+	source := config.Manager().CertSource()
+	source.OCSPFile = config.OCSPFile
+	source.HTTPClient = httpClient
+	source.HoldUntilValid = *flagWaitUntilValid
+	server := &http.Server{
+		Addr:              *flagServeAddr,
+		Handler:           handler,
+		TLSConfig:         &tls.Config{GetCertificate: source.GetCertificate, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		infof("Serving HTTPS on %s", *flagServeAddr)
+		if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+			fatal("Error serving HTTPS: ", err)
+		}
+	}()
+
+	daemonCtx, stop := interruptContext()
+	defer stop()
+	runDaemon(daemonCtx, config, func() {
+		// Files are checked for changes on each handshake, but other
+		// storage backends have to be reloaded
+		if err := source.Reload(); err != nil {
+			errorf("Error reloading certificate: %v", err)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+func serveHandler(dir, proxy string) (http.Handler, error) {
+	switch {
+	case dir != "" && proxy != "":
+		return nil, errors.New("serve takes -dir or -proxy, not both")
+	case dir != "":
+		return http.FileServer(http.Dir(dir)), nil
+	case proxy != "":
+		backend, err := url.Parse(proxy)
+		if err != nil || (backend.Scheme != "http" && backend.Scheme != "https") || backend.Host == "" {
+			return nil, errors.New("-proxy must be an http:// or https:// URL")
+		}
+		rp := httputil.NewSingleHostReverseProxy(backend)
+		director := rp.Director
+		rp.Director = func(req *http.Request) {
+			director(req)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", req.Host)
+		}
+		return rp, nil
+	}
+	return nil, errors.New("serve needs -dir or -proxy")
+}
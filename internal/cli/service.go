@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"flag"
+	"os"
+)
+
+var flagLaunchdDir = flag.String("launchdDir", "/Library/LaunchDaemons", "directory install-service writes the launchd plist to on macOS")
+
+// InstallService installs the daemon as a service of the system's service
+// manager: a Windows service, or a launchd daemon on macOS.
+func InstallService() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error finding localcert executable: ", err)
+	}
+	name := "localcert"
+	if config.Profile != "" {
+		name += "-" + config.Profile
+	}
+	result, err := installService(config, name, serviceArgs(exe, "daemon"))
+	if err != nil {
+		fatal("Error installing service: ", err)
+	}
+	printResult(result)
+}
+
+type installServiceResult struct {
+	Profile string `json:"profile,omitempty"`
+	Service string `json:"service"`
+	File    string `json:"file,omitempty"`
+}
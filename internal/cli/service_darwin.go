@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var plistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": template.HTMLEscapeString}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .WorkingDirectory}}</string>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>HOME</key>
+		<string>{{xml .Home}}</string>
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogFile}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogFile}}</string>
+</dict>
+</plist>
+`))
+
+type launchdJob struct {
+	Label            string
+	Args             []string
+	WorkingDirectory string
+	Home             string
+	LogFile          string
+}
+
+// installService writes a launchd daemon plist, which launchd keeps
+// running, restarting it if it exits.
+func installService(config *Config, name string, args []string) (installServiceResult, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return installServiceResult{}, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return installServiceResult{}, err
+	}
+	job := launchdJob{
+		Label:            "dev.localcert." + name,
+		Args:             args,
+		WorkingDirectory: wd,
+		// Daemons don't get the user's HOME, so pin the config and data dir
+		// defaults
+		Home:    home,
+		LogFile: "/Library/Logs/" + name + ".log",
+	}
+	var b strings.Builder
+	if err := plistTemplate.Execute(&b, job); err != nil {
+		return installServiceResult{}, err
+	}
+	file := filepath.Join(*flagLaunchdDir, job.Label+".plist")
+	if err := writeFileAtomic(file, []byte(b.String()), 0644); err != nil {
+		return installServiceResult{}, err
+	}
+
+	infof("launchd plist written to: %s", file)
+	infof("Load it with: launchctl bootstrap system %s", file)
+	return installServiceResult{Profile: config.Profile, Service: job.Label, File: file}, nil
+}
+
+func runAsService(config *Config) bool {
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package cli
+
+import "errors"
+
+func installService(config *Config, name string, args []string) (installServiceResult, error) {
+	return installServiceResult{}, errors.New("install-service supports Windows and macOS; use install-systemd on Linux")
+}
+
+func runAsService(config *Config) bool {
+	return false
+}
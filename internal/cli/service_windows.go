@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers a Windows service that runs the daemon at boot,
+// and that the service manager restarts if it fails.
+func installService(config *Config, name string, args []string) (installServiceResult, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return installServiceResult{}, fmt.Errorf("connecting to the service manager (run from an elevated prompt): %w", err)
+	}
+	defer m.Disconnect()
+
+	serviceConfig := mgr.Config{
+		DisplayName:      "localcert",
+		Description:      "Keeps the localcert certificate renewed",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}
+	if config.Profile != "" {
+		serviceConfig.DisplayName += " (" + config.Profile + ")"
+	}
+	s, err := m.OpenService(name)
+	if err == nil {
+		// Reinstalling updates the service's command line and settings
+		for i, arg := range args {
+			args[i] = syscall.EscapeArg(arg)
+		}
+		serviceConfig.BinaryPathName = strings.Join(args, " ")
+		err = s.UpdateConfig(serviceConfig)
+	} else {
+		s, err = m.CreateService(name, args[0], serviceConfig, args[1:]...)
+	}
+	if err != nil {
+		return installServiceResult{}, err
+	}
+	defer s.Close()
+
+	// Restart after failures, backing off, and start afresh after a day
+	// without one
+	actions := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Minute},
+	}
+	if err := s.SetRecoveryActions(actions, uint32((24 * time.Hour).Seconds())); err != nil {
+		return installServiceResult{}, fmt.Errorf("setting recovery actions: %w", err)
+	}
+
+	// Services run as LocalSystem, so pin the config and data dir defaults
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return installServiceResult{}, err
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+name, registry.SET_VALUE)
+	if err != nil {
+		return installServiceResult{}, err
+	}
+	defer key.Close()
+	if err := key.SetStringsValue("Environment", []string{"APPDATA=" + userConfigDir}); err != nil {
+		return installServiceResult{}, fmt.Errorf("setting service environment: %w", err)
+	}
+
+	infof("Service installed:  %s", name)
+	infof("Start it with: sc.exe start %s", name)
+	return installServiceResult{Profile: config.Profile, Service: name}, nil
+}
+
+// runAsService runs the daemon under the service manager, if it started
+// this process, returning false otherwise.
+func runAsService(config *Config) bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	// Services have no console, so log to a file
+	name := "localcert"
+	if config.Profile != "" {
+		name += "-" + config.Profile
+	}
+	logFile := filepath.Join(os.Getenv("ProgramData"), "localcert", name+".log")
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err == nil {
+		if f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+			os.Stdout, os.Stderr = f, f
+		}
+	}
+	if err := svc.Run(name, windowsService{config: config}); err != nil {
+		fatal("Service error: ", err)
+	}
+	return true
+}
+
+type windowsService struct {
+	config *Config
+}
+
+func (s windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		runDaemon(ctx, s.config, nil)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		case <-done:
+			return false, 0
+		}
+	}
+}
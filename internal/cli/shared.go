@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wildone/localcert"
+)
+
+var flagHostID = flag.String("hostId", "", `when several hosts share the dataDir (on a network share, or with -vaultPath) and its ACME account, this host's name; its certificate, key, orders and state are kept under hosts/<hostId>, and the account file is only replaced if no other host has changed it. "hostname" uses the machine's hostname`)
+
+// sharedHostID returns -hostId, with "hostname" resolved, after checking
+// that it can name a directory.
+func sharedHostID() (string, error) {
+	id := *flagHostID
+	if id == "hostname" {
+		name, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("-hostId: %w", err)
+		}
+		id = strings.ToLower(name)
+	}
+	if id == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(id, ".") || strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", fmt.Errorf("-hostId %q: use letters, digits, '.', '-' and '_'", id)
+	}
+	if *flagStorage == "sqlite" {
+		return "", errors.New("-hostId needs file or dir storage on a shared filesystem, or -vaultPath; SQLite databases can't be shared over the network safely")
+	}
+	return id, nil
+}
+
+// sharedStore returns the store when the account file is shared with other
+// hosts and writes to it must not clobber theirs, and nil otherwise,
+// including for -dryRun.
+func (c *Config) sharedStore() localcert.VersionedStore {
+	if c.HostID == "" {
+		return nil
+	}
+	store, _ := c.store.(localcert.VersionedStore)
+	return store
+}
+
+// readACMEAccountFile reads the account file, remembering its version when
+// it's shared.
+func (c *Config) readACMEAccountFile() ([]byte, error) {
+	store := c.sharedStore()
+	if store == nil {
+		return c.store.ReadFile(c.ACMEAccountFile)
+	}
+	data, version, err := store.ReadFileVersion(c.ACMEAccountFile)
+	if err == nil {
+		c.accountVersion = version
+	}
+	return data, err
+}
+
+// writeACMEAccountData writes the encoded account file. A shared one is only
+// replaced if it's still the version read, so that hosts don't undo each
+// other's registrations and key rotations.
+func (c *Config) writeACMEAccountData(data []byte) error {
+	store := c.sharedStore()
+	if store == nil {
+		return c.store.WriteFile(c.ACMEAccountFile, data, filePerm)
+	}
+	version, err := store.WriteFileIfVersion(c.ACMEAccountFile, data, filePerm, c.accountVersion)
+	if errors.Is(err, localcert.ErrConflict) {
+		return fmt.Errorf("%s was changed by another host: %w", c.ACMEAccountFile, err)
+	} else if err != nil {
+		return err
+	}
+	c.accountVersion = version
+	return nil
+}
+
+// saveRegisteredAccount records the account registered at accountURL. If
+// another host saved the shared account file first, it registered the same
+// key (the key is written before registering), so its file is kept.
+func (c *Config) saveRegisteredAccount(accountURL, acceptedTerms string) error {
+	c.ACME.PrivateKey.KeyID = accountURL
+	c.ACME.AcceptedTerms = acceptedTerms
+	err := c.WriteACMEAccountFile()
+	if !errors.Is(err, localcert.ErrConflict) {
+		return err
+	}
+	key := c.acmeKey
+	if err := c.readOrGenerateACMEAccount(c.ACME.DirectoryURL); err != nil {
+		return err
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(c.acmeKey.Public()) {
+		return fmt.Errorf("another host replaced the account key in %s while registering; run again to use it", c.ACMEAccountFile)
+	}
+	if c.ACME.PrivateKey.KeyID == accountURL && c.ACME.AcceptedTerms == acceptedTerms {
+		debugf("Another host saved account %s first", accountURL)
+		return nil
+	}
+	c.ACME.PrivateKey.KeyID = accountURL
+	c.ACME.AcceptedTerms = acceptedTerms
+	return c.WriteACMEAccountFile()
+}
+
+// refreshSharedAccount re-reads the shared account file if another host has
+// changed it since, as by rotating the key, which would leave this host
+// signing with one the CA no longer accepts.
+func refreshSharedAccount(config *Config) error {
+	store := config.sharedStore()
+	if store == nil {
+		return nil
+	}
+	_, version, err := store.ReadFileVersion(config.ACMEAccountFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read %q: %w", config.ACMEAccountFile, err)
+	}
+	if err == nil && version == config.accountVersion {
+		return nil
+	}
+	infof("Another host changed the shared ACME account in %s; re-reading it", config.ACMEAccountFile)
+	return config.readOrGenerateACMEAccount(config.ACME.DirectoryURL)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var flagShortLived = flag.Bool("shortLived", false, "for CAs issuing certificates valid for days: renew two thirds of the way through the lifetime unless -renewAt is set, and have the daemon check every -checkInterval (default 5m), following the CA's renewal information")
+
+// Defaults for -shortLived. A 6-day certificate then renews with 2 days
+// left.
+const (
+	shortLivedRenewAt       = "2/3"
+	shortLivedCheckInterval = 5 * time.Minute
+)
+
+// renewalBeforeFraction returns the fraction of the lifetime to renew
+// before expiry: from -renewAt, the -shortLived default, or
+// -renewBeforePercent as beforeFraction.
+func renewalBeforeFraction(beforeFraction float64) (float64, error) {
+	at := *flagRenewAt
+	if at == "" {
+		if !*flagShortLived || beforeFraction != 0 {
+			return beforeFraction, nil
+		}
+		at = shortLivedRenewAt
+	} else if beforeFraction != 0 {
+		return 0, errors.New("-renewAt can't be combined with -renewBeforePercent")
+	}
+	fraction, err := parseFraction(at)
+	if err != nil {
+		return 0, fmt.Errorf("-renewAt: %w", err)
+	}
+	return 1 - fraction, nil
+}
+
+// parseFraction parses a fraction strictly between 0 and 1, written as a
+// ratio such as "2/3" or a decimal such as "0.75".
+func parseFraction(s string) (float64, error) {
+	var fraction float64
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, err := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+		den, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+		if err != nil || den == 0 {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+		fraction = num / den
+	} else {
+		var err error
+		if fraction, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return 0, fmt.Errorf("invalid fraction %q", s)
+		}
+	}
+	if fraction <= 0 || fraction >= 1 {
+		return 0, fmt.Errorf("%q is not between 0 and 1", s)
+	}
+	return fraction, nil
+}
+
+// checkInterval returns the longest the daemon sleeps between checks of the
+// renewal time, or 0 to sleep until renewal is due.
+func checkInterval() time.Duration {
+	if *flagCheckInterval == 0 && *flagShortLived {
+		return shortLivedCheckInterval
+	}
+	return *flagCheckInterval
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/wildone/localcert/internal/sshca"
+)
+
+var (
+	flagSSHCA            = flag.String("sshCA", "", "URL of a step-ca SSH CA, such as https://ca.lan, to also keep an SSH certificate from, using the certificate and key as credentials")
+	flagSSHProvisioner   = flag.String("sshProvisioner", "", "name of the -sshCA's X5C provisioner, which must trust the certificate's CA")
+	flagSSHCertType      = flag.String("sshCertType", "host", "type of SSH certificate: host or user")
+	flagSSHPublicKey     = flag.String("sshPublicKey", "", "SSH public key to certify; the certificate is written next to it, as <key>-cert.pub (default /etc/ssh/ssh_host_ecdsa_key.pub for host certificates)")
+	flagSSHPrincipals    = flag.String("sshPrincipals", "", "comma-separated principals of the SSH certificate (default the certificate's names for host certificates, and the current user for user certificates)")
+	flagSSHLifetime      = flag.Duration("sshLifetime", 0, "lifetime of the SSH certificate to ask for (default the provisioner's)")
+	flagSSHPostRenewHook = flag.String("sshPostRenewHook", "", "command to run after writing a new SSH certificate, such as to reload sshd")
+)
+
+// sshRetryInterval is how soon the daemon tries again after failing to
+// renew the SSH certificate.
+const sshRetryInterval = 5 * time.Minute
+
+// updateSSHCert keeps the -sshCA certificate current, signing a new one
+// when it is missing, doesn't match the configured key and principals, or
+// is two thirds through its validity.
+func updateSSHCert(ctx context.Context, config *Config, certChain [][]byte) error {
+	if *flagSSHCA == "" {
+		return nil
+	}
+	req, certFile, err := sshRequest(certChain)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(sshRenewalTime(certFile, req)) {
+		return nil
+	}
+	key, err := config.Manager().CertificateKey()
+	if err != nil {
+		return fmt.Errorf("ssh certificate: %w", err)
+	}
+	client := &sshca.Client{
+		URL:         *flagSSHCA,
+		Provisioner: *flagSSHProvisioner,
+		Chain:       certChain,
+		Key:         key,
+		HTTPClient:  httpClient,
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	cert, err := client.Sign(ctx, req)
+	if err != nil {
+		return fmt.Errorf("ssh certificate: %w", err)
+	}
+	err = writeFileAtomic(certFile, ssh.MarshalAuthorizedKey(cert), 0644)
+	config.auditWrite(certFile, err)
+	if err != nil {
+		return fmt.Errorf("writing ssh certificate %q: %w", certFile, err)
+	}
+	infof("SSH certificate:      %s (expires %s)", certFile, time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	return runHook(config, "sshPostRenew", *flagSSHPostRenewHook, req.KeyID, "LOCALCERT_SSH_CERT_PATH="+certFile)
+}
+
+// untilSSHRenewal returns how long until the -sshCA certificate is due for
+// renewal, and false if there is none to keep.
+func untilSSHRenewal(certChain [][]byte) (time.Duration, bool) {
+	if *flagSSHCA == "" {
+		return 0, false
+	}
+	req, certFile, err := sshRequest(certChain)
+	if err != nil {
+		return sshRetryInterval, true
+	}
+	// Past the renewal time, the last renewal failed
+	if wait := time.Until(sshRenewalTime(certFile, req)); wait > 0 {
+		return wait, true
+	}
+	return sshRetryInterval, true
+}
+
+// sshRequest returns the request for the SSH certificate of the configured
+// key, and the file it goes in.
+func sshRequest(certChain [][]byte) (sshca.Request, string, error) {
+	if *flagSSHProvisioner == "" {
+		return sshca.Request{}, "", ConfigError{Err: errors.New("-sshCA requires -sshProvisioner")}
+	}
+	req := sshca.Request{CertType: *flagSSHCertType, ValidFor: *flagSSHLifetime}
+	keyFile := *flagSSHPublicKey
+	switch req.CertType {
+	case "host":
+		if keyFile == "" {
+			keyFile = "/etc/ssh/ssh_host_ecdsa_key.pub"
+		}
+	case "user":
+		if keyFile == "" {
+			return req, "", ConfigError{Err: errors.New("-sshCertType user requires -sshPublicKey")}
+		}
+	default:
+		return req, "", ConfigError{Err: fmt.Errorf("invalid -sshCertType %q; use host or user", req.CertType)}
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return req, "", fmt.Errorf("ssh public key: %w", err)
+	}
+	if req.PublicKey, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+		return req, "", fmt.Errorf("ssh public key %q: %w", keyFile, err)
+	}
+
+	leaf, err := x509.ParseCertificate(certChain[0])
+	if err != nil {
+		return req, "", err
+	}
+	req.KeyID = leaf.Subject.CommonName
+	for _, principal := range strings.Split(*flagSSHPrincipals, ",") {
+		if principal = strings.TrimSpace(principal); principal != "" {
+			req.Principals = append(req.Principals, principal)
+		}
+	}
+	if len(req.Principals) == 0 && req.CertType == "host" {
+		// Host principals are matched exactly, so wildcards are no use
+		for _, name := range leaf.DNSNames {
+			if !strings.HasPrefix(name, "*.") {
+				req.Principals = append(req.Principals, name)
+			}
+		}
+		for _, ip := range leaf.IPAddresses {
+			req.Principals = append(req.Principals, ip.String())
+		}
+	} else if len(req.Principals) == 0 {
+		u, err := user.Current()
+		if err != nil {
+			return req, "", err
+		}
+		req.Principals = []string{u.Username}
+	}
+	return req, strings.TrimSuffix(keyFile, ".pub") + "-cert.pub", nil
+}
+
+// sshRenewalTime returns when the SSH certificate in certFile is due to be
+// replaced by one for req: two thirds through its validity, or now if it
+// is missing or for another key, type or principals.
+func sshRenewalTime(certFile string, req sshca.Request) time.Time {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return time.Time{}
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return time.Time{}
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || !bytes.Equal(cert.Key.Marshal(), req.PublicKey.Marshal()) {
+		return time.Time{}
+	}
+	if certType := map[string]uint32{"host": ssh.HostCert, "user": ssh.UserCert}[req.CertType]; cert.CertType != certType {
+		return time.Time{}
+	}
+	if !samePrincipals(cert.ValidPrincipals, req.Principals) {
+		return time.Time{}
+	}
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return time.Now().AddDate(100, 0, 0)
+	}
+	validAfter, validBefore := time.Unix(int64(cert.ValidAfter), 0), time.Unix(int64(cert.ValidBefore), 0)
+	return validAfter.Add(validBefore.Sub(validAfter) * 2 / 3)
+}
+
+func samePrincipals(a, b []string) bool {
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+// Exit codes for status
+const (
+	statusOK            = 0
+	statusRenewalDue    = 1
+	statusExpired       = 2
+	statusNoCertificate = 3
+)
+
+type chainCertInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+type statusResult struct {
+	Domain          string          `json:"domain"`
+	Names           []string        `json:"names"`
+	KeyType         string          `json:"keyType"`
+	DaysRemaining   int             `json:"daysRemaining"`
+	Status          string          `json:"status"`
+	OCSPServers     []string        `json:"ocspServers,omitempty"`
+	CRLDistribution []string        `json:"crlDistributionPoints,omitempty"`
+	Chain           []chainCertInfo `json:"chain"`
+}
+
+func Status() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+
+	result, cert, err := certStatus(config)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("No certificate at", config.CertificateFile)
+		printResult(errorResult{Error: "no certificate"})
+		os.Exit(statusNoCertificate)
+	} else if err != nil {
+		fatal("Error ", err)
+	}
+	code := statusOK
+	switch result.Status {
+	case "expired":
+		code = statusExpired
+	case "renewalDue":
+		code = statusRenewalDue
+	}
+
+	fmt.Printf("Domain:      %s\n", result.Domain)
+	fmt.Printf("Names:       %s\n", strings.Join(result.Names, ", "))
+	fmt.Printf("Key type:    %s\n", result.KeyType)
+	fmt.Printf("Expires:     %s (%d days)\n", cert.NotAfter, result.DaysRemaining)
+	fmt.Printf("Status:      %s\n", result.Status)
+	if len(result.OCSPServers) > 0 {
+		fmt.Printf("OCSP:        %s\n", strings.Join(result.OCSPServers, ", "))
+	}
+	if len(result.CRLDistribution) > 0 {
+		fmt.Printf("CRL:         %s\n", strings.Join(result.CRLDistribution, ", "))
+	}
+	fmt.Println("Chain:")
+	for i, c := range result.Chain {
+		fmt.Printf("  %d: %s\n", i, c.Subject)
+		fmt.Printf("     issuer %s, serial %s, expires %s\n", c.Issuer, c.Serial, c.NotAfter)
+	}
+
+	printResult(result)
+	os.Exit(code)
+}
+
+// certStatus describes the current certificate, with an error wrapping
+// os.ErrNotExist if there is none.
+func certStatus(config *Config) (statusResult, *x509.Certificate, error) {
+	certChain, err := config.ReadCertificateChain()
+	if err != nil {
+		return statusResult{}, nil, fmt.Errorf("reading certificate: %w", err)
+	}
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return statusResult{}, nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	cert := certs[0]
+
+	result := statusResult{
+		Domain:          cert.Subject.CommonName,
+		Names:           cert.DNSNames,
+		KeyType:         string(localcert.KeyTypeOf(cert.PublicKey)),
+		DaysRemaining:   int(time.Until(cert.NotAfter).Hours() / 24),
+		Status:          "ok",
+		OCSPServers:     cert.OCSPServer,
+		CRLDistribution: cert.CRLDistributionPoints,
+	}
+	for _, c := range certs {
+		result.Chain = append(result.Chain, chainCertInfo{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			Serial:    c.SerialNumber.Text(16),
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+		})
+	}
+	if time.Now().After(cert.NotAfter) {
+		result.Status = "expired"
+	} else if config.Manager().NeedsRenewal(context.Background(), cert) {
+		result.Status = "renewalDue"
+	}
+	return result, cert, nil
+}
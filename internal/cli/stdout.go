@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagStdout = flag.String("stdout", "", "also print the certificate chain and key on stdout after provisioning: json, pem or env; progress messages go to stderr")
+
+func parseStdoutFormat(s string) (string, error) {
+	switch s {
+	case "", "json", "pem", "env":
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown -stdout format %q", s)
+	}
+}
+
+type stdoutCert struct {
+	Profile     string    `json:"profile,omitempty"`
+	Domain      string    `json:"domain"`
+	Names       []string  `json:"names"`
+	NotAfter    time.Time `json:"notAfter"`
+	Certificate string    `json:"certificate"`
+	Chain       string    `json:"chain"`
+	FullChain   string    `json:"fullchain"`
+	Key         string    `json:"key"`
+}
+
+// writeStdout prints the certificate chain and key in the -stdout format, for
+// containers that consume them without a persistent data directory.
+func writeStdout(config *Config, result *localcert.Result) error {
+	if config.StdoutFormat == "" {
+		return nil
+	}
+	keyPEM, err := config.keyPEM()
+	if err != nil {
+		return err
+	}
+	fullChain := pemutil.EncodePEMChain(pemutil.CertificateType, result.Chain)
+
+	switch config.StdoutFormat {
+	case "json":
+		return json.NewEncoder(jsonOut).Encode(stdoutCert{
+			Profile:     config.Profile,
+			Domain:      result.Domain,
+			Names:       result.Certificate.DNSNames,
+			NotAfter:    result.Certificate.NotAfter,
+			Certificate: string(pemutil.EncodePEMChain(pemutil.CertificateType, result.Chain[:1])),
+			Chain:       string(pemutil.EncodePEMChain(pemutil.CertificateType, result.Chain[1:])),
+			FullChain:   string(fullChain),
+			Key:         string(keyPEM),
+		})
+	case "pem":
+		_, err := jsonOut.Write(append(fullChain, keyPEM...))
+		return err
+	case "env":
+		// dotenv syntax, as read by Docker Compose env_file
+		_, err := fmt.Fprintf(jsonOut, "TLS_DOMAIN=%s\nTLS_CERT=%s\nTLS_KEY=%s\n",
+			strconv.Quote(result.Domain), strconv.Quote(string(fullChain)), strconv.Quote(string(keyPEM)))
+		return err
+	}
+	return nil
+}
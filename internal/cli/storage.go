@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/sqlstore"
+)
+
+var (
+	flagStorage = flag.String("storage", "file", "how to keep the ACME account, key and certificate: file (<dataDir>/profiles/<profile>), dir (<dataDir>/<domain or profile>) or sqlite (one <dataDir>/localcert.db for all profiles)")
+	flagBackups = flag.Int("backups", 1, "number of previous generations of the certificate, key and account files to keep as <file>.bak.N with file storage")
+)
+
+// sqlStores keeps databases open across profiles and config reloads.
+var sqlDBs = map[string]*sql.DB{}
+
+// layoutDataDir returns the directory for the profile's files under
+// baseDir, the -dataDir or its default.
+func layoutDataDir(baseDir, profile string) (string, error) {
+	switch *flagStorage {
+	case "file", "sqlite":
+		// Each profile gets its own account, key and certificate, unless it
+		// sets its own dataDir
+		if profile != "" && *flagDataDir == "" {
+			return filepath.Join(baseDir, "profiles", profile), nil
+		}
+		return baseDir, nil
+	case "dir":
+		name := strings.TrimPrefix(*flagDomain, "*.")
+		if name == "" {
+			name = profile
+		}
+		if name == "" {
+			name = "default"
+		}
+		return filepath.Join(baseDir, name), nil
+	default:
+		return "", fmt.Errorf("unknown -storage %q", *flagStorage)
+	}
+}
+
+// configStore returns where the profile's account, key and certificate are
+// kept, and the directory their names are relative to.
+func configStore(baseDir, dataDir, profile string) (localcert.Store, string, error) {
+	if *flagVaultPath != "" {
+		if *flagStorage != "file" {
+			return nil, "", errors.New("-vaultPath can't be combined with -storage")
+		}
+		store, err := vaultStore(profile)
+		return store, "", err
+	}
+	if *flagStorage != "sqlite" {
+		return localcert.FileStore{Backups: *flagBackups}, dataDir, nil
+	}
+
+	dbFile := filepath.Join(baseDir, "localcert.db")
+	prefix, err := filepath.Rel(baseDir, dataDir)
+	if err != nil {
+		return nil, "", err
+	}
+	db, ok := sqlDBs[dbFile]
+	if !ok && *flagDryRun {
+		// Don't create the database just to find it empty
+		if _, err := os.Stat(dbFile); errors.Is(err, os.ErrNotExist) {
+			return newDryRunStore(nil), "", nil
+		}
+	}
+	if !ok {
+		if db, err = sqlstore.OpenDB(dbFile); err != nil {
+			return nil, "", err
+		}
+		sqlDBs[dbFile] = db
+	}
+	// Profiles share the database, each under its own prefix
+	return &sqlstore.Store{DB: db, Prefix: filepath.ToSlash(prefix)}, "", nil
+}
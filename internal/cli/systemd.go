@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var (
+	flagSystemdDir = flag.String("systemdDir", "/etc/systemd/system", "directory install-systemd writes the service and timer units to")
+	flagOnCalendar = flag.String("onCalendar", "daily", "systemd OnCalendar schedule for the install-systemd renewal timer")
+)
+
+// installFlags configure install-systemd and install-service themselves
+// rather than the installed service, so they aren't passed on to it.
+var installFlags = map[string]bool{"systemdDir": true, "onCalendar": true, "launchdDir": true, "json": true}
+
+var serviceTemplate = template.Must(template.New("service").Parse(`[Unit]
+Description=Renew localcert certificate{{if .Profile}} ({{.Profile}}){{end}}
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory={{.WorkingDirectory}}
+Environment={{.Environment}}
+ExecStart={{.ExecStart}}
+`))
+
+var timerTemplate = template.Must(template.New("timer").Parse(`[Unit]
+Description=Periodically renew localcert certificate{{if .Profile}} ({{.Profile}}){{end}}
+
+[Timer]
+OnCalendar={{.OnCalendar}}
+RandomizedDelaySec=1h
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+type systemdUnit struct {
+	Profile          string
+	WorkingDirectory string
+	Environment      string
+	ExecStart        string
+	OnCalendar       string
+}
+
+func InstallSystemd() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error finding localcert executable: ", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		fatal("Error getting working directory: ", err)
+	}
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		fatal("Error finding user config dir: ", err)
+	}
+
+	args := serviceArgs(exe, "provision")
+	for i, arg := range args {
+		args[i] = systemdQuote(arg)
+	}
+
+	unit := systemdUnit{
+		Profile:          config.Profile,
+		WorkingDirectory: strings.ReplaceAll(wd, "%", "%%"),
+		// Services don't get a HOME, so pin the config and data dir defaults
+		Environment: systemdQuote("XDG_CONFIG_HOME=" + userConfigDir),
+		ExecStart:   strings.Join(args, " "),
+		OnCalendar:  *flagOnCalendar,
+	}
+
+	name := "localcert"
+	if config.Profile != "" {
+		name += "-" + config.Profile
+	}
+	serviceFile := filepath.Join(*flagSystemdDir, name+".service")
+	timerFile := filepath.Join(*flagSystemdDir, name+".timer")
+	if err := writeUnit(serviceFile, serviceTemplate, unit); err != nil {
+		fatalf("Error writing %q: %v", serviceFile, err)
+	}
+	if err := writeUnit(timerFile, timerTemplate, unit); err != nil {
+		fatalf("Error writing %q: %v", timerFile, err)
+	}
+
+	infof("Service unit written to: %s", serviceFile)
+	infof("Timer unit written to:   %s", timerFile)
+	infof("Enable it with: systemctl daemon-reload && systemctl enable --now %s.timer", name)
+	printResult(installSystemdResult{Profile: config.Profile, ServiceFile: serviceFile, TimerFile: timerFile})
+}
+
+// serviceArgs returns the command line that runs subcmd with the same flags
+// as this command.
+func serviceArgs(exe, subcmd string) []string {
+	args := []string{exe}
+	flag.Visit(func(fl *flag.Flag) {
+		if !installFlags[fl.Name] {
+			args = append(args, "-"+fl.Name+"="+fl.Value.String())
+		}
+	})
+	return append(args, subcmd)
+}
+
+type installSystemdResult struct {
+	Profile     string `json:"profile,omitempty"`
+	ServiceFile string `json:"serviceFile"`
+	TimerFile   string `json:"timerFile"`
+}
+
+func writeUnit(name string, tmpl *template.Template, unit systemdUnit) error {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, unit); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, []byte(b.String()), 0644)
+}
+
+// systemdQuote quotes s as a single word of a unit file setting, escaping
+// systemd's specifier and variable expansion.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
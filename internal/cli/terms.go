@@ -10,7 +10,15 @@ import (
 	"github.com/mattn/go-isatty"
 )
 
-var flagAcceptTerms = flag.Bool("acceptTerms", false, "accept ACME provider's terms of service")
+var (
+	flagAcceptTerms    = flag.Bool("acceptTerms", false, "accept ACME provider's terms of service")
+	flagNonInteractive = flag.Bool("nonInteractive", false, "never prompt for terms acceptance, passphrases or confirmation; fail instead, as when not run in a terminal")
+)
+
+// interactive reports whether prompts can be answered.
+func interactive() bool {
+	return !*flagNonInteractive && isatty.IsTerminal(os.Stdin.Fd())
+}
 
 func PromptRequireAcceptTerms(termsURI string) {
 	if !*flagAcceptTerms {
@@ -19,14 +27,13 @@ func PromptRequireAcceptTerms(termsURI string) {
 		fmt.Println("The ACME provder you are registering with requires acceptance of these terms of service:")
 		fmt.Println(termsURI)
 
-		if isatty.IsTerminal(os.Stdin.Fd()) {
+		if interactive() {
 			stdin := bufio.NewReader(os.Stdin)
 			for {
 				fmt.Print("Do you agree? (Y)es/(N)o: ")
 				ans, err := stdin.ReadString('\n')
 				if err != nil {
-					fmt.Print("Error getting prompt response: ", err)
-					os.Exit(2)
+					fatalCode(exitTerms, fmt.Sprint("Error getting prompt response: ", err))
 				}
 				switch strings.ToLower(strings.TrimSpace(ans)) {
 				case "y", "yes":
@@ -34,13 +41,12 @@ func PromptRequireAcceptTerms(termsURI string) {
 					fmt.Println()
 					return
 				case "n", "no":
-					fmt.Print("Terms rejected; exiting...")
-					os.Exit(1)
+					fatalCode(exitTerms, "Terms rejected; exiting...")
 				}
 			}
 		} else {
-			fmt.Println("You can run this command in a supported terminal or pass the -acceptTerms flag.")
-			os.Exit(1)
+			fmt.Println("You can run this command in a supported terminal without -nonInteractive, or pass the -acceptTerms flag.")
+			fatalCode(exitTerms, "Terms of service not accepted")
 		}
 	}
 }
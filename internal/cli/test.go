@@ -1,10 +1,10 @@
 package cli
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"strings"
@@ -17,12 +17,12 @@ func Test() {
 
 	config, err := GetConfig()
 	if err != nil {
-		log.Fatal("Config error: ", err)
+		fatal("Config error: ", err)
 	}
 
 	cert, err := config.ReadCertificate()
 	if err != nil {
-		log.Fatal("Error reading certificate: ", err)
+		fatal("Error reading certificate: ", err)
 	}
 	domain := strings.TrimPrefix(cert.Subject.CommonName, "*.")
 	url := fmt.Sprintf("https://localhost.%s:%d", domain, *flagTestPort)
@@ -36,29 +36,37 @@ func Test() {
 	go func() {
 		l, err := net.Listen("tcp", addr)
 		if err != nil {
-			log.Fatalf("Error listening to %s: %v", addr, err)
+			fatalf("Error listening to %s: %v", addr, err)
 		}
 		wg.Done()
-		log.Fatal(http.ServeTLS(l, nil, config.CertificateFile, config.KeyFile))
+		certSource := config.Manager().CertSource()
+		server := &http.Server{TLSConfig: &tls.Config{GetCertificate: certSource.GetCertificate}}
+		fatal(server.ServeTLS(l, "", ""))
 	}()
 	wg.Wait()
 
-	fmt.Println("Sending self-test request...")
+	infof("Sending self-test request...")
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Fatal("Error: ", err)
+		fatal("Error: ", err)
 	}
 	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		log.Fatal("Error reading response body: ", err)
+		fatal("Error reading response body: ", err)
 	}
 	fmt.Printf("Response: %q\n\n", body)
+	printResult(testResult{URL: url, Response: string(body)})
 
 	fmt.Println("You can test in a browser now or Ctrl-C to exit.")
 	<-(chan struct{})(nil)
 }
 
+type testResult struct {
+	URL      string `json:"url"`
+	Response string `json:"response"`
+}
+
 func handleTest(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("It worked!"))
 }
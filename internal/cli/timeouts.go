@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+var (
+	flagRegistrationTimeout = flag.Duration("registrationTimeout", 2*time.Minute, "time limit for finding or registering the ACME account and getting the assigned domain (0 for none)")
+	flagOrderTimeout        = flag.Duration("orderTimeout", time.Minute, "time limit for creating the ACME order (0 for none)")
+	flagChallengeTimeout    = flag.Duration("challengeTimeout", 10*time.Minute, "time limit for completing the challenges (0 for none)")
+	flagFinalizeTimeout     = flag.Duration("finalizeTimeout", 5*time.Minute, "time limit for the CA to issue the certificate once the challenges pass (0 for none)")
+	flagDownloadTimeout     = flag.Duration("downloadTimeout", time.Minute, "time limit for downloading the issued certificate again if the first download fails (0 for none)")
+)
+
+func timeouts() localcert.Timeouts {
+	return localcert.Timeouts{
+		Registration: *flagRegistrationTimeout,
+		Order:        *flagOrderTimeout,
+		Challenge:    *flagChallengeTimeout,
+		Finalize:     *flagFinalizeTimeout,
+		Download:     *flagDownloadTimeout,
+	}
+}
+
+// interruptContext returns a context that is cancelled by the first Ctrl-C
+// or SIGTERM, so that an issuance in progress can clean up after itself. A
+// second one kills the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
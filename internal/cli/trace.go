@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const maxTraceBody = 8 << 10
+
+// redactedHeaders carry credentials.
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true, "Set-Cookie": true}
+
+// redactedFields are JWS signatures and private JWK members.
+var redactedFields = map[string]bool{"signature": true, "d": true, "p": true, "q": true, "dp": true, "dq": true, "qi": true, "k": true}
+
+// traceTransport logs each request and response at debug level, for
+// diagnosing ACME and challenge failures.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	debugf("> %s %s\n%s%s", req.Method, req.URL, traceHeaders(req.Header), traceBody(reqBody))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		debugf("< %s %s: %v", req.Method, req.URL, err)
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		return nil, err
+	}
+	debugf("< %s %s: %s\n%s%s", req.Method, req.URL, resp.Status, traceHeaders(resp.Header), traceBody(respBody))
+	return resp, nil
+}
+
+func traceHeaders(h http.Header) string {
+	var names []string
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redactedHeaders[name] {
+			value = "REDACTED"
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", name, value)
+	}
+	return b.String()
+}
+
+// traceBody formats a body for the log, decoding JWS requests so that
+// their header and payload are readable.
+func traceBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	if json.Unmarshal(body, &v) == nil {
+		v = decodeJWS(redact(v))
+		if pretty, err := json.MarshalIndent(v, "  ", "  "); err == nil {
+			body = pretty
+		}
+	}
+	if len(body) > maxTraceBody {
+		return fmt.Sprintf("  %s\n  ... (%d bytes)", body[:maxTraceBody], len(body))
+	}
+	return "  " + string(body)
+}
+
+// decodeJWS replaces the base64url protected header and payload of a
+// flattened JWS with their JSON.
+func decodeJWS(v interface{}) interface{} {
+	jws, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for _, field := range []string{"protected", "payload"} {
+		s, ok := jws[field].(string)
+		if !ok {
+			continue
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			continue
+		}
+		var inner interface{}
+		if len(decoded) == 0 {
+			jws[field] = ""
+		} else if json.Unmarshal(decoded, &inner) == nil {
+			// Key rollover payloads are JWSs themselves
+			jws[field] = decodeJWS(redact(inner))
+		}
+	}
+	return jws
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if redactedFields[key] {
+				v[key] = "REDACTED"
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
+}
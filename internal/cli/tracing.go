@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/wildone/localcert/internal/otlp"
+)
+
+var flagOTLPEndpoint = flag.String("otlpEndpoint", "", "OpenTelemetry collector to export traces of provisioning to with OTLP over HTTP, such as http://localhost:4318 (default OTEL_EXPORTER_OTLP_ENDPOINT from the environment)")
+
+// tracer exports the spans of provisioning, if an OTLP endpoint is
+// configured.
+var tracer *otlp.Exporter
+
+// initTracing sets tracer from -otlpEndpoint and the standard OTEL_*
+// environment variables.
+func initTracing() error {
+	tracer = nil
+	endpoint := otlpTracesEndpoint()
+	if endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("OTLP endpoint %q: must be an http:// or https:// URL", endpoint)
+	}
+	headers, err := parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "localcert"
+	}
+	tracer = &otlp.Exporter{Endpoint: endpoint, Headers: headers, ServiceName: serviceName, Logf: warnf}
+	return nil
+}
+
+// otlpTracesEndpoint returns the URL to export traces to: the /v1/traces
+// path of -otlpEndpoint or OTEL_EXPORTER_OTLP_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT as is.
+func otlpTracesEndpoint() string {
+	base := *flagOTLPEndpoint
+	if base == "" {
+		if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+			return endpoint
+		}
+		base = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if base == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/v1/traces"
+}
+
+// parseOTLPHeaders parses comma-separated name=value pairs with
+// URL-encoded values.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: want name=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", pair, err)
+		}
+		headers[strings.TrimSpace(pair[:i])] = value
+	}
+	return headers, nil
+}
+
+// phaseSpan traces a phase of issuance for the localcert.Client.
+func phaseSpan(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, name, otlp.KindInternal)
+	return ctx, span.End
+}
+
+// spanTransport traces each ACME and localcert server request, passing
+// the trace on to the server in a traceparent header.
+type spanTransport struct {
+	next http.RoundTripper
+}
+
+func (t spanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), req.Method, otlp.KindClient)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.Redacted())
+	span.SetAttribute("server.address", req.URL.Hostname())
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Traceparent())
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		span.SetAttribute("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			span.End(fmt.Errorf("%s", resp.Status))
+			return resp, nil
+		}
+	}
+	span.End(err)
+	return resp, err
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+var flagTrustCert = flag.String("trustCert", "", "PEM file of the root CA certificate for trust install and uninstall (default the root of the certificate's chain, fetched from its issuer if the CA doesn't include it)")
+
+// maxIssuerFetches bounds following issuer URLs up to the root.
+const maxIssuerFetches = 5
+
+type trustResult struct {
+	Action  string `json:"action"`
+	Subject string `json:"subject"`
+	SHA256  string `json:"sha256"`
+}
+
+// Trust installs the root CA of the certificate into, or removes it from,
+// the system trust store, for private CAs whose certificates browsers and
+// other clients would otherwise reject.
+func Trust() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+	action := flag.Arg(1)
+	switch action {
+	case "install", "uninstall":
+	case "":
+		fatal("Usage: localcert trust install|uninstall")
+	default:
+		fatalf("Invalid trust subcommand %q", action)
+	}
+
+	root, err := trustRoot(config)
+	if err != nil {
+		fatal("Error finding the root CA certificate: ", err)
+	}
+	sum := sha256.Sum256(root.Raw)
+	result := trustResult{Action: action, Subject: root.Subject.String(), SHA256: hex.EncodeToString(sum[:])}
+	if action == "install" {
+		if systemTrusts(root) {
+			infof("%s is already trusted by the system; not installing it", root.Subject)
+			result.Action = "none"
+			printResult(result)
+			return
+		}
+		if err := installTrust(root, trustName(root), *flagCertStoreLocation); err != nil {
+			fatal("Error installing the root CA certificate: ", err)
+		}
+		infof("Installed %s in the system trust store", root.Subject)
+	} else {
+		err := uninstallTrust(root, trustName(root), *flagCertStoreLocation)
+		if errors.Is(err, errNotTrusted) {
+			infof("%s isn't in the system trust store", root.Subject)
+			result.Action = "none"
+		} else if err != nil {
+			fatal("Error removing the root CA certificate: ", err)
+		} else {
+			infof("Removed %s from the system trust store", root.Subject)
+		}
+	}
+	printResult(result)
+}
+
+// trustRoot returns the -trustCert, or the self-signed root the
+// certificate chains to, following the issuer URL of the topmost
+// certificate if the chain stops short of it.
+func trustRoot(config *Config) (*x509.Certificate, error) {
+	if *flagTrustCert != "" {
+		der, err := pemutil.ReadPEMChainFile(*flagTrustCert, pemutil.CertificateType)
+		if err != nil {
+			return nil, fmt.Errorf("-trustCert: %w", err)
+		}
+		if len(der) != 1 {
+			return nil, fmt.Errorf("-trustCert: want 1 certificate, got %d", len(der))
+		}
+		return x509.ParseCertificate(der[0])
+	}
+
+	certChain, err := config.ReadCertificateChain()
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseChain(certChain)
+	if err != nil {
+		return nil, err
+	}
+	top := certs[len(certs)-1]
+	for i := 0; !selfSigned(top); i++ {
+		if len(top.IssuingCertificateURL) == 0 || i == maxIssuerFetches {
+			return nil, fmt.Errorf("the chain ends at %s, which isn't a root, without an issuer URL to fetch it from; pass the root with -trustCert", top.Subject)
+		}
+		issuer, err := fetchIssuer(top.IssuingCertificateURL[0])
+		if err != nil {
+			return nil, fmt.Errorf("fetching the issuer of %s: %w", top.Subject, err)
+		}
+		if err := top.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("the issuer fetched from %s didn't sign %s: %w", top.IssuingCertificateURL[0], top.Subject, err)
+		}
+		top = issuer
+	}
+	return top, nil
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// fetchIssuer downloads a DER or PEM certificate.
+func fetchIssuer(url string) (*x509.Certificate, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// systemTrusts reports whether the system already trusts root, as for the
+// roots of public CAs.
+func systemTrusts(root *x509.Certificate) bool {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return false
+	}
+	_, err = root.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err == nil
+}
+
+// trustName names the installed root, so that uninstall finds it.
+func trustName(root *x509.Certificate) string {
+	sum := sha256.Sum256(root.Raw)
+	return "localcert-" + hex.EncodeToString(sum[:8])
+}
+
+// sha1Thumbprint is how the Windows and macOS stores identify certificates.
+func sha1Thumbprint(cert *x509.Certificate) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sha1.Sum(cert.Raw)))
+}
+
+var errNotTrusted = errors.New("it isn't installed")
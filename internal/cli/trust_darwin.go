@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+func installTrust(root *x509.Certificate, name, keychain string) error {
+	if keychain == "" {
+		keychain = systemKeychain
+	}
+	dir, err := os.MkdirTemp("", "localcert-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, name+".pem")
+	if err := pemutil.WritePEMFile(file, pemutil.CertificateType, root.Raw, 0600); err != nil {
+		return err
+	}
+	if out, err := exec.Command("security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", keychain, file).CombinedOutput(); err != nil {
+		return fmt.Errorf("security add-trusted-cert: %w: %s", err, out)
+	}
+	return nil
+}
+
+func uninstallTrust(root *x509.Certificate, name, keychain string) error {
+	if keychain == "" {
+		keychain = systemKeychain
+	}
+	out, err := exec.Command("security", "delete-certificate", "-t", "-Z", sha1Thumbprint(root), keychain).CombinedOutput()
+	if err != nil && bytes.Contains(out, []byte("could not be found")) {
+		return errNotTrusted
+	} else if err != nil {
+		return fmt.Errorf("security delete-certificate: %w: %s", err, out)
+	}
+	return nil
+}
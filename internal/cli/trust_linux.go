@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/wildone/localcert/internal/pemutil"
+)
+
+// linuxTrustStores are the anchor directories of the distributions' CA
+// certificate tools: Debian and Ubuntu, Fedora and RHEL, then Arch.
+var linuxTrustStores = []struct {
+	dir    string
+	update []string
+}{
+	{"/usr/local/share/ca-certificates", []string{"update-ca-certificates", "--fresh"}},
+	{"/etc/pki/ca-trust/source/anchors", []string{"update-ca-trust", "extract"}},
+	{"/etc/ca-certificates/trust-source/anchors", []string{"update-ca-trust", "extract"}},
+}
+
+func linuxTrustStore() (dir string, update []string, err error) {
+	for _, store := range linuxTrustStores {
+		if _, err := os.Stat(store.dir); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(store.update[0]); err != nil {
+			continue
+		}
+		return store.dir, store.update, nil
+	}
+	return "", nil, errors.New("no ca-certificates or ca-trust installation found")
+}
+
+func installTrust(root *x509.Certificate, name, location string) error {
+	dir, update, err := linuxTrustStore()
+	if err != nil {
+		return err
+	}
+	// update-ca-certificates only picks up .crt files
+	file := filepath.Join(dir, name+".crt")
+	if err := pemutil.WritePEMFile(file, pemutil.CertificateType, root.Raw, 0644); err != nil {
+		return err
+	}
+	return runTrustUpdate(update)
+}
+
+func uninstallTrust(root *x509.Certificate, name, location string) error {
+	dir, update, err := linuxTrustStore()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, name+".crt")); errors.Is(err, os.ErrNotExist) {
+		return errNotTrusted
+	} else if err != nil {
+		return err
+	}
+	return runTrustUpdate(update)
+}
+
+func runTrustUpdate(update []string) error {
+	if out, err := exec.Command(update[0], update[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", update[0], err, out)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package cli
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+var errTrustUnsupported = errors.New("trust is only supported on Linux, macOS and Windows")
+
+func installTrust(root *x509.Certificate, name, location string) error {
+	return errTrustUnsupported
+}
+
+func uninstallTrust(root *x509.Certificate, name, location string) error {
+	return errTrustUnsupported
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// certutilArgs returns certutil's arguments for the Root store of the
+// given location.
+func certutilArgs(location string, args ...string) ([]string, error) {
+	switch location {
+	case "", "LocalMachine":
+		return args, nil
+	case "CurrentUser":
+		return append([]string{"-user"}, args...), nil
+	}
+	return nil, fmt.Errorf("unknown store location %q; use LocalMachine or CurrentUser", location)
+}
+
+func installTrust(root *x509.Certificate, name, location string) error {
+	dir, err := os.MkdirTemp("", "localcert-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, name+".cer")
+	if err := os.WriteFile(file, root.Raw, 0600); err != nil {
+		return err
+	}
+	args, err := certutilArgs(location, "-addstore", "-f", "Root", file)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("certutil", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("certutil: %w: %s", err, out)
+	}
+	return nil
+}
+
+func uninstallTrust(root *x509.Certificate, name, location string) error {
+	args, err := certutilArgs(location, "-delstore", "Root", sha1Thumbprint(root))
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("certutil", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("certutil: %w: %s", err, out)
+	}
+	return nil
+}
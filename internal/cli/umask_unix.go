@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import "syscall"
+
+func setUmask(mask int) error {
+	syscall.Umask(mask)
+	return nil
+}
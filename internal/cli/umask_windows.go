@@ -0,0 +1,7 @@
+package cli
+
+import "errors"
+
+func setUmask(int) error {
+	return errors.New("-umask isn't supported on Windows")
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"path"
+
+	"github.com/wildone/localcert"
+	"github.com/wildone/localcert/internal/vault"
+)
+
+var flagVaultPath = flag.String("vaultPath", "", "Vault KV v2 mount and path, e.g. secret/localcert, to keep the ACME account, key and certificate in instead of dataDir (uses VAULT_ADDR and VAULT_TOKEN)")
+
+// vaultStore returns the profile's Vault store, mirroring the data dir
+// layout under -vaultPath.
+func vaultStore(profile string) (localcert.Store, error) {
+	vaultPath := *flagVaultPath
+	if profile != "" {
+		vaultPath = path.Join(vaultPath, "profiles", profile)
+	}
+	if sandbox := sandboxDir(); sandbox != "" {
+		vaultPath = path.Join(vaultPath, sandbox)
+	}
+	store, err := vault.NewStore(vaultPath)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/wildone/localcert"
+)
+
+var flagVerifyConnect = flag.String("connect", "", "host:port of the TLS endpoint to verify")
+
+func Verify() {
+	config, err := GetConfig()
+	if err != nil {
+		fatal("Config error: ", err)
+	}
+
+	cert, err := config.ReadCertificate()
+	if err != nil {
+		fatal("Error reading certificate: ", err)
+	}
+
+	addr := *flagVerifyConnect
+	if addr == "" {
+		fatal("Missing -connect host:port")
+	}
+
+	infof("Connecting to %s...", addr)
+	state, err := dialEndpoint(addr)
+	if err != nil {
+		fatal("Error connecting: ", err)
+	}
+
+	served := state.PeerCertificates[0]
+	result := verifyResult{
+		Endpoint:       addr,
+		ServedSerial:   served.SerialNumber.Text(16),
+		ServedNotAfter: served.NotAfter,
+		MustStaple:     localcert.HasMustStaple(served),
+		OCSPStapled:    len(state.OCSPResponse) > 0,
+	}
+	if !bytes.Equal(served.Raw, cert.Raw) {
+		fmt.Printf("Endpoint is serving a different certificate (serial %s, expires %s)\n", served.SerialNumber, served.NotAfter)
+		result.exit()
+	}
+	result.Current = true
+	fmt.Println("Endpoint is serving the current certificate")
+
+	if !result.OCSPStapled {
+		if result.MustStaple {
+			fmt.Println("Certificate requires OCSP stapling (must-staple) but the endpoint did not staple a response")
+			result.exit()
+		}
+		fmt.Println("Endpoint did not staple an OCSP response")
+		result.OK = true
+		printResult(result)
+		return
+	}
+
+	// Without the issuer, the response's signature can't be checked
+	issuer := findIssuer(served, state.PeerCertificates[1:])
+	if issuer == nil {
+		if chain, err := config.ReadCertificateChain(); err == nil {
+			if certs, err := parseChain(chain); err == nil {
+				issuer = findIssuer(served, certs[1:])
+			}
+		}
+	}
+	if issuer == nil {
+		fmt.Println("Can't verify the stapled OCSP response: the issuer of the certificate is in neither the served nor the stored chain")
+		result.exit()
+	}
+	resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, served, issuer)
+	if err != nil {
+		fatal("Invalid stapled OCSP response: ", err)
+	}
+
+	result.OCSPStatus = ocspStatusString(resp.Status)
+	result.OCSPNextUpdate = &resp.NextUpdate
+	fmt.Printf("Stapled OCSP response status: %s\n", result.OCSPStatus)
+	fmt.Printf("Stapled OCSP response next update: %s\n", resp.NextUpdate)
+	if resp.Status != ocsp.Good {
+		result.exit()
+	}
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		fmt.Println("Stapled OCSP response is stale")
+		result.exit()
+	}
+	result.OK = true
+	printResult(result)
+}
+
+type verifyResult struct {
+	Endpoint       string     `json:"endpoint"`
+	OK             bool       `json:"ok"`
+	Current        bool       `json:"current"`
+	ServedSerial   string     `json:"servedSerial"`
+	ServedNotAfter time.Time  `json:"servedNotAfter"`
+	MustStaple     bool       `json:"mustStaple"`
+	OCSPStapled    bool       `json:"ocspStapled"`
+	OCSPStatus     string     `json:"ocspStatus,omitempty"`
+	OCSPNextUpdate *time.Time `json:"ocspNextUpdate,omitempty"`
+}
+
+// exit reports a failed verification.
+func (r verifyResult) exit() {
+	printResult(r)
+	os.Exit(1)
+}
+
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// findIssuer returns the certificate among candidates that signed cert, or
+// nil if there is none.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
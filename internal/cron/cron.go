@@ -0,0 +1,176 @@
+// Package cron parses cron expressions and finds the times they match.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Days match either field when both are restricted, and both
+	// otherwise, as in Vixie cron.
+	domStar, dowStar bool
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Parse parses a five-field expression of minute, hour, day of month,
+// month and day of week, such as "30 3 * * mon-fri". Fields take *, lists,
+// ranges, steps, and month and weekday names; day of week 7 is Sunday too.
+// The macros @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly stand for their expressions.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(fields))
+	}
+	s := &Schedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	parsers := []struct {
+		bits     *uint64
+		name     string
+		min, max int
+		names    []string
+		nameBase int
+	}{
+		{&s.minute, "minute", 0, 59, nil, 0},
+		{&s.hour, "hour", 0, 23, nil, 0},
+		{&s.dom, "day of month", 1, 31, nil, 0},
+		{&s.month, "month", 1, 12, monthNames, 1},
+		{&s.dow, "day of week", 0, 7, weekdayNames, 0},
+	}
+	for i, p := range parsers {
+		if *p.bits, err = parseField(fields[i], p.min, p.max, p.names, p.nameBase); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s: %w", expr, p.name, err)
+		}
+	}
+	// Sunday is 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField parses a comma-separated list of *, values and ranges, each
+// with an optional /step, into a bit set of the values it matches.
+func parseField(field string, min, max int, names []string, nameBase int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			i := strings.Index(rangePart, "-")
+			var err error
+			if lo, err = parseValue(rangePart[:i], min, max, names, nameBase); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(rangePart[i+1:], min, max, names, nameBase); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q goes backwards", rangePart)
+			}
+		default:
+			var err error
+			if lo, err = parseValue(rangePart, min, max, names, nameBase); err != nil {
+				return 0, err
+			}
+			// "5/15" runs from 5 to the end
+			if !strings.Contains(part, "/") {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, min, max int, names []string, nameBase int) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i + nameBase, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the first minute after t that s matches, in t's location,
+// or the zero time if none does within five years, as for February 30.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// Daylight saving changes can map a wall clock time to an earlier
+		// instant
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
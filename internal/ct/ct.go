@@ -0,0 +1,218 @@
+// Package ct checks the Signed Certificate Timestamps (RFC 6962) a CA
+// embeds in certificates, optionally verifying them against the public keys
+// of known Certificate Transparency logs.
+package ct
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// SCT is a log's promise to include a (pre)certificate.
+type SCT struct {
+	LogID      [32]byte
+	Timestamp  time.Time
+	extensions []byte
+	hashAlg    uint8
+	sigAlg     uint8
+	signature  []byte
+	timestamp  uint64
+}
+
+// Log is a CT log from a log list.
+type Log struct {
+	Description string
+	URL         string
+	Key         crypto.PublicKey
+}
+
+// ParseLogList parses a log list in the v3 JSON format browsers use, such as
+// https://www.gstatic.com/ct/log_list/v3/log_list.json, keyed by log ID.
+func ParseLogList(data []byte) (map[[32]byte]*Log, error) {
+	var list struct {
+		Operators []struct {
+			Logs []struct {
+				Description string `json:"description"`
+				Key         []byte `json:"key"`
+				URL         string `json:"url"`
+			} `json:"logs"`
+		} `json:"operators"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode log list: %w", err)
+	}
+	logs := map[[32]byte]*Log{}
+	for _, operator := range list.Operators {
+		for _, l := range operator.Logs {
+			key, err := x509.ParsePKIXPublicKey(l.Key)
+			if err != nil {
+				return nil, fmt.Errorf("log %q: %w", l.Description, err)
+			}
+			logs[sha256.Sum256(l.Key)] = &Log{Description: l.Description, URL: l.URL, Key: key}
+		}
+	}
+	if len(logs) == 0 {
+		return nil, errors.New("log list has no logs")
+	}
+	return logs, nil
+}
+
+// EmbeddedSCTs returns the SCTs embedded in cert.
+func EmbeddedSCTs(cert *x509.Certificate) ([]*SCT, error) {
+	var ext []byte
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oidSCTList) {
+			ext = e.Value
+		}
+	}
+	if ext == nil {
+		return nil, nil
+	}
+	var list []byte
+	if _, err := asn1.Unmarshal(ext, &list); err != nil {
+		return nil, fmt.Errorf("SCT list: %w", err)
+	}
+	s := cryptobyte.String(list)
+	var scts cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&scts) || !s.Empty() {
+		return nil, errors.New("malformed SCT list")
+	}
+	var result []*SCT
+	for !scts.Empty() {
+		var raw cryptobyte.String
+		if !scts.ReadUint16LengthPrefixed(&raw) {
+			return nil, errors.New("malformed SCT list")
+		}
+		sct, err := parseSCT(raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sct)
+	}
+	return result, nil
+}
+
+func parseSCT(s cryptobyte.String) (*SCT, error) {
+	var sct SCT
+	var version uint8
+	var logID, timestamp, extensions, signature []byte
+	if !s.ReadUint8(&version) {
+		return nil, errors.New("malformed SCT")
+	}
+	if version != 0 {
+		return nil, fmt.Errorf("unsupported SCT version %d", version)
+	}
+	if !s.ReadBytes(&logID, 32) ||
+		!s.ReadBytes(&timestamp, 8) ||
+		!s.ReadUint16LengthPrefixed((*cryptobyte.String)(&extensions)) ||
+		!s.ReadUint8(&sct.hashAlg) ||
+		!s.ReadUint8(&sct.sigAlg) ||
+		!s.ReadUint16LengthPrefixed((*cryptobyte.String)(&signature)) ||
+		!s.Empty() {
+		return nil, errors.New("malformed SCT")
+	}
+	copy(sct.LogID[:], logID)
+	sct.timestamp = binary.BigEndian.Uint64(timestamp)
+	sct.Timestamp = time.Unix(0, int64(sct.timestamp)*int64(time.Millisecond)).UTC()
+	sct.extensions, sct.signature = extensions, signature
+	return &sct, nil
+}
+
+// Verify checks that sct is log's signature over the precertificate of
+// cert, which issuer signed.
+func (sct *SCT) Verify(log *Log, cert, issuer *x509.Certificate) error {
+	tbs, err := precertTBS(cert.RawTBSCertificate)
+	if err != nil {
+		return err
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(0) // v1
+	b.AddUint8(0) // certificate_timestamp
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], sct.timestamp)
+	b.AddBytes(timestamp[:])
+	b.AddUint16(1) // precert_entry
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sct.extensions) })
+	signed, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// Logs sign with SHA-256 and ECDSA or RSA
+	if sct.hashAlg != 4 {
+		return fmt.Errorf("unsupported SCT hash algorithm %d", sct.hashAlg)
+	}
+	digest := sha256.Sum256(signed)
+	switch key := log.Key.(type) {
+	case *ecdsa.PublicKey:
+		if sct.sigAlg != 3 || !ecdsa.VerifyASN1(key, digest[:], sct.signature) {
+			return errors.New("invalid SCT signature")
+		}
+	case *rsa.PublicKey:
+		if sct.sigAlg != 1 || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.signature) != nil {
+			return errors.New("invalid SCT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported log key %T", log.Key)
+	}
+	return nil
+}
+
+// precertTBS returns tbs without its SCT list extension, which is what the
+// log signed.
+func precertTBS(tbs []byte) ([]byte, error) {
+	var fields []asn1.RawValue
+	if rest, err := asn1.Unmarshal(tbs, &fields); err != nil || len(rest) > 0 {
+		return nil, errors.New("malformed TBSCertificate")
+	}
+	last := &fields[len(fields)-1]
+	if last.Class != asn1.ClassContextSpecific || last.Tag != 3 {
+		return nil, errors.New("certificate has no extensions")
+	}
+	var exts []asn1.RawValue
+	if _, err := asn1.Unmarshal(last.Bytes, &exts); err != nil {
+		return nil, fmt.Errorf("extensions: %w", err)
+	}
+	var kept []byte
+	for _, raw := range exts {
+		var ext pkix.Extension
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ext); err != nil {
+			return nil, fmt.Errorf("extension: %w", err)
+		}
+		if !ext.Id.Equal(oidSCTList) {
+			kept = append(kept, raw.FullBytes...)
+		}
+	}
+	extSeq, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+	if err != nil {
+		return nil, err
+	}
+	*last = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: extSeq}
+
+	var content []byte
+	for _, field := range fields {
+		encoded, err := asn1.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, encoded...)
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+}
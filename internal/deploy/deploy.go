@@ -0,0 +1,251 @@
+// Package deploy pushes certificate files to remote targets: hosts over
+// SSH, S3 buckets and HTTP endpoints that accept PUT.
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert/internal/awssig"
+)
+
+// File is a file to deploy.
+type File struct {
+	Name string
+	Data []byte
+	// Secret files, such as the key, are only readable by their owner.
+	Secret bool
+}
+
+// Target receives the certificate files after each renewal.
+type Target interface {
+	Deploy(ctx context.Context, files []File) error
+}
+
+// Parse returns the target for a URL:
+//
+//	ssh://user@host[:port]/dir?reload=<command>&identity=<key file>
+//	s3://bucket/prefix?region=<region>&endpoint=<URL>
+//	https://host/path/ (each file is PUT to the URL plus its name)
+func Parse(spec string, httpClient *http.Client) (Target, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	switch u.Scheme {
+	case "ssh", "scp", "sftp":
+		if u.Host == "" || u.Path == "" {
+			return nil, fmt.Errorf("%s: expected ssh://[user@]host[:port]/dir", spec)
+		}
+		return &SSH{
+			User:     u.User.Username(),
+			Host:     u.Hostname(),
+			Port:     u.Port(),
+			Dir:      u.Path,
+			Reload:   query.Get("reload"),
+			Identity: query.Get("identity"),
+		}, nil
+	case "s3":
+		region := query.Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		target := &S3{
+			Bucket:          u.Host,
+			Prefix:          strings.TrimPrefix(u.Path, "/"),
+			Region:          region,
+			Endpoint:        query.Get("endpoint"),
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			HTTPClient:      httpClient,
+		}
+		if target.AccessKeyID == "" || target.SecretAccessKey == "" {
+			return nil, errors.New("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+		}
+		return target, nil
+	case "http", "https":
+		return &HTTP{URL: spec, Token: os.Getenv("LOCALCERT_DEPLOY_TOKEN"), HTTPClient: httpClient}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown deploy target type %q", spec, u.Scheme)
+}
+
+// SSH copies the files into a directory on a host with scp, then runs the
+// reload command there. Authentication and host keys are up to the ssh
+// configuration of the user running localcert.
+type SSH struct {
+	User     string
+	Host     string
+	Port     string
+	Dir      string
+	Reload   string
+	Identity string
+}
+
+func (t *SSH) Deploy(ctx context.Context, files []File) error {
+	tmp, err := os.MkdirTemp("", "localcert-deploy-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	args := t.options("-P")
+	args = append(args, "-p")
+	for _, file := range files {
+		perm := os.FileMode(0644)
+		if file.Secret {
+			perm = 0600
+		}
+		name := filepath.Join(tmp, file.Name)
+		if err := os.WriteFile(name, file.Data, perm); err != nil {
+			return err
+		}
+		args = append(args, name)
+	}
+	args = append(args, t.destination()+":"+strings.TrimSuffix(t.Dir, "/")+"/")
+	if err := run(ctx, "scp", args...); err != nil {
+		return err
+	}
+	if t.Reload == "" {
+		return nil
+	}
+	args = append(t.options("-p"), t.destination(), t.Reload)
+	return run(ctx, "ssh", args...)
+}
+
+func (t *SSH) options(portFlag string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if t.Port != "" {
+		args = append(args, portFlag, t.Port)
+	}
+	if t.Identity != "" {
+		args = append(args, "-i", t.Identity)
+	}
+	return args
+}
+
+func (t *SSH) destination() string {
+	if t.User != "" {
+		return t.User + "@" + t.Host
+	}
+	return t.Host
+}
+
+func run(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// S3 uploads the files to a bucket under a prefix. Endpoint, if set,
+// points at an S3-compatible service instead of AWS, with path-style URLs.
+type S3 struct {
+	Bucket          string
+	Prefix          string
+	Region          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client
+}
+
+func (t *S3) Deploy(ctx context.Context, files []File) error {
+	for _, file := range files {
+		key := path.Join(t.Prefix, file.Name)
+		var objectURL string
+		if t.Endpoint != "" {
+			objectURL = strings.TrimSuffix(t.Endpoint, "/") + "/" + t.Bucket + "/" + key
+		} else {
+			objectURL = "https://" + t.Bucket + ".s3." + t.Region + ".amazonaws.com/" + key
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", objectURL, bytes.NewReader(file.Data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType(file.Name))
+		awssig.Sign(req, file.Data, t.AccessKeyID, t.SecretAccessKey, t.SessionToken, t.Region, "s3", time.Now())
+		if err := do(t.HTTPClient, req); err != nil {
+			return fmt.Errorf("s3: put %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// HTTP PUTs each file to URL followed by the file name, authenticating
+// with Token as a bearer token if set, or with the URL's user info.
+type HTTP struct {
+	URL        string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func (t *HTTP) Deploy(ctx context.Context, files []File) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	base := *u
+	base.User = nil
+	for _, file := range files {
+		fileURL := strings.TrimSuffix(base.String(), "/") + "/" + url.PathEscape(file.Name)
+		req, err := http.NewRequestWithContext(ctx, "PUT", fileURL, bytes.NewReader(file.Data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType(file.Name))
+		if t.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+t.Token)
+		} else if password, ok := u.User.Password(); ok {
+			req.SetBasicAuth(u.User.Username(), password)
+		}
+		if err := do(t.HTTPClient, req); err != nil {
+			return fmt.Errorf("put %s: %w", fileURL, err)
+		}
+	}
+	return nil
+}
+
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func contentType(name string) string {
+	if strings.HasSuffix(name, ".pem") {
+		return "application/x-pem-file"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,16 @@
+//go:build !cgo
+// +build !cgo
+
+package hsm
+
+import (
+	"crypto"
+	"errors"
+
+	"github.com/wildone/localcert"
+)
+
+// Open isn't available without cgo, which loading PKCS #11 modules needs.
+func Open(uri string, keyType localcert.KeyType) (crypto.Signer, error) {
+	return nil, errors.New("PKCS #11 keys need a localcert built with cgo")
+}
@@ -0,0 +1,335 @@
+//go:build cgo
+// +build cgo
+
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/wildone/localcert"
+)
+
+var (
+	oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// Signer is a private key on a PKCS #11 token.
+type Signer struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	public  crypto.PublicKey
+}
+
+// Open returns the private key identified by a PKCS #11 URI, generating a
+// key of keyType on the token if there isn't one. Generated keys are
+// marked sensitive and non-extractable, so they never leave the token.
+func Open(uri string, keyType localcert.KeyType) (crypto.Signer, error) {
+	u, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(u.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("load PKCS #11 module %q", u.ModulePath)
+	}
+	if err := ctx.Initialize(); err != nil && !isError(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, fmt.Errorf("initialize PKCS #11 module: %w", err)
+	}
+
+	slot, err := findSlot(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("open PKCS #11 session: %w", err)
+	}
+	if u.PIN != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, u.PIN); err != nil && !isError(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			return nil, fmt.Errorf("PKCS #11 login: %w", err)
+		}
+	}
+
+	s := &Signer{ctx: ctx, session: session}
+	privateKey, err := s.findObject(pkcs11.CKO_PRIVATE_KEY, u)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey == nil {
+		if err := s.generate(u, keyType); err != nil {
+			return nil, fmt.Errorf("generate key on PKCS #11 token: %w", err)
+		}
+		return s, nil
+	}
+	s.key = *privateKey
+
+	publicKey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, u)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil {
+		return nil, errors.New("PKCS #11 token has no public key for the private key")
+	}
+	if s.public, err = s.readPublicKey(*publicKey); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, u *URI) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("list PKCS #11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		if u.SlotID >= 0 && uint(u.SlotID) != slot {
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("PKCS #11 token info: %w", err)
+		}
+		if (u.Token == "" || u.Token == info.Label) && (u.Serial == "" || u.Serial == info.SerialNumber) {
+			return slot, nil
+		}
+	}
+	return 0, errors.New("no matching PKCS #11 token found")
+}
+
+func (s *Signer) findObject(class uint, u *URI) (*pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if u.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+	}
+	if u.ID != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return nil, fmt.Errorf("find PKCS #11 key: %w", err)
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return nil, fmt.Errorf("find PKCS #11 key: %w", err)
+	}
+	switch len(objects) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &objects[0], nil
+	default:
+		return nil, errors.New("PKCS #11 URI matches more than one key")
+	}
+}
+
+func (s *Signer) generate(u *URI, keyType localcert.KeyType) error {
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	}
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	}
+	for _, attrs := range []*[]*pkcs11.Attribute{&public, &private} {
+		if u.Object != "" {
+			*attrs = append(*attrs, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+		}
+		if u.ID != nil {
+			*attrs = append(*attrs, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+		}
+	}
+
+	var mechanism uint
+	switch keyType {
+	case localcert.KeyTypeECDSAP256, localcert.KeyTypeECDSAP384, "":
+		oid := oidP256
+		if keyType == localcert.KeyTypeECDSAP384 {
+			oid = oidP384
+		}
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return err
+		}
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	case localcert.KeyTypeRSA2048, localcert.KeyTypeRSA4096:
+		bits := 2048
+		if keyType == localcert.KeyTypeRSA4096 {
+			bits = 4096
+		}
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		)
+	default:
+		return fmt.Errorf("key type %s isn't supported on PKCS #11 tokens", keyType)
+	}
+
+	publicKey, privateKey, err := s.ctx.GenerateKeyPair(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+	if err != nil {
+		return err
+	}
+	s.key = privateKey
+	s.public, err = s.readPublicKey(publicKey)
+	return err
+}
+
+func (s *Signer) readPublicKey(object pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("read PKCS #11 public key: %w", err)
+	}
+	switch decodeUlong(attrs[0].Value) {
+	case pkcs11.CKK_EC:
+		attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read PKCS #11 public key: %w", err)
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+			return nil, fmt.Errorf("PKCS #11 EC parameters: %w", err)
+		}
+		var curve elliptic.Curve
+		switch {
+		case oid.Equal(oidP256):
+			curve = elliptic.P256()
+		case oid.Equal(oidP384):
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported PKCS #11 EC curve %v", oid)
+		}
+		// The point should be DER-encoded, but some tokens return it raw
+		point := attrs[1].Value
+		var encoded []byte
+		if rest, err := asn1.Unmarshal(point, &encoded); err == nil && len(rest) == 0 {
+			point = encoded
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("invalid PKCS #11 EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case pkcs11.CKK_RSA:
+		attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read PKCS #11 public key: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	default:
+		return nil, errors.New("unsupported PKCS #11 key type")
+	}
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs digest on the token. RSA keys sign with PKCS #1 v1.5, or PSS
+// when opts is *rsa.PSSOptions.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	var input []byte
+	switch pub := s.public.(type) {
+	case *ecdsa.PublicKey:
+		mechanism, input = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			hashes, ok := pssHashes[opts.HashFunc()]
+			if !ok {
+				return nil, fmt.Errorf("unsupported PSS hash %v", opts.HashFunc())
+			}
+			saltLength := pssOpts.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = opts.HashFunc().Size()
+			}
+			params := pkcs11.NewPSSParams(hashes[0], hashes[1], uint(saltLength))
+			mechanism, input = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), digest
+		} else {
+			prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+			}
+			mechanism, input = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), append(append([]byte{}, prefix...), digest...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("PKCS #11 sign: %w", err)
+	}
+	signature, err := s.ctx.Sign(s.session, input)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS #11 sign: %w", err)
+	}
+
+	// PKCS #11 ECDSA signatures are r || s; Go wants ASN.1
+	if _, ok := s.public.(*ecdsa.PublicKey); ok {
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(signature[:half]),
+			new(big.Int).SetBytes(signature[half:]),
+		})
+	}
+	return signature, nil
+}
+
+// pssHashes are the hash mechanism and MGF1 function for each PSS hash.
+var pssHashes = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers PKCS #1 v1.5 signatures
+// wrap the digest in (RFC 8017, section 9.2).
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// decodeUlong decodes a CK_ULONG attribute value, which is in the host's
+// (little-endian, on supported platforms) byte order.
+func decodeUlong(b []byte) uint {
+	var v uint
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint(b[i])
+	}
+	return v
+}
+
+func isError(err error, code uint) bool {
+	var p11Err pkcs11.Error
+	return errors.As(err, &p11Err) && uint(p11Err) == code
+}
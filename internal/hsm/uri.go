@@ -0,0 +1,112 @@
+// Package hsm uses private keys held in PKCS #11 tokens, such as HSMs and
+// YubiKeys, as crypto.Signers.
+package hsm
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// URI is a PKCS #11 URI (RFC 7512) identifying a token and a key on it.
+type URI struct {
+	Token  string
+	Serial string
+	SlotID int // -1 if not set
+	Object string
+	ID     []byte
+
+	ModulePath string
+	PIN        string
+}
+
+// ParseURI parses a PKCS #11 URI such as
+//
+//	pkcs11:token=YubiKey;object=localcert?module-path=/usr/lib/libykcs11.so&pin-source=/etc/localcert/pin
+//
+// A pin-source file is read immediately.
+func ParseURI(s string) (*URI, error) {
+	if !strings.HasPrefix(s, "pkcs11:") {
+		return nil, errors.New("PKCS #11 URI must start with pkcs11:")
+	}
+	s = strings.TrimPrefix(s, "pkcs11:")
+	path, query := s, ""
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		path, query = s[:i], s[i+1:]
+	}
+
+	u := &URI{SlotID: -1}
+	for _, attr := range splitAttributes(path, ";") {
+		name, value, err := parseAttribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "token":
+			u.Token = value
+		case "serial":
+			u.Serial = value
+		case "slot-id":
+			if u.SlotID, err = strconv.Atoi(value); err != nil || u.SlotID < 0 {
+				return nil, fmt.Errorf("invalid PKCS #11 slot-id %q", value)
+			}
+		case "object":
+			u.Object = value
+		case "id":
+			u.ID = []byte(value)
+		case "type":
+			if value != "private" {
+				return nil, fmt.Errorf("PKCS #11 URI must identify a private key, not type=%s", value)
+			}
+		}
+	}
+	for _, attr := range splitAttributes(query, "&") {
+		name, value, err := parseAttribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "module-path":
+			u.ModulePath = value
+		case "pin-value":
+			u.PIN = value
+		case "pin-source":
+			name := strings.TrimPrefix(value, "file:")
+			pin, err := os.ReadFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS #11 pin-source: %w", err)
+			}
+			u.PIN = strings.TrimRight(string(pin), "\r\n")
+		}
+	}
+
+	if u.ModulePath == "" {
+		return nil, errors.New("PKCS #11 URI needs a module-path")
+	}
+	if u.Object == "" && u.ID == nil {
+		return nil, errors.New("PKCS #11 URI needs an object or id for the key")
+	}
+	return u, nil
+}
+
+func splitAttributes(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, sep)
+}
+
+func parseAttribute(attr string) (name, value string, err error) {
+	i := strings.IndexByte(attr, '=')
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid PKCS #11 URI attribute %q", attr)
+	}
+	value, err = url.PathUnescape(attr[i+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid PKCS #11 URI attribute %q: %w", attr, err)
+	}
+	return attr[:i], value, nil
+}
@@ -0,0 +1,143 @@
+// Package jks encodes Java KeyStore (JKS) files, for Java versions and
+// tools that don't read PKCS #12 keystores.
+//
+// The private key is protected with the JDK's proprietary key protector
+// (OID 1.3.6.1.4.1.42.2.17.1.1), and the file with its SHA-1 integrity
+// check, as keytool writes them.
+package jks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	magic          = 0xFEEDFEED
+	version        = 2
+	privateKeyType = 1
+)
+
+var oidKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// Encode returns a keystore holding key, under alias, with its certificate
+// chain, the key and keystore both protected with password.
+func Encode(key interface{}, chain []*x509.Certificate, alias, password string) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("jks: no certificate")
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	// The JDK looks aliases up lowercased
+	alias = strings.ToLower(alias)
+	passwordBytes := encodePassword(password)
+	protected, err := protectKey(pkcs8, passwordBytes)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	writeUint32(&b, magic)
+	writeUint32(&b, version)
+	writeUint32(&b, 1)
+	writeUint32(&b, privateKeyType)
+	if err := writeUTF(&b, alias); err != nil {
+		return nil, err
+	}
+	binary.Write(&b, binary.BigEndian, time.Now().UnixNano()/int64(time.Millisecond))
+	writeUint32(&b, uint32(len(encryptedKey)))
+	b.Write(encryptedKey)
+	writeUint32(&b, uint32(len(chain)))
+	for _, cert := range chain {
+		if err := writeUTF(&b, "X.509"); err != nil {
+			return nil, err
+		}
+		writeUint32(&b, uint32(len(cert.Raw)))
+		b.Write(cert.Raw)
+	}
+
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b.Bytes())
+	b.Write(h.Sum(nil))
+	return b.Bytes(), nil
+}
+
+// protectKey encrypts plaintext with the JDK key protector: XORed with a
+// SHA-1 keystream of the password and a random salt, followed by a SHA-1
+// check of the password and plaintext.
+func protectKey(plaintext, password []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), salt...)
+	digest := salt
+	for i := 0; i < len(plaintext); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte(nil), password...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plaintext); j++ {
+			out = append(out, plaintext[i+j]^digest[j])
+		}
+	}
+	check := sha1.Sum(append(append([]byte(nil), password...), plaintext...))
+	return append(out, check[:]...), nil
+}
+
+// encodePassword returns password as Java chars: UTF-16, big-endian.
+func encodePassword(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+func writeUint32(b *bytes.Buffer, v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+// writeUTF writes s as Java's DataOutput.writeUTF does, which differs from
+// UTF-8 only for NUL and characters outside the BMP.
+func writeUTF(b *bytes.Buffer, s string) error {
+	var encoded []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c != 0 && c < 0x80:
+			encoded = append(encoded, byte(c))
+		case c < 0x800:
+			encoded = append(encoded, byte(0xC0|c>>6), byte(0x80|c&0x3F))
+		default:
+			encoded = append(encoded, byte(0xE0|c>>12), byte(0x80|c>>6&0x3F), byte(0x80|c&0x3F))
+		}
+	}
+	if len(encoded) > 0xFFFF {
+		return errors.New("jks: alias too long")
+	}
+	binary.Write(b, binary.BigEndian, uint16(len(encoded)))
+	b.Write(encoded)
+	return nil
+}
@@ -0,0 +1,306 @@
+package jks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// keystore is a keystore as read back by decode.
+type keystore struct {
+	alias   string
+	created time.Time
+	key     interface{}
+	chain   [][]byte
+}
+
+var (
+	errIntegrity = errors.New("keystore was tampered with, or password was incorrect")
+	errRecover   = errors.New("cannot recover key")
+)
+
+// decode reads a keystore holding one private key entry the way the JDK's
+// JavaKeyStore.engineLoad and KeyProtector.recover do, independently of
+// Encode.
+func decode(data []byte, password string) (*keystore, error) {
+	if len(data) < sha1.Size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	passwordBytes := encodePassword(password)
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return nil, errIntegrity
+	}
+
+	r := bytes.NewReader(body)
+	var header struct{ Magic, Version, Count, Tag uint32 }
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != magic || header.Version != version {
+		return nil, fmt.Errorf("magic %#x version %d", header.Magic, header.Version)
+	}
+	if header.Count != 1 || header.Tag != privateKeyType {
+		return nil, fmt.Errorf("%d entries, first of type %d; want one private key", header.Count, header.Tag)
+	}
+	var ks keystore
+	alias, err := readBytes16(r)
+	if err != nil {
+		return nil, err
+	}
+	ks.alias = string(alias)
+	var millis int64
+	if err := binary.Read(r, binary.BigEndian, &millis); err != nil {
+		return nil, err
+	}
+	ks.created = time.Unix(0, millis*int64(time.Millisecond))
+
+	encrypted, err := readBytes32(r)
+	if err != nil {
+		return nil, err
+	}
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(encrypted, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("protected key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidKeyProtector) {
+		return nil, fmt.Errorf("key protected with %v", info.Algorithm.Algorithm)
+	}
+	pkcs8, err := recoverKey(info.EncryptedData, passwordBytes)
+	if err != nil {
+		return nil, err
+	}
+	if ks.key, err = x509.ParsePKCS8PrivateKey(pkcs8); err != nil {
+		return nil, err
+	}
+
+	var certs uint32
+	if err := binary.Read(r, binary.BigEndian, &certs); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < certs; i++ {
+		certType, err := readBytes16(r)
+		if err != nil {
+			return nil, err
+		}
+		if string(certType) != "X.509" {
+			return nil, fmt.Errorf("certificate type %q", certType)
+		}
+		cert, err := readBytes32(r)
+		if err != nil {
+			return nil, err
+		}
+		ks.chain = append(ks.chain, cert)
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes after the entry", r.Len())
+	}
+	return &ks, nil
+}
+
+// recoverKey reverses the JDK key protector.
+func recoverKey(protected, password []byte) ([]byte, error) {
+	if len(protected) < 2*sha1.Size {
+		return nil, errRecover
+	}
+	salt := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	check := protected[len(protected)-sha1.Size:]
+
+	plaintext := make([]byte, len(encrypted))
+	digest := salt
+	for i := range encrypted {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(append([]byte(nil), password...), digest...))
+			digest = sum[:]
+		}
+		plaintext[i] = encrypted[i] ^ digest[i%sha1.Size]
+	}
+	if sum := sha1.Sum(append(append([]byte(nil), password...), plaintext...)); !bytes.Equal(sum[:], check) {
+		return nil, errRecover
+	}
+	return plaintext, nil
+}
+
+func readBytes16(r io.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func readBytes32(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func testChain(t *testing.T, key crypto.Signer) []*x509.Certificate {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.localcert.dev"},
+		NotBefore:    ca.NotBefore,
+		NotAfter:     ca.NotAfter,
+	}
+	var chain []*x509.Certificate
+	for _, c := range []struct {
+		template *x509.Certificate
+		key      crypto.Signer
+	}{{leaf, key}, {ca, caKey}} {
+		der, err := x509.CreateCertificate(rand.Reader, c.template, ca, c.key.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain
+}
+
+func TestEncode(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		key      crypto.Signer
+		alias    string
+		password string
+	}{
+		{"ecdsa", ecKey, "tomcat", "changeit"},
+		{"rsa", rsaKey, "Tomcat", "changeit"},
+		{"non-ASCII password", ecKey, "tomcat", "pässwörd€"},
+		{"empty password", ecKey, "tomcat", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := testChain(t, test.key)
+			before := time.Now().Truncate(time.Millisecond)
+			data, err := Encode(test.key, chain, test.alias, test.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ks, err := decode(data, test.password)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if ks.alias != "tomcat" {
+				t.Errorf("alias = %q, want tomcat", ks.alias)
+			}
+			if ks.created.Before(before) || ks.created.After(time.Now()) {
+				t.Errorf("creation date %v, want about now", ks.created)
+			}
+			if !test.key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(ks.key) {
+				t.Error("recovered key doesn't match the encoded one")
+			}
+			if len(ks.chain) != len(chain) {
+				t.Fatalf("%d certificates, want %d", len(ks.chain), len(chain))
+			}
+			for i, cert := range chain {
+				if !bytes.Equal(ks.chain[i], cert.Raw) {
+					t.Errorf("certificate %d doesn't match", i)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWrongPassword(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(key, testChain(t, key), "tomcat", "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "changei", "Changeit", "changeit "} {
+		if _, err := decode(data, password); err != errIntegrity {
+			t.Errorf("decode with password %q = %v, want %v", password, err, errIntegrity)
+		}
+	}
+
+	// The key protector checks the password on its own too
+	// (after the header, the alias, the creation date and the key length)
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(data[16+2+len("tomcat")+8+4:], &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recoverKey(info.EncryptedData, encodePassword("wrong")); err != errRecover {
+		t.Errorf("recovering the key with the wrong password = %v, want %v", err, errRecover)
+	}
+	if _, err := recoverKey(info.EncryptedData, encodePassword("changeit")); err != nil {
+		t.Errorf("recovering the key: %v", err)
+	}
+
+	// A flipped bit fails the integrity check
+	data[len(data)/2] ^= 1
+	if _, err := decode(data, "changeit"); err != errIntegrity {
+		t.Errorf("decode of a corrupted keystore = %v, want %v", err, errIntegrity)
+	}
+}
+
+func TestEncodeNoCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Encode(key, nil, "tomcat", "changeit"); err == nil {
+		t.Error("Encode without a certificate succeeded")
+	}
+}
+
+func TestEncodePassword(t *testing.T) {
+	// Java chars, as keytool hashes them
+	want := []byte{0x00, 'p', 0x00, 0xE4, 0x20, 0xAC, 0xD8, 0x3D, 0xDD, 0x11}
+	if got := encodePassword("pä€🔑"); !bytes.Equal(got, want) {
+		t.Errorf("encodePassword = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+// Package kube writes TLS Secrets through the Kubernetes API, authenticating
+// as the pod's service account or with a kubeconfig file.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type Client struct {
+	Server string
+	// Token is a bearer token; clients authenticating with a client
+	// certificate leave it empty.
+	Token      string
+	HTTPClient *http.Client
+	// Namespace is the namespace of the service account or kubeconfig
+	// context, used when none is given.
+	Namespace string
+}
+
+// NewClient returns a client for the kubeconfig file, or if it is empty,
+// for the pod's service account when running in a cluster and otherwise
+// for $KUBECONFIG or ~/.kube/config. contextName selects a kubeconfig
+// context other than the current one.
+func NewClient(kubeconfig, contextName string) (*Client, error) {
+	if kubeconfig == "" && contextName == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return InClusterClient()
+	}
+	if kubeconfig == "" {
+		kubeconfig = strings.Split(os.Getenv("KUBECONFIG"), string(os.PathListSeparator))[0]
+	}
+	if kubeconfig == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	return KubeconfigClient(kubeconfig, contextName)
+}
+
+// InClusterClient returns a client for the pod's service account.
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("service account token: %w", err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("service account CA: %w", err)
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("service account namespace: %w", err)
+	}
+	tlsConfig, err := tlsConfig(caPEM, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Server:     "https://" + net.JoinHostPort(host, port),
+		Token:      strings.TrimSpace(string(token)),
+		HTTPClient: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		Namespace:  strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+type kubeconfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string
+		Cluster struct {
+			Server                   string
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		}
+	}
+	Users []struct {
+		Name string
+		User struct {
+			Token                 string
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Exec                  yaml.Node `yaml:"exec"`
+			AuthProvider          yaml.Node `yaml:"auth-provider"`
+		}
+	}
+	Contexts []struct {
+		Name    string
+		Context struct {
+			Cluster   string
+			User      string
+			Namespace string
+		}
+	}
+}
+
+// KubeconfigClient returns a client for the named context of a kubeconfig
+// file, or its current context if name is empty. Token and client
+// certificate authentication are supported; exec and auth-provider plugins
+// aren't.
+func KubeconfigClient(name, contextName string) (*Client, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var file kubeconfigFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode %q: %w", name, err)
+	}
+	dir := filepath.Dir(name)
+
+	if contextName == "" {
+		contextName = file.CurrentContext
+	}
+	c := &Client{Namespace: "default"}
+	var clusterName, userName string
+	found := false
+	for _, ctx := range file.Contexts {
+		if ctx.Name == contextName {
+			clusterName, userName = ctx.Context.Cluster, ctx.Context.User
+			if ctx.Context.Namespace != "" {
+				c.Namespace = ctx.Context.Namespace
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%q has no context %q", name, contextName)
+	}
+
+	var tlsCfg *tls.Config
+	found = false
+	for _, cluster := range file.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+		c.Server = strings.TrimRight(cluster.Cluster.Server, "/")
+		caPEM, err := readData(dir, cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q certificate authority: %w", clusterName, err)
+		}
+		if tlsCfg, err = tlsConfig(caPEM, cluster.Cluster.InsecureSkipTLSVerify); err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", clusterName, err)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%q has no cluster %q", name, clusterName)
+	}
+
+	for _, user := range file.Users {
+		if user.Name != userName {
+			continue
+		}
+		u := user.User
+		if !u.Exec.IsZero() || !u.AuthProvider.IsZero() {
+			return nil, fmt.Errorf("user %q: exec and auth-provider credential plugins aren't supported", userName)
+		}
+		c.Token = u.Token
+		if u.TokenFile != "" {
+			token, err := os.ReadFile(resolvePath(dir, u.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("user %q token: %w", userName, err)
+			}
+			c.Token = strings.TrimSpace(string(token))
+		}
+		certPEM, err := readData(dir, u.ClientCertificateData, u.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("user %q client certificate: %w", userName, err)
+		}
+		keyPEM, err := readData(dir, u.ClientKeyData, u.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("user %q client key: %w", userName, err)
+		}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("user %q client certificate: %w", userName, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	c.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	return c, nil
+}
+
+// readData returns the base64 data of a kubeconfig "-data" field, or the
+// contents of the file it's an alternative to.
+func readData(dir, data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolvePath(dir, file))
+	}
+	return nil, nil
+}
+
+// resolvePath resolves kubeconfig paths relative to the kubeconfig file.
+func resolvePath(dir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+func tlsConfig(caPEM []byte, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caPEM != nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates in certificate authority")
+		}
+	}
+	return config, nil
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   secretMetadata    `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type secretMetadata struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// ApplyTLSSecret creates or updates the kubernetes.io/tls Secret
+// namespace/name with the certificate chain and key, leaving any other keys
+// in an existing Secret alone.
+func (c *Client) ApplyTLSSecret(ctx context.Context, namespace, name string, certPEM, keyPEM []byte) error {
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	data := map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM}
+	secretURL := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.Server, url.PathEscape(namespace), url.PathEscape(name))
+
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	status, err := c.do(ctx, http.MethodPatch, secretURL, "application/merge-patch+json", patch)
+	if err != nil || status != http.StatusNotFound {
+		return err
+	}
+
+	create, err := json.Marshal(secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: secretMetadata{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "localcert"},
+		},
+		Type: "kubernetes.io/tls",
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	status, err = c.do(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", c.Server, url.PathEscape(namespace)), "application/json", create)
+	if err == nil && status == http.StatusNotFound {
+		return fmt.Errorf("namespace %q not found", namespace)
+	}
+	return err
+}
+
+// do sends a request, returning an error for any failure status but 404.
+func (c *Client) do(ctx context.Context, method, url, contentType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 || resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	var status struct {
+		Message string
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(respBody, &status) == nil && status.Message != "" {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, status.Message)
+	}
+	return resp.StatusCode, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+}
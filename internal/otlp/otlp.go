@@ -0,0 +1,237 @@
+// Package otlp records trace spans and exports them to an OpenTelemetry
+// collector with OTLP over HTTP, in its JSON encoding.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanKind is the OTLP kind of a span.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindClient   SpanKind = 3
+)
+
+// Exporter records spans, and exports each trace once its root span ends.
+// A nil *Exporter records nothing.
+type Exporter struct {
+	// Endpoint is the URL of the collector's traces endpoint, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	HTTPClient  *http.Client
+	// Logf, if set, receives export errors.
+	Logf func(format string, args ...interface{})
+
+	mu sync.Mutex
+	// ended holds the ended spans of traces whose root span hasn't.
+	ended map[[16]byte][]span
+}
+
+// Span is an operation in a trace. A nil *Span does nothing.
+type Span struct {
+	exporter *Exporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     SpanKind
+	start    time.Time
+	attrs    []keyValue
+}
+
+type spanKey struct{}
+
+// Start starts a span called name, a child of the span in ctx if there is
+// one, and returns a context holding it.
+func (e *Exporter) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if e == nil {
+		return ctx, nil
+	}
+	s := &Span{exporter: e, name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttribute records a string, int or bool attribute of s.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var v anyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case int:
+		i := strconv.Itoa(value)
+		v.IntValue = &i
+	case bool:
+		v.BoolValue = &value
+	default:
+		str := fmt.Sprint(value)
+		v.StringValue = &str
+	}
+	s.attrs = append(s.attrs, keyValue{Key: key, Value: v})
+}
+
+// Traceparent returns the W3C Trace Context header value identifying s, for
+// servers to continue the trace.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// End ends s, failed if err isn't nil. Ending a root span exports its
+// trace, returning once the collector has it.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	e := s.exporter
+	encoded := span{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        s.attrs,
+	}
+	if s.parentID != ([8]byte{}) {
+		encoded.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		encoded.Status = status{Code: statusError, Message: err.Error()}
+	}
+
+	e.mu.Lock()
+	if e.ended == nil {
+		e.ended = map[[16]byte][]span{}
+	}
+	e.ended[s.traceID] = append(e.ended[s.traceID], encoded)
+	var trace []span
+	if s.parentID == ([8]byte{}) {
+		trace = e.ended[s.traceID]
+		delete(e.ended, s.traceID)
+	}
+	e.mu.Unlock()
+
+	if trace != nil {
+		if err := e.export(trace); err != nil && e.Logf != nil {
+			e.Logf("Exporting trace %s: %v", encoded.TraceID, err)
+		}
+	}
+}
+
+func (e *Exporter) export(spans []span) error {
+	serviceName := e.ServiceName
+	body, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: &serviceName}}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "localcert"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+	httpClient := e.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// exportTimeout bounds exporting a trace, so an unreachable collector
+// doesn't hold up the command.
+const exportTimeout = 10 * time.Second
+
+// statusError is the OTLP status code of a failed span; others are left
+// unset.
+const statusError = 2
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,99 @@
+package pemutil
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	CertificateType         = "CERTIFICATE"
+	PrivateKeyType          = "PRIVATE KEY"
+	EncryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+	CertificateRequestType  = "CERTIFICATE REQUEST"
+)
+
+var ErrNotPEM = errors.New("no PEM data found")
+
+func ReadPEMFile(name, pemType string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePEM(data, pemType)
+}
+
+// DecodePEM returns the contents of the first PEM block in data, which
+// must be of type pemType.
+func DecodePEM(data []byte, pemType string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNotPEM
+	}
+	if block.Type != pemType {
+		return nil, fmt.Errorf("unexpected PEM type %q", block.Type)
+	}
+	return block.Bytes, nil
+}
+
+// ReadPEMBlockFile returns the first PEM block in a file, whatever its type.
+func ReadPEMBlockFile(name string) (*pem.Block, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePEMBlock(data)
+}
+
+// DecodePEMBlock returns the first PEM block in data, whatever its type.
+func DecodePEMBlock(data []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNotPEM
+	}
+	return block, nil
+}
+
+func WritePEMFile(name, pemType string, content []byte, perm os.FileMode) error {
+	block := &pem.Block{Type: pemType, Bytes: content}
+	return os.WriteFile(name, pem.EncodeToMemory(block), perm)
+}
+
+func ReadPEMChainFile(name, pemType string) ([][]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePEMChain(data, pemType)
+}
+
+// DecodePEMChain returns the contents of the PEM blocks in data, which must
+// all be of type pemType.
+func DecodePEMChain(data []byte, pemType string) ([][]byte, error) {
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != pemType {
+			return nil, fmt.Errorf("unexpected PEM type %q", block.Type)
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(chain) == 0 {
+		return nil, ErrNotPEM
+	}
+	return chain, nil
+}
+
+func EncodePEMChain(pemType string, chain [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&buf, &pem.Block{Type: pemType, Bytes: der})
+	}
+	return buf.Bytes()
+}
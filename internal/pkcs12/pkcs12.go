@@ -0,0 +1,345 @@
+// Package pkcs12 encodes PKCS #12 (PFX) files.
+//
+// Keys and certificates are encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC
+// and the file is protected with an HMAC-SHA1 MAC, which is what Windows,
+// Java and OpenSSL all accept without extra options.
+package pkcs12
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"unicode/utf16"
+)
+
+const iterations = 2048
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidPBEWithSHAAnd3KeyTDES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPKCS8ShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	AlgorithmIdentifier pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// Encode returns a PFX containing key, its certificate and the rest of the
+// chain, encrypted with password. If friendlyName is set it is used as the
+// alias of the key entry.
+func Encode(key interface{}, cert *x509.Certificate, caCerts []*x509.Certificate, password, friendlyName string) ([]byte, error) {
+	encodedPassword := bmpString(password)
+
+	localKeyID := sha1.Sum(cert.Raw)
+	keyAttributes, err := bagAttributes(localKeyID[:], friendlyName)
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, c := range append([]*x509.Certificate{cert}, caCerts...) {
+		bag, err := makeCertBag(c.Raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = keyAttributes
+		}
+		certBags = append(certBags, bag)
+	}
+
+	keyBag, err := makeShroudedKeyBag(key, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = keyAttributes
+
+	certContent, err := makeEncryptedContentInfo(certBags, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyContent, err := makeDataContentInfo([]safeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+
+	authenticatedSafe, err := asn1.Marshal([]contentInfo{certContent, keyContent})
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := makeDataContentInfoBytes(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := computeMac(authenticatedSafe, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pfxPdu{Version: 3, AuthSafe: authSafe, MacData: mac})
+}
+
+func bagAttributes(localKeyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	idValue, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: idValue},
+	}}
+	if friendlyName != "" {
+		name := bmpString(friendlyName)
+		nameValue, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: 30, Bytes: name[:len(name)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{
+			ID:    oidFriendlyName,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: nameValue},
+		})
+	}
+	return attributes, nil
+}
+
+func makeCertBag(der []byte) (safeBag, error) {
+	value, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: der})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidCertBag, Value: asn1.RawValue{FullBytes: explicitTag0(value)}}, nil
+}
+
+func makeShroudedKeyBag(key interface{}, password []byte) (safeBag, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return safeBag{}, err
+	}
+	algorithm, encrypted, err := pbeEncrypt(pkcs8, password)
+	if err != nil {
+		return safeBag{}, err
+	}
+	value, err := asn1.Marshal(encryptedPrivateKeyInfo{AlgorithmIdentifier: algorithm, EncryptedData: encrypted})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidPKCS8ShroudedKeyBag, Value: asn1.RawValue{FullBytes: explicitTag0(value)}}, nil
+}
+
+func makeDataContentInfo(bags []safeBag) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return makeDataContentInfoBytes(safeContents)
+}
+
+func makeDataContentInfoBytes(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: asn1.RawValue{FullBytes: explicitTag0(octets)}}, nil
+}
+
+func makeEncryptedContentInfo(bags []safeBag, password []byte) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	algorithm, encrypted, err := pbeEncrypt(safeContents, password)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	value, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidEncryptedDataContentType, Content: asn1.RawValue{FullBytes: explicitTag0(value)}}, nil
+}
+
+// explicitTag0 wraps der in a context-specific [0] constructed tag.
+func explicitTag0(der []byte) []byte {
+	wrapped, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der})
+	return wrapped
+}
+
+func pbeEncrypt(plaintext, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: iterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	key := deriveKey(salt, password, iterations, 1, 24)
+	iv := deriveKey(salt, password, iterations, 2, 8)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	padding := block.BlockSize() - len(plaintext)%block.BlockSize()
+	padded := make([]byte, len(plaintext), len(plaintext)+padding)
+	copy(padded, plaintext)
+	for i := 0; i < padding; i++ {
+		padded = append(padded, byte(padding))
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTDES, Parameters: asn1.RawValue{FullBytes: params}}
+	return algorithm, padded, nil
+}
+
+func computeMac(message, password []byte) (macData, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return macData{}, err
+	}
+	key := deriveKey(salt, password, iterations, 3, 20)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+			Digest:    mac.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: iterations,
+	}, nil
+}
+
+// deriveKey implements the PKCS #12 key derivation function (RFC 7292,
+// appendix B.2) with SHA-1.
+func deriveKey(salt, password []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for each v-byte block of I
+		b := make([]byte, v)
+		for k := range b {
+			b[k] = a[k%u]
+		}
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString returns s as a null-terminated UTF-16BE string.
+func bmpString(s string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return append(out, 0, 0)
+}
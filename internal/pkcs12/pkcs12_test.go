@@ -0,0 +1,176 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xpkcs12 "golang.org/x/crypto/pkcs12"
+)
+
+type signer interface {
+	crypto.Signer
+	Equal(crypto.PrivateKey) bool
+}
+
+func testCertificate(t *testing.T, name string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testKeys(t *testing.T) map[string]signer {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]signer{"ecdsa": ecKey, "rsa": rsaKey}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for name, key := range testKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			cert := testCertificate(t, "example.localcert.dev", key, nil, nil)
+			pfx, err := Encode(key, cert, nil, "changeit", "tomcat")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotKey, gotCert, err := xpkcs12.Decode(pfx, "changeit")
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !key.Equal(gotKey) {
+				t.Error("decoded key doesn't match the encoded one")
+			}
+			if !bytes.Equal(gotCert.Raw, cert.Raw) {
+				t.Error("decoded certificate doesn't match the encoded one")
+			}
+
+			if _, _, err := xpkcs12.Decode(pfx, "wrong"); err != xpkcs12.ErrIncorrectPassword {
+				t.Errorf("decode with the wrong password = %v, want %v", err, xpkcs12.ErrIncorrectPassword)
+			}
+		})
+	}
+}
+
+func TestEncodeChain(t *testing.T) {
+	keys := testKeys(t)
+	caKey, key := keys["rsa"], keys["ecdsa"]
+	ca := testCertificate(t, "Test CA", caKey, nil, nil)
+	cert := testCertificate(t, "example.localcert.dev", key, ca, caKey)
+
+	pfx, err := Encode(key, cert, []*x509.Certificate{ca}, "changeit", "tomcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := xpkcs12.ToPEM(pfx, "changeit")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	var certs [][]byte
+	var keyBlocks int
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			certs = append(certs, block.Bytes)
+		case "PRIVATE KEY":
+			keyBlocks++
+			if block.Headers["friendlyName"] != "tomcat" {
+				t.Errorf("key friendlyName = %q, want tomcat", block.Headers["friendlyName"])
+			}
+			if block.Headers["localKeyId"] == "" {
+				t.Error("key has no localKeyId")
+			}
+		default:
+			t.Errorf("unexpected %s block", block.Type)
+		}
+	}
+	if keyBlocks != 1 {
+		t.Errorf("%d keys, want 1", keyBlocks)
+	}
+	if len(certs) != 2 || !bytes.Equal(certs[0], cert.Raw) || !bytes.Equal(certs[1], ca.Raw) {
+		t.Errorf("decoded %d certificates, want the leaf followed by the CA", len(certs))
+	}
+}
+
+// TestOpenSSL checks that openssl, when it is installed, reads the file
+// with its default settings.
+func TestOpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl isn't installed")
+	}
+	key := testKeys(t)["ecdsa"]
+	cert := testCertificate(t, "example.localcert.dev", key, nil, nil)
+	pfx, err := Encode(key, cert, nil, "changeit", "tomcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "keystore.p12")
+	if err := os.WriteFile(name, pfx, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(openssl, "pkcs12", "-in", name, "-passin", "pass:changeit", "-nodes").Output()
+	if err != nil {
+		t.Fatalf("openssl pkcs12: %v", err)
+	}
+	var gotCert, gotKey bool
+	for rest := out; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			gotCert = gotCert || bytes.Equal(block.Bytes, cert.Raw)
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			gotKey = gotKey || err == nil && key.Equal(parsed)
+		}
+	}
+	if !gotCert || !gotKey {
+		t.Errorf("openssl output lacks the certificate (%v) or key (%v):\n%s", gotCert, gotKey, out)
+	}
+
+	if err := exec.Command(openssl, "pkcs12", "-in", name, "-passin", "pass:wrong", "-nodes").Run(); err == nil {
+		t.Error("openssl read the file with the wrong password")
+	}
+}
@@ -0,0 +1,93 @@
+// Package seal encrypts data with a passphrase, for files such as backups
+// that are moved between machines.
+//
+// A sealed file is the magic "LCB1", the scrypt cost as log2(N), a 16-byte
+// salt and a 12-byte nonce, followed by the data encrypted with AES-256-GCM
+// under the scrypt-derived key. The header is authenticated along with the
+// data.
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const magic = "LCB1"
+
+// MagicSize is the number of bytes at the start of a file that IsSealed
+// needs.
+const MagicSize = len(magic)
+
+// logN is the scrypt cost of new files, the interactive-login
+// recommendation of 2^15 with r=8 and p=1.
+const logN = 15
+
+const (
+	saltSize   = 16
+	nonceSize  = 12
+	headerSize = len(magic) + 1 + saltSize + nonceSize
+)
+
+// ErrIncorrectPassphrase is returned by Open when the passphrase doesn't
+// decrypt the data, or the data was altered.
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase, or the file is damaged")
+
+// Seal encrypts plaintext with passphrase.
+func Seal(plaintext, passphrase []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = logN
+	if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, logN, header[len(magic)+1:len(magic)+1+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[headerSize-nonceSize:], plaintext, header), nil
+}
+
+// IsSealed reports whether data, which may be just the first MagicSize
+// bytes of a file, starts like a sealed file.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Open decrypts data sealed with passphrase.
+func Open(data, passphrase []byte) ([]byte, error) {
+	if len(data) < headerSize || !IsSealed(data) {
+		return nil, errors.New("not a sealed file")
+	}
+	header := data[:headerSize]
+	n := header[len(magic)]
+	if n < 10 || n > 22 {
+		return nil, fmt.Errorf("unsupported scrypt cost 2^%d", n)
+	}
+	aead, err := newAEAD(passphrase, int(n), header[len(magic)+1:len(magic)+1+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, header[headerSize-nonceSize:], data[headerSize:], header)
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext, nil
+}
+
+func newAEAD(passphrase []byte, logN int, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
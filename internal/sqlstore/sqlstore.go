@@ -0,0 +1,73 @@
+// Package sqlstore keeps files in a single SQLite database, so that several
+// profiles can share one file that is updated atomically.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS files (
+	name TEXT PRIMARY KEY,
+	data BLOB NOT NULL,
+	mode INTEGER NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`
+
+// Store is a localcert.Store keeping files under Prefix in a SQLite
+// database.
+type Store struct {
+	DB     *sql.DB
+	Prefix string
+}
+
+// OpenDB opens or creates a database file for Stores.
+func OpenDB(name string) (*sql.DB, error) {
+	// Other processes (say, other profiles' daemons) may be writing too
+	db, err := sql.Open("sqlite3", "file:"+name+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %q: %w", name, err)
+	}
+	return db, nil
+}
+
+func (s *Store) ReadFile(name string) ([]byte, error) {
+	var data []byte
+	err := s.DB.QueryRow(`SELECT data FROM files WHERE name = ?`, s.key(name)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", s.key(name), fs.ErrNotExist)
+	}
+	return data, err
+}
+
+func (s *Store) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	_, err := s.DB.Exec(`INSERT INTO files (name, data, mode, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET data = excluded.data, mode = excluded.mode, updated_at = excluded.updated_at`,
+		s.key(name), data, uint32(perm), time.Now().UTC())
+	return err
+}
+
+func (s *Store) Remove(name string) error {
+	result, err := s.DB.Exec(`DELETE FROM files WHERE name = ?`, s.key(name))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", s.key(name), fs.ErrNotExist)
+	}
+	return nil
+}
+
+func (s *Store) key(name string) string {
+	return path.Join(s.Prefix, name)
+}
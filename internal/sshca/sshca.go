@@ -0,0 +1,173 @@
+// Package sshca requests SSH certificates from a step-ca SSH CA, through an
+// X5C provisioner that accepts an X.509 certificate and its key as
+// credentials.
+package sshca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/wildone/localcert/internal/acmeutil"
+)
+
+// tokenLifetime is how long a signing token is valid for.
+const tokenLifetime = 5 * time.Minute
+
+// Client signs SSH certificates with the CA at URL, such as
+// "https://ca.lan", authenticating to its Provisioner with Chain, an X.509
+// certificate chain the CA trusts, and Chain's key.
+type Client struct {
+	URL         string
+	Provisioner string
+	Chain       [][]byte
+	Key         crypto.Signer
+
+	HTTPClient *http.Client
+}
+
+// Request describes the certificate to sign.
+type Request struct {
+	PublicKey ssh.PublicKey
+	// CertType is "host" or "user".
+	CertType   string
+	KeyID      string
+	Principals []string
+	// ValidFor, if set, asks for a certificate valid this long instead of
+	// the provisioner's default.
+	ValidFor time.Duration
+}
+
+type signOptions struct {
+	CertType    string   `json:"certType"`
+	KeyID       string   `json:"keyID"`
+	Principals  []string `json:"principals"`
+	ValidAfter  string   `json:"validAfter,omitempty"`
+	ValidBefore string   `json:"validBefore,omitempty"`
+}
+
+// Sign returns a certificate for req.PublicKey signed by the CA.
+func (c *Client) Sign(ctx context.Context, req Request) (*ssh.Certificate, error) {
+	opts := signOptions{CertType: req.CertType, KeyID: req.KeyID, Principals: req.Principals}
+	if req.ValidFor > 0 {
+		now := time.Now()
+		opts.ValidAfter = now.UTC().Format(time.RFC3339)
+		opts.ValidBefore = now.Add(req.ValidFor).UTC().Format(time.RFC3339)
+	}
+	signURL := strings.TrimRight(c.URL, "/") + "/1.0/ssh/sign"
+	ott, err := c.token(signURL, opts)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	body, err := json.Marshal(struct {
+		PublicKey []byte `json:"publicKey"`
+		OTT       string `json:"ott"`
+		signOptions
+	}{req.PublicKey.Marshal(), ott, opts})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, signURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var caErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &caErr) == nil && caErr.Message != "" {
+			return nil, fmt.Errorf("ssh sign: %s: %s", resp.Status, caErr.Message)
+		}
+		return nil, fmt.Errorf("ssh sign: %s", resp.Status)
+	}
+
+	var signed struct {
+		Certificate string `json:"crt"`
+	}
+	if err := json.Unmarshal(respBody, &signed); err != nil {
+		return nil, fmt.Errorf("decode ssh sign response: %w", err)
+	}
+	der, err := base64.StdEncoding.DecodeString(signed.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("decode ssh certificate: %w", err)
+	}
+	pub, err := ssh.ParsePublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("the CA didn't return a certificate")
+	}
+	return cert, nil
+}
+
+// token returns the one-time token authorizing the request: a JWT for
+// audience, signed by the key with the chain in its x5c header.
+func (c *Client) token(audience string, opts signOptions) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":  c.Provisioner,
+		"aud":  audience,
+		"sub":  opts.KeyID,
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(tokenLifetime).Unix(),
+		"jti":  hex.EncodeToString(jti),
+		"step": map[string]interface{}{"ssh": opts},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingKey, err := acmeutil.SigningKey(c.Key)
+	if err != nil {
+		return "", err
+	}
+	var x5c []string
+	for _, der := range c.Chain {
+		x5c = append(x5c, base64.StdEncoding.EncodeToString(der))
+	}
+	signerOpts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("x5c", x5c)
+	signer, err := jose.NewSigner(signingKey, signerOpts)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
@@ -0,0 +1,591 @@
+// Package toml decodes and encodes the subset of TOML 1.0 (https://toml.io)
+// that config files use:
+//
+//   - tables, with headers such as [profiles.web]
+//   - bare, quoted and dotted keys
+//   - basic, literal and multi-line strings
+//   - decimal, hexadecimal, octal and binary integers
+//   - floats, including inf and nan
+//   - booleans, arrays and inline tables
+//
+// Dates and times, and arrays of tables ([[name]]), aren't supported:
+// Unmarshal fails on them with an error that says so and gives the line,
+// rather than misreading them. An array of inline tables holds the same
+// settings as an array of tables.
+package toml
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unmarshal decodes a TOML document into tables of
+// map[string]interface{} holding string, int64, float64, bool and
+// []interface{} values.
+func Unmarshal(data []byte) (map[string]interface{}, error) {
+	p := &parser{src: string(data), line: 1, defined: map[string]bool{}}
+	root := map[string]interface{}{}
+	if err := p.document(root); err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return root, nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	// defined holds the tables defined by a header, which may not be
+	// defined again.
+	defined map[string]bool
+}
+
+func (p *parser) document(root map[string]interface{}) error {
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			return nil
+		}
+		if p.src[p.pos] == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return fmt.Errorf("arrays of tables ([[name]]) aren't supported; use an array of inline tables")
+			}
+			p.pos++
+			p.skipSpace(false)
+			path, err := p.key()
+			if err != nil {
+				return err
+			}
+			p.skipSpace(false)
+			if !p.consume(']') {
+				return fmt.Errorf("expected ] after table name")
+			}
+			name := strings.Join(path, ".")
+			if p.defined[name] {
+				return fmt.Errorf("table %q defined twice", name)
+			}
+			p.defined[name] = true
+			if table, err = subtable(root, path); err != nil {
+				return err
+			}
+		} else {
+			path, err := p.key()
+			if err != nil {
+				return err
+			}
+			p.skipSpace(false)
+			if !p.consume('=') {
+				return fmt.Errorf("expected = after key %q", strings.Join(path, "."))
+			}
+			p.skipSpace(false)
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			if err := set(table, path, value); err != nil {
+				return err
+			}
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+			return fmt.Errorf("unexpected %q at end of line", p.src[p.pos])
+		}
+	}
+}
+
+// subtable returns the table at path below table, creating it if needed.
+func subtable(table map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for _, key := range path {
+		next, ok := table[key]
+		if !ok {
+			next = map[string]interface{}{}
+			table[key] = next
+		}
+		if table, ok = next.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("key %q is already a value, not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func set(table map[string]interface{}, path []string, value interface{}) error {
+	table, err := subtable(table, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	key := path[len(path)-1]
+	if _, ok := table[key]; ok {
+		return fmt.Errorf("key %q defined twice", key)
+	}
+	table[key] = value
+	return nil
+}
+
+// skipSpace skips whitespace and comments, and newlines too if newlines is
+// set.
+func (p *parser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case (c == '\n' || c == '\r') && newlines:
+			if c == '\n' {
+				p.line++
+			}
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) consume(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// key parses a possibly dotted key.
+func (p *parser) key() ([]string, error) {
+	var path []string
+	for {
+		var part string
+		var err error
+		switch {
+		case p.pos >= len(p.src):
+			return nil, fmt.Errorf("expected a key")
+		case p.src[p.pos] == '"':
+			part, err = p.basicString()
+		case p.src[p.pos] == '\'':
+			part, err = p.literalString()
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if part = p.src[start:p.pos]; part == "" {
+				return nil, fmt.Errorf("expected a key, not %q", p.src[p.pos])
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, part)
+		p.skipSpace(false)
+		if !p.consume('.') {
+			return path, nil
+		}
+		p.skipSpace(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *parser) value() (interface{}, error) {
+	rest := p.src[p.pos:]
+	switch {
+	case rest == "":
+		return nil, fmt.Errorf("expected a value")
+	case strings.HasPrefix(rest, `"""`):
+		return p.multilineString(`"""`)
+	case strings.HasPrefix(rest, `'''`):
+		return p.multilineString(`'''`)
+	case rest[0] == '"':
+		return p.basicString()
+	case rest[0] == '\'':
+		return p.literalString()
+	case rest[0] == '[':
+		return p.array()
+	case rest[0] == '{':
+		return p.inlineTable()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n#,]}", p.src[p.pos]) < 0 {
+		p.pos++
+	}
+	return scalar(p.src[start:p.pos])
+}
+
+func scalar(s string) (interface{}, error) {
+	switch s {
+	case "":
+		return nil, fmt.Errorf("expected a value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		if unsigned := strings.TrimLeft(s, "+-"); len(unsigned) > 1 && unsigned[0] == '0' && isDigit(unsigned[1]) {
+			return nil, fmt.Errorf("invalid integer %q (leading zeros aren't allowed)", s)
+		}
+		return n, nil
+	}
+	if strings.ContainsAny(s, ".eE") && !strings.ContainsAny(s, "xob:") && !badDot(s) {
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err == nil {
+			return f, nil
+		}
+	}
+	if strings.ContainsAny(s, ":") || strings.Count(s, "-") >= 2 {
+		return nil, fmt.Errorf("dates and times aren't supported: %s", s)
+	}
+	return nil, fmt.Errorf("invalid value %q (strings must be quoted)", s)
+}
+
+// badDot reports whether the decimal point of the float s isn't between
+// digits, as in 1. or .5, which Go parses but TOML doesn't allow.
+func badDot(s string) bool {
+	i := strings.IndexByte(s, '.')
+	return i >= 0 && (i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1]))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func (p *parser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) escape(b *strings.Builder) error {
+	p.pos++
+	if p.pos >= len(p.src) {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return fmt.Errorf("invalid escape \\%c", c)
+		}
+		r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid escape \\%c%s", c, p.src[p.pos:p.pos+n])
+		}
+		b.WriteRune(rune(r))
+		p.pos += n
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *parser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// multilineString parses a string delimited by three double or single
+// quotes, whose first newline is dropped.
+func (p *parser) multilineString(delim string) (string, error) {
+	p.pos += len(delim)
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if strings.HasPrefix(p.src[p.pos:], "\n") {
+		p.pos++
+		p.line++
+	}
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			// Up to two quotes before the delimiter belong to the string
+			n := len(delim)
+			for n < len(delim)+2 && p.pos+n < len(p.src) && p.src[p.pos+n] == delim[0] {
+				n++
+			}
+			b.WriteString(p.src[p.pos : p.pos+n-len(delim)])
+			p.pos += n
+			return b.String(), nil
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && delim == `"""`:
+			// A backslash at the end of a line trims the whitespace after it
+			if rest := strings.TrimLeft(p.src[p.pos+1:], " \t"); strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+				for p.pos++; p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0; p.pos++ {
+					if p.src[p.pos] == '\n' {
+						p.line++
+					}
+				}
+				continue
+			}
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) array() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.consume(']') {
+			return values, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipSpace(true)
+		if p.consume(']') {
+			return values, nil
+		}
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *parser) inlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := map[string]interface{}{}
+	p.skipSpace(false)
+	if p.consume('}') {
+		return table, nil
+	}
+	for {
+		p.skipSpace(false)
+		path, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.consume('=') {
+			return nil, fmt.Errorf("expected = after key %q", strings.Join(path, "."))
+		}
+		p.skipSpace(false)
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := set(table, path, value); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.consume('}') {
+			return table, nil
+		}
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// Marshal encodes tables of the values Unmarshal returns, as well as int
+// and whole float64 values, which are written as integers.
+func Marshal(table map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := marshalTable(&b, nil, table); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func marshalTable(b *bytes.Buffer, path []string, table map[string]interface{}) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var subtables []string
+	for _, key := range keys {
+		if _, ok := table[key].(map[string]interface{}); ok {
+			subtables = append(subtables, key)
+			continue
+		}
+		value, err := marshalValue(table[key])
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(append(path, key), "."), err)
+		}
+		fmt.Fprintf(b, "%s = %s\n", marshalKey(key), value)
+	}
+	for _, key := range subtables {
+		subpath := append(path[:len(path):len(path)], key)
+		sub := table[key].(map[string]interface{})
+		// A table of tables only needs their headers
+		if !onlyTables(sub) {
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			names := make([]string, len(subpath))
+			for i, name := range subpath {
+				names[i] = marshalKey(name)
+			}
+			fmt.Fprintf(b, "[%s]\n", strings.Join(names, "."))
+		}
+		if err := marshalTable(b, subpath, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func onlyTables(table map[string]interface{}) bool {
+	for _, value := range table {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(table) > 0
+}
+
+func marshalKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isBareKeyChar(key[i]) {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func marshalValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return marshalString(value), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(value):
+			return "nan", nil
+		case math.IsInf(value, 1):
+			return "inf", nil
+		case math.IsInf(value, -1):
+			return "-inf", nil
+		case value == math.Trunc(value) && math.Abs(value) < 1<<53:
+			return strconv.FormatInt(int64(value), 10), nil
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			s, err := marshalValue(v)
+			if err != nil {
+				return "", err
+			}
+			values[i] = s
+		}
+		return "[" + strings.Join(values, ", ") + "]", nil
+	case map[string]interface{}:
+		var b bytes.Buffer
+		if err := marshalTable(&b, nil, value); err != nil {
+			return "", err
+		}
+		pairs := strings.Split(strings.TrimSpace(b.String()), "\n")
+		return "{" + strings.Join(pairs, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("can't encode %T", value)
+}
+
+// marshalString quotes s as a basic string, escaping what TOML requires.
+func marshalString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package toml
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{"empty", "", map[string]interface{}{}},
+		{"comments and blank lines", "# comment\n\n  # indented\n", map[string]interface{}{}},
+		{"bare key", "key = \"value\"", map[string]interface{}{"key": "value"}},
+		{"bare key with dashes", "post-renew_hook1 = 'x'", map[string]interface{}{"post-renew_hook1": "x"}},
+		{"quoted keys", "\"a b\" = 1\n'c.d' = 2", map[string]interface{}{"a b": int64(1), "c.d": int64(2)}},
+		{"dotted keys", "a.b.c = true\na . d = false", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"c": true}, "d": false},
+		}},
+		{"trailing comment", "a = 1 # one", map[string]interface{}{"a": int64(1)}},
+		{"CRLF line endings", "a = 1\r\nb = 2\r\n", map[string]interface{}{"a": int64(1), "b": int64(2)}},
+		{"tables", "top = 1\n[profiles.web]\nhook = \"reload\"\n[profiles.db]\nkeyType = \"rsa2048\"", map[string]interface{}{
+			"top": int64(1),
+			"profiles": map[string]interface{}{
+				"web": map[string]interface{}{"hook": "reload"},
+				"db":  map[string]interface{}{"keyType": "rsa2048"},
+			},
+		}},
+		{"super table after its subtable", "[a.b]\nx = 1\n[a]\ny = 2", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"x": int64(1)}, "y": int64(2)},
+		}},
+		{"quoted table name", "[\"example.com\"]\na = 1", map[string]interface{}{
+			"example.com": map[string]interface{}{"a": int64(1)},
+		}},
+		{"basic string escapes", `s = "a\tb\n\"c\" \\ \u00e9 \U0001F600 \e"`, map[string]interface{}{"s": "a\tb\n\"c\" \\ é 😀 \x1b"}},
+		{"literal string", `s = 'C:\dir\n'`, map[string]interface{}{"s": `C:\dir\n`}},
+		{"multi-line basic string", "s = \"\"\"\nline 1\nline \\\n    2\\t\"\"\"", map[string]interface{}{"s": "line 1\nline 2\t"}},
+		{"multi-line literal string", "s = '''\nfirst\n\\n second'''", map[string]interface{}{"s": "first\n\\n second"}},
+		{"quotes before the closing delimiter", `s = """a ""quoted"""""`, map[string]interface{}{"s": `a ""quoted""`}},
+		{"integers", "a = 42\nb = -17\nc = +1_000\nd = 0xff\ne = 0o17\nf = 0b101\ng = 0", map[string]interface{}{
+			"a": int64(42), "b": int64(-17), "c": int64(1000), "d": int64(255), "e": int64(15), "f": int64(5), "g": int64(0),
+		}},
+		{"floats", "a = 1.5\nb = -0.25\nc = 5e3\nd = 6.626e-34\ne = 1_000.5\nf = -inf", map[string]interface{}{
+			"a": 1.5, "b": -0.25, "c": 5e3, "d": 6.626e-34, "e": 1000.5, "f": math.Inf(-1),
+		}},
+		{"arrays", "a = [1, 2, 3]\nb = [\"x\", 'y',]\nc = []\nd = [[1], [\"mixed\", true]]", map[string]interface{}{
+			"a": []interface{}{int64(1), int64(2), int64(3)},
+			"b": []interface{}{"x", "y"},
+			"c": []interface{}{},
+			"d": []interface{}{[]interface{}{int64(1)}, []interface{}{"mixed", true}},
+		}},
+		{"multi-line array with comments", "a = [\n  1, # one\n  2,\n]\nb = 3", map[string]interface{}{
+			"a": []interface{}{int64(1), int64(2)}, "b": int64(3),
+		}},
+		{"inline tables", "a = {x = 1, y.z = \"s\"}\nb = {}", map[string]interface{}{
+			"a": map[string]interface{}{"x": int64(1), "y": map[string]interface{}{"z": "s"}},
+			"b": map[string]interface{}{},
+		}},
+		{"array of inline tables", "hooks = [{cmd = \"a\"}, {cmd = \"b\"}]", map[string]interface{}{
+			"hooks": []interface{}{map[string]interface{}{"cmd": "a"}, map[string]interface{}{"cmd": "b"}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Unmarshal([]byte(test.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Unmarshal(%q) = %#v, want %#v", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalNaN(t *testing.T) {
+	got, err := Unmarshal([]byte("a = nan\nb = -nan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if f, ok := got[key].(float64); !ok || !math.IsNaN(f) {
+			t.Errorf("%s = %#v, want NaN", key, got[key])
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"array of tables", "[[profiles]]\nname = \"web\"", "line 1: arrays of tables ([[name]]) aren't supported"},
+		{"offset date-time", "a = 1\nwhen = 1979-05-27T07:32:00Z", "line 2: dates and times aren't supported"},
+		{"date-time with a space", "when = 1979-05-27 07:32:00", "dates and times aren't supported"},
+		{"local date", "when = 1979-05-27", "dates and times aren't supported"},
+		{"local time", "when = 07:32:00", "dates and times aren't supported"},
+		{"date in an array", "when = [1979-05-27]", "dates and times aren't supported"},
+		{"unquoted string", "a = value", `invalid value "value" (strings must be quoted)`},
+		{"missing value", "a =\nb = 1", "line 1: expected a value"},
+		{"missing value at the end", "a = ", "expected a value"},
+		{"missing =", "a 1", `expected = after key "a"`},
+		{"missing key", "= 1", `expected a key, not '='`},
+		{"leading zeros", "a = 012", "leading zeros aren't allowed"},
+		{"float without digits after the dot", "a = 1.", `invalid value "1."`},
+		{"float without digits before the dot", "a = .5", `invalid value ".5"`},
+		{"two values on a line", "a = 1 2", `unexpected '2' at end of line`},
+		{"duplicate key", "a = 1\na = 2", `line 2: key "a" defined twice`},
+		{"duplicate dotted key", "a.b = 1\na.b = 2", `key "b" defined twice`},
+		{"duplicate table", "[a]\n[b]\n[a]", `line 3: table "a" defined twice`},
+		{"table over a value", "a = 1\n[a]", `key "a" is already a value, not a table`},
+		{"dotted key over a value", "a = 1\na.b = 2", `key "a" is already a value, not a table`},
+		{"unterminated table header", "[a\nb = 1", "expected ] after table name"},
+		{"unterminated string", "a = \"abc\nb = 1", "unterminated string"},
+		{"unterminated literal string", "a = 'abc", "unterminated string"},
+		{"unterminated multi-line string", "a = \"\"\"\nabc", "unterminated string"},
+		{"invalid escape", `a = "\x41"`, `invalid escape \x`},
+		{"invalid unicode escape", `a = "\uD800"`, `invalid escape \uD800`},
+		{"unterminated array", "a = [1, 2", "expected , or ] in array"},
+		{"array without commas", "a = [1 2]", "expected , or ] in array"},
+		{"inline table without commas", "a = {x = 1 y = 2}", "expected , or } in inline table"},
+		{"inline table over lines", "a = {x = 1,\ny = 2}", "expected a key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Unmarshal([]byte(test.in))
+			if err == nil {
+				t.Fatalf("Unmarshal(%q) succeeded, want an error containing %q", test.in, test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Unmarshal(%q) = %q, want an error containing %q", test.in, err, test.want)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	in := map[string]interface{}{
+		"version":     1,
+		"acceptTerms": true,
+		"ratio":       0.5,
+		"whole":       float64(3),
+		"name":        "quote \" backslash \\ newline \n tab \t bell \a",
+		"key.dotted":  "x",
+		"list":        []interface{}{"a", int64(2), []interface{}{}},
+		"inline":      []interface{}{map[string]interface{}{"cmd": "reload", "n": int64(1)}},
+		"profiles": map[string]interface{}{
+			"web": map[string]interface{}{"postRenewHook": "systemctl reload nginx"},
+			"db":  map[string]interface{}{"keyType": "rsa2048", "sub": map[string]interface{}{"a": false}},
+		},
+	}
+	want := `acceptTerms = true
+inline = [{cmd = "reload", n = 1}]
+"key.dotted" = "x"
+list = ["a", 2, []]
+name = "quote \" backslash \\ newline \n tab \t bell \u0007"
+ratio = 0.5
+version = 1
+whole = 3
+
+[profiles.db]
+keyType = "rsa2048"
+
+[profiles.db.sub]
+a = false
+
+[profiles.web]
+postRenewHook = "systemctl reload nginx"
+`
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", data, want)
+	}
+
+	// What Marshal writes, Unmarshal reads back
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in["version"], in["whole"] = int64(1), int64(3)
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("Unmarshal(Marshal(v)) = %#v, want %#v", got, in)
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	_, err := Marshal(map[string]interface{}{"a": map[string]interface{}{"b": struct{}{}}})
+	if err == nil || !strings.Contains(err.Error(), "a.b: can't encode struct {}") {
+		t.Errorf("Marshal of a struct = %v, want an error naming a.b", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package vault stores files as secrets in a HashiCorp Vault KV version 2
+// secrets engine.
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wildone/localcert"
+)
+
+// Store is a localcert.Store keeping each file as a secret under Path in
+// the KV engine mounted at Mount, with the contents in its "contents" key.
+type Store struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Mount     string
+	Path      string
+
+	HTTPClient *http.Client
+}
+
+// NewStore returns a Store for mountPath, a KV mount followed by the path
+// to keep files under, such as "secret/localcert". The address and token
+// come from the usual VAULT_ADDR, VAULT_TOKEN (or ~/.vault-token),
+// VAULT_NAMESPACE and VAULT_CACERT environment variables.
+func NewStore(mountPath string) (*Store, error) {
+	mountPath = strings.Trim(mountPath, "/")
+	mount, secretPath := mountPath, ""
+	if i := strings.IndexByte(mountPath, '/'); i >= 0 {
+		mount, secretPath = mountPath[:i], mountPath[i+1:]
+	}
+	if mount == "" {
+		return nil, errors.New("empty Vault path")
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR isn't set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		tokenBytes, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_TOKEN isn't set and no token helper file: %w", err)
+		}
+		token = strings.TrimSpace(string(tokenBytes))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("VAULT_CACERT %q has no certificates", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Store{
+		Addr:       strings.TrimRight(addr, "/"),
+		Token:      token,
+		Namespace:  os.Getenv("VAULT_NAMESPACE"),
+		Mount:      mount,
+		Path:       secretPath,
+		HTTPClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+type secret struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+func (s *Store) ReadFile(name string) ([]byte, error) {
+	data, _, err := s.ReadFileVersion(name)
+	return data, err
+}
+
+// ReadFileVersion returns the secret's KV version as its version.
+func (s *Store) ReadFileVersion(name string) ([]byte, string, error) {
+	body, status, err := s.do(http.MethodGet, "data", name, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if status == http.StatusNotFound {
+		return nil, "", fmt.Errorf("vault secret %q: %w", s.secretPath(name), fs.ErrNotExist)
+	}
+	var sec secret
+	if err := json.Unmarshal(body, &sec); err != nil {
+		return nil, "", fmt.Errorf("decode vault secret %q: %w", s.secretPath(name), err)
+	}
+	contents, ok := sec.Data.Data["contents"]
+	if !ok {
+		return nil, "", fmt.Errorf("vault secret %q has no contents", s.secretPath(name))
+	}
+	return []byte(contents), strconv.Itoa(sec.Data.Metadata.Version), nil
+}
+
+func (s *Store) WriteFile(name string, data []byte, _ fs.FileMode) error {
+	_, err := s.write(name, data, nil)
+	return err
+}
+
+// WriteFileIfVersion writes with KV check-and-set, which Vault applies
+// atomically.
+func (s *Store) WriteFileIfVersion(name string, data []byte, _ fs.FileMode, version string) (string, error) {
+	// Version 0 is a secret that doesn't exist yet
+	cas := 0
+	if version != "" {
+		var err error
+		if cas, err = strconv.Atoi(version); err != nil {
+			return "", fmt.Errorf("vault secret %q: invalid version %q", s.secretPath(name), version)
+		}
+	}
+	return s.write(name, data, map[string]int{"cas": cas})
+}
+
+// write stores data as the contents of the named secret, returning its new
+// version.
+func (s *Store) write(name string, data []byte, options map[string]int) (string, error) {
+	req := map[string]interface{}{
+		"data": map[string]string{"contents": string(data)},
+	}
+	if options != nil {
+		req["options"] = options
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	respBody, status, err := s.do(http.MethodPost, "data", name, body)
+	if status == http.StatusBadRequest && options != nil && err != nil && strings.Contains(err.Error(), "check-and-set") {
+		return "", fmt.Errorf("vault secret %q: %w", s.secretPath(name), localcert.ErrConflict)
+	} else if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return "", fmt.Errorf("decode vault response for %q: %w", s.secretPath(name), err)
+	}
+	return strconv.Itoa(resp.Data.Version), nil
+}
+
+// Remove deletes every version of the named secret.
+func (s *Store) Remove(name string) error {
+	_, status, err := s.do(http.MethodDelete, "metadata", name, nil)
+	if err == nil && status == http.StatusNotFound {
+		return fmt.Errorf("vault secret %q: %w", s.secretPath(name), fs.ErrNotExist)
+	}
+	return err
+}
+
+func (s *Store) secretPath(name string) string {
+	return path.Join(s.Path, filepath.ToSlash(name))
+}
+
+// do makes a KV API request, returning the response body. A 404 is returned
+// as a status rather than an error.
+func (s *Store) do(method, api, name string, body []byte) ([]byte, int, error) {
+	u := s.Addr + "/v1/" + url.PathEscape(s.Mount) + "/" + api + "/" + escapePath(s.secretPath(name))
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, resp.StatusCode, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return nil, resp.StatusCode, fmt.Errorf("vault %s %s: %s", method, s.secretPath(name), strings.Join(vaultErr.Errors, "; "))
+		}
+		return nil, resp.StatusCode, fmt.Errorf("vault %s %s: %s", method, s.secretPath(name), resp.Status)
+	}
+	return respBody, resp.StatusCode, nil
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
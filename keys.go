@@ -0,0 +1,243 @@
+package localcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/wildone/localcert/internal/pemutil"
+	"github.com/wildone/localcert/internal/pkcs8"
+)
+
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA4096   KeyType = "rsa4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+
+	DefaultKeyType = KeyTypeECDSAP256
+)
+
+func ParseKeyType(s string) (KeyType, error) {
+	switch kt := KeyType(s); kt {
+	case KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519:
+		return kt, nil
+	case "":
+		return DefaultKeyType, nil
+	default:
+		return "", fmt.Errorf("unknown key type %q", s)
+	}
+}
+
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeECDSAP256, "":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+// KeyTypeOf returns the KeyType of pub, or "" if it isn't one of the
+// supported types.
+func KeyTypeOf(pub crypto.PublicKey) KeyType {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		switch pub.N.BitLen() {
+		case 2048:
+			return KeyTypeRSA2048
+		case 4096:
+			return KeyTypeRSA4096
+		}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return KeyTypeECDSAP256
+		case elliptic.P384():
+			return KeyTypeECDSAP384
+		}
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	}
+	return ""
+}
+
+// CheckSigner signs a test message with key and verifies the signature,
+// so that a key backend of the caller's own, such as a TPM or cloud KMS,
+// that signs wrongly fails before the CA is asked for a certificate rather
+// than with an unhelpful error from the CA. Sign must return ECDSA
+// signatures ASN.1-encoded, as crypto/ecdsa does.
+func CheckSigner(key crypto.Signer) error {
+	msg := []byte("localcert signer check")
+	digest := sha256.Sum256(msg)
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("the RSA signature doesn't verify; Sign must make PKCS #1 v1.5 signatures of the digest")
+		}
+	case *ecdsa.PublicKey:
+		sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("the ECDSA signature doesn't verify; Sign must return it ASN.1-encoded")
+		}
+	case ed25519.PublicKey:
+		sig, err := key.Sign(rand.Reader, msg, crypto.Hash(0))
+		if err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+		if !ed25519.Verify(pub, msg, sig) {
+			return errors.New("the Ed25519 signature doesn't verify; Sign must sign the message itself")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+// MarshalPrivateKey encodes key as PKCS #8.
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// EncryptPrivateKey encodes key as a PKCS #8 EncryptedPrivateKeyInfo,
+// encrypted with passphrase.
+func EncryptPrivateKey(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	der, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pkcs8.Encrypt(der, passphrase)
+}
+
+// DecryptPrivateKey decodes a PKCS #8 EncryptedPrivateKeyInfo encrypted
+// with passphrase.
+func DecryptPrivateKey(der, passphrase []byte) (crypto.Signer, error) {
+	decrypted, err := pkcs8.Decrypt(der, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(decrypted)
+}
+
+// ParsePrivateKey decodes a PKCS #8, SEC 1 or PKCS #1 private key.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key encoding")
+}
+
+// readKeyFile reads a PEM private key, decrypting it with passphrase if it
+// is encrypted. It also reports whether the file was encrypted.
+func readKeyFile(store Store, name string, passphrase []byte) (crypto.Signer, bool, error) {
+	data, err := store.ReadFile(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("read %q: %w", name, err)
+	}
+	block, err := pemutil.DecodePEMBlock(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("read %q: %w", name, err)
+	}
+	switch block.Type {
+	case pemutil.EncryptedPrivateKeyType:
+		if len(passphrase) == 0 {
+			return nil, true, fmt.Errorf("decode %q: key is encrypted and no passphrase was given", name)
+		}
+		key, err := DecryptPrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, true, fmt.Errorf("decode %q: %w", name, err)
+		}
+		return key, true, nil
+	case pemutil.PrivateKeyType, "EC PRIVATE KEY", "RSA PRIVATE KEY":
+		key, err := ParsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, false, fmt.Errorf("decode %q: %w", name, err)
+		}
+		return key, false, nil
+	default:
+		return nil, false, fmt.Errorf("read %q: unexpected PEM type %q", name, block.Type)
+	}
+}
+
+// writeKeyFile writes key as PEM, encrypted with passphrase if it is set.
+func writeKeyFile(store Store, name string, key crypto.Signer, passphrase []byte, perm os.FileMode) error {
+	pemType := pemutil.PrivateKeyType
+	var der []byte
+	var err error
+	if len(passphrase) > 0 {
+		pemType = pemutil.EncryptedPrivateKeyType
+		der, err = EncryptPrivateKey(key, passphrase)
+	} else {
+		der, err = MarshalPrivateKey(key)
+	}
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	if err := store.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), perm); err != nil {
+		return fmt.Errorf("write %q: %w", name, err)
+	}
+	return nil
+}
+
+// loadCertificate reads a certificate chain file and pairs it with key.
+// ErrKeyMismatch means a certificate's public key isn't that of the key
+// stored with it, which would make servers fail to load the pair.
+var ErrKeyMismatch = errors.New("certificate does not match its key")
+
+func loadCertificate(store Store, certFile string, key crypto.Signer) (*tls.Certificate, error) {
+	data, err := store.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", certFile, err)
+	}
+	chain, err := pemutil.DecodePEMChain(data, pemutil.CertificateType)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", certFile, err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %w", certFile, err)
+	}
+	if !publicKeysEqual(leaf.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("%q: %w", certFile, ErrKeyMismatch)
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,1072 @@
+package localcert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wildone/localcert/internal/acmeutil"
+	"github.com/wildone/localcert/internal/pemutil"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// DefaultFailureRetryInterval is how long GetCertificate waits after a
+	// failed issuance before trying again, unless FailureRetryInterval is
+	// set.
+	DefaultFailureRetryInterval = time.Minute
+
+	filePerm = 0700
+)
+
+// Manager provisions and renews a localcert certificate, storing the
+// certificate chain and its private key in files.
+type Manager struct {
+	Config Config
+
+	CertificateFile string
+	KeyFile         string
+
+	// OrderFile, if set, records the URL of the order in progress until its
+	// certificate is stored, so that if the process dies in between, the next
+	// renewal resumes the order rather than placing another.
+	OrderFile string
+
+	// Store, if set, holds CertificateFile, KeyFile and OrderFile instead of
+	// the local filesystem.
+	Store Store
+
+	// Signer, if set, is the certificate key, such as one held in a hardware
+	// token, TPM or cloud KMS, and KeyFile, KeyType and KeyPassphrase are
+	// unused. Any crypto.Signer with an RSA, ECDSA or Ed25519 public key
+	// will do; it is checked with CheckSigner before each issuance.
+	Signer crypto.Signer
+
+	// CertFileMode and KeyFileMode are the permissions CertificateFile, and
+	// KeyFile and PreviousKeyFile, are written with; 0700 if zero.
+	CertFileMode os.FileMode
+	KeyFileMode  os.FileMode
+
+	// KeyPassphrase, if set, encrypts the certificate key file. An existing
+	// unencrypted key is encrypted the next time it is used.
+	KeyPassphrase []byte
+
+	// KeyType is the type of certificate key to generate; DefaultKeyType if
+	// empty. An existing key of another type is replaced on the next renewal.
+	KeyType KeyType
+
+	// RotateKey replaces the certificate key with a new one on the next
+	// renewal even if it is of KeyType.
+	RotateKey bool
+
+	// PreviousKeyFile, if set, keeps a replaced certificate key, so that it
+	// can be served until clients that pin it have moved to the new one.
+	PreviousKeyFile string
+
+	// Domain, if set, is issued for instead of the domain assigned by the
+	// localcert server. Config.ChallengeSolver or Config.Challengers must be
+	// able to complete its challenges.
+	Domain string
+
+	// CachedDomain is the domain the localcert server assigned before,
+	// renewed for when the server can't be reached to look it up, as during
+	// an outage; the existing certificate's domain if empty.
+	CachedDomain string
+
+	// Wildcard requests both *.<domain> and the bare domain, checking first
+	// that the servers allow wildcard issuance.
+	Wildcard bool
+
+	// Subdomains are names under the assigned domain, such as "app" for
+	// app.<domain>, to include in the certificate alongside it.
+	Subdomains []string
+
+	// IPAddresses are included in the certificate as IP identifiers (RFC
+	// 8738), for services reached by address. The CA must support them, and
+	// Config.Challengers must include http-01 or tls-alpn-01 to validate
+	// them.
+	IPAddresses []net.IP
+
+	// MustStaple requests OCSP Must-Staple in the certificate, which clients
+	// then reject unless the server staples an OCSP response.
+	MustStaple bool
+
+	// CSR, if set, is a DER-encoded certificate signing request sent to the
+	// CA as is. Its names replace Domain, Wildcard and Subdomains, and its
+	// key is the certificate key: KeyFile is read but never generated.
+	CSR []byte
+
+	// AccountURL and AcceptedTerms identify the registered ACME account. They
+	// are updated on registration and passed to SaveAccount, if set.
+	AccountURL    string
+	AcceptedTerms string
+	SaveAccount   func(accountURL, acceptedTerms string) error
+
+	// AcceptTerms is called when the ACME provider's terms of service have
+	// not been accepted. If nil, registration fails with TermsNotAcceptedError.
+	AcceptTerms func(termsURI string) bool
+
+	// Renewal decides when a certificate is renewed, unless the CA suggests
+	// a renewal window with ACME Renewal Information and IgnoreARI is unset.
+	Renewal   RenewalPolicy
+	IgnoreARI bool
+
+	// FailureRetryInterval is how long GetCertificate fails handshakes with
+	// the error of a failed issuance before trying again, so that they
+	// don't hammer the CA; DefaultFailureRetryInterval if zero.
+	FailureRetryInterval time.Duration
+
+	// Logf, if set, receives progress messages.
+	Logf func(format string, args ...interface{})
+
+	mu sync.Mutex
+
+	// getMu guards source, flight, failure, checked and recheck, which
+	// GetCertificate uses without waiting on mu. checked is the served
+	// certificate last found not due for renewal, until recheck.
+	getMu   sync.Mutex
+	source  *CertSource
+	flight  *issuance
+	failure *failedIssuance
+	checked *x509.Certificate
+	recheck time.Time
+}
+
+// issuance is a GetCertificate issuance in progress; done is closed once
+// cert or err is set.
+type issuance struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// failedIssuance is the error of the last GetCertificate issuance, until it
+// is retried.
+type failedIssuance struct {
+	err error
+	at  time.Time
+}
+
+type Result struct {
+	Domain      string
+	Chain       [][]byte
+	Certificate *x509.Certificate
+	Previous    *x509.Certificate
+	Renewed     bool
+	// CachedDomain reports that Domain wasn't confirmed by the localcert
+	// server, which couldn't be reached, and came from Manager.CachedDomain
+	// or the previous certificate.
+	CachedDomain bool
+}
+
+func (m *Manager) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// Certificate returns the current certificate and key.
+func (m *Manager) Certificate() (*tls.Certificate, error) {
+	key, err := m.readKey()
+	if err != nil {
+		return nil, err
+	}
+	return loadCertificate(storeOrFiles(m.Store), m.CertificateFile, key)
+}
+
+// GetCertificate serves the certificate to tls.Config.GetCertificate from
+// CertSource, so that it picks up renewals made by Provision, Renew or
+// another process. Once the certificate is due for renewal it is renewed in
+// the background while it is still served. If there is none yet or it has
+// expired, one is obtained first: handshakes that arrive meanwhile wait for
+// that single issuance, each no longer than its own handshake context
+// allows. A failed issuance isn't tried again for FailureRetryInterval;
+// handshakes without a certificate fail with its error until then.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := m.certSource().GetCertificate(hello)
+	if err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+		m.getMu.Lock()
+		if m.flight == nil && !m.failing() && (cert.Leaf != m.checked || !time.Now().Before(m.recheck)) {
+			m.flight = &issuance{done: make(chan struct{})}
+			go m.issueForHandshakes(m.flight, cert.Leaf)
+		}
+		m.getMu.Unlock()
+		return cert, nil
+	}
+
+	ctx := context.Background()
+	if hello != nil && hello.Context() != nil {
+		ctx = hello.Context()
+	}
+	m.getMu.Lock()
+	if m.failing() {
+		err := m.failure.err
+		m.getMu.Unlock()
+		return nil, err
+	}
+	call := m.flight
+	if call == nil {
+		call = &issuance{done: make(chan struct{})}
+		m.flight = call
+		go m.issueForHandshakes(call, nil)
+	}
+	m.getMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.cert, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// certSource returns the CertSource GetCertificate serves from.
+func (m *Manager) certSource() *CertSource {
+	m.getMu.Lock()
+	defer m.getMu.Unlock()
+	if m.source == nil {
+		m.source = m.CertSource()
+	}
+	return m.source
+}
+
+// reloadSource has GetCertificate serve a certificate just stored without
+// waiting for its CertSource to notice.
+func (m *Manager) reloadSource() {
+	m.getMu.Lock()
+	source := m.source
+	m.getMu.Unlock()
+	if source == nil {
+		return
+	}
+	if err := source.Reload(); err != nil {
+		m.logf("Error loading the new certificate for TLS handshakes: %v\n", err)
+	}
+}
+
+// failing reports whether a GetCertificate issuance failed within
+// FailureRetryInterval. getMu must be held.
+func (m *Manager) failing() bool {
+	return m.failure != nil && time.Since(m.failure.at) < m.failureRetryInterval()
+}
+
+// issueForHandshakes renews leaf, the served certificate, if it is due, or
+// provisions one if leaf is nil because there is none or it has expired,
+// for the GetCertificate calls waiting on call. It doesn't use their
+// contexts, so that the handshake that started it timing out doesn't fail
+// the others.
+func (m *Manager) issueForHandshakes(call *issuance, leaf *x509.Certificate) {
+	ctx := context.Background()
+	var next time.Time
+	var err error
+	if leaf == nil {
+		_, err = m.Provision(ctx)
+	} else if due, recheck := m.RenewalSchedule(ctx, leaf); m.NeedsRenewalAt(leaf, due) {
+		m.logf("Renewing the certificate served to TLS handshakes\n")
+		_, err = m.Provision(ctx)
+	} else {
+		next = due
+		if !recheck.IsZero() && recheck.Before(next) {
+			next = recheck
+		}
+	}
+	var cert *tls.Certificate
+	if err == nil {
+		cert, err = m.certSource().GetCertificate(nil)
+	}
+
+	m.getMu.Lock()
+	defer m.getMu.Unlock()
+	m.flight = nil
+	if err != nil {
+		m.logf("Error obtaining a certificate for TLS handshakes; retrying in %s: %v\n", m.failureRetryInterval(), err)
+		m.failure = &failedIssuance{err: err, at: time.Now()}
+		cert = nil
+	} else {
+		m.failure = nil
+		// A renewed certificate is checked on the next handshake
+		m.checked, m.recheck = nil, time.Time{}
+		if cert.Leaf == leaf {
+			m.checked, m.recheck = leaf, next
+		}
+	}
+	call.cert, call.err = cert, err
+	close(call.done)
+}
+
+func (m *Manager) failureRetryInterval() time.Duration {
+	if m.FailureRetryInterval <= 0 {
+		return DefaultFailureRetryInterval
+	}
+	return m.FailureRetryInterval
+}
+
+// NeedsRenewal reports whether cert is due for renewal, or doesn't match
+// the configured key type or names.
+func (m *Manager) NeedsRenewal(ctx context.Context, cert *x509.Certificate) bool {
+	return m.NeedsRenewalAt(cert, m.RenewalTime(ctx, cert))
+}
+
+// NeedsRenewalAt reports whether cert needs renewal as NeedsRenewal does,
+// given when it is due from RenewalTime or RenewalSchedule, so that a
+// caller that has it doesn't fetch the CA's renewal information again.
+func (m *Manager) NeedsRenewalAt(cert *x509.Certificate, due time.Time) bool {
+	if !time.Now().Before(due) || len(m.MissingNames(cert)) > 0 {
+		return true
+	}
+	if m.CSR != nil {
+		csr, err := x509.ParseCertificateRequest(m.CSR)
+		return err != nil || !publicKeysEqual(cert.PublicKey, csr.PublicKey)
+	}
+	return KeyTypeOf(cert.PublicKey) != m.keyType() || HasMustStaple(cert) != m.MustStaple
+}
+
+// RenewalTime returns when cert is due for renewal: within the CA's
+// suggested window if it supports ARI, and according to Renewal otherwise.
+func (m *Manager) RenewalTime(ctx context.Context, cert *x509.Certificate) time.Time {
+	due, _ := m.RenewalSchedule(ctx, cert)
+	return due
+}
+
+// RenewalSchedule returns when cert is due for renewal, as RenewalTime
+// does, and when to fetch the CA's renewal information again in case its
+// suggested window moves. recheck is zero if there is none to fetch.
+func (m *Manager) RenewalSchedule(ctx context.Context, cert *x509.Certificate) (due, recheck time.Time) {
+	if !m.IgnoreARI {
+		info, err := m.Config.Client().RenewalInfo(ctx, cert)
+		if err != nil {
+			m.logf("Error fetching renewal info; using renewal policy: %v\n", err)
+			return m.Renewal.RenewalTime(cert), time.Now().Add(DefaultARIPollInterval)
+		} else if info != nil {
+			if info.ExplanationURL != "" {
+				m.logf("CA renewal window %s to %s: %s\n", info.SuggestedWindow.Start, info.SuggestedWindow.End, info.ExplanationURL)
+			}
+			retryAfter := info.RetryAfter
+			if retryAfter <= 0 {
+				retryAfter = DefaultARIPollInterval
+			}
+			return info.RenewalTime(cert), time.Now().Add(retryAfter)
+		}
+	}
+	return m.Renewal.RenewalTime(cert), time.Time{}
+}
+
+// Names returns the names to request for the assigned domain.
+func (m *Manager) Names(domain string) []string {
+	names := []string{domain}
+	base := strings.TrimPrefix(domain, "*.")
+	if m.Wildcard {
+		names = []string{"*." + base, base}
+	}
+	for _, subdomain := range m.Subdomains {
+		names = append(names, subdomain+"."+base)
+	}
+	for _, ip := range m.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// MissingNames returns the configured names that cert doesn't cover.
+func (m *Manager) MissingNames(cert *x509.Certificate) []string {
+	names := m.Names(cert.Subject.CommonName)
+	if m.CSR != nil {
+		if csr, err := x509.ParseCertificateRequest(m.CSR); err == nil {
+			names = CSRNames(csr)
+		}
+	}
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, dnsName := range cert.DNSNames {
+			if strings.EqualFold(dnsName, name) {
+				found = true
+				break
+			}
+		}
+		if ip := net.ParseIP(name); ip != nil {
+			for _, certIP := range cert.IPAddresses {
+				if certIP.Equal(ip) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func (m *Manager) keyType() KeyType {
+	if m.Signer != nil {
+		return KeyTypeOf(m.Signer.Public())
+	}
+	if m.KeyType == "" {
+		return DefaultKeyType
+	}
+	return m.KeyType
+}
+
+// Provision obtains a certificate if there is none or the current one needs
+// renewal. Concurrent calls wait for a single issuance.
+func (m *Manager) Provision(ctx context.Context) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chain, cert, err := m.readChain()
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil && !m.NeedsRenewal(ctx, cert) {
+		err := m.CheckPair(cert)
+		if errors.Is(err, ErrKeyMismatch) && m.InterruptedRenewal(cert) {
+			m.logf("The renewal of this certificate was interrupted before storing its key; storing it now\n")
+			if err = m.finishRenewal(); err == nil {
+				m.reloadSource()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Domain: cert.Subject.CommonName, Chain: chain, Certificate: cert, Previous: cert}, nil
+	}
+	result, err := m.renew(ctx, cert)
+	if err == nil {
+		m.reloadSource()
+	}
+	return result, rateLimited(err)
+}
+
+// InterruptedRenewal reports whether cert, which CheckPair found doesn't
+// match the stored key, is for the new key of a renewal that was
+// interrupted after storing cert but before swapping in its key.
+// FinishRenewal then swaps it in.
+func (m *Manager) InterruptedRenewal(cert *x509.Certificate) bool {
+	if m.Signer != nil || m.KeyFile == "" {
+		return false
+	}
+	key, _, err := readKeyFile(storeOrFiles(m.Store), m.pendingKeyFile(), m.KeyPassphrase)
+	return err == nil && publicKeysEqual(cert.PublicKey, key.Public())
+}
+
+// FinishRenewal swaps in the key of a renewal that was interrupted after
+// storing its certificate, as reported by InterruptedRenewal.
+func (m *Manager) FinishRenewal() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.finishRenewal(); err != nil {
+		return err
+	}
+	m.reloadSource()
+	return nil
+}
+
+// Renew obtains a new certificate regardless of the current one's expiry.
+func (m *Manager) Renew(ctx context.Context) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, cert, err := m.readChain()
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.renew(ctx, cert)
+	if err == nil {
+		m.reloadSource()
+	}
+	return result, rateLimited(err)
+}
+
+// Register registers the ACME account, accepting the terms of service with
+// AcceptTerms, or checks that the existing account is valid.
+func (m *Manager) Register(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return rateLimited(m.ensureRegistration(ctx, m.Config.Client()))
+}
+
+// ImportCertificate stores a certificate chain issued outside of ACME after
+// checking that it matches the certificate key.
+func (m *Manager) ImportCertificate(chain [][]byte) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, prev, err := m.readChain()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	key, err := m.readKey()
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("key %q: %w", m.KeyFile, ErrKeyMismatch)
+	}
+	if err := m.writeChain(chain); err != nil {
+		return nil, err
+	}
+	if err := m.checkStoredPair(); err != nil {
+		return nil, err
+	}
+	m.reloadSource()
+	return &Result{Domain: cert.Subject.CommonName, Chain: chain, Certificate: cert, Previous: prev, Renewed: true}, nil
+}
+
+// ImportKeyPair stores key as the certificate key along with a chain for
+// it, such as one issued to another ACME client.
+func (m *Manager) ImportKeyPair(key crypto.Signer, chain [][]byte) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Signer != nil {
+		return nil, errors.New("can't import a key into a hardware-backed Signer")
+	}
+	_, prev, err := m.readChain()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("imported key: %w", ErrKeyMismatch)
+	}
+	if err := m.keepPreviousKey(key); err != nil {
+		return nil, err
+	}
+	if err := m.writeKey(key); err != nil {
+		return nil, err
+	}
+	if err := m.writeChain(chain); err != nil {
+		return nil, err
+	}
+	if err := m.checkStoredPair(); err != nil {
+		return nil, err
+	}
+	m.reloadSource()
+	return &Result{Domain: cert.Subject.CommonName, Chain: chain, Certificate: cert, Previous: prev, Renewed: true}, nil
+}
+
+// Revoke revokes the current certificate. The request is signed with the
+// certificate key if withCertKey is set, and with the ACME account key
+// otherwise.
+func (m *Manager) Revoke(ctx context.Context, reason acme.CRLReasonCode, withCertKey bool) (*x509.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, cert, err := m.readChain()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate in %q", m.CertificateFile)
+	}
+	var key crypto.Signer
+	if withCertKey {
+		if key, err = m.readKey(); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.Config.Client().RevokeCertificate(ctx, cert.Raw, key, reason); err != nil {
+		return nil, rateLimited(err)
+	}
+	return cert, nil
+}
+
+// CertificateKey returns the certificate private key, generating it if it
+// doesn't exist yet or isn't of the configured KeyType.
+func (m *Manager) CertificateKey() (crypto.Signer, error) {
+	key, isNew, err := m.issuanceKey()
+	if err != nil {
+		return nil, err
+	}
+	if isNew {
+		if err := m.writeKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// issuanceKey returns the key to issue a certificate for and whether it
+// needs writing, because it was newly generated or is to be encrypted.
+func (m *Manager) issuanceKey() (crypto.Signer, bool, error) {
+	if m.Signer != nil {
+		if err := CheckSigner(m.Signer); err != nil {
+			return nil, false, err
+		}
+		return m.Signer, false, nil
+	}
+	key, encrypted, err := readKeyFile(storeOrFiles(m.Store), m.KeyFile, m.KeyPassphrase)
+	if m.CSR != nil {
+		// The key belongs to whoever made the CSR
+		if err != nil {
+			return nil, false, fmt.Errorf("the CSR's key: %w", err)
+		}
+		return key, false, nil
+	}
+	if err == nil {
+		keyType := KeyTypeOf(key.Public())
+		switch {
+		case keyType == m.keyType() && !m.RotateKey:
+			return key, len(m.KeyPassphrase) > 0 && !encrypted, nil
+		case keyType == m.keyType():
+			m.logf("Rotating the certificate key\n")
+		default:
+			m.logf("Replacing %s certificate key with a new %s key\n", keyType, m.keyType())
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	key, err = GenerateKey(m.keyType())
+	if err != nil {
+		return nil, false, fmt.Errorf("generate: %w", err)
+	}
+	return key, true, nil
+}
+
+func (m *Manager) renew(ctx context.Context, prev *x509.Certificate) (*Result, error) {
+	client := m.Config.Client()
+	if err := m.ensureRegistration(ctx, client); err != nil {
+		return nil, err
+	}
+
+	if m.CSR != nil {
+		return m.renewCSR(ctx, client, prev)
+	}
+
+	domain, cachedDomain := m.Domain, false
+	if domain == "" {
+		var err error
+		if domain, err = client.GetDomain(ctx); err != nil {
+			if domain = m.cachedDomain(prev); domain == "" || ctx.Err() != nil || !serverUnavailable(err) {
+				return nil, fmt.Errorf("get domain: %w", err)
+			}
+			m.logf("Couldn't reach the localcert server to look up the domain (%v); renewing the cached domain %q\n", err, domain)
+			cachedDomain = true
+		}
+	}
+
+	if m.Wildcard {
+		if err := client.CheckWildcardPolicy(ctx, domain); err != nil {
+			return nil, fmt.Errorf("wildcard: %w", err)
+		}
+	}
+	if len(m.IPAddresses) > 0 {
+		if err := client.CheckIPPolicy(ctx); err != nil {
+			return nil, fmt.Errorf("ip addresses: %w", err)
+		}
+	}
+
+	certKey, newKey, err := m.issuanceKey()
+	if err != nil {
+		return nil, fmt.Errorf("certificate key: %w", err)
+	}
+	var extensions []pkix.Extension
+	if m.MustStaple {
+		extensions = append(extensions, MustStapleExtension)
+	}
+	names := m.Names(domain)
+	csr, err := CreateCSRWithExtensions(names[0], certKey, extensions, names[1:]...)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logf("Provisioning domain %q...\n", domain)
+	chain, err := m.issue(ctx, client, names, csr, certKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	m.auditIssuance(cert)
+	// A new key is stored next to the old one and swapped in only once its
+	// chain is stored: if the chain isn't written, the old pair is left as
+	// it was, and if the swap isn't made, the next Provision makes it
+	if newKey {
+		if err := m.writePendingKey(certKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.writeChain(chain); err != nil {
+		return nil, err
+	}
+	if newKey {
+		if err := m.finishRenewal(); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.checkStoredPair(); err != nil {
+		return nil, err
+	}
+	if err := m.removeOrder(); err != nil {
+		return nil, err
+	}
+	return &Result{Domain: domain, Chain: chain, Certificate: cert, Previous: prev, Renewed: true, CachedDomain: cachedDomain}, nil
+}
+
+// cachedDomain returns the domain to fall back on when the localcert server
+// can't be reached, or "" if there is none.
+func (m *Manager) cachedDomain(prev *x509.Certificate) string {
+	if m.CachedDomain != "" {
+		return m.CachedDomain
+	}
+	if prev != nil {
+		return prev.Subject.CommonName
+	}
+	return ""
+}
+
+// serverUnavailable reports whether err means the server couldn't be
+// reached or couldn't answer, rather than that it refused the request.
+func serverUnavailable(err error) bool {
+	var netErr net.Error
+	var statusErr *acmeutil.StatusError
+	switch {
+	case errors.As(err, &netErr):
+		return true
+	case errors.As(err, &statusErr):
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// renewCSR orders a certificate for the names of the user-supplied CSR.
+func (m *Manager) renewCSR(ctx context.Context, client *Client, prev *x509.Certificate) (*Result, error) {
+	csr, err := x509.ParseCertificateRequest(m.CSR)
+	if err != nil {
+		return nil, fmt.Errorf("parse csr: %w", err)
+	}
+	names := CSRNames(csr)
+	m.logf("Provisioning %s for the CSR...\n", strings.Join(names, ", "))
+	chain, err := m.issue(ctx, client, names, m.CSR, csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	m.auditIssuance(cert)
+	if err := m.writeChain(chain); err != nil {
+		return nil, err
+	}
+	if err := m.checkStoredPair(); err != nil {
+		return nil, err
+	}
+	if err := m.removeOrder(); err != nil {
+		return nil, err
+	}
+	return &Result{Domain: names[0], Chain: chain, Certificate: cert, Previous: prev, Renewed: true}, nil
+}
+
+// auditIssuance records the issuance of cert.
+func (m *Manager) auditIssuance(cert *x509.Certificate) {
+	names := cert.DNSNames
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	audit(m.Config.Audit, "issuance", nil, "names", strings.Join(names, ","), "serial", cert.SerialNumber.Text(16), "notAfter", cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// issue orders a certificate for names with csr, whose key is certKey. It
+// resumes the order in OrderFile, if any, and records a new order there.
+func (m *Manager) issue(ctx context.Context, client *Client, names []string, csr []byte, certKey crypto.PublicKey) ([][]byte, error) {
+	chain, err := m.issueOrder(ctx, client, names, csr, certKey)
+	if err != nil {
+		audit(m.Config.Audit, "issuance", err, "names", strings.Join(names, ","))
+	}
+	return chain, err
+}
+
+func (m *Manager) issueOrder(ctx context.Context, client *Client, names []string, csr []byte, certKey crypto.PublicKey) ([][]byte, error) {
+	orderURL, err := m.readOrder()
+	if err != nil {
+		return nil, err
+	}
+	if orderURL != "" {
+		order, err := client.ResumeOrder(ctx, orderURL, names)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			m.logf("Not resuming order %s: %v\n", orderURL, err)
+		} else {
+			m.logf("Resuming order %s; waiting for certificate generation...\n", orderURL)
+			chain, err := client.FinalizeCSR(ctx, order, csr)
+			if err != nil {
+				return nil, fmt.Errorf("fetch certificate: %w", err)
+			}
+			if chainHasKey(chain, certKey) {
+				return chain, nil
+			}
+			// The order was finalized with a new key that was lost before it
+			// could be stored
+			m.logf("The resumed order's certificate is for another key; ordering again\n")
+		}
+	}
+
+	order, err := client.NewOrder(ctx, names)
+	if err != nil {
+		return nil, fmt.Errorf("provision domain: %w", err)
+	}
+	if err := m.writeOrder(order.URI); err != nil {
+		return nil, err
+	}
+	if order, err = client.Authorize(ctx, order); err != nil {
+		return nil, fmt.Errorf("provision domain: %w", err)
+	}
+	m.logf("Domain provisioned; waiting for certificate generation...\n")
+	chain, err := client.FinalizeCSR(ctx, order, csr)
+	if err != nil {
+		return nil, fmt.Errorf("fetch certificate: %w", err)
+	}
+	if !chainHasKey(chain, certKey) {
+		return nil, fmt.Errorf("fetch certificate: %w", ErrKeyMismatch)
+	}
+	return chain, nil
+}
+
+func chainHasKey(chain [][]byte, key crypto.PublicKey) bool {
+	cert, err := x509.ParseCertificate(chain[0])
+	return err == nil && publicKeysEqual(cert.PublicKey, key)
+}
+
+func (m *Manager) readOrder() (string, error) {
+	if m.OrderFile == "" {
+		return "", nil
+	}
+	data, err := storeOrFiles(m.Store).ReadFile(m.OrderFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("read %q: %w", m.OrderFile, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (m *Manager) writeOrder(orderURL string) error {
+	if m.OrderFile == "" {
+		return nil
+	}
+	if err := storeOrFiles(m.Store).WriteFile(m.OrderFile, []byte(orderURL+"\n"), filePerm); err != nil {
+		return fmt.Errorf("write %q: %w", m.OrderFile, err)
+	}
+	return nil
+}
+
+// removeOrder forgets the order once its certificate is stored, so that the
+// next renewal doesn't download the same certificate again.
+func (m *Manager) removeOrder() error {
+	if m.OrderFile == "" {
+		return nil
+	}
+	err := storeOrFiles(m.Store).Remove(m.OrderFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove %q: %w", m.OrderFile, err)
+	}
+	return nil
+}
+
+func (m *Manager) ensureRegistration(ctx context.Context, client *Client) error {
+	termsRetry := false
+	for {
+		account, err := client.EnsureRegistration(ctx, m.AcceptedTerms, m.AccountURL)
+		if termsErr := (TermsNotAcceptedError{}); !termsRetry && m.AcceptTerms != nil && errors.As(err, &termsErr) {
+			if !m.AcceptTerms(termsErr.URI) {
+				return err
+			}
+			m.AcceptedTerms = termsErr.URI
+			termsRetry = true
+			continue
+		} else if err != nil {
+			return fmt.Errorf("registration: %w", err)
+		}
+		m.AccountURL = account.URI
+		break
+	}
+	if m.SaveAccount != nil {
+		if err := m.SaveAccount(m.AccountURL, m.AcceptedTerms); err != nil {
+			return fmt.Errorf("save account: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) readChain() ([][]byte, *x509.Certificate, error) {
+	data, err := storeOrFiles(m.Store).ReadFile(m.CertificateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("read %q: %w", m.CertificateFile, err)
+	}
+	chain, err := pemutil.DecodePEMChain(data, pemutil.CertificateType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %q: %w", m.CertificateFile, err)
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %q: %w", m.CertificateFile, err)
+	}
+	return chain, cert, nil
+}
+
+func (m *Manager) writeChain(chain [][]byte) error {
+	err := storeOrFiles(m.Store).WriteFile(m.CertificateFile, pemutil.EncodePEMChain(pemutil.CertificateType, chain), fileMode(m.CertFileMode))
+	audit(m.Config.Audit, "write", err, "path", m.CertificateFile)
+	if err != nil {
+		return fmt.Errorf("write %q: %w", m.CertificateFile, err)
+	}
+	return nil
+}
+
+// CheckPair returns an error wrapping ErrKeyMismatch if cert isn't for the
+// stored key.
+func (m *Manager) CheckPair(cert *x509.Certificate) error {
+	key, err := m.readKey()
+	if err != nil {
+		return fmt.Errorf("certificate key: %w", err)
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		if m.KeyFile == "" || m.Signer != nil {
+			return fmt.Errorf("%q: %w", m.CertificateFile, ErrKeyMismatch)
+		}
+		return fmt.Errorf("%q and %q: %w", m.CertificateFile, m.KeyFile, ErrKeyMismatch)
+	}
+	return nil
+}
+
+// checkStoredPair reads back the certificate and key just written and
+// checks that they match, so that a write gone wrong is an error rather
+// than a pair servers fail to load.
+func (m *Manager) checkStoredPair() error {
+	_, cert, err := m.readChain()
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		return fmt.Errorf("%q is missing after writing it", m.CertificateFile)
+	}
+	return m.CheckPair(cert)
+}
+
+func (m *Manager) readKey() (crypto.Signer, error) {
+	if m.Signer != nil {
+		return m.Signer, nil
+	}
+	key, _, err := readKeyFile(storeOrFiles(m.Store), m.KeyFile, m.KeyPassphrase)
+	return key, err
+}
+
+// keepPreviousKey copies the stored key to PreviousKeyFile if key is to
+// replace it.
+func (m *Manager) keepPreviousKey(key crypto.Signer) error {
+	if m.PreviousKeyFile == "" || m.Signer != nil {
+		return nil
+	}
+	store := storeOrFiles(m.Store)
+	prev, _, err := readKeyFile(store, m.KeyFile, m.KeyPassphrase)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil || publicKeysEqual(prev.Public(), key.Public()) {
+		return err
+	}
+	data, err := store.ReadFile(m.KeyFile)
+	if err != nil {
+		return fmt.Errorf("read %q: %w", m.KeyFile, err)
+	}
+	if err := store.WriteFile(m.PreviousKeyFile, data, fileMode(m.KeyFileMode)); err != nil {
+		return fmt.Errorf("write %q: %w", m.PreviousKeyFile, err)
+	}
+	return nil
+}
+
+// pendingKeyFile is where a renewal stores its new key until its
+// certificate is stored.
+func (m *Manager) pendingKeyFile() string {
+	return m.KeyFile + ".new"
+}
+
+func (m *Manager) writePendingKey(key crypto.Signer) error {
+	name := m.pendingKeyFile()
+	err := writeKeyFile(storeOrFiles(m.Store), name, key, m.KeyPassphrase, fileMode(m.KeyFileMode))
+	audit(m.Config.Audit, "write", err, "path", name)
+	return err
+}
+
+// finishRenewal replaces KeyFile with the pending key, keeping the old one
+// in PreviousKeyFile.
+func (m *Manager) finishRenewal() error {
+	store := storeOrFiles(m.Store)
+	name := m.pendingKeyFile()
+	key, _, err := readKeyFile(store, name, m.KeyPassphrase)
+	if err != nil {
+		return err
+	}
+	data, err := store.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("read %q: %w", name, err)
+	}
+	if err := m.keepPreviousKey(key); err != nil {
+		return err
+	}
+	err = store.WriteFile(m.KeyFile, data, fileMode(m.KeyFileMode))
+	audit(m.Config.Audit, "write", err, "path", m.KeyFile)
+	if err != nil {
+		return fmt.Errorf("write %q: %w", m.KeyFile, err)
+	}
+	if err := store.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove %q: %w", name, err)
+	}
+	return nil
+}
+
+func (m *Manager) writeKey(key crypto.Signer) error {
+	err := writeKeyFile(storeOrFiles(m.Store), m.KeyFile, key, m.KeyPassphrase, fileMode(m.KeyFileMode))
+	audit(m.Config.Audit, "write", err, "path", m.KeyFile)
+	return err
+}
+
+// fileMode returns mode, or the default if it is zero.
+func fileMode(mode os.FileMode) os.FileMode {
+	if mode == 0 {
+		return filePerm
+	}
+	return mode
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	aBytes, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bBytes, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
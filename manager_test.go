@@ -0,0 +1,369 @@
+package localcert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wildone/localcert/internal/acmetest"
+)
+
+func newTestManager(t *testing.T, server *acmetest.Server) *Manager {
+	t.Helper()
+	accountKey, err := GenerateKey(DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	return &Manager{
+		Config: Config{
+			ACMEPrivateKey:     accountKey,
+			ACMEDirectoryURL:   server.DirectoryURL(),
+			LocalCertServerURL: server.LocalcertURL(),
+			Retry:              RetryPolicy{MaxRetries: -1},
+		},
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+		IgnoreARI:       true,
+	}
+}
+
+func TestGetCertificateSingleFlight(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	tlsConfig := &tls.Config{GetCertificate: m.GetCertificate}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				tls.Server(conn, tlsConfig).HandshakeContext(ctx)
+			}()
+		}
+	}()
+
+	const handshakes = 300
+	var wg sync.WaitGroup
+	serials := make(chan string, handshakes)
+	errs := make(chan error, handshakes)
+	for i := 0; i < handshakes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialer := &net.Dialer{Timeout: 30 * time.Second}
+			conn, err := tls.DialWithDialer(dialer, "tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			leaf := conn.ConnectionState().PeerCertificates[0]
+			if err := leaf.CheckSignatureFrom(server.CA()); err != nil {
+				errs <- err
+				return
+			}
+			serials <- leaf.SerialNumber.String()
+		}()
+	}
+	wg.Wait()
+	close(serials)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("handshake: %v", err)
+	}
+	seen := map[string]bool{}
+	for serial := range serials {
+		seen[serial] = true
+	}
+	if len(seen) != 1 {
+		t.Errorf("handshakes were served %d certificates, want 1", len(seen))
+	}
+	if orders := server.Orders(); orders != 1 {
+		t.Errorf("%d orders were placed, want 1", orders)
+	}
+}
+
+func TestGetCertificateFailureRetryInterval(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	server.SetFailOrders(true)
+	m := newTestManager(t, server)
+	m.FailureRetryInterval = 200 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+			t.Fatal("GetCertificate succeeded while orders fail")
+		}
+	}
+	if orders := server.Orders(); orders != 1 {
+		t.Errorf("%d orders were placed within the retry interval, want 1", orders)
+	}
+
+	server.SetFailOrders(false)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("GetCertificate didn't return the cached failure within the retry interval")
+	}
+	time.Sleep(m.FailureRetryInterval)
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate after the retry interval: %v", err)
+	}
+	if orders := server.Orders(); orders != 2 {
+		t.Errorf("%d orders were placed, want 2", orders)
+	}
+	if again, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != nil || again != cert {
+		t.Errorf("GetCertificate didn't serve the obtained certificate again: %v", err)
+	}
+}
+
+func TestGetCertificateHandshakeDeadline(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+
+	// Holding mu holds up the issuance, so the handshake times out waiting
+	// for it
+	m.mu.Lock()
+	client, serverConn := net.Pipe()
+	defer client.Close()
+	defer serverConn.Close()
+	go tls.Client(client, &tls.Config{InsecureSkipVerify: true}).Handshake()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tls.Server(serverConn, &tls.Config{GetCertificate: m.GetCertificate}).HandshakeContext(ctx)
+	waited := time.Since(start)
+	m.mu.Unlock()
+	if err == nil {
+		t.Fatal("handshake succeeded while the issuance is held up")
+	}
+	if waited > 5*time.Second {
+		t.Errorf("handshake waited %s past its deadline", waited)
+	}
+
+	// The issuance goes on for later handshakes
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Errorf("GetCertificate once the issuance could go ahead: %v", err)
+	}
+	if orders := server.Orders(); orders != 1 {
+		t.Errorf("%d orders were placed, want 1", orders)
+	}
+}
+
+// waitForServed calls GetCertificate until it serves a certificate other
+// than prev, and returns it.
+func waitForServed(t *testing.T, m *Manager, prev *tls.Certificate) *tls.Certificate {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.SerialNumber.Cmp(prev.Leaf.SerialNumber) != 0 {
+			return cert
+		}
+	}
+	t.Fatalf("GetCertificate still serves certificate %s", prev.Leaf.SerialNumber.Text(16))
+	return nil
+}
+
+// waitForIssuance waits for a GetCertificate issuance in progress.
+func waitForIssuance(m *Manager) {
+	m.getMu.Lock()
+	call := m.flight
+	m.getMu.Unlock()
+	if call != nil {
+		<-call.done
+	}
+}
+
+func TestGetCertificateServesExternalRenewal(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+	m.certSource().CheckInterval = 10 * time.Millisecond
+	first, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process renews the certificate in the same files
+	other := newTestManager(t, server)
+	other.Config = m.Config
+	other.CertificateFile, other.KeyFile = m.CertificateFile, m.KeyFile
+	result, err := other.Renew(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := waitForServed(t, m, first)
+	if served.Leaf.SerialNumber.Cmp(result.Certificate.SerialNumber) != 0 {
+		t.Errorf("GetCertificate serves %s, want the renewal %s", served.Leaf.SerialNumber.Text(16), result.Certificate.SerialNumber.Text(16))
+	}
+	waitForIssuance(m)
+	if orders := server.Orders(); orders != 2 {
+		t.Errorf("%d orders were placed, want 2", orders)
+	}
+}
+
+func TestGetCertificateRenewsWhenDue(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+	first, err := m.Provision(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is valid for long yet, but of another key type, so
+	// it is due for renewal
+	m.KeyType = KeyTypeECDSAP384
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.SerialNumber.Cmp(first.Certificate.SerialNumber) != 0 {
+		t.Fatal("GetCertificate waited for the renewal rather than serving the current certificate")
+	}
+
+	renewed := waitForServed(t, m, cert)
+	if got := KeyTypeOf(renewed.Leaf.PublicKey); got != KeyTypeECDSAP384 {
+		t.Errorf("renewed certificate has a %s key, want %s", got, KeyTypeECDSAP384)
+	}
+	waitForIssuance(m)
+	if orders := server.Orders(); orders != 2 {
+		t.Errorf("%d orders were placed, want 2", orders)
+	}
+}
+
+func TestGetCertificateServesProvision(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+	first, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForIssuance(m)
+
+	result, err := m.Renew(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Renew reloads the served certificate rather than leaving it to the
+	// next file check
+	served, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if served == first || served.Leaf.SerialNumber.Cmp(result.Certificate.SerialNumber) != 0 {
+		t.Errorf("GetCertificate serves %s after Renew, want %s", served.Leaf.SerialNumber.Text(16), result.Certificate.SerialNumber.Text(16))
+	}
+	waitForIssuance(m)
+}
+
+// crashingStore fails writes of one file, as if the process died before
+// writing it.
+type crashingStore struct {
+	FileStore
+	crashAt string
+}
+
+func (s crashingStore) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if name == s.crashAt {
+		return errors.New("crashed")
+	}
+	return s.FileStore.WriteFile(name, data, perm)
+}
+
+func TestRenewInterruptedBeforeChainWrite(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+	ctx := context.Background()
+	first, err := m.Provision(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Store = crashingStore{crashAt: m.CertificateFile}
+	m.RotateKey = true
+	if _, err := m.Renew(ctx); err == nil {
+		t.Fatal("Renew succeeded without writing the certificate")
+	}
+	m.Store, m.RotateKey = nil, false
+
+	// Without an OrderFile to resume, the old pair is still a pair
+	_, cert, err := m.readChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Cmp(first.Certificate.SerialNumber) != 0 {
+		t.Error("the certificate was replaced")
+	}
+	if err := m.CheckPair(cert); err != nil {
+		t.Errorf("CheckPair after the interrupted renewal: %v", err)
+	}
+}
+
+func TestRenewInterruptedBeforeKeySwap(t *testing.T) {
+	server := acmetest.NewServer()
+	defer server.Close()
+	m := newTestManager(t, server)
+	ctx := context.Background()
+	if _, err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Store = crashingStore{crashAt: m.KeyFile}
+	m.RotateKey = true
+	if _, err := m.Renew(ctx); err == nil {
+		t.Fatal("Renew succeeded without writing the key")
+	}
+	m.Store, m.RotateKey = nil, false
+	_, cert, err := m.readChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckPair(cert); !errors.Is(err, ErrKeyMismatch) || !m.InterruptedRenewal(cert) {
+		t.Fatalf("CheckPair after the interrupted renewal = %v, want an interrupted renewal", err)
+	}
+
+	result, err := m.Provision(ctx)
+	if err != nil {
+		t.Fatalf("Provision after the interrupted renewal: %v", err)
+	}
+	if result.Renewed || result.Certificate.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Error("Provision replaced the certificate rather than storing its key")
+	}
+	if err := m.CheckPair(result.Certificate); err != nil {
+		t.Error(err)
+	}
+	if orders := server.Orders(); orders != 2 {
+		t.Errorf("%d orders were placed, want 2", orders)
+	}
+	if _, err := os.Stat(m.pendingKeyFile()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the pending key is still there: %v", err)
+	}
+}
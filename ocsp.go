@@ -0,0 +1,142 @@
+package localcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspFallbackLifetime is how long a response without a nextUpdate is
+// used for.
+const ocspFallbackLifetime = 12 * time.Hour
+
+// OCSPStaple is an OCSP response for a certificate, as stapled to TLS
+// handshakes.
+type OCSPStaple struct {
+	Raw      []byte
+	Response *ocsp.Response
+}
+
+// RefreshTime is when the response should be replaced: halfway between its
+// thisUpdate and nextUpdate, so that an outage of the responder has time
+// to pass before the response goes stale.
+func (s *OCSPStaple) RefreshTime() time.Time {
+	if s.Response.NextUpdate.IsZero() {
+		return s.Response.ThisUpdate.Add(ocspFallbackLifetime / 2)
+	}
+	return s.Response.ThisUpdate.Add(s.Response.NextUpdate.Sub(s.Response.ThisUpdate) / 2)
+}
+
+// Valid reports whether the response can still be stapled.
+func (s *OCSPStaple) Valid() bool {
+	if s.Response.NextUpdate.IsZero() {
+		return time.Since(s.Response.ThisUpdate) < ocspFallbackLifetime
+	}
+	return time.Now().Before(s.Response.NextUpdate)
+}
+
+// ParseOCSP parses raw as an OCSP response for the leaf of chain, signed
+// by or on behalf of its issuer.
+func ParseOCSP(raw []byte, chain [][]byte) (*OCSPStaple, error) {
+	leaf, issuer, err := leafAndIssuer(chain)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &OCSPStaple{Raw: raw, Response: resp}, nil
+}
+
+// FetchOCSP gets a fresh OCSP response for the leaf of chain from the
+// responder it names. A revoked certificate is an error.
+func FetchOCSP(ctx context.Context, client *http.Client, chain [][]byte) (*OCSPStaple, error) {
+	leaf, issuer, err := leafAndIssuer(chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create OCSP request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", leaf.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned %s", leaf.OCSPServer[0], resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP response: %w", err)
+	}
+	staple, err := ParseOCSP(raw, chain)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP response: %w", err)
+	}
+	switch staple.Response.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return nil, fmt.Errorf("certificate was revoked at %s", staple.Response.RevokedAt.Format(time.RFC3339))
+	default:
+		return nil, errors.New("OCSP responder doesn't know the certificate")
+	}
+	return staple, nil
+}
+
+// UpdateOCSPFile returns the OCSP response cached in the named file if it
+// is for the leaf of chain and not yet due for refresh, and otherwise
+// fetches a fresh one and writes it to the file. It reports whether the
+// file was written.
+func UpdateOCSPFile(ctx context.Context, client *http.Client, store Store, name string, chain [][]byte) (*OCSPStaple, bool, error) {
+	store = storeOrFiles(store)
+	if raw, err := store.ReadFile(name); err == nil {
+		if staple, err := ParseOCSP(raw, chain); err == nil && time.Now().Before(staple.RefreshTime()) {
+			return staple, false, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, fmt.Errorf("read %q: %w", name, err)
+	}
+	staple, err := FetchOCSP(ctx, client, chain)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.WriteFile(name, staple.Raw, filePerm); err != nil {
+		return nil, false, fmt.Errorf("write %q: %w", name, err)
+	}
+	return staple, true, nil
+}
+
+func leafAndIssuer(chain [][]byte) (leaf, issuer *x509.Certificate, err error) {
+	if len(chain) < 2 {
+		return nil, nil, errors.New("OCSP needs the issuer, but the chain has no intermediate")
+	}
+	if leaf, err = x509.ParseCertificate(chain[0]); err != nil {
+		return nil, nil, err
+	}
+	if issuer, err = x509.ParseCertificate(chain[1]); err != nil {
+		return nil, nil, err
+	}
+	return leaf, issuer, nil
+}
@@ -0,0 +1,93 @@
+package localcert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Profiles returns the certificate profiles the CA offers, such as
+// "classic" and "tlsserver", with their descriptions, or nil if it doesn't
+// support profiles (draft-aaron-acme-profiles).
+func (c *Client) Profiles(ctx context.Context) (map[string]string, error) {
+	dirURL := c.acmeClient.DirectoryURL
+	if dirURL == "" {
+		dirURL = acme.LetsEncryptURL
+	}
+	var dir struct {
+		Meta struct {
+			Profiles map[string]string `json:"profiles"`
+		} `json:"meta"`
+	}
+	if _, err := c.getJSON(ctx, dirURL, &dir); err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	return dir.Meta.Profiles, nil
+}
+
+// checkProfile returns an error unless the CA offers the configured
+// profile.
+func (c *Client) checkProfile(ctx context.Context) error {
+	profiles, err := c.Profiles(ctx)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("the CA doesn't offer certificate profiles, so can't issue with profile %q", c.profile)
+	}
+	if _, ok := profiles[c.profile]; ok {
+		return nil
+	}
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("the CA doesn't offer profile %q; it offers %s", c.profile, strings.Join(names, ", "))
+}
+
+// newProfileOrder creates an order for ids with the configured profile,
+// which the acme package can't send.
+func (c *Client) newProfileOrder(ctx context.Context, ids []acme.AuthzID) (*acme.Order, error) {
+	if err := c.checkProfile(ctx); err != nil {
+		return nil, err
+	}
+	dir, err := c.acmeClient.Discover(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %w", err)
+	}
+	type identifier struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	req := struct {
+		Identifiers []identifier `json:"identifiers"`
+		Profile     string       `json:"profile"`
+	}{Profile: c.profile}
+	for _, id := range ids {
+		req.Identifiers = append(req.Identifiers, identifier{id.Type, id.Value})
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	header, err := c.post(ctx, dir, dir.OrderURL, payload)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := header.Get("Location")
+	if orderURL == "" {
+		return nil, errors.New("no order URL in the CA's response")
+	}
+	order, err := c.acmeClient.GetOrder(ctx, orderURL)
+	if err != nil {
+		return nil, err
+	}
+	order.URI = orderURL
+	return order, nil
+}
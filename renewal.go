@@ -0,0 +1,39 @@
+package localcert
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// RenewalPolicy decides when a certificate is due for renewal.
+type RenewalPolicy struct {
+	// Before renews this long before expiry; DefaultRenewBefore if zero.
+	// Certificates whose whole lifetime is shorter are renewed two thirds of
+	// the way through it instead.
+	Before time.Duration
+
+	// BeforeFraction, if set, overrides Before and renews once less than
+	// this fraction of the lifetime remains, e.g. 0.33.
+	BeforeFraction float64
+}
+
+// RenewalTime returns when cert is due for renewal.
+func (p RenewalPolicy) RenewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if p.BeforeFraction > 0 {
+		return cert.NotAfter.Add(-time.Duration(float64(lifetime) * p.BeforeFraction))
+	}
+	before := p.Before
+	if before == 0 {
+		before = DefaultRenewBefore
+	}
+	if lifetime <= before {
+		return cert.NotBefore.Add(lifetime * 2 / 3)
+	}
+	return cert.NotAfter.Add(-before)
+}
+
+// NeedsRenewal reports whether cert is due for renewal under policy.
+func NeedsRenewal(cert *x509.Certificate, policy RenewalPolicy) bool {
+	return !time.Now().Before(policy.RenewalTime(cert))
+}
@@ -0,0 +1,190 @@
+package localcert
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/wildone/localcert/internal/acmeutil"
+)
+
+const (
+	DefaultMaxRetries = 5
+
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// RetryPolicy controls how requests are retried after rate limiting, server
+// errors and network failures.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries of each request;
+	// DefaultMaxRetries if zero, and none if negative.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the jittered exponential backoff
+	// between retries; one second and one minute if zero. A Retry-After
+	// longer than MaxBackoff is not waited for, and the request fails with
+	// RateLimitedError.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// RateLimitedError is returned when the CA or the localcert server is still
+// rate limiting requests once retries are exhausted.
+type RateLimitedError struct {
+	// RetryAfter is when the server said to try again; zero if it didn't.
+	RetryAfter time.Time
+	Err        error
+}
+
+func (e RateLimitedError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("rate limited: %v", e.Err)
+	}
+	return fmt.Sprintf("rate limited until %s: %v", e.RetryAfter.Format(time.RFC3339), e.Err)
+}
+
+func (e RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// rateLimited wraps err in RateLimitedError if it was caused by a rate
+// limited response.
+func rateLimited(err error) error {
+	if err == nil || errors.As(err, &RateLimitedError{}) {
+		return err
+	}
+	var acmeErr *acme.Error
+	if errors.As(err, &acmeErr) && (acmeErr.StatusCode == http.StatusTooManyRequests || acmeErr.ProblemType == "urn:ietf:params:acme:error:rateLimited") {
+		rle := RateLimitedError{Err: err}
+		if d, ok := parseRetryAfter(acmeErr.Header.Get("Retry-After"), time.Now()); ok {
+			rle.RetryAfter = time.Now().Add(d)
+		}
+		return rle
+	}
+	var statusErr *acmeutil.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests {
+		return RateLimitedError{Err: err}
+	}
+	return err
+}
+
+func (p RetryPolicy) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return p.MaxRetries
+}
+
+// backoff returns how long to wait before retry n, counting from 1, or -1
+// to give up.
+func (p RetryPolicy) backoff(n int, resp *http.Response) time.Duration {
+	if n > p.maxRetries() {
+		return -1
+	}
+	minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if d > maxBackoff {
+				return -1
+			}
+			return d
+		}
+	}
+
+	d := maxBackoff
+	if n < 31 && minBackoff<<uint(n-1) < maxBackoff {
+		d = minBackoff << uint(n-1)
+	}
+	// Wait between half and all of d, so clients that failed together
+	// don't retry together.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// acmeBackoff returns an acme.Client.RetryBackoff. The acme package
+// retries 429 and 5xx responses, and bad nonces, re-signing each attempt.
+func (p RetryPolicy) acmeBackoff(logf func(format string, args ...interface{})) func(int, *http.Request, *http.Response) time.Duration {
+	return func(n int, r *http.Request, resp *http.Response) time.Duration {
+		d := p.backoff(n, resp)
+		if d >= 0 && logf != nil {
+			logf("%s %s returned %s (retrying in %s)\n", r.Method, r.URL.Redacted(), resp.Status, d.Round(time.Millisecond))
+		}
+		return d
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// retryTransport retries requests that fail without a response, such as on
+// a connection reset. ACME requests can be resent as is, since a replayed
+// nonce is rejected.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+	logf   func(format string, args ...interface{})
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	for n := 1; ; n++ {
+		resp, err := next.RoundTrip(req)
+		if err == nil || req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		d := t.policy.backoff(n, nil)
+		if d < 0 {
+			return nil, err
+		}
+		if t.logf != nil {
+			t.logf("%s %s failed (retrying in %s): %v\n", req.Method, req.URL.Redacted(), d.Round(time.Millisecond), err)
+		}
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+			return nil, err
+		}
+		if req.Body != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
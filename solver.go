@@ -0,0 +1,51 @@
+package localcert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ChallengeSolver completes DNS-01 challenges by publishing TXT records, for
+// domains that aren't delegated to the localcert service. Implementations
+// for some DNS providers are in the dnsprovider package.
+type ChallengeSolver interface {
+	// Present publishes a TXT record with value at fqdn, alongside any
+	// existing values, and returns once it is being served.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the value published by Present.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNS01 returns a Challenger that completes DNS-01 challenges with solver.
+func DNS01(solver ChallengeSolver) Challenger {
+	return dns01{solver}
+}
+
+type dns01 struct {
+	solver ChallengeSolver
+}
+
+func (dns01) Type() string { return "dns-01" }
+
+func (d dns01) Present(ctx context.Context, client *acme.Client, identifier string, chal *acme.Challenge) (func(), error) {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := "_acme-challenge." + strings.TrimPrefix(identifier, "*.") + "."
+	if err := d.solver.Present(ctx, fqdn, value); err != nil {
+		return nil, fmt.Errorf("present %s: %w", fqdn, err)
+	}
+	return func() {
+		// Clean up even if ctx was cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := d.solver.CleanUp(ctx, fqdn, value); err != nil {
+			log.Printf("Error cleaning up %s: %v", fqdn, err)
+		}
+	}, nil
+}
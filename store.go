@@ -0,0 +1,205 @@
+package localcert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Store holds the files a Manager keeps, such as the certificate chain and
+// its key, by name.
+type Store interface {
+	// ReadFile returns the contents of the named file, or an error wrapping
+	// fs.ErrNotExist if there is none.
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Remove(name string) error
+}
+
+// ErrConflict is returned by VersionedStore.WriteFileIfVersion when the file
+// has changed since it was read.
+var ErrConflict = errors.New("changed by another writer")
+
+// VersionedStore is a Store that only replaces a file if no one else has
+// since it was read, for stores that several hosts share.
+type VersionedStore interface {
+	Store
+	// ReadFileVersion is ReadFile, also returning an opaque version of the
+	// contents.
+	ReadFileVersion(name string) (data []byte, version string, err error)
+	// WriteFileIfVersion writes the named file if it is still at version,
+	// or doesn't exist if version is "", and returns the new version.
+	// Otherwise it returns an error wrapping ErrConflict.
+	WriteFileIfVersion(name string, data []byte, perm fs.FileMode, version string) (string, error)
+}
+
+// FileStore is the default Store, keeping files on the local filesystem.
+// Files are replaced atomically, so a crash leaves either the old or the
+// new contents.
+type FileStore struct {
+	// Backups is the number of previous generations of each file to keep,
+	// as <name>.bak.1 (the newest) to <name>.bak.<Backups>.
+	Backups int
+}
+
+func (FileStore) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (s FileStore) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := s.backup(name); err != nil {
+		return fmt.Errorf("back up %q: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	// Make the rename itself durable; directories can't be synced on Windows
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backup shifts the backups of name down a generation and makes the current
+// file the newest.
+func (s FileStore) backup(name string) error {
+	if s.Backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	for i := s.Backups - 1; i >= 1; i-- {
+		err := os.Rename(backupName(name, i), backupName(name, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	// Link rather than rename so that name never goes missing
+	newest := backupName(name, 1)
+	if err := os.Remove(newest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(name, newest); err == nil {
+		return nil
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(newest, data, fi.Mode().Perm())
+}
+
+// ReadFileVersion versions files by the SHA-256 hash of their contents.
+func (FileStore) ReadFileVersion(name string) ([]byte, string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+// WriteFileIfVersion holds <name>.lock while it checks the version and
+// writes. The lock file is created exclusively rather than locked, which
+// network filesystems support more reliably.
+func (s FileStore) WriteFileIfVersion(name string, data []byte, perm fs.FileMode, version string) (string, error) {
+	unlock, err := createLockFile(name + ".lock")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	var current string
+	if existing, err := os.ReadFile(name); err == nil {
+		current = contentVersion(existing)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if current != version {
+		return "", fmt.Errorf("%s: %w", name, ErrConflict)
+	}
+	if err := s.WriteFile(name, data, perm); err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Lock files older than this were left by a process that died, as writes
+// take far less.
+const staleLockAge = 30 * time.Second
+
+// createLockFile creates name, waiting for another process to remove it,
+// and returns a func that removes it.
+func createLockFile(name string) (unlock func(), err error) {
+	deadline := time.Now().Add(2 * staleLockAge)
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another writer", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func backupName(name string, generation int) string {
+	return fmt.Sprintf("%s.bak.%d", name, generation)
+}
+
+func (FileStore) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func storeOrFiles(store Store) Store {
+	if store == nil {
+		return FileStore{}
+	}
+	return store
+}
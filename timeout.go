@@ -0,0 +1,63 @@
+package localcert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// cleanupTimeout bounds removing challenge records, which happens even
+// after the issuance context is cancelled.
+const cleanupTimeout = time.Minute
+
+// Timeouts bound each phase of issuance. A zero field means the phase is
+// only limited by the context passed in.
+type Timeouts struct {
+	// Registration covers finding or registering the ACME account and
+	// getting the assigned domain.
+	Registration time.Duration
+	// Order covers creating the ACME order.
+	Order time.Duration
+	// Challenge covers completing the authorizations, until the order is
+	// ready.
+	Challenge time.Duration
+	// Finalize covers submitting the CSR until the certificate is issued
+	// and downloaded.
+	Finalize time.Duration
+	// Download covers downloading an issued certificate again if the
+	// download during Finalize failed.
+	Download time.Duration
+}
+
+// phaseContext limits ctx to timeout, if set. The returned func ends the
+// phase, annotating an error caused by its timeout.
+func phaseContext(ctx context.Context, timeout time.Duration) (context.Context, func(error) error) {
+	if timeout <= 0 {
+		return ctx, func(err error) error { return err }
+	}
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	return phaseCtx, func(err error) error {
+		defer cancel()
+		if err != nil && ctx.Err() == nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+		return err
+	}
+}
+
+// phase starts the phase of issuance called name, limited to timeout and
+// in a span of its own if the Config has StartSpan. The returned func ends
+// it, as for phaseContext.
+func (c *Client) phase(ctx context.Context, name string, timeout time.Duration) (context.Context, func(error) error) {
+	if c.startSpan == nil {
+		return phaseContext(ctx, timeout)
+	}
+	ctx, endSpan := c.startSpan(ctx, name)
+	ctx, done := phaseContext(ctx, timeout)
+	return ctx, func(err error) error {
+		err = done(err)
+		endSpan(err)
+		return err
+	}
+}